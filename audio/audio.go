@@ -0,0 +1,182 @@
+// Package audio parses and validates the RIFF/WAVE audio of the voice samples and voice messages, and joins the
+// wave segments of the synthesized speech.
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+var (
+	// ErrUnsupported is returned for the well formed wave content of an audio format or bit depth that is not supported.
+	ErrUnsupported = errors.New("unsupported wave audio")
+	// ErrOutOfBounds is returned for the wave content outside of the accepted bounds of sample rate, channels, bit
+	// depth, or duration.
+	ErrOutOfBounds = errors.New("wave audio out of bounds")
+)
+
+const (
+	// FormatPCM is the name of the integer PCM audio format.
+	FormatPCM = "pcm"
+	// FormatFloat is the name of the IEEE floating point audio format.
+	FormatFloat = "float"
+)
+
+// The format codes of the "fmt " chunk.
+const (
+	formatCodePCM        = 1
+	formatCodeFloat      = 3
+	formatCodeExtensible = 0xFFFE
+)
+
+// supportedBitDepths are the bits per sample of each supported audio format.
+var supportedBitDepths = map[string][]int{
+	FormatPCM:   {8, 16, 24, 32},
+	FormatFloat: {32, 64},
+}
+
+// Info is the metadata of wave audio.
+type Info struct {
+	// Format is the audio format, FormatPCM or FormatFloat.
+	Format string `json:"format"`
+	// SampleRate is the number of samples per second of each channel.
+	SampleRate int `json:"sampleRate"`
+	// Channels is the number of channels.
+	Channels int `json:"channels"`
+	// BitsPerSample is the bit depth of each sample.
+	BitsPerSample int `json:"bitsPerSample"`
+	// Duration is the duration of the audio.
+	Duration time.Duration `json:"duration"`
+}
+
+// Parse returns the metadata of the wave content. The content must be a well formed RIFF/WAVE file of a supported
+// audio format.
+func Parse(wavContent []byte) (Info, error) {
+	format, data, err := chunks(wavContent)
+	if err != nil {
+		return Info{}, err
+	}
+	if len(format) < 16 {
+		return Info{}, fmt.Errorf("%w: the fmt chunk is too short", ErrMalformed)
+	}
+	formatCode := binary.LittleEndian.Uint16(format[0:2])
+	channels := int(binary.LittleEndian.Uint16(format[2:4]))
+	sampleRate := int(binary.LittleEndian.Uint32(format[4:8]))
+	byteRate := int(binary.LittleEndian.Uint32(format[8:12]))
+	blockAlign := int(binary.LittleEndian.Uint16(format[12:14]))
+	bitsPerSample := int(binary.LittleEndian.Uint16(format[14:16]))
+	if formatCode == formatCodeExtensible {
+		// The extensible format has the actual format code at the start of its sub-format GUID.
+		if len(format) < 26 {
+			return Info{}, fmt.Errorf("%w: the extensible fmt chunk is too short", ErrMalformed)
+		}
+		formatCode = binary.LittleEndian.Uint16(format[24:26])
+	}
+	if channels == 0 || sampleRate == 0 || bitsPerSample == 0 {
+		return Info{}, fmt.Errorf("%w: zero channels, sample rate, or bits per sample", ErrMalformed)
+	}
+	if blockAlign != channels*((bitsPerSample+7)/8) || byteRate != sampleRate*blockAlign {
+		return Info{}, fmt.Errorf("%w: the block align and byte rate do not match the channels, sample rate, and bits per sample", ErrMalformed)
+	}
+	info := Info{
+		SampleRate:    sampleRate,
+		Channels:      channels,
+		BitsPerSample: bitsPerSample,
+		Duration:      time.Duration(len(data)/blockAlign) * time.Second / time.Duration(sampleRate),
+	}
+	switch formatCode {
+	case formatCodePCM:
+		info.Format = FormatPCM
+	case formatCodeFloat:
+		info.Format = FormatFloat
+	default:
+		return Info{}, fmt.Errorf("%w: audio format code %#x, only PCM and IEEE float are supported", ErrUnsupported, formatCode)
+	}
+	if !slices.Contains(supportedBitDepths[info.Format], bitsPerSample) {
+		return Info{}, fmt.Errorf("%w: %d-bit %s, the supported bit depths are %v", ErrUnsupported, bitsPerSample, info.Format, supportedBitDepths[info.Format])
+	}
+	return info, nil
+}
+
+// Default bounds of the audio inputs.
+const (
+	DefaultMinSampleRate = 8000
+	DefaultMaxSampleRate = 48000
+	DefaultMaxChannels   = 2
+	DefaultMinDuration   = 500 * time.Millisecond
+	DefaultMaxDuration   = 10 * time.Minute
+)
+
+// Limits are the bounds of the accepted audio, a zero or negative bound is unbounded.
+type Limits struct {
+	// MinSampleRate and MaxSampleRate are the bounds of the sample rate.
+	MinSampleRate, MaxSampleRate int
+	// MaxChannels is the most channels.
+	MaxChannels int
+	// BitDepths are the accepted bits per sample, any supported bit depth is accepted if it is empty.
+	BitDepths []int
+	// MinDuration and MaxDuration are the bounds of the duration.
+	MinDuration, MaxDuration time.Duration
+}
+
+// DefaultLimits are the bounds of the audio inputs in the absence of configuration.
+var DefaultLimits = Limits{
+	MinSampleRate: DefaultMinSampleRate,
+	MaxSampleRate: DefaultMaxSampleRate,
+	MaxChannels:   DefaultMaxChannels,
+	MinDuration:   DefaultMinDuration,
+	MaxDuration:   DefaultMaxDuration,
+}
+
+// WithDefaults returns the limits with the zero bounds replaced by the default bounds, the negative bounds stay
+// unbounded.
+func (limits Limits) WithDefaults() Limits {
+	if limits.MinSampleRate == 0 {
+		limits.MinSampleRate = DefaultMinSampleRate
+	}
+	if limits.MaxSampleRate == 0 {
+		limits.MaxSampleRate = DefaultMaxSampleRate
+	}
+	if limits.MaxChannels == 0 {
+		limits.MaxChannels = DefaultMaxChannels
+	}
+	if limits.MinDuration == 0 {
+		limits.MinDuration = DefaultMinDuration
+	}
+	if limits.MaxDuration == 0 {
+		limits.MaxDuration = DefaultMaxDuration
+	}
+	return limits
+}
+
+// Check returns an error if the audio is outside of the limits.
+func (limits Limits) Check(info Info) error {
+	switch {
+	case limits.MinSampleRate > 0 && info.SampleRate < limits.MinSampleRate:
+		return fmt.Errorf("%w: the sample rate %d Hz is below %d Hz", ErrOutOfBounds, info.SampleRate, limits.MinSampleRate)
+	case limits.MaxSampleRate > 0 && info.SampleRate > limits.MaxSampleRate:
+		return fmt.Errorf("%w: the sample rate %d Hz is above %d Hz", ErrOutOfBounds, info.SampleRate, limits.MaxSampleRate)
+	case limits.MaxChannels > 0 && info.Channels > limits.MaxChannels:
+		return fmt.Errorf("%w: %d channels are more than %d", ErrOutOfBounds, info.Channels, limits.MaxChannels)
+	case len(limits.BitDepths) > 0 && !slices.Contains(limits.BitDepths, info.BitsPerSample):
+		return fmt.Errorf("%w: the bit depth %d is not one of %v", ErrOutOfBounds, info.BitsPerSample, limits.BitDepths)
+	case limits.MinDuration > 0 && info.Duration < limits.MinDuration:
+		return fmt.Errorf("%w: the duration %v is shorter than %v", ErrOutOfBounds, info.Duration, limits.MinDuration)
+	case limits.MaxDuration > 0 && info.Duration > limits.MaxDuration:
+		return fmt.Errorf("%w: the duration %v is longer than %v", ErrOutOfBounds, info.Duration, limits.MaxDuration)
+	}
+	return nil
+}
+
+// Validate returns the metadata of the wave content, or an error if the content is malformed, unsupported, or outside
+// of the limits.
+func Validate(wavContent []byte, limits Limits) (Info, error) {
+	info, err := Parse(wavContent)
+	if err != nil {
+		return Info{}, err
+	}
+	return info, limits.Check(info)
+}
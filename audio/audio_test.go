@@ -0,0 +1,80 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSilence returns a wave file of the silence of the duration in the audio format.
+func testSilence(formatCode uint16, channels, sampleRate, bitsPerSample int, duration time.Duration) []byte {
+	blockAlign := channels * bitsPerSample / 8
+	dataSize := int(duration.Seconds()*float64(sampleRate)) * blockAlign
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(4+24+8+dataSize))
+	buf.WriteString("WAVEfmt ")
+	for _, field := range []any{uint32(16), formatCode, uint16(channels), uint32(sampleRate), uint32(sampleRate * blockAlign), uint16(blockAlign), uint16(bitsPerSample)} {
+		_ = binary.Write(&buf, binary.LittleEndian, field)
+	}
+	buf.WriteString("data")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(dataSize))
+	buf.Write(make([]byte, dataSize))
+	return buf.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	info, err := Parse(testSilence(formatCodePCM, 1, 24000, 16, 2*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, Info{Format: FormatPCM, SampleRate: 24000, Channels: 1, BitsPerSample: 16, Duration: 2 * time.Second}, info)
+	info, err = Parse(testSilence(formatCodeFloat, 2, 48000, 32, 1500*time.Millisecond))
+	require.NoError(t, err)
+	assert.Equal(t, Info{Format: FormatFloat, SampleRate: 48000, Channels: 2, BitsPerSample: 32, Duration: 1500 * time.Millisecond}, info)
+	// The chunks other than "fmt " and "data" are skipped.
+	info, err = Parse(testWAV(24000, 1, 2, 3))
+	require.NoError(t, err)
+	assert.Equal(t, 3*time.Second/24000, info.Duration)
+
+	// Not a wave file at all.
+	_, err = Parse([]byte("ID3\x04\x00\x00\x00\x00\x00\x00 an mp3 mislabelled as wave"))
+	assert.ErrorIs(t, err, ErrMalformed)
+	// The byte rate does not match the rest of the format.
+	corrupt := testSilence(formatCodePCM, 1, 24000, 16, time.Second)
+	binary.LittleEndian.PutUint32(corrupt[28:32], 12345)
+	_, err = Parse(corrupt)
+	assert.ErrorIs(t, err, ErrMalformed)
+	// ADPCM and 12-bit PCM are not supported.
+	_, err = Parse(testSilence(2, 1, 24000, 16, time.Second))
+	assert.ErrorIs(t, err, ErrUnsupported)
+	_, err = Parse(testSilence(formatCodeFloat, 1, 24000, 16, time.Second))
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestValidate(t *testing.T) {
+	info, err := Validate(testSilence(formatCodePCM, 1, 24000, 16, 2*time.Second), DefaultLimits)
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Second, info.Duration)
+	for _, wav := range [][]byte{
+		testSilence(formatCodePCM, 1, 4000, 16, 2*time.Second),
+		testSilence(formatCodePCM, 1, 96000, 16, 2*time.Second),
+		testSilence(formatCodePCM, 6, 24000, 16, 2*time.Second),
+		testSilence(formatCodePCM, 1, 24000, 16, 100*time.Millisecond),
+		testSilence(formatCodePCM, 1, 8000, 8, 11*time.Minute),
+	} {
+		_, err := Validate(wav, DefaultLimits)
+		assert.ErrorIs(t, err, ErrOutOfBounds)
+	}
+	_, err = Validate(testSilence(formatCodePCM, 1, 24000, 8, 2*time.Second), Limits{BitDepths: []int{16, 24}})
+	assert.ErrorIs(t, err, ErrOutOfBounds)
+	// The zero and negative limits are unbounded, unless the zero limits are replaced by the defaults.
+	loud := testSilence(formatCodePCM, 6, 96000, 8, 100*time.Millisecond)
+	_, err = Validate(loud, Limits{})
+	assert.NoError(t, err)
+	_, err = Validate(loud, Limits{MinSampleRate: -1, MaxSampleRate: -1, MaxChannels: -1, MinDuration: -1, MaxDuration: -1}.WithDefaults())
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultLimits, Limits{}.WithDefaults())
+}
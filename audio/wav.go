@@ -0,0 +1,73 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrMalformed is returned for the content that is not a well formed RIFF/WAVE file.
+var ErrMalformed = errors.New("malformed wave content")
+
+// chunks returns the content of the "fmt " and "data" chunks of the wave content.
+func chunks(wavContent []byte) (format, data []byte, err error) {
+	if len(wavContent) < 12 || string(wavContent[0:4]) != "RIFF" || string(wavContent[8:12]) != "WAVE" {
+		return nil, nil, fmt.Errorf("%w: missing RIFF/WAVE header", ErrMalformed)
+	}
+	for offset := 12; offset+8 <= len(wavContent); {
+		id := string(wavContent[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(wavContent[offset+4 : offset+8]))
+		body := wavContent[offset+8:]
+		if size > len(body) {
+			// Streaming writers leave the size of the last chunk unknown.
+			size = len(body)
+		}
+		switch id {
+		case "fmt ":
+			format = body[:size]
+		case "data":
+			data = body[:size]
+		}
+		// The chunks are aligned to even offsets.
+		offset += 8 + size + size%2
+	}
+	if format == nil || data == nil {
+		return nil, nil, fmt.Errorf("%w: missing fmt or data chunk", ErrMalformed)
+	}
+	return format, data, nil
+}
+
+// Concat joins the wave segments into a single wave file. The segments must have the same audio format.
+func Concat(segments [][]byte) ([]byte, error) {
+	var format []byte
+	var data bytes.Buffer
+	for i, segment := range segments {
+		segmentFormat, segmentData, err := chunks(segment)
+		if err != nil {
+			return nil, fmt.Errorf("segment %d: %w", i, err)
+		}
+		if format == nil {
+			format = segmentFormat
+		} else if !bytes.Equal(format, segmentFormat) {
+			return nil, fmt.Errorf("segment %d: %w: the audio format differs from the first segment's", i, ErrMalformed)
+		}
+		data.Write(segmentData)
+	}
+	if format == nil {
+		return nil, fmt.Errorf("%w: no segments", ErrMalformed)
+	}
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(4+8+len(format)+len(format)%2+8+data.Len()))
+	buf.WriteString("WAVEfmt ")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(len(format)))
+	buf.Write(format)
+	if len(format)%2 == 1 {
+		buf.WriteByte(0)
+	}
+	buf.WriteString("data")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(data.Len()))
+	buf.Write(data.Bytes())
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,46 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testWAV returns a 16-bit mono PCM wave file of the samples, with an extra chunk between the fmt and data chunks.
+func testWAV(sampleRate uint32, samples ...int16) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(4+24+10+8+2*len(samples)))
+	buf.WriteString("WAVEfmt ")
+	for _, field := range []any{uint32(16), uint16(1), uint16(1), sampleRate, sampleRate * 2, uint16(2), uint16(16)} {
+		_ = binary.Write(&buf, binary.LittleEndian, field)
+	}
+	buf.WriteString("LIST")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(1))
+	buf.WriteString("x\x00")
+	buf.WriteString("data")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(2*len(samples)))
+	_ = binary.Write(&buf, binary.LittleEndian, samples)
+	return buf.Bytes()
+}
+
+func TestConcat(t *testing.T) {
+	joined, err := Concat([][]byte{testWAV(24000, 1, 2), testWAV(24000, 3), testWAV(24000)})
+	require.NoError(t, err)
+	format, data, err := chunks(joined)
+	require.NoError(t, err)
+	assert.Len(t, format, 16)
+	assert.Equal(t, []byte{1, 0, 2, 0, 3, 0}, data)
+	assert.Equal(t, uint32(len(joined)-8), binary.LittleEndian.Uint32(joined[4:8]))
+
+	// The segments must be wave files of the same format.
+	_, err = Concat([][]byte{testWAV(24000, 1), testWAV(16000, 1)})
+	assert.ErrorIs(t, err, ErrMalformed)
+	_, err = Concat([][]byte{testWAV(24000, 1), []byte("RIFF")})
+	assert.ErrorIs(t, err, ErrMalformed)
+	_, err = Concat(nil)
+	assert.ErrorIs(t, err, ErrMalformed)
+}
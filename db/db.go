@@ -2,12 +2,18 @@ package db
 
 import (
 	"database/sql"
+	_ "embed"
 	"fmt"
 
 	"github.com/HouzuoGuo/reconn-voice-clone/db/dbgen"
 	_ "github.com/lib/pq"
 )
 
+// Schema creates the tables and indexes of the database, and upgrades the existing ones. It is safe to apply repeatedly.
+//
+//go:embed schema.sql
+var Schema string
+
 // Config describes database connection parameters.
 type Config struct {
 	Host     string
@@ -15,11 +21,17 @@ type Config struct {
 	User     string
 	Password string
 	Database string
+	// SSLMode is the libpq sslmode of the connection, it defaults to verify-full.
+	SSLMode string
 }
 
-// Connect to the postgresql database with TLS verification.
+// Connect to the postgresql database, with TLS verification unless the SSL mode says otherwise.
 func Connect(conf Config) (*sql.DB, *dbgen.Queries, error) {
-	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=verify-full", conf.Host, conf.Port, conf.User, conf.Password, conf.Database)
+	sslMode := conf.SSLMode
+	if sslMode == "" {
+		sslMode = "verify-full"
+	}
+	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s", conf.Host, conf.Port, conf.User, conf.Password, conf.Database, sslMode)
 	lowLevelDB, err := sql.Open("postgres", connStr)
 	if err != nil {
 		return nil, nil, err
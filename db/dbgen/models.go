@@ -10,10 +10,11 @@ import (
 )
 
 type AiPerson struct {
-	ID            int64
-	UserID        int64
-	Name          string
-	ContextPrompt string
+	ID             int64
+	UserID         int64
+	Name           string
+	ContextPrompt  string
+	ExpressiveCues bool
 }
 
 type AiPersonReply struct {
@@ -22,6 +23,11 @@ type AiPersonReply struct {
 	Status       string
 	Message      string
 	Timestamp    time.Time
+	ErrorMessage sql.NullString
+	Attempts     int32
+	StartedAt    sql.NullTime
+	FinishedAt   sql.NullTime
+	SpeechMarkup sql.NullString
 }
 
 type AiPersonReplyVoice struct {
@@ -29,6 +35,80 @@ type AiPersonReplyVoice struct {
 	AiPersonReplyID int64
 	Status          string
 	FileName        sql.NullString
+	ErrorMessage    sql.NullString
+	Attempts        int32
+	StartedAt       sql.NullTime
+	FinishedAt      sql.NullTime
+	TaskID          sql.NullString
+}
+
+type AiPersonTtsSetting struct {
+	AiPersonID   int64
+	Preset       string
+	TopK         float64
+	TopP         float64
+	MineosP      float64
+	SemanticTemp float64
+	WaveformTemp float64
+	FineTemp     float64
+	UpdatedAt    time.Time
+}
+
+type GpuWorker struct {
+	ID                string
+	HostName          string
+	VoiceServiceAddrs []string
+	TaskTypes         []string
+	Concurrency       int32
+	Version           string
+	StartedAt         time.Time
+	HeartbeatAt       time.Time
+	CurrentTasks      []string
+	LastError         sql.NullString
+	LastErrorAt       sql.NullTime
+	StoppedAt         sql.NullTime
+}
+
+type GpuWorkerModelCach struct {
+	WorkerID      string
+	ModelFileName string
+	CachedAt      time.Time
+}
+
+type IdempotencyKey struct {
+	RequestPath    string
+	IdempotencyKey string
+	ResponseStatus sql.NullInt32
+	ResponseBody   []byte
+	CreatedAt      time.Time
+}
+
+type TaskOutbox struct {
+	ID        int64
+	Body      []byte
+	CreatedAt time.Time
+	SentAt    sql.NullTime
+}
+
+type TaskQueueJob struct {
+	ID                    int64
+	Queue                 string
+	Body                  []byte
+	VisibleAt             time.Time
+	DeliveryCount         int32
+	CreatedAt             time.Time
+	DeadLetteredAt        sql.NullTime
+	DeadLetterReason      sql.NullString
+	DeadLetterDescription sql.NullString
+}
+
+type TtsCacheEntry struct {
+	CacheKey      string
+	ModelFileName string
+	SizeBytes     int64
+	Hits          int64
+	CreatedAt     time.Time
+	LastUsedAt    time.Time
 }
 
 type User struct {
@@ -52,11 +132,20 @@ type UserTextPrompt struct {
 }
 
 type UserVoicePrompt struct {
-	ID            int64
-	UserPromptID  int64
-	Status        string
-	FileName      string
-	Transcription sql.NullString
+	ID              int64
+	UserPromptID    int64
+	Status          string
+	FileName        string
+	Transcription   sql.NullString
+	ErrorMessage    sql.NullString
+	Attempts        int32
+	StartedAt       sql.NullTime
+	FinishedAt      sql.NullTime
+	AudioFormat     string
+	SampleRate      int32
+	Channels        int32
+	BitsPerSample   int32
+	DurationSeconds float64
 }
 
 type VoiceModel struct {
@@ -65,11 +154,33 @@ type VoiceModel struct {
 	Status        string
 	FileName      sql.NullString
 	Timestamp     time.Time
+	ErrorMessage  sql.NullString
+	Attempts      int32
+	StartedAt     sql.NullTime
+	FinishedAt    sql.NullTime
+	TaskID        sql.NullString
+}
+
+type VoiceModelTtsSetting struct {
+	VoiceModelID int64
+	Preset       string
+	TopK         float64
+	TopP         float64
+	MineosP      float64
+	SemanticTemp float64
+	WaveformTemp float64
+	FineTemp     float64
+	UpdatedAt    time.Time
 }
 
 type VoiceSample struct {
-	ID         int64
-	AiPersonID int64
-	FileName   sql.NullString
-	Timestamp  time.Time
+	ID              int64
+	AiPersonID      int64
+	FileName        sql.NullString
+	Timestamp       time.Time
+	AudioFormat     string
+	SampleRate      int32
+	Channels        int32
+	BitsPerSample   int32
+	DurationSeconds float64
 }
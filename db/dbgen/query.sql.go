@@ -9,32 +9,165 @@ import (
 	"context"
 	"database/sql"
 	"time"
+
+	"github.com/lib/pq"
 )
 
+const cancelAIPersonReplyVoiceByID = `-- name: CancelAIPersonReplyVoiceByID :execrows
+update ai_person_reply_voices set status = 'cancelled', finished_at = now() where id = $1 and status = 'processing'
+`
+
+func (q *Queries) CancelAIPersonReplyVoiceByID(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, cancelAIPersonReplyVoiceByID, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const cancelVoiceModelByID = `-- name: CancelVoiceModelByID :execrows
+update voice_models set status = 'cancelled', finished_at = now() where id = $1 and status = 'processing'
+`
+
+func (q *Queries) CancelVoiceModelByID(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, cancelVoiceModelByID, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const claimAIPersonReplyVoiceByID = `-- name: ClaimAIPersonReplyVoiceByID :execrows
+update ai_person_reply_voices set status = 'processing', attempts = case when status = 'failed' then 1 else attempts + 1 end,
+started_at = now(), finished_at = null, task_id = $1
+where id = $2 and (status = 'processing' or (status = 'failed' and $3::boolean))
+and (task_id is null or finished_at is not null or started_at < now() - make_interval(secs => $4::float8))
+`
+
+type ClaimAIPersonReplyVoiceByIDParams struct {
+	TaskID       sql.NullString
+	ID           int64
+	Requeued     bool
+	LeaseSeconds float64
+}
+
+// Claim the reply voice for the task, unless the reply voice is no longer in processing, or another task is still working on it.
+// A retry claims the reply voice again after the failed attempt has finished, and any task claims it after a crashed attempt's lease.
+// A task requeued from the dead letters also claims the failed reply voice, putting it back into processing with its attempts starting over.
+func (q *Queries) ClaimAIPersonReplyVoiceByID(ctx context.Context, arg ClaimAIPersonReplyVoiceByIDParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, claimAIPersonReplyVoiceByID,
+		arg.TaskID,
+		arg.ID,
+		arg.Requeued,
+		arg.LeaseSeconds,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const claimStuckAIPersonReplyVoiceByID = `-- name: ClaimStuckAIPersonReplyVoiceByID :execrows
+update ai_person_reply_voices rv set started_at = now(), task_id = null
+from ai_person_replies r
+where rv.id = $1 and r.id = rv.ai_person_reply_id and rv.status = 'processing'
+and coalesce(rv.started_at, r.timestamp) < now() - make_interval(secs => $2::float8)
+`
+
+type ClaimStuckAIPersonReplyVoiceByIDParams struct {
+	ID           int64
+	StuckSeconds float64
+}
+
+// Claim the stuck reply voice for the reaper, which releases the claim of the stuck task so that the next task may claim it.
+func (q *Queries) ClaimStuckAIPersonReplyVoiceByID(ctx context.Context, arg ClaimStuckAIPersonReplyVoiceByIDParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, claimStuckAIPersonReplyVoiceByID, arg.ID, arg.StuckSeconds)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const claimStuckVoiceModelByID = `-- name: ClaimStuckVoiceModelByID :execrows
+update voice_models set started_at = now(), task_id = null
+where id = $1 and status = 'processing' and coalesce(started_at, timestamp) < now() - make_interval(secs => $2::float8)
+`
+
+type ClaimStuckVoiceModelByIDParams struct {
+	ID           int64
+	StuckSeconds float64
+}
+
+// Claim the stuck model for the reaper, which releases the claim of the stuck task so that the next task may claim it.
+func (q *Queries) ClaimStuckVoiceModelByID(ctx context.Context, arg ClaimStuckVoiceModelByIDParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, claimStuckVoiceModelByID, arg.ID, arg.StuckSeconds)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const claimVoiceModelByID = `-- name: ClaimVoiceModelByID :execrows
+update voice_models set status = 'processing', attempts = case when status = 'failed' then 1 else attempts + 1 end,
+started_at = now(), finished_at = null, task_id = $1
+where id = $2 and (status = 'processing' or (status = 'failed' and $3::boolean))
+and (task_id is null or finished_at is not null or started_at < now() - make_interval(secs => $4::float8))
+`
+
+type ClaimVoiceModelByIDParams struct {
+	TaskID       sql.NullString
+	ID           int64
+	Requeued     bool
+	LeaseSeconds float64
+}
+
+// Claim the model for the task, unless the model is no longer in processing, or another task is still working on it.
+// A retry claims the model again after the failed attempt has finished, and any task claims it after a crashed attempt's lease.
+// A task requeued from the dead letters also claims the failed model, putting it back into processing with its attempts starting over.
+func (q *Queries) ClaimVoiceModelByID(ctx context.Context, arg ClaimVoiceModelByIDParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, claimVoiceModelByID,
+		arg.TaskID,
+		arg.ID,
+		arg.Requeued,
+		arg.LeaseSeconds,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const createAIPerson = `-- name: CreateAIPerson :one
-insert into ai_persons (user_id, name, context_prompt) values ($1, $2, $3) returning id, user_id, name, context_prompt
+insert into ai_persons (user_id, name, context_prompt, expressive_cues) values ($1, $2, $3, $4) returning id, user_id, name, context_prompt, expressive_cues
 `
 
 type CreateAIPersonParams struct {
-	UserID        int64
-	Name          string
-	ContextPrompt string
+	UserID         int64
+	Name           string
+	ContextPrompt  string
+	ExpressiveCues bool
 }
 
 func (q *Queries) CreateAIPerson(ctx context.Context, arg CreateAIPersonParams) (AiPerson, error) {
-	row := q.db.QueryRowContext(ctx, createAIPerson, arg.UserID, arg.Name, arg.ContextPrompt)
+	row := q.db.QueryRowContext(ctx, createAIPerson,
+		arg.UserID,
+		arg.Name,
+		arg.ContextPrompt,
+		arg.ExpressiveCues,
+	)
 	var i AiPerson
 	err := row.Scan(
 		&i.ID,
 		&i.UserID,
 		&i.Name,
 		&i.ContextPrompt,
+		&i.ExpressiveCues,
 	)
 	return i, err
 }
 
 const createAIPersonReply = `-- name: CreateAIPersonReply :one
-insert into ai_person_replies (user_prompt_id, status, message, timestamp) values ($1, $2, $3, $4) returning id, user_prompt_id, status, message, timestamp
+insert into ai_person_replies (user_prompt_id, status, message, timestamp) values ($1, $2, $3, $4) returning id, user_prompt_id, status, message, timestamp, error_message, attempts, started_at, finished_at, speech_markup
 `
 
 type CreateAIPersonReplyParams struct {
@@ -58,12 +191,17 @@ func (q *Queries) CreateAIPersonReply(ctx context.Context, arg CreateAIPersonRep
 		&i.Status,
 		&i.Message,
 		&i.Timestamp,
+		&i.ErrorMessage,
+		&i.Attempts,
+		&i.StartedAt,
+		&i.FinishedAt,
+		&i.SpeechMarkup,
 	)
 	return i, err
 }
 
 const createAIPersonReplyVoice = `-- name: CreateAIPersonReplyVoice :one
-insert into ai_person_reply_voices (ai_person_reply_id, status, file_name) values ($1, $2, $3) returning id, ai_person_reply_id, status, file_name
+insert into ai_person_reply_voices (ai_person_reply_id, status, file_name) values ($1, $2, $3) returning id, ai_person_reply_id, status, file_name, error_message, attempts, started_at, finished_at, task_id
 `
 
 type CreateAIPersonReplyVoiceParams struct {
@@ -80,6 +218,101 @@ func (q *Queries) CreateAIPersonReplyVoice(ctx context.Context, arg CreateAIPers
 		&i.AiPersonReplyID,
 		&i.Status,
 		&i.FileName,
+		&i.ErrorMessage,
+		&i.Attempts,
+		&i.StartedAt,
+		&i.FinishedAt,
+		&i.TaskID,
+	)
+	return i, err
+}
+
+const createIdempotencyKey = `-- name: CreateIdempotencyKey :execrows
+insert into idempotency_keys (request_path, idempotency_key, created_at) values ($1, $2, now())
+on conflict (request_path, idempotency_key) do update set response_status = null, response_body = null, created_at = now()
+where idempotency_keys.created_at < now() - make_interval(secs => $3::float8)
+or (idempotency_keys.response_status is null and idempotency_keys.created_at < now() - make_interval(secs => $4::float8))
+`
+
+type CreateIdempotencyKeyParams struct {
+	RequestPath    string
+	IdempotencyKey string
+	TtlSeconds     float64
+	LeaseSeconds   float64
+}
+
+// Record the idempotency key of a request, or take over an expired one, or one left in progress by a request that did
+// not finish within the lease. Nothing is affected if the key is taken.
+func (q *Queries) CreateIdempotencyKey(ctx context.Context, arg CreateIdempotencyKeyParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, createIdempotencyKey,
+		arg.RequestPath,
+		arg.IdempotencyKey,
+		arg.TtlSeconds,
+		arg.LeaseSeconds,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const createTTSCacheEntry = `-- name: CreateTTSCacheEntry :exec
+insert into tts_cache_entries (cache_key, model_file_name, size_bytes, created_at, last_used_at)
+values ($1, $2, $3, now(), now())
+on conflict (cache_key) do update set size_bytes = excluded.size_bytes, last_used_at = now()
+`
+
+type CreateTTSCacheEntryParams struct {
+	CacheKey      string
+	ModelFileName string
+	SizeBytes     int64
+}
+
+func (q *Queries) CreateTTSCacheEntry(ctx context.Context, arg CreateTTSCacheEntryParams) error {
+	_, err := q.db.ExecContext(ctx, createTTSCacheEntry, arg.CacheKey, arg.ModelFileName, arg.SizeBytes)
+	return err
+}
+
+const createTaskOutboxEntry = `-- name: CreateTaskOutboxEntry :one
+insert into task_outbox (body, created_at) values ($1, now()) returning id, body, created_at, sent_at
+`
+
+func (q *Queries) CreateTaskOutboxEntry(ctx context.Context, body []byte) (TaskOutbox, error) {
+	row := q.db.QueryRowContext(ctx, createTaskOutboxEntry, body)
+	var i TaskOutbox
+	err := row.Scan(
+		&i.ID,
+		&i.Body,
+		&i.CreatedAt,
+		&i.SentAt,
+	)
+	return i, err
+}
+
+const createTaskQueueJob = `-- name: CreateTaskQueueJob :one
+insert into task_queue_jobs (queue, body, visible_at, delivery_count, created_at)
+values ($1, $2, now() + make_interval(secs => $3::float8), 0, now()) returning id, queue, body, visible_at, delivery_count, created_at, dead_lettered_at, dead_letter_reason, dead_letter_description
+`
+
+type CreateTaskQueueJobParams struct {
+	Queue        string
+	Body         []byte
+	DelaySeconds float64
+}
+
+func (q *Queries) CreateTaskQueueJob(ctx context.Context, arg CreateTaskQueueJobParams) (TaskQueueJob, error) {
+	row := q.db.QueryRowContext(ctx, createTaskQueueJob, arg.Queue, arg.Body, arg.DelaySeconds)
+	var i TaskQueueJob
+	err := row.Scan(
+		&i.ID,
+		&i.Queue,
+		&i.Body,
+		&i.VisibleAt,
+		&i.DeliveryCount,
+		&i.CreatedAt,
+		&i.DeadLetteredAt,
+		&i.DeadLetterReason,
+		&i.DeadLetterDescription,
 	)
 	return i, err
 }
@@ -140,14 +373,20 @@ func (q *Queries) CreateUserTextPrompt(ctx context.Context, arg CreateUserTextPr
 }
 
 const createUserVoicePrompt = `-- name: CreateUserVoicePrompt :one
-insert into user_voice_prompts (user_prompt_id, status, file_name, transcription) values ($1, $2, $3, $4) returning id, user_prompt_id, status, file_name, transcription
+insert into user_voice_prompts (user_prompt_id, status, file_name, transcription, audio_format, sample_rate, channels, bits_per_sample, duration_seconds)
+values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id, user_prompt_id, status, file_name, transcription, error_message, attempts, started_at, finished_at, audio_format, sample_rate, channels, bits_per_sample, duration_seconds
 `
 
 type CreateUserVoicePromptParams struct {
-	UserPromptID  int64
-	Status        string
-	FileName      string
-	Transcription sql.NullString
+	UserPromptID    int64
+	Status          string
+	FileName        string
+	Transcription   sql.NullString
+	AudioFormat     string
+	SampleRate      int32
+	Channels        int32
+	BitsPerSample   int32
+	DurationSeconds float64
 }
 
 func (q *Queries) CreateUserVoicePrompt(ctx context.Context, arg CreateUserVoicePromptParams) (UserVoicePrompt, error) {
@@ -156,6 +395,11 @@ func (q *Queries) CreateUserVoicePrompt(ctx context.Context, arg CreateUserVoice
 		arg.Status,
 		arg.FileName,
 		arg.Transcription,
+		arg.AudioFormat,
+		arg.SampleRate,
+		arg.Channels,
+		arg.BitsPerSample,
+		arg.DurationSeconds,
 	)
 	var i UserVoicePrompt
 	err := row.Scan(
@@ -164,12 +408,21 @@ func (q *Queries) CreateUserVoicePrompt(ctx context.Context, arg CreateUserVoice
 		&i.Status,
 		&i.FileName,
 		&i.Transcription,
+		&i.ErrorMessage,
+		&i.Attempts,
+		&i.StartedAt,
+		&i.FinishedAt,
+		&i.AudioFormat,
+		&i.SampleRate,
+		&i.Channels,
+		&i.BitsPerSample,
+		&i.DurationSeconds,
 	)
 	return i, err
 }
 
 const createVoiceModel = `-- name: CreateVoiceModel :one
-insert into voice_models (voice_sample_id, status, file_name, timestamp) values ($1, $2, $3, $4) returning id, voice_sample_id, status, file_name, timestamp
+insert into voice_models (voice_sample_id, status, file_name, timestamp) values ($1, $2, $3, $4) returning id, voice_sample_id, status, file_name, timestamp, error_message, attempts, started_at, finished_at, task_id
 `
 
 type CreateVoiceModelParams struct {
@@ -193,34 +446,294 @@ func (q *Queries) CreateVoiceModel(ctx context.Context, arg CreateVoiceModelPara
 		&i.Status,
 		&i.FileName,
 		&i.Timestamp,
+		&i.ErrorMessage,
+		&i.Attempts,
+		&i.StartedAt,
+		&i.FinishedAt,
+		&i.TaskID,
 	)
 	return i, err
 }
 
 const createVoiceSample = `-- name: CreateVoiceSample :one
-insert into voice_samples (ai_person_id, file_name, timestamp) values ($1, $2, $3) returning id, ai_person_id, file_name, timestamp
+insert into voice_samples (ai_person_id, file_name, timestamp, audio_format, sample_rate, channels, bits_per_sample, duration_seconds)
+values ($1, $2, $3, $4, $5, $6, $7, $8) returning id, ai_person_id, file_name, timestamp, audio_format, sample_rate, channels, bits_per_sample, duration_seconds
 `
 
 type CreateVoiceSampleParams struct {
-	AiPersonID int64
-	FileName   sql.NullString
-	Timestamp  time.Time
+	AiPersonID      int64
+	FileName        sql.NullString
+	Timestamp       time.Time
+	AudioFormat     string
+	SampleRate      int32
+	Channels        int32
+	BitsPerSample   int32
+	DurationSeconds float64
 }
 
 func (q *Queries) CreateVoiceSample(ctx context.Context, arg CreateVoiceSampleParams) (VoiceSample, error) {
-	row := q.db.QueryRowContext(ctx, createVoiceSample, arg.AiPersonID, arg.FileName, arg.Timestamp)
+	row := q.db.QueryRowContext(ctx, createVoiceSample,
+		arg.AiPersonID,
+		arg.FileName,
+		arg.Timestamp,
+		arg.AudioFormat,
+		arg.SampleRate,
+		arg.Channels,
+		arg.BitsPerSample,
+		arg.DurationSeconds,
+	)
 	var i VoiceSample
 	err := row.Scan(
 		&i.ID,
 		&i.AiPersonID,
 		&i.FileName,
 		&i.Timestamp,
+		&i.AudioFormat,
+		&i.SampleRate,
+		&i.Channels,
+		&i.BitsPerSample,
+		&i.DurationSeconds,
 	)
 	return i, err
 }
 
+const deadLetterTaskQueueJob = `-- name: DeadLetterTaskQueueJob :execrows
+update task_queue_jobs set dead_lettered_at = now(), dead_letter_reason = $3, dead_letter_description = $4
+where id = $1 and delivery_count = $2
+`
+
+type DeadLetterTaskQueueJobParams struct {
+	ID                    int64
+	DeliveryCount         int32
+	DeadLetterReason      sql.NullString
+	DeadLetterDescription sql.NullString
+}
+
+func (q *Queries) DeadLetterTaskQueueJob(ctx context.Context, arg DeadLetterTaskQueueJobParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deadLetterTaskQueueJob,
+		arg.ID,
+		arg.DeliveryCount,
+		arg.DeadLetterReason,
+		arg.DeadLetterDescription,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteDeadGPUWorkers = `-- name: DeleteDeadGPUWorkers :execrows
+delete from gpu_workers where heartbeat_at < now() - make_interval(secs => $1::float8)
+`
+
+// Forget the workers that have stopped or died a while ago.
+func (q *Queries) DeleteDeadGPUWorkers(ctx context.Context, retentionSeconds float64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteDeadGPUWorkers, retentionSeconds)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteIdempotencyKey = `-- name: DeleteIdempotencyKey :exec
+delete from idempotency_keys where request_path = $1 and idempotency_key = $2
+`
+
+type DeleteIdempotencyKeyParams struct {
+	RequestPath    string
+	IdempotencyKey string
+}
+
+func (q *Queries) DeleteIdempotencyKey(ctx context.Context, arg DeleteIdempotencyKeyParams) error {
+	_, err := q.db.ExecContext(ctx, deleteIdempotencyKey, arg.RequestPath, arg.IdempotencyKey)
+	return err
+}
+
+const deleteSentTaskOutboxEntries = `-- name: DeleteSentTaskOutboxEntries :execrows
+delete from task_outbox where sent_at < now() - make_interval(secs => $1::float8)
+`
+
+func (q *Queries) DeleteSentTaskOutboxEntries(ctx context.Context, retentionSeconds float64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteSentTaskOutboxEntries, retentionSeconds)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteTTSCacheEntry = `-- name: DeleteTTSCacheEntry :exec
+delete from tts_cache_entries where cache_key = $1
+`
+
+func (q *Queries) DeleteTTSCacheEntry(ctx context.Context, cacheKey string) error {
+	_, err := q.db.ExecContext(ctx, deleteTTSCacheEntry, cacheKey)
+	return err
+}
+
+const deleteTaskQueueJob = `-- name: DeleteTaskQueueJob :execrows
+delete from task_queue_jobs where id = $1 and delivery_count = $2
+`
+
+type DeleteTaskQueueJobParams struct {
+	ID            int64
+	DeliveryCount int32
+}
+
+func (q *Queries) DeleteTaskQueueJob(ctx context.Context, arg DeleteTaskQueueJobParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteTaskQueueJob, arg.ID, arg.DeliveryCount)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteVoiceModelTTSSettings = `-- name: DeleteVoiceModelTTSSettings :execrows
+delete from voice_model_tts_settings where voice_model_id = $1
+`
+
+func (q *Queries) DeleteVoiceModelTTSSettings(ctx context.Context, voiceModelID int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteVoiceModelTTSSettings, voiceModelID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const evictTTSCacheEntries = `-- name: EvictTTSCacheEntries :many
+delete from tts_cache_entries where cache_key in (
+    select cache_key from (
+        select cache_key, last_used_at, sum(size_bytes) over (order by last_used_at desc, cache_key) as cumulative_bytes
+        from tts_cache_entries
+    ) as lru
+    where lru.cumulative_bytes > $1::bigint or lru.last_used_at < now() - make_interval(secs => $2::float8)
+) returning cache_key
+`
+
+type EvictTTSCacheEntriesParams struct {
+	MaxBytes      int64
+	MaxAgeSeconds float64
+}
+
+// Evict the least recently used entries beyond the size budget, and the entries unused for longer than the max age.
+func (q *Queries) EvictTTSCacheEntries(ctx context.Context, arg EvictTTSCacheEntriesParams) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, evictTTSCacheEntries, arg.MaxBytes, arg.MaxAgeSeconds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var cache_key string
+		if err := rows.Scan(&cache_key); err != nil {
+			return nil, err
+		}
+		items = append(items, cache_key)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const finishAIPersonReplyByID = `-- name: FinishAIPersonReplyByID :exec
+update ai_person_replies set status = $2, message = $3, error_message = $4, speech_markup = $5, finished_at = now() where id = $1
+`
+
+type FinishAIPersonReplyByIDParams struct {
+	ID           int64
+	Status       string
+	Message      string
+	ErrorMessage sql.NullString
+	SpeechMarkup sql.NullString
+}
+
+func (q *Queries) FinishAIPersonReplyByID(ctx context.Context, arg FinishAIPersonReplyByIDParams) error {
+	_, err := q.db.ExecContext(ctx, finishAIPersonReplyByID,
+		arg.ID,
+		arg.Status,
+		arg.Message,
+		arg.ErrorMessage,
+		arg.SpeechMarkup,
+	)
+	return err
+}
+
+const finishAIPersonReplyVoiceByID = `-- name: FinishAIPersonReplyVoiceByID :exec
+update ai_person_reply_voices set status = $1, file_name = $2, error_message = $3, finished_at = now()
+where id = $4 and status = 'processing' and task_id is not distinct from $5
+`
+
+type FinishAIPersonReplyVoiceByIDParams struct {
+	Status       string
+	FileName     sql.NullString
+	ErrorMessage sql.NullString
+	ID           int64
+	TaskID       sql.NullString
+}
+
+// The task finishes the record only while holding the claim, and a record never claimed by a task is finished with a null task ID.
+func (q *Queries) FinishAIPersonReplyVoiceByID(ctx context.Context, arg FinishAIPersonReplyVoiceByIDParams) error {
+	_, err := q.db.ExecContext(ctx, finishAIPersonReplyVoiceByID,
+		arg.Status,
+		arg.FileName,
+		arg.ErrorMessage,
+		arg.ID,
+		arg.TaskID,
+	)
+	return err
+}
+
+const finishUserVoicePromptByID = `-- name: FinishUserVoicePromptByID :exec
+update user_voice_prompts set status = $2, transcription = $3, error_message = $4, finished_at = now() where id = $1
+`
+
+type FinishUserVoicePromptByIDParams struct {
+	ID            int64
+	Status        string
+	Transcription sql.NullString
+	ErrorMessage  sql.NullString
+}
+
+func (q *Queries) FinishUserVoicePromptByID(ctx context.Context, arg FinishUserVoicePromptByIDParams) error {
+	_, err := q.db.ExecContext(ctx, finishUserVoicePromptByID,
+		arg.ID,
+		arg.Status,
+		arg.Transcription,
+		arg.ErrorMessage,
+	)
+	return err
+}
+
+const finishVoiceModelByID = `-- name: FinishVoiceModelByID :exec
+update voice_models set status = $1, file_name = $2, error_message = $3, finished_at = now()
+where id = $4 and status = 'processing' and task_id is not distinct from $5
+`
+
+type FinishVoiceModelByIDParams struct {
+	Status       string
+	FileName     sql.NullString
+	ErrorMessage sql.NullString
+	ID           int64
+	TaskID       sql.NullString
+}
+
+// The task finishes the record only while holding the claim, and a record never claimed by a task is finished with a null task ID.
+func (q *Queries) FinishVoiceModelByID(ctx context.Context, arg FinishVoiceModelByIDParams) error {
+	_, err := q.db.ExecContext(ctx, finishVoiceModelByID,
+		arg.Status,
+		arg.FileName,
+		arg.ErrorMessage,
+		arg.ID,
+		arg.TaskID,
+	)
+	return err
+}
+
 const getAIPerson = `-- name: GetAIPerson :one
-select id, user_id, name, context_prompt from ai_persons where id = $1
+select id, user_id, name, context_prompt, expressive_cues from ai_persons where id = $1
 `
 
 func (q *Queries) GetAIPerson(ctx context.Context, id int64) (AiPerson, error) {
@@ -231,12 +744,13 @@ func (q *Queries) GetAIPerson(ctx context.Context, id int64) (AiPerson, error) {
 		&i.UserID,
 		&i.Name,
 		&i.ContextPrompt,
+		&i.ExpressiveCues,
 	)
 	return i, err
 }
 
 const getAIPersonReplyByID = `-- name: GetAIPersonReplyByID :one
-select id, user_prompt_id, status, message, timestamp from ai_person_replies where id = $1
+select id, user_prompt_id, status, message, timestamp, error_message, attempts, started_at, finished_at, speech_markup from ai_person_replies where id = $1
 `
 
 func (q *Queries) GetAIPersonReplyByID(ctx context.Context, id int64) (AiPersonReply, error) {
@@ -248,12 +762,17 @@ func (q *Queries) GetAIPersonReplyByID(ctx context.Context, id int64) (AiPersonR
 		&i.Status,
 		&i.Message,
 		&i.Timestamp,
+		&i.ErrorMessage,
+		&i.Attempts,
+		&i.StartedAt,
+		&i.FinishedAt,
+		&i.SpeechMarkup,
 	)
 	return i, err
 }
 
 const getAIPersonReplyVoiceByID = `-- name: GetAIPersonReplyVoiceByID :one
-select id, ai_person_reply_id, status, file_name from ai_person_reply_voices where id = $1
+select id, ai_person_reply_id, status, file_name, error_message, attempts, started_at, finished_at, task_id from ai_person_reply_voices where id = $1
 `
 
 func (q *Queries) GetAIPersonReplyVoiceByID(ctx context.Context, id int64) (AiPersonReplyVoice, error) {
@@ -264,13 +783,66 @@ func (q *Queries) GetAIPersonReplyVoiceByID(ctx context.Context, id int64) (AiPe
 		&i.AiPersonReplyID,
 		&i.Status,
 		&i.FileName,
+		&i.ErrorMessage,
+		&i.Attempts,
+		&i.StartedAt,
+		&i.FinishedAt,
+		&i.TaskID,
+	)
+	return i, err
+}
+
+const getDeadLetterTaskQueueJob = `-- name: GetDeadLetterTaskQueueJob :one
+select id, queue, body, visible_at, delivery_count, created_at, dead_lettered_at, dead_letter_reason, dead_letter_description from task_queue_jobs where id = $1 and queue = $2 and dead_lettered_at is not null
+`
+
+type GetDeadLetterTaskQueueJobParams struct {
+	ID    int64
+	Queue string
+}
+
+func (q *Queries) GetDeadLetterTaskQueueJob(ctx context.Context, arg GetDeadLetterTaskQueueJobParams) (TaskQueueJob, error) {
+	row := q.db.QueryRowContext(ctx, getDeadLetterTaskQueueJob, arg.ID, arg.Queue)
+	var i TaskQueueJob
+	err := row.Scan(
+		&i.ID,
+		&i.Queue,
+		&i.Body,
+		&i.VisibleAt,
+		&i.DeliveryCount,
+		&i.CreatedAt,
+		&i.DeadLetteredAt,
+		&i.DeadLetterReason,
+		&i.DeadLetterDescription,
+	)
+	return i, err
+}
+
+const getIdempotencyKey = `-- name: GetIdempotencyKey :one
+select request_path, idempotency_key, response_status, response_body, created_at from idempotency_keys where request_path = $1 and idempotency_key = $2
+`
+
+type GetIdempotencyKeyParams struct {
+	RequestPath    string
+	IdempotencyKey string
+}
+
+func (q *Queries) GetIdempotencyKey(ctx context.Context, arg GetIdempotencyKeyParams) (IdempotencyKey, error) {
+	row := q.db.QueryRowContext(ctx, getIdempotencyKey, arg.RequestPath, arg.IdempotencyKey)
+	var i IdempotencyKey
+	err := row.Scan(
+		&i.RequestPath,
+		&i.IdempotencyKey,
+		&i.ResponseStatus,
+		&i.ResponseBody,
+		&i.CreatedAt,
 	)
 	return i, err
 }
 
 const getLatestVoiceModel = `-- name: GetLatestVoiceModel :one
 select m.id as id, m.status as status, m.file_name as file_name, m.timestamp as timestamp,
-a.user_id as user_id, a.name as ai_name, a.context_prompt as ai_context_prompt
+a.user_id as user_id, a.name as ai_name, a.context_prompt as ai_context_prompt, a.expressive_cues as ai_expressive_cues
 from voice_models m
 join voice_samples s on m.voice_sample_id = s.id
 join ai_persons a on s.ai_person_id = a.id and a.id = $1
@@ -280,13 +852,14 @@ limit 1
 `
 
 type GetLatestVoiceModelRow struct {
-	ID              int64
-	Status          string
-	FileName        sql.NullString
-	Timestamp       time.Time
-	UserID          int64
-	AiName          string
-	AiContextPrompt string
+	ID               int64
+	Status           string
+	FileName         sql.NullString
+	Timestamp        time.Time
+	UserID           int64
+	AiName           string
+	AiContextPrompt  string
+	AiExpressiveCues bool
 }
 
 func (q *Queries) GetLatestVoiceModel(ctx context.Context, id int64) (GetLatestVoiceModelRow, error) {
@@ -300,6 +873,50 @@ func (q *Queries) GetLatestVoiceModel(ctx context.Context, id int64) (GetLatestV
 		&i.UserID,
 		&i.AiName,
 		&i.AiContextPrompt,
+		&i.AiExpressiveCues,
+	)
+	return i, err
+}
+
+const getTTSSettings = `-- name: GetTTSSettings :one
+select 'voice-model'::text as source, preset, top_k, top_p, mineos_p, semantic_temp, waveform_temp, fine_temp
+from voice_model_tts_settings where voice_model_id = $1
+union all
+select 'ai-person'::text as source, preset, top_k, top_p, mineos_p, semantic_temp, waveform_temp, fine_temp
+from ai_person_tts_settings where ai_person_id = $2
+order by source desc
+limit 1
+`
+
+type GetTTSSettingsParams struct {
+	VoiceModelID int64
+	AiPersonID   int64
+}
+
+type GetTTSSettingsRow struct {
+	Source       string
+	Preset       string
+	TopK         float64
+	TopP         float64
+	MineosP      float64
+	SemanticTemp float64
+	WaveformTemp float64
+	FineTemp     float64
+}
+
+// The settings of the voice model override the settings of the AI person.
+func (q *Queries) GetTTSSettings(ctx context.Context, arg GetTTSSettingsParams) (GetTTSSettingsRow, error) {
+	row := q.db.QueryRowContext(ctx, getTTSSettings, arg.VoiceModelID, arg.AiPersonID)
+	var i GetTTSSettingsRow
+	err := row.Scan(
+		&i.Source,
+		&i.Preset,
+		&i.TopK,
+		&i.TopP,
+		&i.MineosP,
+		&i.SemanticTemp,
+		&i.WaveformTemp,
+		&i.FineTemp,
 	)
 	return i, err
 }
@@ -322,7 +939,7 @@ func (q *Queries) GetUserByName(ctx context.Context, name string) (User, error)
 }
 
 const getVoiceModelByID = `-- name: GetVoiceModelByID :one
-select id, voice_sample_id, status, file_name, timestamp from voice_models where id = $1
+select id, voice_sample_id, status, file_name, timestamp, error_message, attempts, started_at, finished_at, task_id from voice_models where id = $1
 `
 
 func (q *Queries) GetVoiceModelByID(ctx context.Context, id int64) (VoiceModel, error) {
@@ -334,12 +951,17 @@ func (q *Queries) GetVoiceModelByID(ctx context.Context, id int64) (VoiceModel,
 		&i.Status,
 		&i.FileName,
 		&i.Timestamp,
+		&i.ErrorMessage,
+		&i.Attempts,
+		&i.StartedAt,
+		&i.FinishedAt,
+		&i.TaskID,
 	)
 	return i, err
 }
 
 const getVoiceModelByVoiceSample = `-- name: GetVoiceModelByVoiceSample :one
-select id, voice_sample_id, status, file_name, timestamp from voice_models where voice_sample_id = $1
+select id, voice_sample_id, status, file_name, timestamp, error_message, attempts, started_at, finished_at, task_id from voice_models where voice_sample_id = $1
 `
 
 func (q *Queries) GetVoiceModelByVoiceSample(ctx context.Context, voiceSampleID int64) (VoiceModel, error) {
@@ -351,12 +973,17 @@ func (q *Queries) GetVoiceModelByVoiceSample(ctx context.Context, voiceSampleID
 		&i.Status,
 		&i.FileName,
 		&i.Timestamp,
+		&i.ErrorMessage,
+		&i.Attempts,
+		&i.StartedAt,
+		&i.FinishedAt,
+		&i.TaskID,
 	)
 	return i, err
 }
 
 const getVoiceSampleByID = `-- name: GetVoiceSampleByID :one
-select id, ai_person_id, file_name, timestamp from voice_samples where id = $1 limit 1
+select id, ai_person_id, file_name, timestamp, audio_format, sample_rate, channels, bits_per_sample, duration_seconds from voice_samples where id = $1 limit 1
 `
 
 func (q *Queries) GetVoiceSampleByID(ctx context.Context, id int64) (VoiceSample, error) {
@@ -367,12 +994,38 @@ func (q *Queries) GetVoiceSampleByID(ctx context.Context, id int64) (VoiceSample
 		&i.AiPersonID,
 		&i.FileName,
 		&i.Timestamp,
+		&i.AudioFormat,
+		&i.SampleRate,
+		&i.Channels,
+		&i.BitsPerSample,
+		&i.DurationSeconds,
 	)
 	return i, err
 }
 
+const heartbeatGPUWorker = `-- name: HeartbeatGPUWorker :exec
+update gpu_workers set heartbeat_at = now(), current_tasks = $2, last_error = $3, last_error_at = $4 where id = $1
+`
+
+type HeartbeatGPUWorkerParams struct {
+	ID           string
+	CurrentTasks []string
+	LastError    sql.NullString
+	LastErrorAt  sql.NullTime
+}
+
+func (q *Queries) HeartbeatGPUWorker(ctx context.Context, arg HeartbeatGPUWorkerParams) error {
+	_, err := q.db.ExecContext(ctx, heartbeatGPUWorker,
+		arg.ID,
+		pq.Array(arg.CurrentTasks),
+		arg.LastError,
+		arg.LastErrorAt,
+	)
+	return err
+}
+
 const listAIPersons = `-- name: ListAIPersons :many
-select id, user_id, name, context_prompt from ai_persons where user_id = $1 order by id
+select id, user_id, name, context_prompt, expressive_cues from ai_persons where user_id = $1 order by id
 `
 
 func (q *Queries) ListAIPersons(ctx context.Context, userID int64) ([]AiPerson, error) {
@@ -389,6 +1042,7 @@ func (q *Queries) ListAIPersons(ctx context.Context, userID int64) ([]AiPerson,
 			&i.UserID,
 			&i.Name,
 			&i.ContextPrompt,
+			&i.ExpressiveCues,
 		); err != nil {
 			return nil, err
 		}
@@ -406,9 +1060,9 @@ func (q *Queries) ListAIPersons(ctx context.Context, userID int64) ([]AiPerson,
 const listConversations = `-- name: ListConversations :many
 select u.id as id, u.ai_person_id as ai_person_id, u.timestamp as timestamp,
 t.message as text_message,
-v.status as voice_status, v.file_name as voice_filename, v.transcription as voice_transcription,
-r.status as reply_status, r.message as reply_message, r.timestamp as reply_timestamp,
-rv.status as reply_voice_status, rv.file_name as reply_voice_filename
+v.status as voice_status, v.file_name as voice_filename, v.transcription as voice_transcription, v.error_message as voice_error_message,
+r.status as reply_status, r.message as reply_message, r.speech_markup as reply_speech_markup, r.timestamp as reply_timestamp, r.error_message as reply_error_message,
+rv.status as reply_voice_status, rv.file_name as reply_voice_filename, rv.error_message as reply_voice_error_message, rv.attempts as reply_voice_attempts
 from user_prompts u
 left outer join user_text_prompts t on t.user_prompt_id = u.id
 left outer join user_voice_prompts v on v.user_prompt_id = u.id
@@ -425,18 +1079,23 @@ type ListConversationsParams struct {
 }
 
 type ListConversationsRow struct {
-	ID                 int64
-	AiPersonID         int64
-	Timestamp          time.Time
-	TextMessage        sql.NullString
-	VoiceStatus        sql.NullString
-	VoiceFilename      sql.NullString
-	VoiceTranscription sql.NullString
-	ReplyStatus        sql.NullString
-	ReplyMessage       sql.NullString
-	ReplyTimestamp     sql.NullTime
-	ReplyVoiceStatus   sql.NullString
-	ReplyVoiceFilename sql.NullString
+	ID                     int64
+	AiPersonID             int64
+	Timestamp              time.Time
+	TextMessage            sql.NullString
+	VoiceStatus            sql.NullString
+	VoiceFilename          sql.NullString
+	VoiceTranscription     sql.NullString
+	VoiceErrorMessage      sql.NullString
+	ReplyStatus            sql.NullString
+	ReplyMessage           sql.NullString
+	ReplySpeechMarkup      sql.NullString
+	ReplyTimestamp         sql.NullTime
+	ReplyErrorMessage      sql.NullString
+	ReplyVoiceStatus       sql.NullString
+	ReplyVoiceFilename     sql.NullString
+	ReplyVoiceErrorMessage sql.NullString
+	ReplyVoiceAttempts     sql.NullInt32
 }
 
 func (q *Queries) ListConversations(ctx context.Context, arg ListConversationsParams) ([]ListConversationsRow, error) {
@@ -456,11 +1115,16 @@ func (q *Queries) ListConversations(ctx context.Context, arg ListConversationsPa
 			&i.VoiceStatus,
 			&i.VoiceFilename,
 			&i.VoiceTranscription,
+			&i.VoiceErrorMessage,
 			&i.ReplyStatus,
 			&i.ReplyMessage,
+			&i.ReplySpeechMarkup,
 			&i.ReplyTimestamp,
+			&i.ReplyErrorMessage,
 			&i.ReplyVoiceStatus,
 			&i.ReplyVoiceFilename,
+			&i.ReplyVoiceErrorMessage,
+			&i.ReplyVoiceAttempts,
 		); err != nil {
 			return nil, err
 		}
@@ -475,12 +1139,347 @@ func (q *Queries) ListConversations(ctx context.Context, arg ListConversationsPa
 	return items, nil
 }
 
-const listUsers = `-- name: ListUsers :many
-select id, name, password, status, challenge from users
+const listDeadLetterTaskQueueJobs = `-- name: ListDeadLetterTaskQueueJobs :many
+select id, queue, body, visible_at, delivery_count, created_at, dead_lettered_at, dead_letter_reason, dead_letter_description from task_queue_jobs where queue = $1 and dead_lettered_at is not null order by id limit $2
 `
 
-func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
-	rows, err := q.db.QueryContext(ctx, listUsers)
+type ListDeadLetterTaskQueueJobsParams struct {
+	Queue string
+	Limit int32
+}
+
+func (q *Queries) ListDeadLetterTaskQueueJobs(ctx context.Context, arg ListDeadLetterTaskQueueJobsParams) ([]TaskQueueJob, error) {
+	rows, err := q.db.QueryContext(ctx, listDeadLetterTaskQueueJobs, arg.Queue, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TaskQueueJob
+	for rows.Next() {
+		var i TaskQueueJob
+		if err := rows.Scan(
+			&i.ID,
+			&i.Queue,
+			&i.Body,
+			&i.VisibleAt,
+			&i.DeliveryCount,
+			&i.CreatedAt,
+			&i.DeadLetteredAt,
+			&i.DeadLetterReason,
+			&i.DeadLetterDescription,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listGPUWorkers = `-- name: ListGPUWorkers :many
+select id, host_name, voice_service_addrs, task_types, concurrency, version, started_at, heartbeat_at, current_tasks, last_error, last_error_at, stopped_at, (stopped_at is null and heartbeat_at > now() - make_interval(secs => $1::float8))::boolean as alive
+from gpu_workers order by started_at desc limit $2
+`
+
+type ListGPUWorkersParams struct {
+	DeadSeconds float64
+	MaxWorkers  int32
+}
+
+type ListGPUWorkersRow struct {
+	ID                string
+	HostName          string
+	VoiceServiceAddrs []string
+	TaskTypes         []string
+	Concurrency       int32
+	Version           string
+	StartedAt         time.Time
+	HeartbeatAt       time.Time
+	CurrentTasks      []string
+	LastError         sql.NullString
+	LastErrorAt       sql.NullTime
+	StoppedAt         sql.NullTime
+	Alive             bool
+}
+
+func (q *Queries) ListGPUWorkers(ctx context.Context, arg ListGPUWorkersParams) ([]ListGPUWorkersRow, error) {
+	rows, err := q.db.QueryContext(ctx, listGPUWorkers, arg.DeadSeconds, arg.MaxWorkers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListGPUWorkersRow
+	for rows.Next() {
+		var i ListGPUWorkersRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.HostName,
+			pq.Array(&i.VoiceServiceAddrs),
+			pq.Array(&i.TaskTypes),
+			&i.Concurrency,
+			&i.Version,
+			&i.StartedAt,
+			&i.HeartbeatAt,
+			pq.Array(&i.CurrentTasks),
+			&i.LastError,
+			&i.LastErrorAt,
+			&i.StoppedAt,
+			&i.Alive,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listLiveGPUWorkersCachingModel = `-- name: ListLiveGPUWorkersCachingModel :many
+select w.id from gpu_workers w
+join gpu_worker_model_caches c on c.worker_id = w.id
+where c.model_file_name = $1 and w.stopped_at is null and w.heartbeat_at > now() - make_interval(secs => $2::float8)
+order by w.id
+`
+
+type ListLiveGPUWorkersCachingModelParams struct {
+	ModelFileName string
+	DeadSeconds   float64
+}
+
+func (q *Queries) ListLiveGPUWorkersCachingModel(ctx context.Context, arg ListLiveGPUWorkersCachingModelParams) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listLiveGPUWorkersCachingModel, arg.ModelFileName, arg.DeadSeconds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listLiveGPUWorkersServingAIPerson = `-- name: ListLiveGPUWorkersServingAIPerson :many
+select distinct w.id from gpu_workers w
+join gpu_worker_model_caches c on c.worker_id = w.id
+join voice_models m on m.file_name = c.model_file_name
+join voice_samples s on m.voice_sample_id = s.id
+where s.ai_person_id = $1 and w.stopped_at is null and w.heartbeat_at > now() - make_interval(secs => $2::float8)
+order by w.id
+`
+
+type ListLiveGPUWorkersServingAIPersonParams struct {
+	AiPersonID  int64
+	DeadSeconds float64
+}
+
+// The workers that have cached any voice model of the AI person are likely to serve the AI person again.
+func (q *Queries) ListLiveGPUWorkersServingAIPerson(ctx context.Context, arg ListLiveGPUWorkersServingAIPersonParams) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listLiveGPUWorkersServingAIPerson, arg.AiPersonID, arg.DeadSeconds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMostActiveVoiceModels = `-- name: ListMostActiveVoiceModels :many
+select distinct on (a.prompts, s.ai_person_id) s.ai_person_id as ai_person_id, m.file_name as file_name
+from (
+    select u.ai_person_id, count(*) as prompts from user_prompts u
+    where u.timestamp > now() - make_interval(secs => $1::float8)
+    group by u.ai_person_id order by prompts desc limit $2
+) a
+join voice_samples s on s.ai_person_id = a.ai_person_id
+join voice_models m on m.voice_sample_id = s.id and m.status = 'ready'
+order by a.prompts desc, s.ai_person_id, m.timestamp desc
+`
+
+type ListMostActiveVoiceModelsParams struct {
+	SinceSeconds float64
+	MaxAiPersons int32
+}
+
+type ListMostActiveVoiceModelsRow struct {
+	AiPersonID int64
+	FileName   sql.NullString
+}
+
+// The latest ready voice models of the AI persons who received the most prompts recently.
+func (q *Queries) ListMostActiveVoiceModels(ctx context.Context, arg ListMostActiveVoiceModelsParams) ([]ListMostActiveVoiceModelsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listMostActiveVoiceModels, arg.SinceSeconds, arg.MaxAiPersons)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListMostActiveVoiceModelsRow
+	for rows.Next() {
+		var i ListMostActiveVoiceModelsRow
+		if err := rows.Scan(&i.AiPersonID, &i.FileName); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listStuckAIPersonReplyVoices = `-- name: ListStuckAIPersonReplyVoices :many
+select rv.id as id, rv.attempts as attempts, u.ai_person_id as ai_person_id
+from ai_person_reply_voices rv
+join ai_person_replies r on rv.ai_person_reply_id = r.id
+join user_prompts u on r.user_prompt_id = u.id
+where rv.status = 'processing' and coalesce(rv.started_at, r.timestamp) < now() - make_interval(secs => $1::float8)
+order by rv.id
+`
+
+type ListStuckAIPersonReplyVoicesRow struct {
+	ID         int64
+	Attempts   int32
+	AiPersonID int64
+}
+
+func (q *Queries) ListStuckAIPersonReplyVoices(ctx context.Context, stuckSeconds float64) ([]ListStuckAIPersonReplyVoicesRow, error) {
+	rows, err := q.db.QueryContext(ctx, listStuckAIPersonReplyVoices, stuckSeconds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListStuckAIPersonReplyVoicesRow
+	for rows.Next() {
+		var i ListStuckAIPersonReplyVoicesRow
+		if err := rows.Scan(&i.ID, &i.Attempts, &i.AiPersonID); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listStuckVoiceModels = `-- name: ListStuckVoiceModels :many
+select id, voice_sample_id, status, file_name, timestamp, error_message, attempts, started_at, finished_at, task_id from voice_models
+where status = 'processing' and coalesce(started_at, timestamp) < now() - make_interval(secs => $1::float8)
+order by id
+`
+
+func (q *Queries) ListStuckVoiceModels(ctx context.Context, stuckSeconds float64) ([]VoiceModel, error) {
+	rows, err := q.db.QueryContext(ctx, listStuckVoiceModels, stuckSeconds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []VoiceModel
+	for rows.Next() {
+		var i VoiceModel
+		if err := rows.Scan(
+			&i.ID,
+			&i.VoiceSampleID,
+			&i.Status,
+			&i.FileName,
+			&i.Timestamp,
+			&i.ErrorMessage,
+			&i.Attempts,
+			&i.StartedAt,
+			&i.FinishedAt,
+			&i.TaskID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUnsentTaskOutboxEntries = `-- name: ListUnsentTaskOutboxEntries :many
+select id, body, created_at, sent_at from task_outbox where sent_at is null order by id limit $1 for update skip locked
+`
+
+// Lock the oldest unsent entries for the relay, skipping the ones locked by the other relays.
+func (q *Queries) ListUnsentTaskOutboxEntries(ctx context.Context, limit int32) ([]TaskOutbox, error) {
+	rows, err := q.db.QueryContext(ctx, listUnsentTaskOutboxEntries, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TaskOutbox
+	for rows.Next() {
+		var i TaskOutbox
+		if err := rows.Scan(
+			&i.ID,
+			&i.Body,
+			&i.CreatedAt,
+			&i.SentAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsers = `-- name: ListUsers :many
+select id, name, password, status, challenge from users
+`
+
+func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, listUsers)
 	if err != nil {
 		return nil, err
 	}
@@ -508,8 +1507,49 @@ func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
 	return items, nil
 }
 
+const listVoiceModels = `-- name: ListVoiceModels :many
+select m.id, m.voice_sample_id, m.status, m.file_name, m.timestamp, m.error_message, m.attempts, m.started_at, m.finished_at, m.task_id from voice_models m
+join voice_samples s on m.voice_sample_id = s.id
+where s.ai_person_id = $1
+order by m.id
+`
+
+func (q *Queries) ListVoiceModels(ctx context.Context, aiPersonID int64) ([]VoiceModel, error) {
+	rows, err := q.db.QueryContext(ctx, listVoiceModels, aiPersonID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []VoiceModel
+	for rows.Next() {
+		var i VoiceModel
+		if err := rows.Scan(
+			&i.ID,
+			&i.VoiceSampleID,
+			&i.Status,
+			&i.FileName,
+			&i.Timestamp,
+			&i.ErrorMessage,
+			&i.Attempts,
+			&i.StartedAt,
+			&i.FinishedAt,
+			&i.TaskID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listVoiceSamples = `-- name: ListVoiceSamples :many
-select id, ai_person_id, file_name, timestamp from voice_samples where ai_person_id = $1 order by id
+select id, ai_person_id, file_name, timestamp, audio_format, sample_rate, channels, bits_per_sample, duration_seconds from voice_samples where ai_person_id = $1 order by id
 `
 
 func (q *Queries) ListVoiceSamples(ctx context.Context, aiPersonID int64) ([]VoiceSample, error) {
@@ -526,6 +1566,11 @@ func (q *Queries) ListVoiceSamples(ctx context.Context, aiPersonID int64) ([]Voi
 			&i.AiPersonID,
 			&i.FileName,
 			&i.Timestamp,
+			&i.AudioFormat,
+			&i.SampleRate,
+			&i.Channels,
+			&i.BitsPerSample,
+			&i.DurationSeconds,
 		); err != nil {
 			return nil, err
 		}
@@ -540,6 +1585,294 @@ func (q *Queries) ListVoiceSamples(ctx context.Context, aiPersonID int64) ([]Voi
 	return items, nil
 }
 
+const markTaskOutboxEntrySent = `-- name: MarkTaskOutboxEntrySent :exec
+update task_outbox set sent_at = now() where id = $1
+`
+
+func (q *Queries) MarkTaskOutboxEntrySent(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, markTaskOutboxEntrySent, id)
+	return err
+}
+
+const receiveTaskQueueJobs = `-- name: ReceiveTaskQueueJobs :many
+update task_queue_jobs set visible_at = now() + make_interval(secs => $1::float8), delivery_count = delivery_count + 1
+where id in (
+    select j.id from task_queue_jobs j
+    where j.queue = $2 and j.visible_at <= now() and j.dead_lettered_at is null
+    order by j.id
+    limit $3
+    for update skip locked
+)
+returning id, queue, body, visible_at, delivery_count, created_at, dead_lettered_at, dead_letter_reason, dead_letter_description
+`
+
+type ReceiveTaskQueueJobsParams struct {
+	LockSeconds float64
+	Queue       string
+	MaxJobs     int32
+}
+
+func (q *Queries) ReceiveTaskQueueJobs(ctx context.Context, arg ReceiveTaskQueueJobsParams) ([]TaskQueueJob, error) {
+	rows, err := q.db.QueryContext(ctx, receiveTaskQueueJobs, arg.LockSeconds, arg.Queue, arg.MaxJobs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TaskQueueJob
+	for rows.Next() {
+		var i TaskQueueJob
+		if err := rows.Scan(
+			&i.ID,
+			&i.Queue,
+			&i.Body,
+			&i.VisibleAt,
+			&i.DeliveryCount,
+			&i.CreatedAt,
+			&i.DeadLetteredAt,
+			&i.DeadLetterReason,
+			&i.DeadLetterDescription,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const recordGPUWorkerModelCache = `-- name: RecordGPUWorkerModelCache :exec
+insert into gpu_worker_model_caches (worker_id, model_file_name, cached_at) values ($1, $2, now())
+on conflict (worker_id, model_file_name) do update set cached_at = now()
+`
+
+type RecordGPUWorkerModelCacheParams struct {
+	WorkerID      string
+	ModelFileName string
+}
+
+func (q *Queries) RecordGPUWorkerModelCache(ctx context.Context, arg RecordGPUWorkerModelCacheParams) error {
+	_, err := q.db.ExecContext(ctx, recordGPUWorkerModelCache, arg.WorkerID, arg.ModelFileName)
+	return err
+}
+
+const registerGPUWorker = `-- name: RegisterGPUWorker :exec
+insert into gpu_workers (id, host_name, voice_service_addrs, task_types, concurrency, version, started_at, heartbeat_at)
+values ($1, $2, $3, $4, $5, $6, now(), now())
+`
+
+type RegisterGPUWorkerParams struct {
+	ID                string
+	HostName          string
+	VoiceServiceAddrs []string
+	TaskTypes         []string
+	Concurrency       int32
+	Version           string
+}
+
+func (q *Queries) RegisterGPUWorker(ctx context.Context, arg RegisterGPUWorkerParams) error {
+	_, err := q.db.ExecContext(ctx, registerGPUWorker,
+		arg.ID,
+		arg.HostName,
+		pq.Array(arg.VoiceServiceAddrs),
+		pq.Array(arg.TaskTypes),
+		arg.Concurrency,
+		arg.Version,
+	)
+	return err
+}
+
+const renewTaskQueueJobLock = `-- name: RenewTaskQueueJobLock :execrows
+update task_queue_jobs set visible_at = now() + make_interval(secs => $1::float8)
+where id = $2 and delivery_count = $3 and dead_lettered_at is null
+`
+
+type RenewTaskQueueJobLockParams struct {
+	LockSeconds   float64
+	ID            int64
+	DeliveryCount int32
+}
+
+func (q *Queries) RenewTaskQueueJobLock(ctx context.Context, arg RenewTaskQueueJobLockParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, renewTaskQueueJobLock, arg.LockSeconds, arg.ID, arg.DeliveryCount)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const requeueDeadLetterTaskQueueJob = `-- name: RequeueDeadLetterTaskQueueJob :execrows
+update task_queue_jobs set dead_lettered_at = null, dead_letter_reason = null, dead_letter_description = null, visible_at = now(), delivery_count = 0, body = $1
+where id = $2 and queue = $3 and dead_lettered_at is not null
+`
+
+type RequeueDeadLetterTaskQueueJobParams struct {
+	Body  []byte
+	ID    int64
+	Queue string
+}
+
+func (q *Queries) RequeueDeadLetterTaskQueueJob(ctx context.Context, arg RequeueDeadLetterTaskQueueJobParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, requeueDeadLetterTaskQueueJob, arg.Body, arg.ID, arg.Queue)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const saveIdempotencyKeyResponse = `-- name: SaveIdempotencyKeyResponse :exec
+update idempotency_keys set response_status = $3, response_body = $4 where request_path = $1 and idempotency_key = $2
+`
+
+type SaveIdempotencyKeyResponseParams struct {
+	RequestPath    string
+	IdempotencyKey string
+	ResponseStatus sql.NullInt32
+	ResponseBody   []byte
+}
+
+func (q *Queries) SaveIdempotencyKeyResponse(ctx context.Context, arg SaveIdempotencyKeyResponseParams) error {
+	_, err := q.db.ExecContext(ctx, saveIdempotencyKeyResponse,
+		arg.RequestPath,
+		arg.IdempotencyKey,
+		arg.ResponseStatus,
+		arg.ResponseBody,
+	)
+	return err
+}
+
+const setAIPersonTTSSettings = `-- name: SetAIPersonTTSSettings :exec
+insert into ai_person_tts_settings (ai_person_id, preset, top_k, top_p, mineos_p, semantic_temp, waveform_temp, fine_temp, updated_at)
+values ($1, $2, $3, $4, $5, $6, $7, $8, now())
+on conflict (ai_person_id) do update set preset = excluded.preset, top_k = excluded.top_k, top_p = excluded.top_p,
+mineos_p = excluded.mineos_p, semantic_temp = excluded.semantic_temp, waveform_temp = excluded.waveform_temp,
+fine_temp = excluded.fine_temp, updated_at = now()
+`
+
+type SetAIPersonTTSSettingsParams struct {
+	AiPersonID   int64
+	Preset       string
+	TopK         float64
+	TopP         float64
+	MineosP      float64
+	SemanticTemp float64
+	WaveformTemp float64
+	FineTemp     float64
+}
+
+func (q *Queries) SetAIPersonTTSSettings(ctx context.Context, arg SetAIPersonTTSSettingsParams) error {
+	_, err := q.db.ExecContext(ctx, setAIPersonTTSSettings,
+		arg.AiPersonID,
+		arg.Preset,
+		arg.TopK,
+		arg.TopP,
+		arg.MineosP,
+		arg.SemanticTemp,
+		arg.WaveformTemp,
+		arg.FineTemp,
+	)
+	return err
+}
+
+const setVoiceModelTTSSettings = `-- name: SetVoiceModelTTSSettings :exec
+insert into voice_model_tts_settings (voice_model_id, preset, top_k, top_p, mineos_p, semantic_temp, waveform_temp, fine_temp, updated_at)
+values ($1, $2, $3, $4, $5, $6, $7, $8, now())
+on conflict (voice_model_id) do update set preset = excluded.preset, top_k = excluded.top_k, top_p = excluded.top_p,
+mineos_p = excluded.mineos_p, semantic_temp = excluded.semantic_temp, waveform_temp = excluded.waveform_temp,
+fine_temp = excluded.fine_temp, updated_at = now()
+`
+
+type SetVoiceModelTTSSettingsParams struct {
+	VoiceModelID int64
+	Preset       string
+	TopK         float64
+	TopP         float64
+	MineosP      float64
+	SemanticTemp float64
+	WaveformTemp float64
+	FineTemp     float64
+}
+
+func (q *Queries) SetVoiceModelTTSSettings(ctx context.Context, arg SetVoiceModelTTSSettingsParams) error {
+	_, err := q.db.ExecContext(ctx, setVoiceModelTTSSettings,
+		arg.VoiceModelID,
+		arg.Preset,
+		arg.TopK,
+		arg.TopP,
+		arg.MineosP,
+		arg.SemanticTemp,
+		arg.WaveformTemp,
+		arg.FineTemp,
+	)
+	return err
+}
+
+const startAIPersonReplyAttemptByID = `-- name: StartAIPersonReplyAttemptByID :exec
+update ai_person_replies set status = 'processing', attempts = attempts + 1, started_at = now(), finished_at = null where id = $1
+`
+
+func (q *Queries) StartAIPersonReplyAttemptByID(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, startAIPersonReplyAttemptByID, id)
+	return err
+}
+
+const startUserVoicePromptAttemptByID = `-- name: StartUserVoicePromptAttemptByID :exec
+update user_voice_prompts set status = 'processing', attempts = attempts + 1, started_at = now(), finished_at = null where id = $1
+`
+
+func (q *Queries) StartUserVoicePromptAttemptByID(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, startUserVoicePromptAttemptByID, id)
+	return err
+}
+
+const stopGPUWorker = `-- name: StopGPUWorker :exec
+update gpu_workers set heartbeat_at = now(), current_tasks = '{}', stopped_at = now() where id = $1
+`
+
+func (q *Queries) StopGPUWorker(ctx context.Context, id string) error {
+	_, err := q.db.ExecContext(ctx, stopGPUWorker, id)
+	return err
+}
+
+const touchTTSCacheEntry = `-- name: TouchTTSCacheEntry :one
+update tts_cache_entries set hits = hits + 1, last_used_at = now() where cache_key = $1 returning cache_key, model_file_name, size_bytes, hits, created_at, last_used_at
+`
+
+func (q *Queries) TouchTTSCacheEntry(ctx context.Context, cacheKey string) (TtsCacheEntry, error) {
+	row := q.db.QueryRowContext(ctx, touchTTSCacheEntry, cacheKey)
+	var i TtsCacheEntry
+	err := row.Scan(
+		&i.CacheKey,
+		&i.ModelFileName,
+		&i.SizeBytes,
+		&i.Hits,
+		&i.CreatedAt,
+		&i.LastUsedAt,
+	)
+	return i, err
+}
+
+const unlockTaskQueueJob = `-- name: UnlockTaskQueueJob :execrows
+update task_queue_jobs set visible_at = now() where id = $1 and delivery_count = $2
+`
+
+type UnlockTaskQueueJobParams struct {
+	ID            int64
+	DeliveryCount int32
+}
+
+func (q *Queries) UnlockTaskQueueJob(ctx context.Context, arg UnlockTaskQueueJobParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, unlockTaskQueueJob, arg.ID, arg.DeliveryCount)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const updateAIPersonContextPromptByID = `-- name: UpdateAIPersonContextPromptByID :exec
 update ai_persons set context_prompt = $1 where id = $2
 `
@@ -554,6 +1887,20 @@ func (q *Queries) UpdateAIPersonContextPromptByID(ctx context.Context, arg Updat
 	return err
 }
 
+const updateAIPersonExpressiveCuesByID = `-- name: UpdateAIPersonExpressiveCuesByID :exec
+update ai_persons set expressive_cues = $1 where id = $2
+`
+
+type UpdateAIPersonExpressiveCuesByIDParams struct {
+	ExpressiveCues bool
+	ID             int64
+}
+
+func (q *Queries) UpdateAIPersonExpressiveCuesByID(ctx context.Context, arg UpdateAIPersonExpressiveCuesByIDParams) error {
+	_, err := q.db.ExecContext(ctx, updateAIPersonExpressiveCuesByID, arg.ExpressiveCues, arg.ID)
+	return err
+}
+
 const updateAIPersonReplyByID = `-- name: UpdateAIPersonReplyByID :exec
 update ai_person_replies set status = $1, message = $2 where id = $3
 `
@@ -569,6 +1916,22 @@ func (q *Queries) UpdateAIPersonReplyByID(ctx context.Context, arg UpdateAIPerso
 	return err
 }
 
+const updateAIPersonReplyVoiceErrorByID = `-- name: UpdateAIPersonReplyVoiceErrorByID :exec
+update ai_person_reply_voices set error_message = $1, finished_at = now() where id = $2 and status = 'processing' and task_id = $3
+`
+
+type UpdateAIPersonReplyVoiceErrorByIDParams struct {
+	ErrorMessage sql.NullString
+	ID           int64
+	TaskID       sql.NullString
+}
+
+// The outcome of an attempt does not overwrite the record cancelled in the meantime, and only the task holding the claim releases it.
+func (q *Queries) UpdateAIPersonReplyVoiceErrorByID(ctx context.Context, arg UpdateAIPersonReplyVoiceErrorByIDParams) error {
+	_, err := q.db.ExecContext(ctx, updateAIPersonReplyVoiceErrorByID, arg.ErrorMessage, arg.ID, arg.TaskID)
+	return err
+}
+
 const updateAIPersonReplyVoiceStatusByID = `-- name: UpdateAIPersonReplyVoiceStatusByID :exec
 update ai_person_reply_voices set status = $1, file_name = $2 where id = $3
 `
@@ -612,3 +1975,19 @@ func (q *Queries) UpdateVoiceModelByID(ctx context.Context, arg UpdateVoiceModel
 	_, err := q.db.ExecContext(ctx, updateVoiceModelByID, arg.Status, arg.FileName, arg.ID)
 	return err
 }
+
+const updateVoiceModelErrorByID = `-- name: UpdateVoiceModelErrorByID :exec
+update voice_models set error_message = $1, finished_at = now() where id = $2 and status = 'processing' and task_id = $3
+`
+
+type UpdateVoiceModelErrorByIDParams struct {
+	ErrorMessage sql.NullString
+	ID           int64
+	TaskID       sql.NullString
+}
+
+// The outcome of an attempt does not overwrite the record cancelled in the meantime, and only the task holding the claim releases it.
+func (q *Queries) UpdateVoiceModelErrorByID(ctx context.Context, arg UpdateVoiceModelErrorByIDParams) error {
+	_, err := q.db.ExecContext(ctx, updateVoiceModelErrorByID, arg.ErrorMessage, arg.ID, arg.TaskID)
+	return err
+}
@@ -0,0 +1,73 @@
+// Package dbtest connects tests to the PostgreSQL database given by the RECONN_TEST_DB* environment variables.
+// The tests that need a database are skipped without them, e.g.
+//
+//	RECONN_TEST_DBHOST=localhost RECONN_TEST_DBUSER=postgres RECONN_TEST_DBPASSWORD=postgres RECONN_TEST_DBNAME=reconn_test go test ./...
+//
+// The tests write to the database, hence it should be a dedicated one.
+package dbtest
+
+import (
+	"database/sql"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/db"
+	"github.com/HouzuoGuo/reconn-voice-clone/db/dbgen"
+)
+
+// Config returns the test database configuration, or skips the test if there is no test database.
+func Config(t testing.TB) db.Config {
+	t.Helper()
+	conf := db.Config{
+		Host:     os.Getenv("RECONN_TEST_DBHOST"),
+		Port:     5432,
+		User:     os.Getenv("RECONN_TEST_DBUSER"),
+		Password: os.Getenv("RECONN_TEST_DBPASSWORD"),
+		Database: os.Getenv("RECONN_TEST_DBNAME"),
+		SSLMode:  os.Getenv("RECONN_TEST_DBSSLMODE"),
+	}
+	if conf.Host == "" {
+		t.Skip("RECONN_TEST_DBHOST is not set")
+	}
+	if port := os.Getenv("RECONN_TEST_DBPORT"); port != "" {
+		var err error
+		if conf.Port, err = strconv.Atoi(port); err != nil {
+			t.Fatalf("invalid RECONN_TEST_DBPORT %q: %v", port, err)
+		}
+	}
+	if conf.SSLMode == "" {
+		conf.SSLMode = "disable"
+	}
+	return conf
+}
+
+// Connect connects to the test database and applies the schema, or skips the test if there is no test database.
+func Connect(t testing.TB) (*sql.DB, *dbgen.Queries) {
+	t.Helper()
+	lowLevelDB, database, err := db.Connect(Config(t))
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	t.Cleanup(func() { _ = lowLevelDB.Close() })
+	if err := applySchema(lowLevelDB); err != nil {
+		t.Fatalf("failed to apply schema: %v", err)
+	}
+	return lowLevelDB, database
+}
+
+// applySchema applies the schema while holding a lock, as the test packages run in parallel.
+func applySchema(lowLevelDB *sql.DB) error {
+	tx, err := lowLevelDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`select pg_advisory_xact_lock(hashtext('reconn_test_schema'))`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(db.Schema); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -7,3 +7,11 @@ drop table if exists user_prompts cascade;
 drop table if exists user_text_prompts cascade;
 drop table if exists user_voice_prompts cascade;
 drop table if exists ai_person_replies cascade;
+drop table if exists task_queue_jobs cascade;
+drop table if exists idempotency_keys cascade;
+drop table if exists task_outbox cascade;
+drop table if exists gpu_workers cascade;
+drop table if exists gpu_worker_model_caches cascade;
+drop table if exists ai_person_tts_settings cascade;
+drop table if exists voice_model_tts_settings cascade;
+drop table if exists tts_cache_entries cascade;
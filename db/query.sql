@@ -6,16 +6,19 @@ select * from users;
 select * from users where name = $1 limit 1;
 
 -- name: CreateAIPerson :one
-insert into ai_persons (user_id, name, context_prompt) values ($1, $2, $3) returning *;
+insert into ai_persons (user_id, name, context_prompt, expressive_cues) values ($1, $2, $3, $4) returning *;
 -- name: ListAIPersons :many
 select * from ai_persons where user_id = $1 order by id;
 -- name: GetAIPerson :one
 select * from ai_persons where id = $1;
 -- name: UpdateAIPersonContextPromptByID :exec
 update ai_persons set context_prompt = $1 where id = $2;
+-- name: UpdateAIPersonExpressiveCuesByID :exec
+update ai_persons set expressive_cues = $1 where id = $2;
 
 -- name: CreateVoiceSample :one
-insert into voice_samples (ai_person_id, file_name, timestamp) values ($1, $2, $3) returning *;
+insert into voice_samples (ai_person_id, file_name, timestamp, audio_format, sample_rate, channels, bits_per_sample, duration_seconds)
+values ($1, $2, $3, $4, $5, $6, $7, $8) returning *;
 -- name: GetVoiceSampleByID :one
 select * from voice_samples where id = $1 limit 1;
 -- name: ListVoiceSamples :many
@@ -29,7 +32,7 @@ select * from voice_models where voice_sample_id = $1;
 select * from voice_models where id = $1;
 -- name: GetLatestVoiceModel :one
 select m.id as id, m.status as status, m.file_name as file_name, m.timestamp as timestamp,
-a.user_id as user_id, a.name as ai_name, a.context_prompt as ai_context_prompt
+a.user_id as user_id, a.name as ai_name, a.context_prompt as ai_context_prompt, a.expressive_cues as ai_expressive_cues
 from voice_models m
 join voice_samples s on m.voice_sample_id = s.id
 join ai_persons a on s.ai_person_id = a.id and a.id = $1
@@ -38,6 +41,36 @@ order by m.timestamp desc
 limit 1;
 -- name: UpdateVoiceModelByID :exec
 update voice_models set status = $1, file_name = $2 where id = $3;
+-- name: ListVoiceModels :many
+select m.* from voice_models m
+join voice_samples s on m.voice_sample_id = s.id
+where s.ai_person_id = $1
+order by m.id;
+-- Claim the model for the task, unless the model is no longer in processing, or another task is still working on it.
+-- A retry claims the model again after the failed attempt has finished, and any task claims it after a crashed attempt's lease.
+-- A task requeued from the dead letters also claims the failed model, putting it back into processing with its attempts starting over.
+-- name: ClaimVoiceModelByID :execrows
+update voice_models set status = 'processing', attempts = case when status = 'failed' then 1 else attempts + 1 end,
+started_at = now(), finished_at = null, task_id = @task_id
+where id = @id and (status = 'processing' or (status = 'failed' and @requeued::boolean))
+and (task_id is null or finished_at is not null or started_at < now() - make_interval(secs => @lease_seconds::float8));
+-- The outcome of an attempt does not overwrite the record cancelled in the meantime, and only the task holding the claim releases it.
+-- name: UpdateVoiceModelErrorByID :exec
+update voice_models set error_message = @error_message, finished_at = now() where id = @id and status = 'processing' and task_id = @task_id;
+-- The task finishes the record only while holding the claim, and a record never claimed by a task is finished with a null task ID.
+-- name: FinishVoiceModelByID :exec
+update voice_models set status = @status, file_name = @file_name, error_message = @error_message, finished_at = now()
+where id = @id and status = 'processing' and task_id is not distinct from @task_id;
+-- name: CancelVoiceModelByID :execrows
+update voice_models set status = 'cancelled', finished_at = now() where id = $1 and status = 'processing';
+-- name: ListStuckVoiceModels :many
+select * from voice_models
+where status = 'processing' and coalesce(started_at, timestamp) < now() - make_interval(secs => @stuck_seconds::float8)
+order by id;
+-- Claim the stuck model for the reaper, which releases the claim of the stuck task so that the next task may claim it.
+-- name: ClaimStuckVoiceModelByID :execrows
+update voice_models set started_at = now(), task_id = null
+where id = @id and status = 'processing' and coalesce(started_at, timestamp) < now() - make_interval(secs => @stuck_seconds::float8);
 
 -- name: CreateUserPrompt :one
 insert into user_prompts (ai_person_id, timestamp) values ($1, $2) returning *;
@@ -46,9 +79,14 @@ insert into user_prompts (ai_person_id, timestamp) values ($1, $2) returning *;
 insert into user_text_prompts (user_prompt_id, message) values ($1, $2) returning *;
 
 -- name: CreateUserVoicePrompt :one
-insert into user_voice_prompts (user_prompt_id, status, file_name, transcription) values ($1, $2, $3, $4) returning *;
+insert into user_voice_prompts (user_prompt_id, status, file_name, transcription, audio_format, sample_rate, channels, bits_per_sample, duration_seconds)
+values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning *;
 -- name: UpdateUserVoicePromptStatusByID :exec
 update user_voice_prompts set status = $1 where id = $2;
+-- name: StartUserVoicePromptAttemptByID :exec
+update user_voice_prompts set status = 'processing', attempts = attempts + 1, started_at = now(), finished_at = null where id = $1;
+-- name: FinishUserVoicePromptByID :exec
+update user_voice_prompts set status = $2, transcription = $3, error_message = $4, finished_at = now() where id = $1;
 
 -- name: CreateAIPersonReply :one
 insert into ai_person_replies (user_prompt_id, status, message, timestamp) values ($1, $2, $3, $4) returning *;
@@ -56,6 +94,10 @@ insert into ai_person_replies (user_prompt_id, status, message, timestamp) value
 select * from ai_person_replies where id = $1;
 -- name: UpdateAIPersonReplyByID :exec
 update ai_person_replies set status = $1, message = $2 where id = $3;
+-- name: StartAIPersonReplyAttemptByID :exec
+update ai_person_replies set status = 'processing', attempts = attempts + 1, started_at = now(), finished_at = null where id = $1;
+-- name: FinishAIPersonReplyByID :exec
+update ai_person_replies set status = $2, message = $3, error_message = $4, speech_markup = $5, finished_at = now() where id = $1;
 
 -- name: CreateAIPersonReplyVoice :one
 insert into ai_person_reply_voices (ai_person_reply_id, status, file_name) values ($1, $2, $3) returning *;
@@ -63,13 +105,43 @@ insert into ai_person_reply_voices (ai_person_reply_id, status, file_name) value
 select * from ai_person_reply_voices where id = $1;
 -- name: UpdateAIPersonReplyVoiceStatusByID :exec
 update ai_person_reply_voices set status = $1, file_name = $2 where id = $3;
+-- Claim the reply voice for the task, unless the reply voice is no longer in processing, or another task is still working on it.
+-- A retry claims the reply voice again after the failed attempt has finished, and any task claims it after a crashed attempt's lease.
+-- A task requeued from the dead letters also claims the failed reply voice, putting it back into processing with its attempts starting over.
+-- name: ClaimAIPersonReplyVoiceByID :execrows
+update ai_person_reply_voices set status = 'processing', attempts = case when status = 'failed' then 1 else attempts + 1 end,
+started_at = now(), finished_at = null, task_id = @task_id
+where id = @id and (status = 'processing' or (status = 'failed' and @requeued::boolean))
+and (task_id is null or finished_at is not null or started_at < now() - make_interval(secs => @lease_seconds::float8));
+-- The outcome of an attempt does not overwrite the record cancelled in the meantime, and only the task holding the claim releases it.
+-- name: UpdateAIPersonReplyVoiceErrorByID :exec
+update ai_person_reply_voices set error_message = @error_message, finished_at = now() where id = @id and status = 'processing' and task_id = @task_id;
+-- The task finishes the record only while holding the claim, and a record never claimed by a task is finished with a null task ID.
+-- name: FinishAIPersonReplyVoiceByID :exec
+update ai_person_reply_voices set status = @status, file_name = @file_name, error_message = @error_message, finished_at = now()
+where id = @id and status = 'processing' and task_id is not distinct from @task_id;
+-- name: CancelAIPersonReplyVoiceByID :execrows
+update ai_person_reply_voices set status = 'cancelled', finished_at = now() where id = $1 and status = 'processing';
+-- name: ListStuckAIPersonReplyVoices :many
+select rv.id as id, rv.attempts as attempts, u.ai_person_id as ai_person_id
+from ai_person_reply_voices rv
+join ai_person_replies r on rv.ai_person_reply_id = r.id
+join user_prompts u on r.user_prompt_id = u.id
+where rv.status = 'processing' and coalesce(rv.started_at, r.timestamp) < now() - make_interval(secs => @stuck_seconds::float8)
+order by rv.id;
+-- Claim the stuck reply voice for the reaper, which releases the claim of the stuck task so that the next task may claim it.
+-- name: ClaimStuckAIPersonReplyVoiceByID :execrows
+update ai_person_reply_voices rv set started_at = now(), task_id = null
+from ai_person_replies r
+where rv.id = @id and r.id = rv.ai_person_reply_id and rv.status = 'processing'
+and coalesce(rv.started_at, r.timestamp) < now() - make_interval(secs => @stuck_seconds::float8);
 
 -- name: ListConversations :many
 select u.id as id, u.ai_person_id as ai_person_id, u.timestamp as timestamp,
 t.message as text_message,
-v.status as voice_status, v.file_name as voice_filename, v.transcription as voice_transcription,
-r.status as reply_status, r.message as reply_message, r.timestamp as reply_timestamp,
-rv.status as reply_voice_status, rv.file_name as reply_voice_filename
+v.status as voice_status, v.file_name as voice_filename, v.transcription as voice_transcription, v.error_message as voice_error_message,
+r.status as reply_status, r.message as reply_message, r.speech_markup as reply_speech_markup, r.timestamp as reply_timestamp, r.error_message as reply_error_message,
+rv.status as reply_voice_status, rv.file_name as reply_voice_filename, rv.error_message as reply_voice_error_message, rv.attempts as reply_voice_attempts
 from user_prompts u
 left outer join user_text_prompts t on t.user_prompt_id = u.id
 left outer join user_voice_prompts v on v.user_prompt_id = u.id
@@ -78,3 +150,142 @@ left outer join ai_person_reply_voices rv on rv.ai_person_reply_id = r.id
 where ai_person_id = $1
 order by u.id desc
 limit $2;
+
+-- name: CreateTaskQueueJob :one
+insert into task_queue_jobs (queue, body, visible_at, delivery_count, created_at)
+values (@queue, @body, now() + make_interval(secs => @delay_seconds::float8), 0, now()) returning *;
+-- name: ReceiveTaskQueueJobs :many
+update task_queue_jobs set visible_at = now() + make_interval(secs => @lock_seconds::float8), delivery_count = delivery_count + 1
+where id in (
+    select j.id from task_queue_jobs j
+    where j.queue = @queue and j.visible_at <= now() and j.dead_lettered_at is null
+    order by j.id
+    limit @max_jobs
+    for update skip locked
+)
+returning *;
+-- name: DeleteTaskQueueJob :execrows
+delete from task_queue_jobs where id = $1 and delivery_count = $2;
+-- name: RenewTaskQueueJobLock :execrows
+update task_queue_jobs set visible_at = now() + make_interval(secs => @lock_seconds::float8)
+where id = @id and delivery_count = @delivery_count and dead_lettered_at is null;
+-- name: UnlockTaskQueueJob :execrows
+update task_queue_jobs set visible_at = now() where id = $1 and delivery_count = $2;
+-- name: DeadLetterTaskQueueJob :execrows
+update task_queue_jobs set dead_lettered_at = now(), dead_letter_reason = $3, dead_letter_description = $4
+where id = $1 and delivery_count = $2;
+-- name: ListDeadLetterTaskQueueJobs :many
+select * from task_queue_jobs where queue = $1 and dead_lettered_at is not null order by id limit $2;
+-- name: GetDeadLetterTaskQueueJob :one
+select * from task_queue_jobs where id = $1 and queue = $2 and dead_lettered_at is not null;
+-- name: RequeueDeadLetterTaskQueueJob :execrows
+update task_queue_jobs set dead_lettered_at = null, dead_letter_reason = null, dead_letter_description = null, visible_at = now(), delivery_count = 0, body = @body
+where id = @id and queue = @queue and dead_lettered_at is not null;
+
+-- Record the idempotency key of a request, or take over an expired one, or one left in progress by a request that did
+-- not finish within the lease. Nothing is affected if the key is taken.
+-- name: CreateIdempotencyKey :execrows
+insert into idempotency_keys (request_path, idempotency_key, created_at) values (@request_path, @idempotency_key, now())
+on conflict (request_path, idempotency_key) do update set response_status = null, response_body = null, created_at = now()
+where idempotency_keys.created_at < now() - make_interval(secs => @ttl_seconds::float8)
+or (idempotency_keys.response_status is null and idempotency_keys.created_at < now() - make_interval(secs => @lease_seconds::float8));
+-- name: GetIdempotencyKey :one
+select * from idempotency_keys where request_path = $1 and idempotency_key = $2;
+-- name: SaveIdempotencyKeyResponse :exec
+update idempotency_keys set response_status = $3, response_body = $4 where request_path = $1 and idempotency_key = $2;
+-- name: DeleteIdempotencyKey :exec
+delete from idempotency_keys where request_path = $1 and idempotency_key = $2;
+
+-- name: CreateTaskOutboxEntry :one
+insert into task_outbox (body, created_at) values ($1, now()) returning *;
+-- Lock the oldest unsent entries for the relay, skipping the ones locked by the other relays.
+-- name: ListUnsentTaskOutboxEntries :many
+select * from task_outbox where sent_at is null order by id limit $1 for update skip locked;
+-- name: MarkTaskOutboxEntrySent :exec
+update task_outbox set sent_at = now() where id = $1;
+-- name: DeleteSentTaskOutboxEntries :execrows
+delete from task_outbox where sent_at < now() - make_interval(secs => @retention_seconds::float8);
+
+-- name: RegisterGPUWorker :exec
+insert into gpu_workers (id, host_name, voice_service_addrs, task_types, concurrency, version, started_at, heartbeat_at)
+values ($1, $2, $3, $4, $5, $6, now(), now());
+-- name: HeartbeatGPUWorker :exec
+update gpu_workers set heartbeat_at = now(), current_tasks = $2, last_error = $3, last_error_at = $4 where id = $1;
+-- name: StopGPUWorker :exec
+update gpu_workers set heartbeat_at = now(), current_tasks = '{}', stopped_at = now() where id = $1;
+-- name: ListGPUWorkers :many
+select *, (stopped_at is null and heartbeat_at > now() - make_interval(secs => @dead_seconds::float8))::boolean as alive
+from gpu_workers order by started_at desc limit @max_workers;
+-- Forget the workers that have stopped or died a while ago.
+-- name: DeleteDeadGPUWorkers :execrows
+delete from gpu_workers where heartbeat_at < now() - make_interval(secs => @retention_seconds::float8);
+
+-- name: RecordGPUWorkerModelCache :exec
+insert into gpu_worker_model_caches (worker_id, model_file_name, cached_at) values ($1, $2, now())
+on conflict (worker_id, model_file_name) do update set cached_at = now();
+-- name: ListLiveGPUWorkersCachingModel :many
+select w.id from gpu_workers w
+join gpu_worker_model_caches c on c.worker_id = w.id
+where c.model_file_name = @model_file_name and w.stopped_at is null and w.heartbeat_at > now() - make_interval(secs => @dead_seconds::float8)
+order by w.id;
+-- The workers that have cached any voice model of the AI person are likely to serve the AI person again.
+-- name: ListLiveGPUWorkersServingAIPerson :many
+select distinct w.id from gpu_workers w
+join gpu_worker_model_caches c on c.worker_id = w.id
+join voice_models m on m.file_name = c.model_file_name
+join voice_samples s on m.voice_sample_id = s.id
+where s.ai_person_id = @ai_person_id and w.stopped_at is null and w.heartbeat_at > now() - make_interval(secs => @dead_seconds::float8)
+order by w.id;
+-- The latest ready voice models of the AI persons who received the most prompts recently.
+-- name: ListMostActiveVoiceModels :many
+select distinct on (a.prompts, s.ai_person_id) s.ai_person_id as ai_person_id, m.file_name as file_name
+from (
+    select u.ai_person_id, count(*) as prompts from user_prompts u
+    where u.timestamp > now() - make_interval(secs => @since_seconds::float8)
+    group by u.ai_person_id order by prompts desc limit @max_ai_persons
+) a
+join voice_samples s on s.ai_person_id = a.ai_person_id
+join voice_models m on m.voice_sample_id = s.id and m.status = 'ready'
+order by a.prompts desc, s.ai_person_id, m.timestamp desc;
+
+-- The settings of the voice model override the settings of the AI person.
+-- name: GetTTSSettings :one
+select 'voice-model'::text as source, preset, top_k, top_p, mineos_p, semantic_temp, waveform_temp, fine_temp
+from voice_model_tts_settings where voice_model_id = @voice_model_id
+union all
+select 'ai-person'::text as source, preset, top_k, top_p, mineos_p, semantic_temp, waveform_temp, fine_temp
+from ai_person_tts_settings where ai_person_id = @ai_person_id
+order by source desc
+limit 1;
+-- name: SetAIPersonTTSSettings :exec
+insert into ai_person_tts_settings (ai_person_id, preset, top_k, top_p, mineos_p, semantic_temp, waveform_temp, fine_temp, updated_at)
+values ($1, $2, $3, $4, $5, $6, $7, $8, now())
+on conflict (ai_person_id) do update set preset = excluded.preset, top_k = excluded.top_k, top_p = excluded.top_p,
+mineos_p = excluded.mineos_p, semantic_temp = excluded.semantic_temp, waveform_temp = excluded.waveform_temp,
+fine_temp = excluded.fine_temp, updated_at = now();
+-- name: SetVoiceModelTTSSettings :exec
+insert into voice_model_tts_settings (voice_model_id, preset, top_k, top_p, mineos_p, semantic_temp, waveform_temp, fine_temp, updated_at)
+values ($1, $2, $3, $4, $5, $6, $7, $8, now())
+on conflict (voice_model_id) do update set preset = excluded.preset, top_k = excluded.top_k, top_p = excluded.top_p,
+mineos_p = excluded.mineos_p, semantic_temp = excluded.semantic_temp, waveform_temp = excluded.waveform_temp,
+fine_temp = excluded.fine_temp, updated_at = now();
+-- name: DeleteVoiceModelTTSSettings :execrows
+delete from voice_model_tts_settings where voice_model_id = $1;
+
+-- name: TouchTTSCacheEntry :one
+update tts_cache_entries set hits = hits + 1, last_used_at = now() where cache_key = $1 returning *;
+-- name: CreateTTSCacheEntry :exec
+insert into tts_cache_entries (cache_key, model_file_name, size_bytes, created_at, last_used_at)
+values ($1, $2, $3, now(), now())
+on conflict (cache_key) do update set size_bytes = excluded.size_bytes, last_used_at = now();
+-- name: DeleteTTSCacheEntry :exec
+delete from tts_cache_entries where cache_key = $1;
+-- Evict the least recently used entries beyond the size budget, and the entries unused for longer than the max age.
+-- name: EvictTTSCacheEntries :many
+delete from tts_cache_entries where cache_key in (
+    select cache_key from (
+        select cache_key, last_used_at, sum(size_bytes) over (order by last_used_at desc, cache_key) as cumulative_bytes
+        from tts_cache_entries
+    ) as lru
+    where lru.cumulative_bytes > @max_bytes::bigint or lru.last_used_at < now() - make_interval(secs => @max_age_seconds::float8)
+) returning cache_key;
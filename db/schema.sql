@@ -20,7 +20,9 @@ create table if not exists ai_persons
     user_id bigint references users (id) on delete cascade not null,
     name text not null,
     -- Contextual, background information for the system role, e.g. you are Esther in Shushan.
-    context_prompt text not null
+    context_prompt text not null,
+    -- Whether the LLM is asked to express laughs, sighs, pauses, and emphasis in the replies for the voice to perform.
+    expressive_cues boolean not null default false
 );
 create index if not exists ai_persons_user_id_index on ai_persons (user_id);
 
@@ -30,7 +32,13 @@ create table if not exists voice_samples
     id bigserial primary key,
     ai_person_id bigint references ai_persons (id) on delete cascade not null,
     file_name text,
-    timestamp timestamp with time zone not null
+    timestamp timestamp with time zone not null,
+    -- The metadata of the wave audio, zero or empty if unknown.
+    audio_format text not null default '',
+    sample_rate integer not null default 0,
+    channels integer not null default 0,
+    bits_per_sample integer not null default 0,
+    duration_seconds double precision not null default 0
 );
 create index if not exists voice_sample_ai_person_id_index on voice_samples (ai_person_id);
 
@@ -40,11 +48,22 @@ create table if not exists voice_models
     id bigserial primary key,
     voice_sample_id bigint references voice_samples (id) on delete cascade not null,
     -- Whether a model has been created for the sample yet.
-    status text check ( status in ('processing', 'ready') ) not null,
+    status text check ( status in ('processing', 'ready', 'failed', 'cancelled') ) not null,
     file_name text,
-    timestamp timestamp with time zone not null
+    timestamp timestamp with time zone not null,
+    -- The reason of the latest failure, if any.
+    error_message text,
+    -- Number of times the processing has been attempted.
+    attempts integer not null default 0,
+    -- The time the latest attempt started and finished.
+    started_at timestamp with time zone,
+    finished_at timestamp with time zone,
+    -- ID of the GPU task that has claimed the record for processing.
+    task_id text
 );
 create index if not exists voice_model_sample_id_index on voice_models (voice_sample_id);
+-- The reaper looks for models stuck in processing.
+create index if not exists voice_model_processing_index on voice_models (id) where status = 'processing';
 
 --- The user's side of conversation with an AI personality - a voice note or text message intended for an AI personality.
 create table if not exists user_prompts
@@ -72,9 +91,22 @@ create table if not exists user_voice_prompts
     id bigserial primary key,
     user_prompt_id bigint references user_prompts (id) on delete cascade not null,
     -- Whether this voice note has been transcribed into text.
-    status text check ( status in ('processing', 'ready') ) not null,
+    status text check ( status in ('processing', 'ready', 'failed') ) not null,
     file_name text not null,
-    transcription text
+    transcription text,
+    -- The reason of the latest failure, if any.
+    error_message text,
+    -- Number of times the processing has been attempted.
+    attempts integer not null default 0,
+    -- The time the latest attempt started and finished.
+    started_at timestamp with time zone,
+    finished_at timestamp with time zone,
+    -- The metadata of the wave audio, zero or empty if unknown.
+    audio_format text not null default '',
+    sample_rate integer not null default 0,
+    channels integer not null default 0,
+    bits_per_sample integer not null default 0,
+    duration_seconds double precision not null default 0
 );
 create index if not exists user_voice_prompt_id_index on user_voice_prompts (user_prompt_id);
 
@@ -84,9 +116,19 @@ create table if not exists ai_person_replies
     id bigserial primary key,
     user_prompt_id bigint references user_prompts (id) on delete cascade not null,
     -- Whether LLM has generated a reply in response to the prompt.
-    status text check ( status in ('processing', 'ready') ) not null,
+    status text check ( status in ('processing', 'ready', 'failed') ) not null,
+    -- The reply transcript, without the expressive cues.
     message text not null,
-    timestamp timestamp with time zone not null
+    timestamp timestamp with time zone not null,
+    -- The reason of the latest failure, if any.
+    error_message text,
+    -- Number of times the processing has been attempted.
+    attempts integer not null default 0,
+    -- The time the latest attempt started and finished.
+    started_at timestamp with time zone,
+    finished_at timestamp with time zone,
+    -- The reply with the expressive cues to be spoken, if the AI person allows them and the reply has any.
+    speech_markup text
 );
 create index if not exists ai_person_reply_person_id_index on ai_person_replies (user_prompt_id);
 
@@ -95,7 +137,183 @@ create table if not exists ai_person_reply_voices
 (
     id bigserial primary key,
     ai_person_reply_id bigint references ai_person_replies (id) on delete cascade not null,
-    status text check ( status in ('processing', 'ready') ) not null,
-    file_name text
+    status text check ( status in ('processing', 'ready', 'failed', 'cancelled') ) not null,
+    file_name text,
+    -- The reason of the latest failure, if any.
+    error_message text,
+    -- Number of times the processing has been attempted.
+    attempts integer not null default 0,
+    -- The time the latest attempt started and finished.
+    started_at timestamp with time zone,
+    finished_at timestamp with time zone,
+    -- ID of the GPU task that has claimed the record for processing.
+    task_id text
 );
 create index if not exists ai_person_reply_voice_reply_id_index  on ai_person_reply_voices (ai_person_reply_id);
+-- The reaper looks for reply voices stuck in processing.
+create index if not exists ai_person_reply_voice_processing_index on ai_person_reply_voices (id) where status = 'processing';
+
+-- A task queue job, used by the postgresql task queue backend as an alternative to azure service bus.
+create table if not exists task_queue_jobs
+(
+    id bigserial primary key,
+    -- Name of the task queue.
+    queue text not null,
+    -- The serialised task.
+    body bytea not null,
+    -- The job is available to receivers from this time onwards. A receiver locks the job by moving it into the future.
+    visible_at timestamp with time zone not null,
+    -- Number of times the job has been received.
+    delivery_count integer not null,
+    created_at timestamp with time zone not null,
+    -- A dead-lettered job is never received again, unless it is requeued.
+    dead_lettered_at timestamp with time zone,
+    dead_letter_reason text,
+    dead_letter_description text
+);
+create index if not exists task_queue_job_queue_visible_at_index on task_queue_jobs (queue, visible_at) where dead_lettered_at is null;
+
+-- The response to an asynchronous http request carrying an idempotency key, replayed to the retries of the request.
+create table if not exists idempotency_keys
+(
+    request_path text not null,
+    idempotency_key text not null,
+    -- The response is absent while the first request is still in progress.
+    response_status integer,
+    response_body bytea,
+    created_at timestamp with time zone not null,
+    primary key (request_path, idempotency_key)
+);
+
+-- GPU tasks written in the same transaction as their records, and relayed to the task queue afterwards.
+create table if not exists task_outbox
+(
+    id bigserial primary key,
+    -- The serialised task.
+    body bytea not null,
+    created_at timestamp with time zone not null,
+    -- The entry has been sent to the task queue if not null. Sent entries are kept for a while for troubleshooting.
+    sent_at timestamp with time zone
+);
+create index if not exists task_outbox_unsent_index on task_outbox (id) where sent_at is null;
+
+-- The GPU workers that have registered themselves, and their latest heartbeat.
+create table if not exists gpu_workers
+(
+    -- The worker generates its ID when it starts.
+    id text primary key,
+    host_name text not null,
+    -- The voice services (reconn/voicesvc) the worker forwards the tasks to.
+    voice_service_addrs text[] not null,
+    -- The types of tasks the worker has handlers for.
+    task_types text[] not null,
+    -- Number of tasks processed at the same time.
+    concurrency integer not null,
+    version text not null,
+    started_at timestamp with time zone not null,
+    -- A worker is considered dead if its heartbeat stops.
+    heartbeat_at timestamp with time zone not null,
+    -- The tasks in flight as of the latest heartbeat.
+    current_tasks text[] not null default '{}',
+    -- The latest task failure, if any.
+    last_error text,
+    last_error_at timestamp with time zone,
+    -- The worker shut down gracefully at this time.
+    stopped_at timestamp with time zone
+);
+
+-- The voice model files each GPU worker has in its local disk, the TTS tasks of a model prefer the workers caching it.
+create table if not exists gpu_worker_model_caches
+(
+    worker_id text references gpu_workers (id) on delete cascade not null,
+    model_file_name text not null,
+    cached_at timestamp with time zone not null,
+    primary key (worker_id, model_file_name)
+);
+create index if not exists gpu_worker_model_cache_file_name_index on gpu_worker_model_caches (model_file_name);
+
+-- The TTS settings of an AI person's speech, the AI persons without settings speak with the default preset.
+create table if not exists ai_person_tts_settings
+(
+    ai_person_id bigint primary key references ai_persons (id) on delete cascade,
+    -- The named preset the settings started from, the parameters may have been adjusted since.
+    preset text not null,
+    top_k double precision not null,
+    top_p double precision not null,
+    mineos_p double precision not null,
+    semantic_temp double precision not null,
+    waveform_temp double precision not null,
+    fine_temp double precision not null,
+    updated_at timestamp with time zone not null
+);
+
+-- The TTS settings of a voice model, which override the settings of its AI person.
+create table if not exists voice_model_tts_settings
+(
+    voice_model_id bigint primary key references voice_models (id) on delete cascade,
+    preset text not null,
+    top_k double precision not null,
+    top_p double precision not null,
+    mineos_p double precision not null,
+    semantic_temp double precision not null,
+    waveform_temp double precision not null,
+    fine_temp double precision not null,
+    updated_at timestamp with time zone not null
+);
+
+-- The cached speech synthesized by the voice service, the blob of each entry is named after its cache key.
+create table if not exists tts_cache_entries
+(
+    -- The hash of the voice model file, normalised text, TTS settings, and voice service version.
+    cache_key text primary key,
+    model_file_name text not null,
+    size_bytes bigint not null,
+    hits bigint not null default 0,
+    created_at timestamp with time zone not null,
+    -- The least recently used entries are evicted first.
+    last_used_at timestamp with time zone not null
+);
+create index if not exists tts_cache_entry_last_used_at_index on tts_cache_entries (last_used_at);
+
+-- Upgrade the asynchronously processed records created before the introduction of the 'failed' and 'cancelled' statuses.
+alter table voice_models add column if not exists error_message text;
+alter table voice_models add column if not exists attempts integer not null default 0;
+alter table voice_models add column if not exists started_at timestamp with time zone;
+alter table voice_models add column if not exists finished_at timestamp with time zone;
+alter table voice_models drop constraint if exists voice_models_status_check;
+alter table voice_models add constraint voice_models_status_check check ( status in ('processing', 'ready', 'failed', 'cancelled') );
+alter table user_voice_prompts add column if not exists error_message text;
+alter table user_voice_prompts add column if not exists attempts integer not null default 0;
+alter table user_voice_prompts add column if not exists started_at timestamp with time zone;
+alter table user_voice_prompts add column if not exists finished_at timestamp with time zone;
+alter table user_voice_prompts drop constraint if exists user_voice_prompts_status_check;
+alter table user_voice_prompts add constraint user_voice_prompts_status_check check ( status in ('processing', 'ready', 'failed') );
+alter table ai_person_replies add column if not exists error_message text;
+alter table ai_person_replies add column if not exists attempts integer not null default 0;
+alter table ai_person_replies add column if not exists started_at timestamp with time zone;
+alter table ai_person_replies add column if not exists finished_at timestamp with time zone;
+alter table ai_person_replies drop constraint if exists ai_person_replies_status_check;
+alter table ai_person_replies add constraint ai_person_replies_status_check check ( status in ('processing', 'ready', 'failed') );
+alter table ai_person_reply_voices add column if not exists error_message text;
+alter table ai_person_reply_voices add column if not exists attempts integer not null default 0;
+alter table ai_person_reply_voices add column if not exists started_at timestamp with time zone;
+alter table ai_person_reply_voices add column if not exists finished_at timestamp with time zone;
+alter table ai_person_reply_voices drop constraint if exists ai_person_reply_voices_status_check;
+alter table ai_person_reply_voices add constraint ai_person_reply_voices_status_check check ( status in ('processing', 'ready', 'failed', 'cancelled') );
+-- Upgrade the records created before GPU tasks claimed them.
+alter table voice_models add column if not exists task_id text;
+alter table ai_person_reply_voices add column if not exists task_id text;
+-- Upgrade the records created before the introduction of the expressive cues.
+alter table ai_persons add column if not exists expressive_cues boolean not null default false;
+alter table ai_person_replies add column if not exists speech_markup text;
+-- Upgrade the records created before the audio metadata was kept.
+alter table voice_samples add column if not exists audio_format text not null default '';
+alter table voice_samples add column if not exists sample_rate integer not null default 0;
+alter table voice_samples add column if not exists channels integer not null default 0;
+alter table voice_samples add column if not exists bits_per_sample integer not null default 0;
+alter table voice_samples add column if not exists duration_seconds double precision not null default 0;
+alter table user_voice_prompts add column if not exists audio_format text not null default '';
+alter table user_voice_prompts add column if not exists sample_rate integer not null default 0;
+alter table user_voice_prompts add column if not exists channels integer not null default 0;
+alter table user_voice_prompts add column if not exists bits_per_sample integer not null default 0;
+alter table user_voice_prompts add column if not exists duration_seconds double precision not null default 0;
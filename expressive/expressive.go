@@ -0,0 +1,168 @@
+// Package expressive translates the expressive markup the LLM writes in its replies, such as <laugh/>,
+// <pause ms="500"/>, and <emphasis>, into the non-verbal tokens and conventions of Bark, and strips the markup from
+// the reply transcript.
+package expressive
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Cues are the self-closing cues and the Bark tokens they are spoken as.
+var Cues = map[string]string{
+	"laugh":        "[laughs]",
+	"laughter":     "[laughter]",
+	"sigh":         "[sighs]",
+	"gasp":         "[gasps]",
+	"clear_throat": "[clears throat]",
+	"music":        "[music]",
+}
+
+const (
+	// pauseTag is the self-closing cue of a hesitation, its optional "ms" attribute is the length in milliseconds.
+	pauseTag = "pause"
+	// emphasisTag encloses the words spoken with emphasis, Bark emphasises the words in capital letters.
+	emphasisTag = "emphasis"
+	// singTag encloses the words sung, Bark sings the words between music notes.
+	singTag = "sing"
+
+	// DefaultPauseMillis is the length of a pause without the "ms" attribute.
+	DefaultPauseMillis = 500
+	// shortPauseMillis is the length of the longest pause spoken as a comma, the longer pauses are spoken as an
+	// ellipsis. Bark does not time its pauses, an ellipsis is about half a second of hesitation.
+	shortPauseMillis = 250
+)
+
+// Instructions tell the LLM how to use the expressive markup, they are added to the system prompt of the AI persons
+// that allow the expressive cues.
+const Instructions = `You may express yourself in your replies with these tags, use them sparingly and only where they come naturally: ` +
+	`<laugh/>, <laughter/>, <sigh/>, <gasp/>, <clear_throat/>, <music/>, ` +
+	`<pause ms="500"/> for a hesitation of the given milliseconds, ` +
+	`<emphasis>words</emphasis> for the words to be stressed, and <sing>words</sing> for the words to be sung.`
+
+var (
+	tagPattern        = regexp.MustCompile(`(?i)<\s*(/?)\s*([a-z_]+)((?:\s+[a-z_]+\s*=\s*"[^"]*")*)\s*(/?)\s*>`)
+	msPattern         = regexp.MustCompile(`(?i)\bms\s*=\s*"(\d+)"`)
+	spacePattern      = regexp.MustCompile(`[ \t]+`)
+	spaceBeforePunct  = regexp.MustCompile(`[ \t]+([,.!?;:])`)
+	repeatedCommas    = regexp.MustCompile(`,([ \t]*,)+`)
+	commaBeforeFinish = regexp.MustCompile(`,([.!?])`)
+)
+
+// isMarkup returns true if the tag name belongs to the expressive markup.
+func isMarkup(name string) bool {
+	_, isCue := Cues[name]
+	return isCue || name == pauseTag || name == emphasisTag || name == singTag
+}
+
+// tag is a tag of the expressive markup.
+type tag struct {
+	// name is the lower case name of the tag.
+	name string
+	// closing flag indicates a closing tag such as </emphasis>, and selfClosing a tag such as <laugh/>.
+	closing, selfClosing bool
+	// attributes are the attributes of the tag as written, e.g. ms="500".
+	attributes string
+}
+
+// translate replaces the text between the tags of the expressive markup and each of the tags with the return
+// values of the functions, the tags of other names are left as they are. The text without expressive markup is
+// returned as is.
+func translate(text string, between func(string) string, replace func(tag) string) string {
+	var ret strings.Builder
+	last := 0
+	for _, match := range tagPattern.FindAllStringSubmatchIndex(text, -1) {
+		markup := tag{
+			name:        strings.ToLower(text[match[4]:match[5]]),
+			closing:     match[3] > match[2],
+			selfClosing: match[9] > match[8],
+			attributes:  text[match[6]:match[7]],
+		}
+		if !isMarkup(markup.name) {
+			continue
+		}
+		ret.WriteString(between(text[last:match[0]]))
+		ret.WriteString(replace(markup))
+		last = match[1]
+	}
+	if last == 0 {
+		return text
+	}
+	ret.WriteString(between(text[last:]))
+	return tidy(ret.String())
+}
+
+// tidy removes the spaces and punctuation left over by the tags.
+func tidy(text string) string {
+	text = spacePattern.ReplaceAllString(text, " ")
+	text = spaceBeforePunct.ReplaceAllString(text, "$1")
+	text = repeatedCommas.ReplaceAllString(text, ",")
+	text = commaBeforeFinish.ReplaceAllString(text, "$1")
+	return strings.TrimSpace(text)
+}
+
+// Strip returns the text without the expressive markup, the words of emphasis and singing are kept.
+func Strip(text string) string {
+	return translate(text, func(between string) string { return between }, func(markup tag) string {
+		if markup.name == emphasisTag {
+			return ""
+		}
+		return " "
+	})
+}
+
+// HasMarkup returns true if the text has any expressive markup.
+func HasMarkup(text string) bool {
+	for _, match := range tagPattern.FindAllStringSubmatch(text, -1) {
+		if isMarkup(strings.ToLower(match[2])) {
+			return true
+		}
+	}
+	return false
+}
+
+// ToBark returns the text with the expressive markup translated into the Bark tokens: the cues become the
+// bracketed non-verbal tokens, the pauses become ellipses, the words of emphasis become capital letters, and the
+// words sung go between music notes.
+func ToBark(text string) string {
+	emphasis := 0
+	between := func(between string) string {
+		if emphasis > 0 {
+			return strings.ToUpper(between)
+		}
+		return between
+	}
+	return translate(text, between, func(markup tag) string {
+		switch {
+		case markup.name == emphasisTag:
+			if markup.closing {
+				emphasis = max(emphasis-1, 0)
+			} else if !markup.selfClosing {
+				emphasis++
+			}
+			return ""
+		case markup.name == singTag:
+			return " ♪ "
+		case markup.closing:
+			// The cues have nothing to close.
+			return " "
+		case markup.name == pauseTag:
+			return " " + pause(markup.attributes) + " "
+		default:
+			return " " + Cues[markup.name] + " "
+		}
+	})
+}
+
+// pause returns the punctuation Bark pauses at for the length of the pause in its attributes.
+func pause(attributes string) string {
+	millis := DefaultPauseMillis
+	if match := msPattern.FindStringSubmatch(attributes); match != nil {
+		millis, _ = strconv.Atoi(match[1])
+	}
+	if millis <= shortPauseMillis {
+		return ","
+	}
+	return "..."
+}
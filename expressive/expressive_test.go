@@ -0,0 +1,25 @@
+package expressive
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpressiveMarkup(t *testing.T) {
+	for _, tc := range []struct {
+		text, bark, transcript string
+	}{
+		{"No markup here.", "No markup here.", "No markup here."},
+		{"Oh <laugh/> that is funny<sigh />.", "Oh [laughs] that is funny [sighs].", "Oh that is funny."},
+		{"Well<pause ms=\"500\"/> I think so.", "Well... I think so.", "Well I think so."},
+		{"Hmm <PAUSE ms=\"1400\"/>, yes <pause ms=\"100\"/> dear.", "Hmm..., yes, dear.", "Hmm, yes dear."},
+		{"I <emphasis>really</emphasis> mean it.", "I REALLY mean it.", "I really mean it."},
+		{"<sing>Happy birthday to you</sing> <clear_throat/>", "♪ Happy birthday to you ♪ [clears throat]", "Happy birthday to you"},
+		{"Keep <b>other</b> tags and 3 < 5.", "Keep <b>other</b> tags and 3 < 5.", "Keep <b>other</b> tags and 3 < 5."},
+	} {
+		assert.Equal(t, tc.bark, ToBark(tc.text), tc.text)
+		assert.Equal(t, tc.transcript, Strip(tc.text), tc.text)
+		assert.Equal(t, tc.bark != tc.text, HasMarkup(tc.text), tc.text)
+	}
+}
@@ -0,0 +1,37 @@
+package httpsvc
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/audio"
+	"github.com/gin-gonic/gin"
+)
+
+// readWaveBody reads the wave audio of the request body and returns its content and metadata. If the audio is
+// missing, malformed, unsupported, or outside of the configured audio limits, it responds with a 4xx status and
+// returns false.
+func (svc *HttpService) readWaveBody(c *gin.Context) ([]byte, audio.Info, bool) {
+	if c.ContentType() != "audio/wav" && c.ContentType() != "audio/x-wav" && c.ContentType() != "audio/wave" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "request content type must be wave"})
+		return nil, audio.Info{}, false
+	}
+	wavContent, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "failed to read request body"})
+		return nil, audio.Info{}, false
+	}
+	info, err := audio.Validate(wavContent, svc.Config.AudioLimits)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, audio.ErrUnsupported) {
+			status = http.StatusUnsupportedMediaType
+		} else if errors.Is(err, audio.ErrOutOfBounds) {
+			status = http.StatusUnprocessableEntity
+		}
+		c.JSON(status, gin.H{"message": err.Error()})
+		return nil, audio.Info{}, false
+	}
+	return wavContent, info, true
+}
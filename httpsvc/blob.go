@@ -7,7 +7,7 @@ import (
 )
 
 func (svc *HttpService) DownloadBlobToLocalFileIfNotExist(ctx context.Context, blobContainerName, fileName, localDir string) (string, error) {
-	return shared.DownloadBlobToLocalFileIfNotExist(ctx, svc.BlobClient, blobContainerName, fileName, localDir)
+	return shared.DownloadBlobToLocalFileIfNotExist(ctx, svc.BlobStore, blobContainerName, fileName, localDir)
 }
 
 func (svc *HttpService) DownloadModelIfNotExist(ctx context.Context, fileName string) (string, error) {
@@ -15,9 +15,9 @@ func (svc *HttpService) DownloadModelIfNotExist(ctx context.Context, fileName st
 }
 
 func (svc *HttpService) UploadFromLocalFile(ctx context.Context, blobContainerName, fileName, localDir string) error {
-	return shared.UploadFromLocalFile(ctx, svc.BlobClient, blobContainerName, fileName, localDir)
+	return shared.UploadFromLocalFile(ctx, svc.BlobStore, blobContainerName, fileName, localDir)
 }
 
 func (svc *HttpService) UploadAndSave(ctx context.Context, blobContainerName, fileName, localDir string, data []byte) (string, error) {
-	return shared.UploadAndSave(ctx, svc.BlobClient, blobContainerName, fileName, localDir, data)
+	return shared.UploadAndSave(ctx, svc.BlobStore, blobContainerName, fileName, localDir, data)
 }
@@ -2,9 +2,6 @@ package httpsvc
 
 import (
 	"bytes"
-	"encoding/json"
-	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
@@ -31,41 +28,22 @@ func (svc *HttpService) handleReadback(c *gin.Context) {
 // handleRelayCloneRealTime is a gin handler that relays a real time voice cloning request to the voice service.
 // This is only used for experimenting, do not expose to the Internet.
 func (svc *HttpService) handleRelayCloneRealTime(c *gin.Context) {
-	if c.ContentType() != "audio/wav" && c.ContentType() != "audio/x-wav" && c.ContentType() != "audio/wave" {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "request content type must be wave"})
-		return
-	}
 	userID := c.Params.ByName("user_id")
 	if userID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"message": "user_id must be present"})
 		return
 	}
-	wavContent, err := io.ReadAll(c.Request.Body)
-	if err != nil || len(wavContent) < 100 {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "failed to read request body"})
+	wavContent, _, ok := svc.readWaveBody(c)
+	if !ok {
 		return
 	}
 	// Relay to voice service.
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/clone-rt/%s", svc.Config.VoiceServiceAddr, userID), bytes.NewReader(wavContent))
-	req.Header.Set("content-type", "audio/wav")
-	if err != nil {
-		log.Printf("failed to construct clone-rt request: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to construct voice service request"})
-		return
-	}
-	resp, err := svc.VoiceClient.Do(req)
+	cloneResp, err := svc.VoiceClient.Clone(c.Request.Context(), userID, bytes.NewReader(wavContent))
 	if err != nil {
 		log.Printf("failed to make clone-rt request: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to make voice service request"})
 		return
 	}
-	log.Printf("clone-rt responded with status %d and content length %d", resp.StatusCode, resp.ContentLength)
-	var cloneResp shared.CloneRealTimeResponse
-	if err := json.NewDecoder(resp.Body).Decode(&cloneResp); err != nil {
-		log.Printf("failed to deserialise clone-rt response: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to make voice service request"})
-		return
-	}
 	c.JSON(http.StatusOK, cloneResp)
 }
 
@@ -86,28 +64,16 @@ func (svc *HttpService) handleRelayTextToSpeechRealTime(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"message": "text must be longer than 2 characters"})
 		return
 	}
-	// Relay to voice service.
-	relayRequest, err := json.Marshal(ttsRequest)
-	if err != nil {
-		log.Printf("failed to construct tts-rt request: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to construct voice service request"})
-		return
-	}
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/tts-rt/%s", svc.Config.VoiceServiceAddr, userID), bytes.NewReader(relayRequest))
-	req.Header.Set("content-type", "application/json")
-	if err != nil {
-		log.Printf("failed to construct tts-rt request: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to construct voice service request"})
-		return
+	// The request without TTS settings speaks with the default preset.
+	if ttsRequest.TTSSettings == (shared.TTSSettings{}) {
+		ttsRequest.TTSSettings = shared.TTSPresets[shared.TTSPresetDefault]
 	}
-	resp, err := svc.VoiceClient.Do(req)
-	if err != nil {
-		log.Printf("failed to make tts-rt request: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to make voice service request"})
+	if err := ttsRequest.TTSSettings.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 		return
 	}
-	log.Printf("tts-rt responded with status %d and content length %d", resp.StatusCode, resp.ContentLength)
-	wavContent, err := io.ReadAll(resp.Body)
+	// Relay to voice service.
+	wavContent, err := svc.VoiceClient.Synthesize(c.Request.Context(), userID, ttsRequest)
 	if err != nil {
 		log.Printf("failed to make tts-rt request: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to make voice service request"})
@@ -215,13 +181,8 @@ type TranscribeRealTimeRespons struct {
 
 // handleTranscribeRealTime is a gin handler that uses ChatGPT Whisper API to transcribe the speech in the request body.
 func (svc *HttpService) handleTranscribeRealTime(c *gin.Context) {
-	if c.ContentType() != "audio/wav" && c.ContentType() != "audio/x-wav" && c.ContentType() != "audio/wave" {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "request content type must be wave"})
-		return
-	}
-	wavContent, err := io.ReadAll(c.Request.Body)
-	if err != nil || len(wavContent) < 100 {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "failed to read request body"})
+	wavContent, _, ok := svc.readWaveBody(c)
+	if !ok {
 		return
 	}
 	// Reference: https://platform.openai.com/docs/api-reference/audio/createTranscription
@@ -50,3 +50,21 @@ func (svc *HttpService) handleUpdateAIPerson(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, gin.H{})
 }
+
+// handleUpdateAIPersonExpressiveCues is a gin handler that allows or disallows the expressive cues, such as laughs and
+// pauses, in the replies of an AI personality.
+func (svc *HttpService) handleUpdateAIPersonExpressiveCues(c *gin.Context) {
+	aiPersonID, _ := strconv.Atoi(c.Params.ByName("ai_person_id"))
+	var req dbgen.UpdateAIPersonExpressiveCuesByIDParams
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	req.ID = int64(aiPersonID)
+	err := svc.Database.UpdateAIPersonExpressiveCuesByID(c.Request.Context(), req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
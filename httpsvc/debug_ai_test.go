@@ -0,0 +1,72 @@
+package httpsvc
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/audio"
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+	"github.com/HouzuoGuo/reconn-voice-clone/voiceclient"
+	"github.com/HouzuoGuo/reconn-voice-clone/voiceclient/voicetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelayCloneAndTextToSpeech(t *testing.T) {
+	voiceService := voicetest.NewServer(t.TempDir())
+	defer voiceService.Close()
+	svc, router := setupRouter(t)
+	svc.VoiceClient = voiceclient.NewBalancer([]string{voiceService.Addr}, http.DefaultClient, voiceclient.BalancerConfig{})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/debug/clone-rt/123", bytes.NewReader(voicetest.Synthesize([]byte("speaker"), "the voice sample")))
+	req.Header.Set("content-type", "audio/wav")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"model":"123.npz"}`, w.Body.String())
+
+	// The audio that is not wave, or is too short, is rejected before reaching the voice service.
+	svc.Config.AudioLimits = audio.DefaultLimits
+	for body, status := range map[string]int{
+		"ID3 an mp3 mislabelled as wave":                      http.StatusBadRequest,
+		string(voicetest.Synthesize([]byte("speaker"), "hi")): http.StatusUnprocessableEntity,
+	} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("POST", "/api/debug/clone-rt/123", bytes.NewReader([]byte(body)))
+		req.Header.Set("content-type", "audio/wav")
+		router.ServeHTTP(w, req)
+		assert.Equal(t, status, w.Code, w.Body.String())
+	}
+	assert.Equal(t, 1, voiceService.Requests("clone-rt"))
+
+	ttsRequest, _ := json.Marshal(shared.TextToSpeechRealTimeRequest{Text: "hello there"})
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/debug/tts-rt/123", bytes.NewReader(ttsRequest))
+	req.Header.Set("content-type", "application/json")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "audio/wav", w.Header().Get("content-type"))
+	assert.Equal(t, "RIFF", w.Body.String()[:4])
+
+	// The out of range TTS settings are rejected before reaching the voice service.
+	badSettings := shared.TTSPresets[shared.TTSPresetDefault]
+	badSettings.TopP = 2
+	badRequest, _ := json.Marshal(shared.TextToSpeechRealTimeRequest{Text: "hello there", TTSSettings: badSettings})
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/debug/tts-rt/123", bytes.NewReader(badRequest))
+	req.Header.Set("content-type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, 1, voiceService.Requests("tts-rt"))
+
+	// The voice service failure is not relayed as speech.
+	voiceService.FailNext(1, http.StatusInternalServerError)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/debug/tts-rt/123", bytes.NewReader(ttsRequest))
+	req.Header.Set("content-type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
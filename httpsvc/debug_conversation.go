@@ -4,24 +4,23 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
 	"path"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/HouzuoGuo/reconn-voice-clone/audio"
 	"github.com/HouzuoGuo/reconn-voice-clone/db/dbgen"
+	"github.com/HouzuoGuo/reconn-voice-clone/expressive"
 	"github.com/HouzuoGuo/reconn-voice-clone/shared"
 	openai "github.com/sashabaranov/go-openai"
 )
 
-func (svc *HttpService) chatCompletionRequest(ctx context.Context, aiPersonID int, contextPrompt, newUserPrompt string) (ret openai.ChatCompletionRequest, err error) {
+func (svc *HttpService) chatCompletionRequest(ctx context.Context, aiPersonID int, contextPrompt string, expressiveCues bool, newUserPrompt string) (ret openai.ChatCompletionRequest, err error) {
 	// Read the latest 10 messages back and forth.
 	recentMessages, err := svc.Database.ListConversations(ctx, dbgen.ListConversationsParams{
 		AiPersonID: int64(aiPersonID),
@@ -31,6 +30,9 @@ func (svc *HttpService) chatCompletionRequest(ctx context.Context, aiPersonID in
 		log.Printf("get latest conversations error: %v", err)
 		return
 	}
+	if expressiveCues {
+		contextPrompt += "\n" + expressive.Instructions
+	}
 	ret = openai.ChatCompletionRequest{
 		Model: "gpt-4",
 		// TODO FIXME: the response abruptly ends after exceeding the token limit.
@@ -57,7 +59,13 @@ func (svc *HttpService) chatCompletionRequest(ctx context.Context, aiPersonID in
 				Content: userPrompt,
 			})
 		}
-		if aiReply := recent.ReplyMessage.String; aiReply != "" {
+		// The LLM sees the expressive cues of its own replies, so that it keeps using them.
+		if aiReply := recent.ReplySpeechMarkup.String; aiReply != "" {
+			ret.Messages = append(ret.Messages, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleAssistant,
+				Content: aiReply,
+			})
+		} else if aiReply := recent.ReplyMessage.String; aiReply != "" {
 			ret.Messages = append(ret.Messages, openai.ChatCompletionMessage{
 				Role:    openai.ChatMessageRoleAssistant,
 				Content: aiReply,
@@ -72,11 +80,170 @@ func (svc *HttpService) chatCompletionRequest(ctx context.Context, aiPersonID in
 	return
 }
 
+// finishAIPersonReply records the final outcome of generating an AI person's reply, the error is nil if the reply is ready.
+func (svc *HttpService) finishAIPersonReply(ctx context.Context, aiReplyID int64, message string, speechMarkup sql.NullString, replyErr error) {
+	params := dbgen.FinishAIPersonReplyByIDParams{ID: aiReplyID, Status: "ready", Message: message, SpeechMarkup: speechMarkup}
+	if replyErr != nil {
+		params.Status = "failed"
+		params.ErrorMessage = sql.NullString{String: replyErr.Error(), Valid: true}
+	}
+	if err := svc.Database.FinishAIPersonReplyByID(ctx, params); err != nil {
+		log.Printf("finish ai person reply by id error: %v", err)
+	}
+}
+
+// generateReply asks the LLM to reply to the user prompt, and records the reply in database along with the failure if any.
+// If the AI person allows expressive cues, the LLM is asked to write them in the reply, and the reply record keeps
+// them apart from the transcript.
+func (svc *HttpService) generateReply(ctx context.Context, aiPersonID int, promptID int64, contextPrompt string, expressiveCues bool, userMessage string) (dbgen.AiPersonReply, error) {
+	aiReply, err := svc.Database.CreateAIPersonReply(ctx, dbgen.CreateAIPersonReplyParams{
+		UserPromptID: promptID,
+		Status:       "processing",
+		Timestamp:    time.Now(),
+	})
+	if err != nil {
+		return aiReply, fmt.Errorf("create ai person reply error: %w", err)
+	}
+	if err := svc.Database.StartAIPersonReplyAttemptByID(ctx, aiReply.ID); err != nil {
+		return aiReply, fmt.Errorf("start ai person reply attempt error: %w", err)
+	}
+	// Generate the chat completion request, given the recent history.
+	completionRequest, err := svc.chatCompletionRequest(ctx, aiPersonID, contextPrompt, expressiveCues, userMessage)
+	if err != nil {
+		err = fmt.Errorf("chat completion request construction error: %w", err)
+		svc.finishAIPersonReply(ctx, aiReply.ID, "", sql.NullString{}, err)
+		return aiReply, err
+	}
+	log.Printf("Chat completion request for AI person %d is: %+v", aiPersonID, completionRequest)
+	// Feed both context prompt and user prompt to LLM.
+	resp, err := svc.OpenAIClient.CreateChatCompletion(ctx, completionRequest)
+	if err != nil {
+		err = fmt.Errorf("create chat completion error: %w", err)
+		svc.finishAIPersonReply(ctx, aiReply.ID, "", sql.NullString{}, err)
+		return aiReply, err
+	}
+	var reply string
+	for _, choice := range resp.Choices {
+		reply += choice.Message.Content + " "
+	}
+	// The LLM may write the markup even if it is not asked to, the transcript never has it.
+	aiReply.Message = expressive.Strip(reply)
+	if expressiveCues && expressive.HasMarkup(reply) {
+		aiReply.SpeechMarkup = sql.NullString{String: reply, Valid: true}
+	}
+	svc.finishAIPersonReply(ctx, aiReply.ID, aiReply.Message, aiReply.SpeechMarkup, nil)
+	aiReply.Status = "ready"
+	log.Printf("ai reply: %+v", aiReply)
+	return aiReply, nil
+}
+
+// transcribeVoicePrompt transcribes the user's voice message, and records the voice prompt in database along with the
+// audio metadata and the failure if any.
+func (svc *HttpService) transcribeVoicePrompt(ctx context.Context, promptID int64, fileName string, voiceWaveform []byte, wavInfo audio.Info) (dbgen.UserVoicePrompt, error) {
+	voicePrompt, err := svc.Database.CreateUserVoicePrompt(ctx, dbgen.CreateUserVoicePromptParams{
+		UserPromptID:    promptID,
+		Status:          "processing",
+		FileName:        fileName,
+		AudioFormat:     wavInfo.Format,
+		SampleRate:      int32(wavInfo.SampleRate),
+		Channels:        int32(wavInfo.Channels),
+		BitsPerSample:   int32(wavInfo.BitsPerSample),
+		DurationSeconds: wavInfo.Duration.Seconds(),
+	})
+	if err != nil {
+		return voicePrompt, fmt.Errorf("create user voice prompt error: %w", err)
+	}
+	if err := svc.Database.StartUserVoicePromptAttemptByID(ctx, voicePrompt.ID); err != nil {
+		return voicePrompt, fmt.Errorf("start user voice prompt attempt error: %w", err)
+	}
+	transcriptionResponse, err := svc.OpenAIClient.CreateTranscription(ctx, openai.AudioRequest{
+		Model: "whisper-1",
+		// The file path is part of the form submission, the extension name must accurately indicate the audio format.
+		FilePath: "input.wav",
+		Reader:   bytes.NewReader(voiceWaveform),
+		Format:   openai.AudioResponseFormatJSON,
+	})
+	params := dbgen.FinishUserVoicePromptByIDParams{ID: voicePrompt.ID, Status: "ready"}
+	if err != nil {
+		err = fmt.Errorf("failed to invoke whisper: %w", err)
+		params.Status = "failed"
+		params.ErrorMessage = sql.NullString{String: err.Error(), Valid: true}
+	} else {
+		params.Transcription = sql.NullString{String: transcriptionResponse.Text, Valid: true}
+	}
+	if finishErr := svc.Database.FinishUserVoicePromptByID(ctx, params); finishErr != nil {
+		log.Printf("finish user voice prompt by id error: %v", finishErr)
+	}
+	voicePrompt.Status = params.Status
+	voicePrompt.Transcription = params.Transcription
+	voicePrompt.ErrorMessage = params.ErrorMessage
+	return voicePrompt, err
+}
+
+// speakReplyRealTime converts the AI person's reply into speech in real time using the voice model, and saves the speech to
+// the voice output file.
+func (svc *HttpService) speakReplyRealTime(ctx context.Context, aiPersonID, voiceModelID int64, modelFileName, message, fileName string) error {
+	ttsSettings, err := shared.ResolveTTSSettings(ctx, svc.Database, aiPersonID, voiceModelID)
+	if err != nil {
+		return err
+	}
+	// Download the model file to local disk and then relay to python voice server.
+	modelPath, err := svc.DownloadModelIfNotExist(ctx, modelFileName)
+	if err != nil {
+		return fmt.Errorf("download model error: %w", err)
+	}
+	ttsRequest := shared.TextToSpeechRealTimeRequest{
+		Text:        svc.TextNormalizer.Normalize(message),
+		TTSSettings: ttsSettings.TTSSettings,
+	}
+	// The same speech synthesized before is served from the cache.
+	ttsWaveContent, err := svc.TTSCache.Synthesize(ctx, modelPath, ttsRequest, func() ([]byte, error) {
+		wavContent, err := svc.VoiceClient.Synthesize(ctx, modelFileName, ttsRequest)
+		if err != nil {
+			return nil, fmt.Errorf("tts request error: %w", err)
+		}
+		return wavContent, nil
+	})
+	if err != nil {
+		return err
+	}
+	// Save the converted speech.
+	if _, err := svc.UploadAndSave(ctx, svc.Config.VoiceOutputContainer, fileName, svc.Config.VoiceOutputDir, ttsWaveContent); err != nil {
+		return fmt.Errorf("upload and save error: %w", err)
+	}
+	return nil
+}
+
+// createReplyVoice records the outcome of converting an AI person's reply into speech in real time, the error is nil if the speech is ready.
+func (svc *HttpService) createReplyVoice(ctx context.Context, aiReplyID int64, fileName string, ttsErr error) (dbgen.AiPersonReplyVoice, error) {
+	aiReplyVoice, err := svc.Database.CreateAIPersonReplyVoice(ctx, dbgen.CreateAIPersonReplyVoiceParams{
+		AiPersonReplyID: aiReplyID,
+		Status:          "processing",
+	})
+	if err != nil {
+		return aiReplyVoice, fmt.Errorf("create ai person reply voice error: %w", err)
+	}
+	params := dbgen.FinishAIPersonReplyVoiceByIDParams{ID: aiReplyVoice.ID, Status: "ready", FileName: sql.NullString{String: fileName, Valid: true}}
+	if ttsErr != nil {
+		params.Status = "failed"
+		params.FileName = sql.NullString{}
+		params.ErrorMessage = sql.NullString{String: ttsErr.Error(), Valid: true}
+	}
+	if err := svc.Database.FinishAIPersonReplyVoiceByID(ctx, params); err != nil {
+		return aiReplyVoice, fmt.Errorf("finish ai person reply voice by id error: %w", err)
+	}
+	aiReplyVoice.Status = params.Status
+	aiReplyVoice.FileName = params.FileName
+	aiReplyVoice.ErrorMessage = params.ErrorMessage
+	return aiReplyVoice, nil
+}
+
 type PostTextMessage struct {
 	Message string `json:"message"`
 }
 
 // handlePostTextMessage is a gin handler that posts a text message to an AI person and synchronously awaits for a response.
+// The client accepting text/event-stream receives the reply as server-sent events, see streamReply.
 func (svc *HttpService) handlePostTextMessage(c *gin.Context) {
 	aiPersonID, _ := strconv.Atoi(c.Params.ByName("ai_person_id"))
 	var req PostTextMessage
@@ -112,104 +279,41 @@ func (svc *HttpService) handlePostTextMessage(c *gin.Context) {
 		return
 	}
 	log.Printf("ai person and model: %+v", aiPersonAndModel)
-	// Generate the chat completion request, given the recent history.
-	completionRequest, err := svc.chatCompletionRequest(c.Request.Context(), aiPersonID, aiPersonAndModel.AiContextPrompt, req.Message)
-	if err != nil {
-		log.Printf("chat completion request construction error: %+v", err)
-		c.JSON(http.StatusInternalServerError, err.Error())
-		return
-	}
-	log.Printf("Chat completion request for AI person %d is: %+v", aiPersonID, completionRequest)
-	// Feed both context prompt and text prompt to LLM.
-	resp, err := svc.OpenAIClient.CreateChatCompletion(c.Request.Context(), completionRequest)
+	aiReply, err := svc.generateReply(c.Request.Context(), aiPersonID, prompt.ID, aiPersonAndModel.AiContextPrompt, aiPersonAndModel.AiExpressiveCues, req.Message)
 	if err != nil {
-		log.Printf("create chat completion error: %v", err)
+		log.Printf("generate reply error: %v", err)
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
-	var llmReply string
-	for _, choice := range resp.Choices {
-		llmReply += choice.Message.Content + " "
-	}
-	// Create the AI person reply in database.
-	timestamp := time.Now()
-	aiReply, err := svc.Database.CreateAIPersonReply(c.Request.Context(), dbgen.CreateAIPersonReplyParams{
-		UserPromptID: prompt.ID,
-		Status:       "ready",
-		Message:      llmReply,
-		Timestamp:    timestamp,
-	})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, err.Error())
-		return
-	}
-	log.Printf("ai reply: %+v", aiReply)
 	// Convert the reply into voice in real time.
-	ttsRequestBody, err := json.Marshal(shared.TextToSpeechRealTimeRequest{
-		Text:         llmReply,
-		TopK:         99,
-		TopP:         0.8,
-		MineosP:      0.01,
-		SemanticTemp: 0.8,
-		WaveformTemp: 0.6,
-		FineTemp:     0.5,
-	})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, err)
-		return
-	}
-	// Download the model file to local disk and then relay to python voice server.
-	if _, err := svc.DownloadModelIfNotExist(c.Request.Context(), aiPersonAndModel.FileName.String); err != nil {
-		log.Printf("download model error: %v", err)
-		c.JSON(http.StatusInternalServerError, err)
+	fileName := fmt.Sprintf("%d-%s.wav", aiPersonID, aiReply.Timestamp.Format(time.RFC3339))
+	if wantsEventStream(c) {
+		svc.streamReply(c, int64(aiPersonID), aiPersonAndModel, aiReply, fileName)
 		return
 	}
-	ttsRequest, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/tts-rt/%s", svc.Config.VoiceServiceAddr, strings.TrimSuffix(aiPersonAndModel.FileName.String, ".npz")), bytes.NewReader(ttsRequestBody))
-	ttsRequest.Header.Set("content-type", "application/json")
-	if err != nil {
-		log.Printf("tts request construction error: %v", err)
-		c.JSON(http.StatusInternalServerError, err)
-		return
+	ttsErr := svc.speakReplyRealTime(c.Request.Context(), int64(aiPersonID), aiPersonAndModel.ID, aiPersonAndModel.FileName.String, shared.ReplySpeech(aiReply), fileName)
+	if ttsErr != nil {
+		log.Printf("speak reply error: %v", ttsErr)
 	}
-	ttsResponse, err := svc.VoiceClient.Do(ttsRequest)
-	if err != nil {
-		log.Printf("tts request error: %v", err)
-		c.JSON(http.StatusInternalServerError, err)
-		return
-	}
-	log.Printf("tts-rt responded with status %d and content length %d", ttsResponse.StatusCode, ttsResponse.ContentLength)
-	ttsWaveContent, err := io.ReadAll(ttsResponse.Body)
+	// Create the AI reply record in database.
+	aiReplyVoice, err := svc.createReplyVoice(c.Request.Context(), aiReply.ID, fileName, ttsErr)
 	if err != nil {
-		log.Printf("failed to read tts response body: %v", err)
-		c.JSON(http.StatusInternalServerError, err)
+		log.Printf("create reply voice error: %v", err)
+		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
-	// Save the converted speech.
-	fileName := fmt.Sprintf("%d-%s.wav", aiPersonID, timestamp.Format(time.RFC3339))
-	if _, err := svc.UploadAndSave(c.Request.Context(), svc.Config.VoiceOutputContainer, fileName, svc.Config.VoiceOutputDir, ttsWaveContent); err != nil {
-		log.Printf("upload and save error: %v", err)
-		c.JSON(http.StatusInternalServerError, err)
+	if ttsErr != nil {
+		c.JSON(http.StatusInternalServerError, ttsErr.Error())
 		return
 	}
-	// Create the AI reply record in database.
-	aiReplyVoice, err := svc.Database.CreateAIPersonReplyVoice(c.Request.Context(), dbgen.CreateAIPersonReplyVoiceParams{
-		AiPersonReplyID: aiReply.ID,
-		Status:          "ready",
-		FileName:        sql.NullString{String: fileName, Valid: true},
-	})
 	c.JSON(http.StatusOK, aiReplyVoice)
 }
 
 // handlePostTextMessage is a gin handler that posts a voice message to an AI person and synchronously awaits for a response.
 func (svc *HttpService) handlePostVoiceMessage(c *gin.Context) {
 	aiPersonID, _ := strconv.Atoi(c.Params.ByName("ai_person_id"))
-	if c.ContentType() != "audio/wav" && c.ContentType() != "audio/x-wav" && c.ContentType() != "audio/wave" {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "request content type must be wave"})
-		return
-	}
-	voiceWaveform, err := io.ReadAll(c.Request.Body)
-	if err != nil || len(voiceWaveform) < 100 {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "failed to read request body"})
+	voiceWaveform, wavInfo, ok := svc.readWaveBody(c)
+	if !ok {
 		return
 	}
 	// Save the voice message to disk.
@@ -220,20 +324,7 @@ func (svc *HttpService) handlePostVoiceMessage(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
-	// Transcribe the message in real time.
-	transcriptionResponse, err := svc.OpenAIClient.CreateTranscription(c.Request.Context(), openai.AudioRequest{
-		Model: "whisper-1",
-		// The file path is part of the form submission, the extension name must accurately indicate the audio format.
-		FilePath: "input.wav",
-		Reader:   bytes.NewReader(voiceWaveform),
-		Format:   openai.AudioResponseFormatJSON,
-	})
-	if err != nil {
-		log.Printf("failed to invoke whisper: %v", err)
-		c.JSON(http.StatusInternalServerError, err.Error())
-		return
-	}
-	// Create the user voice prompt in database.
+	// Create the user voice prompt in database and transcribe the message in real time.
 	prompt, err := svc.Database.CreateUserPrompt(c.Request.Context(), dbgen.CreateUserPromptParams{
 		AiPersonID: int64(aiPersonID),
 		Timestamp:  time.Now(),
@@ -242,14 +333,9 @@ func (svc *HttpService) handlePostVoiceMessage(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
-	voicePrompt, err := svc.Database.CreateUserVoicePrompt(c.Request.Context(), dbgen.CreateUserVoicePromptParams{
-		UserPromptID:  prompt.ID,
-		Status:        "ready",
-		FileName:      sampleFileName,
-		Transcription: sql.NullString{String: transcriptionResponse.Text, Valid: true},
-	})
+	voicePrompt, err := svc.transcribeVoicePrompt(c.Request.Context(), prompt.ID, sampleFileName, voiceWaveform, wavInfo)
 	if err != nil {
-		log.Printf("create user voice prompt error: %v", err)
+		log.Printf("transcribe voice prompt error: %v", err)
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -262,81 +348,33 @@ func (svc *HttpService) handlePostVoiceMessage(c *gin.Context) {
 		return
 	}
 	log.Printf("ai person and model: %+v", aiPersonAndModel)
-	// Generate the chat completion request, given the recent history.
-	completionRequest, err := svc.chatCompletionRequest(c.Request.Context(), aiPersonID, aiPersonAndModel.AiContextPrompt, transcriptionResponse.Text)
-	if err != nil {
-		log.Printf("chat completion request construction error: %+v", err)
-		c.JSON(http.StatusInternalServerError, err.Error())
-		return
-	}
-	log.Printf("Chat completion request for AI person %d is: %+v", aiPersonID, completionRequest)
-	resp, err := svc.OpenAIClient.CreateChatCompletion(c.Request.Context(), completionRequest)
-	if err != nil {
-		log.Printf("create chat completion error: %v", err)
-		c.JSON(http.StatusInternalServerError, err.Error())
-		return
-	}
-	var llmReply string
-	for _, choice := range resp.Choices {
-		llmReply += choice.Message.Content + " "
-	}
-	// Create the AI person reply in database.
-	aiReply, err := svc.Database.CreateAIPersonReply(c.Request.Context(), dbgen.CreateAIPersonReplyParams{
-		UserPromptID: prompt.ID,
-		Status:       "ready",
-		Message:      llmReply,
-		Timestamp:    timestamp,
-	})
+	aiReply, err := svc.generateReply(c.Request.Context(), aiPersonID, prompt.ID, aiPersonAndModel.AiContextPrompt, aiPersonAndModel.AiExpressiveCues, voicePrompt.Transcription.String)
 	if err != nil {
+		log.Printf("generate reply error: %v", err)
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
-	log.Printf("ai reply: %+v", aiReply)
 	// Convert the reply into voice in real time.
-	ttsRequestBody, err := json.Marshal(shared.TextToSpeechRealTimeRequest{
-		Text:         llmReply,
-		TopK:         99,
-		TopP:         0.8,
-		MineosP:      0.01,
-		SemanticTemp: 0.8,
-		WaveformTemp: 0.6,
-		FineTemp:     0.5,
-	})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, err)
-		return
-	}
-	ttsRequest, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/tts-rt/%s", svc.Config.VoiceServiceAddr, strings.TrimSuffix(aiPersonAndModel.FileName.String, ".npz")), bytes.NewReader(ttsRequestBody))
-	ttsRequest.Header.Set("content-type", "application/json")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, err)
+	fileName := fmt.Sprintf("reply-%d-%s.wav", aiPersonID, timestamp.Format(time.RFC3339))
+	if wantsEventStream(c) {
+		svc.streamReply(c, int64(aiPersonID), aiPersonAndModel, aiReply, fileName)
 		return
 	}
-	ttsResponse, err := svc.VoiceClient.Do(ttsRequest)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, err)
-		return
+	ttsErr := svc.speakReplyRealTime(c.Request.Context(), int64(aiPersonID), aiPersonAndModel.ID, aiPersonAndModel.FileName.String, shared.ReplySpeech(aiReply), fileName)
+	if ttsErr != nil {
+		log.Printf("speak reply error: %v", ttsErr)
 	}
-	log.Printf("tts-rt responded with status %d and content length %d", ttsResponse.StatusCode, ttsResponse.ContentLength)
-	ttsWaveContent, err := io.ReadAll(ttsResponse.Body)
+	// Create the AI reply record in database.
+	aiReplyVoice, err := svc.createReplyVoice(c.Request.Context(), aiReply.ID, fileName, ttsErr)
 	if err != nil {
-		log.Printf("failed to read tts response body: %v", err)
-		c.JSON(http.StatusInternalServerError, err)
+		log.Printf("create reply voice error: %v", err)
+		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
-	// Save the converted speech.
-	fileName := fmt.Sprintf("reply-%d-%s.wav", aiPersonID, timestamp.Format(time.RFC3339))
-	if _, err := svc.UploadAndSave(c.Request.Context(), svc.Config.VoiceOutputContainer, fileName, svc.Config.VoiceOutputDir, ttsWaveContent); err != nil {
-		log.Printf("upload and save error: %v", err)
-		c.JSON(http.StatusInternalServerError, err)
+	if ttsErr != nil {
+		c.JSON(http.StatusInternalServerError, ttsErr.Error())
 		return
 	}
-	// Create the AI reply record in database.
-	aiReplyVoice, err := svc.Database.CreateAIPersonReplyVoice(c.Request.Context(), dbgen.CreateAIPersonReplyVoiceParams{
-		AiPersonReplyID: aiReply.ID,
-		Status:          "ready",
-		FileName:        sql.NullString{String: fileName, Valid: true},
-	})
 	c.JSON(http.StatusOK, aiReplyVoice)
 }
 
@@ -0,0 +1,120 @@
+package httpsvc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/audio"
+	"github.com/HouzuoGuo/reconn-voice-clone/db/dbgen"
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+	"github.com/gin-gonic/gin"
+)
+
+// ReplySegment is the server-sent "segment" event of a sentence of the AI person's reply spoken in real time.
+type ReplySegment struct {
+	// Index is the position of the segment in the reply, starting from 0.
+	Index int `json:"index"`
+	// Text is the sentence spoken in the segment, in the words it is spoken.
+	Text string `json:"text"`
+	// Audio is the wave content of the segment's speech, encoded in base64.
+	Audio []byte `json:"audio"`
+}
+
+// wantsEventStream returns true if the client asks for the reply to be streamed as server-sent events.
+func wantsEventStream(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+}
+
+// sendEvent sends a server-sent event to the client straight away, and returns an error if the client has gone away.
+func sendEvent(c *gin.Context, name string, data any) error {
+	c.SSEvent(name, data)
+	c.Writer.Flush()
+	return c.Request.Context().Err()
+}
+
+// streamReplyRealTime converts the AI person's reply into speech sentence by sentence, streams the speech of each
+// sentence to the client as soon as it is ready, and saves the speech of the whole reply to the voice output file.
+func (svc *HttpService) streamReplyRealTime(c *gin.Context, aiPersonID, voiceModelID int64, modelFileName, message, fileName string) error {
+	ctx := c.Request.Context()
+	ttsSettings, err := shared.ResolveTTSSettings(ctx, svc.Database, aiPersonID, voiceModelID)
+	if err != nil {
+		return err
+	}
+	// Download the model file to local disk and then relay to python voice server.
+	modelPath, err := svc.DownloadModelIfNotExist(ctx, modelFileName)
+	if err != nil {
+		return fmt.Errorf("download model error: %w", err)
+	}
+	// The speech of the whole reply synthesized before is served from the cache as a single segment.
+	speakable := svc.TextNormalizer.Normalize(message)
+	cacheKey, ttsWaveContent, hit, err := svc.TTSCache.Lookup(ctx, modelPath, shared.TextToSpeechRealTimeRequest{Text: speakable, TTSSettings: ttsSettings.TTSSettings})
+	if err != nil {
+		return err
+	}
+	if hit {
+		if err := sendEvent(c, "segment", ReplySegment{Index: 0, Text: speakable, Audio: ttsWaveContent}); err != nil {
+			return err
+		}
+	} else {
+		sentences := shared.SplitSentences(speakable)
+		if len(sentences) == 0 {
+			return errors.New("the reply has nothing to speak")
+		}
+		// The voice service speaks the later sentences in the voice of the first.
+		continuationID := shared.NewID()
+		ttsRequests := make([]shared.TextToSpeechRealTimeRequest, len(sentences))
+		for i, sentence := range sentences {
+			ttsRequests[i] = shared.TextToSpeechRealTimeRequest{
+				Text:           sentence,
+				TTSSettings:    ttsSettings.TTSSettings,
+				ContinuationID: continuationID,
+				LastSegment:    i == len(sentences)-1,
+			}
+		}
+		segments := make([][]byte, 0, len(sentences))
+		err := svc.VoiceClient.SynthesizeSegments(ctx, modelFileName, ttsRequests, func(index int, wavContent []byte) error {
+			segments = append(segments, wavContent)
+			return sendEvent(c, "segment", ReplySegment{Index: index, Text: sentences[index], Audio: wavContent})
+		})
+		if err != nil {
+			return fmt.Errorf("tts request error: %w", err)
+		}
+		if ttsWaveContent, err = audio.Concat(segments); err != nil {
+			return fmt.Errorf("concatenate speech segments error: %w", err)
+		}
+		svc.TTSCache.Store(ctx, cacheKey, modelPath, ttsWaveContent)
+	}
+	// Save the converted speech.
+	if _, err := svc.UploadAndSave(ctx, svc.Config.VoiceOutputContainer, fileName, svc.Config.VoiceOutputDir, ttsWaveContent); err != nil {
+		return fmt.Errorf("upload and save error: %w", err)
+	}
+	return nil
+}
+
+// streamReply streams the AI person's reply to the client as server-sent events: a "reply" event of the reply text,
+// a "segment" event of each sentence's speech, and finally a "voice" event of the reply voice record, or an "error"
+// event if the speech fails.
+func (svc *HttpService) streamReply(c *gin.Context, aiPersonID int64, aiPersonAndModel dbgen.GetLatestVoiceModelRow, aiReply dbgen.AiPersonReply, fileName string) {
+	ttsErr := sendEvent(c, "reply", aiReply)
+	if ttsErr == nil {
+		ttsErr = svc.streamReplyRealTime(c, aiPersonID, aiPersonAndModel.ID, aiPersonAndModel.FileName.String, shared.ReplySpeech(aiReply), fileName)
+	}
+	if ttsErr != nil {
+		log.Printf("stream reply error: %v", ttsErr)
+	}
+	// Record the outcome even if the client has gone away.
+	aiReplyVoice, err := svc.createReplyVoice(context.WithoutCancel(c.Request.Context()), aiReply.ID, fileName, ttsErr)
+	if err != nil {
+		log.Printf("create reply voice error: %v", err)
+		_ = sendEvent(c, "error", gin.H{"message": err.Error()})
+		return
+	}
+	if ttsErr != nil {
+		_ = sendEvent(c, "error", gin.H{"message": ttsErr.Error()})
+		return
+	}
+	_ = sendEvent(c, "voice", aiReplyVoice)
+}
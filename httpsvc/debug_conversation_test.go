@@ -0,0 +1,256 @@
+package httpsvc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/audio"
+	"github.com/HouzuoGuo/reconn-voice-clone/db/dbgen"
+	"github.com/HouzuoGuo/reconn-voice-clone/db/dbtest"
+	"github.com/HouzuoGuo/reconn-voice-clone/expressive"
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+	"github.com/HouzuoGuo/reconn-voice-clone/voiceclient/voicetest"
+	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testReply is what the fake LLM replies to every message, and testSpokenReply is how the reply is spoken.
+const (
+	testReply       = "Nice to hear from you. I have been **well** since 3pm."
+	testSpokenReply = "Nice to hear from you. I have been well since three p m."
+)
+
+// setupConversation returns an http service connected to the test database, a fake voice service, and a fake LLM.
+func setupConversation(t *testing.T) (*HttpService, *gin.Engine, *voicetest.Server) {
+	t.Helper()
+	dbtest.Connect(t)
+	voiceModelDir := t.TempDir()
+	voiceService := voicetest.NewServer(voiceModelDir)
+	t.Cleanup(voiceService.Close)
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{
+			{Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: testReply}},
+		}})
+	}))
+	t.Cleanup(llm.Close)
+
+	svc, err := New(&Config{
+		DebugMode:            true,
+		VoiceServiceAddr:     voiceService.Addr,
+		Database:             dbtest.Config(t),
+		VoiceSampleDir:       t.TempDir(),
+		VoiceModelDir:        voiceModelDir,
+		VoiceTempModelDir:    t.TempDir(),
+		VoiceOutputDir:       t.TempDir(),
+		VoiceSampleContainer: "voice-samples",
+		VoiceModelContainer:  "voice-models",
+		VoiceOutputContainer: "voice-outputs",
+		TTSCache:             shared.TTSCacheConfig{VoiceServiceVersion: t.Name() + shared.NewID()},
+		BlobStore:            shared.BlobStoreConfig{Backend: shared.BlobStoreLocal, LocalDir: t.TempDir()},
+		TaskQueue:            shared.TaskQueueConfig{Backend: shared.TaskQueueMemory, Name: t.Name()},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = svc.Close(context.Background()) })
+	llmConf := openai.DefaultConfig("test")
+	llmConf.BaseURL = llm.URL + "/v1"
+	svc.OpenAIClient = openai.NewClientWithConfig(llmConf)
+	return svc, svc.SetupRouter(), voiceService
+}
+
+// serveJSON makes the request to the router, and decodes the successful JSON response.
+func serveJSON(t *testing.T, router *gin.Engine, method, path, contentType string, body []byte, resp any) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("content-type", contentType)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), resp))
+}
+
+// createAIPerson creates an AI person of a new user, and clones the voice of the AI person.
+func createAIPerson(t *testing.T, svc *HttpService, router *gin.Engine) (dbgen.AiPerson, dbgen.VoiceModel) {
+	t.Helper()
+	ctx := context.Background()
+	user, err := svc.Database.CreateUser(ctx, dbgen.CreateUserParams{Name: "test-" + shared.NewID(), Status: "normal"})
+	require.NoError(t, err)
+	aiPerson, err := svc.Database.CreateAIPerson(ctx, dbgen.CreateAIPersonParams{UserID: user.ID, Name: "grandma", ContextPrompt: "You are a grandma."})
+	require.NoError(t, err)
+
+	// Clone the voice sample into a voice model.
+	var voiceSample dbgen.VoiceSample
+	serveJSON(t, router, "POST", fmt.Sprintf("/api/debug/ai_person/%d/voice_sample", aiPerson.ID), "audio/wav", voicetest.Synthesize([]byte("grandma"), "the voice sample of grandma"), &voiceSample)
+	var voiceModel dbgen.VoiceModel
+	serveJSON(t, router, "POST", fmt.Sprintf("/api/debug/voice_sample/%d/create_model", voiceSample.ID), "", nil, &voiceModel)
+	assert.Equal(t, "ready", voiceModel.Status)
+	assert.Equal(t, fmt.Sprintf("%d.npz", voiceSample.ID), voiceModel.FileName.String)
+	return aiPerson, voiceModel
+}
+
+func TestConversationPipeline(t *testing.T) {
+	svc, router, voiceService := setupConversation(t)
+	ctx := context.Background()
+	aiPerson, voiceModel := createAIPerson(t, svc, router)
+
+	// Converse and listen to the reply.
+	var replyVoice dbgen.AiPersonReplyVoice
+	serveJSON(t, router, "POST", fmt.Sprintf("/api/debug/ai_person/%d/post_text_message", aiPerson.ID), "application/json", []byte(`{"message": "How are you?"}`), &replyVoice)
+	assert.Equal(t, "ready", replyVoice.Status)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/debug/voice_output_file/"+url.PathEscape(replyVoice.FileName.String), nil)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	modelPath, err := svc.DownloadModelIfNotExist(ctx, voiceModel.FileName.String)
+	require.NoError(t, err)
+	model, err := os.ReadFile(modelPath)
+	require.NoError(t, err)
+	assert.Equal(t, 1, voiceService.Requests("tts-rt"))
+	assert.Equal(t, voicetest.Synthesize(model, testSpokenReply), w.Body.Bytes())
+
+	// A voice service failure fails the reply voice instead of saving the error as speech.
+	voiceService.FailNext(1, http.StatusInternalServerError)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/debug/ai_person/%d/post_text_message", aiPerson.ID), bytes.NewReader([]byte(`{"message": "Are you there?"}`)))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	conversation, err := svc.Database.ListConversations(ctx, dbgen.ListConversationsParams{AiPersonID: aiPerson.ID, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, conversation, 1)
+	assert.Equal(t, "failed", conversation[0].ReplyVoiceStatus.String)
+}
+
+// serveEvents makes the request to the router asking for server-sent events, and returns the name and data of each event.
+func serveEvents(t *testing.T, router *gin.Engine, path string, body []byte) (names []string, data []string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", path, bytes.NewReader(body))
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("accept", "text/event-stream")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "text/event-stream", w.Header().Get("content-type"))
+	for _, event := range strings.Split(strings.TrimSpace(w.Body.String()), "\n\n") {
+		for _, line := range strings.Split(event, "\n") {
+			if name, found := strings.CutPrefix(line, "event:"); found {
+				names = append(names, name)
+			} else if value, found := strings.CutPrefix(line, "data:"); found {
+				data = append(data, value)
+			}
+		}
+	}
+	require.Len(t, data, len(names))
+	return names, data
+}
+
+func TestStreamedConversation(t *testing.T) {
+	svc, router, voiceService := setupConversation(t)
+	ctx := context.Background()
+	aiPerson, voiceModel := createAIPerson(t, svc, router)
+	modelPath, err := svc.DownloadModelIfNotExist(ctx, voiceModel.FileName.String)
+	require.NoError(t, err)
+	model, err := os.ReadFile(modelPath)
+	require.NoError(t, err)
+
+	// The reply text comes first, then the speech of each sentence, and finally the reply voice record.
+	names, data := serveEvents(t, router, fmt.Sprintf("/api/debug/ai_person/%d/post_text_message", aiPerson.ID), []byte(`{"message": "How are you?"}`))
+	require.Equal(t, []string{"reply", "segment", "segment", "voice"}, names)
+	var reply dbgen.AiPersonReply
+	require.NoError(t, json.Unmarshal([]byte(data[0]), &reply))
+	assert.Equal(t, testReply+" ", reply.Message)
+	var segments [][]byte
+	for i, sentence := range []string{"Nice to hear from you.", "I have been well since three p m."} {
+		var segment ReplySegment
+		require.NoError(t, json.Unmarshal([]byte(data[1+i]), &segment))
+		assert.Equal(t, i, segment.Index)
+		assert.Equal(t, sentence, segment.Text)
+		assert.Equal(t, voicetest.Synthesize(model, sentence), segment.Audio)
+		segments = append(segments, segment.Audio)
+	}
+	assert.Equal(t, 2, voiceService.Requests("tts-rt"))
+
+	// The reply voice file has the speech of the whole reply.
+	var replyVoice dbgen.AiPersonReplyVoice
+	require.NoError(t, json.Unmarshal([]byte(data[3]), &replyVoice))
+	assert.Equal(t, "ready", replyVoice.Status)
+	speech, err := os.ReadFile(filepath.Join(svc.Config.VoiceOutputDir, replyVoice.FileName.String))
+	require.NoError(t, err)
+	wholeReply, err := audio.Concat(segments)
+	require.NoError(t, err)
+	assert.Equal(t, wholeReply, speech)
+
+	// The same reply spoken again comes from the cache in a single segment.
+	names, data = serveEvents(t, router, fmt.Sprintf("/api/debug/ai_person/%d/post_text_message", aiPerson.ID), []byte(`{"message": "How are you?"}`))
+	require.Equal(t, []string{"reply", "segment", "voice"}, names)
+	var segment ReplySegment
+	require.NoError(t, json.Unmarshal([]byte(data[1]), &segment))
+	assert.Equal(t, wholeReply, segment.Audio)
+	assert.Equal(t, 2, voiceService.Requests("tts-rt"))
+
+	// A voice service failure ends the stream with an error event.
+	svc.TTSCache.Config.MaxBytes = -1
+	voiceService.FailNext(1, http.StatusInternalServerError)
+	names, _ = serveEvents(t, router, fmt.Sprintf("/api/debug/ai_person/%d/post_text_message", aiPerson.ID), []byte(`{"message": "Are you there?"}`))
+	assert.Equal(t, "error", names[len(names)-1])
+}
+
+func TestExpressiveConversation(t *testing.T) {
+	svc, router, _ := setupConversation(t)
+	ctx := context.Background()
+	aiPerson, voiceModel := createAIPerson(t, svc, router)
+	modelPath, err := svc.DownloadModelIfNotExist(ctx, voiceModel.FileName.String)
+	require.NoError(t, err)
+	model, err := os.ReadFile(modelPath)
+	require.NoError(t, err)
+	// The fake LLM replies with expressive markup, and remembers the system prompt it is given.
+	var systemPrompts []string
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		systemPrompts = append(systemPrompts, req.Messages[0].Content)
+		_ = json.NewEncoder(w).Encode(openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{
+			{Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: `Oh <laugh/> it is <emphasis>nice</emphasis> to hear from you.`}},
+		}})
+	}))
+	t.Cleanup(llm.Close)
+	llmConf := openai.DefaultConfig("test")
+	llmConf.BaseURL = llm.URL + "/v1"
+	svc.OpenAIClient = openai.NewClientWithConfig(llmConf)
+
+	// Without the expressive cues, the markup is neither asked for nor spoken.
+	var replyVoice dbgen.AiPersonReplyVoice
+	serveJSON(t, router, "POST", fmt.Sprintf("/api/debug/ai_person/%d/post_text_message", aiPerson.ID), "application/json", []byte(`{"message": "How are you?"}`), &replyVoice)
+	speech, err := os.ReadFile(filepath.Join(svc.Config.VoiceOutputDir, replyVoice.FileName.String))
+	require.NoError(t, err)
+	assert.Equal(t, voicetest.Synthesize(model, "Oh it is nice to hear from you."), speech)
+	assert.NotContains(t, systemPrompts[0], expressive.Instructions)
+
+	// With the expressive cues, the markup is spoken in Bark's tokens.
+	var resp gin.H
+	serveJSON(t, router, "PUT", fmt.Sprintf("/api/debug/ai_person/%d/expressive_cues", aiPerson.ID), "application/json", []byte(`{"ExpressiveCues": true}`), &resp)
+	serveJSON(t, router, "POST", fmt.Sprintf("/api/debug/ai_person/%d/post_text_message", aiPerson.ID), "application/json", []byte(`{"message": "How are you?"}`), &replyVoice)
+	speech, err = os.ReadFile(filepath.Join(svc.Config.VoiceOutputDir, replyVoice.FileName.String))
+	require.NoError(t, err)
+	assert.Equal(t, voicetest.Synthesize(model, "Oh [laughs] it is NICE to hear from you."), speech)
+	assert.Contains(t, systemPrompts[1], expressive.Instructions)
+
+	// The transcript never has the markup.
+	conversation, err := svc.Database.ListConversations(ctx, dbgen.ListConversationsParams{AiPersonID: aiPerson.ID, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, conversation, 2)
+	for _, exchange := range conversation {
+		assert.Equal(t, "Oh it is nice to hear from you.", exchange.ReplyMessage.String)
+	}
+	assert.Equal(t, "Oh <laugh/> it is <emphasis>nice</emphasis> to hear from you. ", conversation[0].ReplySpeechMarkup.String)
+	assert.False(t, conversation[1].ReplySpeechMarkup.Valid)
+}
@@ -0,0 +1,32 @@
+package httpsvc
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/db/dbgen"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// GPUWorkerDeadAfter is how long after its last heartbeat a GPU worker is considered dead, it spans several heartbeats.
+	GPUWorkerDeadAfter = 1 * time.Minute
+	// MaxListedGPUWorkers is the maximum number of GPU workers listed, the most recently started first.
+	MaxListedGPUWorkers = 1000
+)
+
+// handleListGPUWorkers is a gin handler that lists the live and dead GPU workers from the worker registry, along with
+// their capabilities, tasks in flight, and latest task failure.
+func (svc *HttpService) handleListGPUWorkers(c *gin.Context) {
+	workers, err := svc.Database.ListGPUWorkers(c.Request.Context(), dbgen.ListGPUWorkersParams{
+		DeadSeconds: GPUWorkerDeadAfter.Seconds(),
+		MaxWorkers:  MaxListedGPUWorkers,
+	})
+	if err != nil {
+		log.Printf("list gpu workers error: %+v", err)
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, workers)
+}
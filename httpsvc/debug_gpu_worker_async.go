@@ -1,25 +1,53 @@
 package httpsvc
 
 import (
-	"bytes"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
 	"time"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
 	"github.com/gin-gonic/gin"
 	"github.com/HouzuoGuo/reconn-voice-clone/db/dbgen"
 	"github.com/HouzuoGuo/reconn-voice-clone/shared"
-	openai "github.com/sashabaranov/go-openai"
 )
 
-var applicationJSON *string = &([]string{"application/json"})[0]
+// createWithGPUTask creates a record using createRecord in a database transaction, along with the GPU task of the record
+// in the outbox, and then responds with the response returned by createRecord. The outbox relay sends the task to the
+// queue after the transaction commits, hence the record and its task are created together or not at all. The response
+// to a request carrying an idempotency key is saved in the same transaction too. The task carries the request ID header
+// as its trace ID if present.
+func (svc *HttpService) createWithGPUTask(c *gin.Context, taskType shared.GPUTaskType, createRecord func(tx *dbgen.Queries) (payload, response any, err error)) error {
+	tx, err := svc.LowLevelDB.BeginTx(c.Request.Context(), nil)
+	if err != nil {
+		return err
+	}
+	txQueries := svc.Database.WithTx(tx)
+	payload, response, err := createRecord(txQueries)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	task, err := shared.NewGPUTask(taskType, c.GetHeader("X-Request-Id"), payload)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	if err := svc.TaskOutbox.Add(c.Request.Context(), txQueries, task); err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	if err := svc.saveIdempotentResponse(c, txQueries, http.StatusOK, response); err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	svc.TaskOutbox.Notify()
+	log.Printf("enqueued %v", task)
+	c.JSON(http.StatusOK, response)
+	return nil
+}
 
 // handleCreateVoiceModelAsync is a gin handler that posts a message to the GPU worker queue to create a voice model.
 func (svc *HttpService) handleCreateVoiceModelAsync(c *gin.Context) {
@@ -45,37 +73,20 @@ func (svc *HttpService) handleCreateVoiceModelAsync(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
-	// Back to this handler, create the cloned voice model record in database.
-	voiceModel, err := svc.Database.CreateVoiceModel(c.Request.Context(), dbgen.CreateVoiceModelParams{
-		VoiceSampleID: int64(voiceSampleID),
-		Status:        "processing",
-		FileName:      sql.NullString{String: "waiting to be processed by GPU worker", Valid: true},
-		Timestamp:     time.Now(),
-	})
-	if err != nil {
+	// Back to this handler, create the cloned voice model record and its GPU task in database.
+	if err := svc.createWithGPUTask(c, shared.GPUTaskCreateVoiceModel, func(tx *dbgen.Queries) (payload, response any, err error) {
+		voiceModel, err := tx.CreateVoiceModel(c.Request.Context(), dbgen.CreateVoiceModelParams{
+			VoiceSampleID: int64(voiceSampleID),
+			Status:        "processing",
+			// The GPU worker fills in the file name of the model it creates.
+			FileName:  sql.NullString{},
+			Timestamp: time.Now(),
+		})
+		return shared.CreateVoiceModelPayload{VoiceModelID: voiceModel.ID}, voiceModel, err
+	}); err != nil {
 		log.Printf("create voice model error: %v", err)
 		c.JSON(http.StatusInternalServerError, err.Error())
-		return
-	}
-	// Post to the GPU task queue.
-	taskBody, err := json.Marshal(shared.GPUTask{
-		VoiceModelID: int(voiceModel.ID),
-	})
-	if err != nil {
-		log.Printf("marshal gputask error: %v", err)
-		c.JSON(http.StatusInternalServerError, err.Error())
-		return
 	}
-	err = svc.ServiceBusSender.SendMessage(c.Request.Context(), &azservicebus.Message{
-		Body:        taskBody,
-		ContentType: applicationJSON,
-	}, nil)
-	if err != nil {
-		log.Printf("service bus send message error: %v", err)
-		c.JSON(http.StatusInternalServerError, err.Error())
-		return
-	}
-	c.JSON(http.StatusOK, voiceModel)
 }
 
 // handlePostTextMessageAsync is a gin handler that posts a text message to an AI person, and post a message to the GPU worker queue for a TTS reply.
@@ -114,81 +125,30 @@ func (svc *HttpService) handlePostTextMessageAsync(c *gin.Context) {
 		return
 	}
 	log.Printf("ai person and model: %+v", aiPersonAndModel)
-	// Generate the chat completion request, given the recent history.
-	completionRequest, err := svc.chatCompletionRequest(c.Request.Context(), aiPersonID, aiPersonAndModel.AiContextPrompt, req.Message)
-	if err != nil {
-		log.Printf("chat completion request construction error: %+v", err)
-		c.JSON(http.StatusInternalServerError, err.Error())
-		return
-	}
-	log.Printf("Chat completion request for AI person %d is: %+v", aiPersonID, completionRequest)
-	// Feed both context prompt and text prompt to LLM.
-	resp, err := svc.OpenAIClient.CreateChatCompletion(c.Request.Context(), completionRequest)
+	aiReply, err := svc.generateReply(c.Request.Context(), aiPersonID, prompt.ID, aiPersonAndModel.AiContextPrompt, aiPersonAndModel.AiExpressiveCues, req.Message)
 	if err != nil {
-		log.Printf("create chat completion error: %v", err)
+		log.Printf("generate reply error: %v", err)
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
-	var llmReply string
-	for _, choice := range resp.Choices {
-		llmReply += choice.Message.Content + " "
-	}
-	// Create the AI person reply in database.
-	timestamp := time.Now()
-	aiReply, err := svc.Database.CreateAIPersonReply(c.Request.Context(), dbgen.CreateAIPersonReplyParams{
-		UserPromptID: prompt.ID,
-		Status:       "ready",
-		Message:      llmReply,
-		Timestamp:    timestamp,
-	})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, err.Error())
-		return
-	}
-	log.Printf("ai reply: %+v", aiReply)
-	// Create the AI reply record in database.
-	aiReplyVoice, err := svc.Database.CreateAIPersonReplyVoice(c.Request.Context(), dbgen.CreateAIPersonReplyVoiceParams{
-		AiPersonReplyID: aiReply.ID,
-		Status:          "processing",
-		FileName:        sql.NullString{String: "waiting to be processed by GPU worker", Valid: true},
-	})
-	if err != nil {
+	// Create the AI reply record and its GPU task in database, the GPU worker fills in the file name.
+	if err := svc.createWithGPUTask(c, shared.GPUTaskConvertReplyToSpeech, func(tx *dbgen.Queries) (payload, response any, err error) {
+		aiReplyVoice, err := tx.CreateAIPersonReplyVoice(c.Request.Context(), dbgen.CreateAIPersonReplyVoiceParams{
+			AiPersonReplyID: aiReply.ID,
+			Status:          "processing",
+		})
+		return shared.ConvertReplyToSpeechPayload{AIPersonID: int64(aiPersonID), AIReplyVoiceID: aiReplyVoice.ID}, aiReplyVoice, err
+	}); err != nil {
 		log.Printf("create ai person reply voice error: %v", err)
 		c.JSON(http.StatusInternalServerError, err.Error())
-		return
 	}
-	// Post to the GPU task queue.
-	taskBody, err := json.Marshal(shared.GPUTask{
-		AIReplyVoiceID:  int(aiReplyVoice.ID),
-		AIReplyPersonID: aiPersonID,
-	})
-	if err != nil {
-		log.Printf("marshal gputask error: %v", err)
-		c.JSON(http.StatusInternalServerError, err.Error())
-		return
-	}
-	err = svc.ServiceBusSender.SendMessage(c.Request.Context(), &azservicebus.Message{
-		Body:        taskBody,
-		ContentType: applicationJSON,
-	}, nil)
-	if err != nil {
-		log.Printf("service bus send message error: %v", err)
-		c.JSON(http.StatusInternalServerError, err.Error())
-		return
-	}
-	c.JSON(http.StatusOK, aiReplyVoice)
 }
 
 // handlePostTextMessageAsync is a gin handler that posts a text message to an AI person, and post a message to the GPU worker queue for a TTS reply.
 func (svc *HttpService) handlePostVoiceMessageAsync(c *gin.Context) {
 	aiPersonID, _ := strconv.Atoi(c.Params.ByName("ai_person_id"))
-	if c.ContentType() != "audio/wav" && c.ContentType() != "audio/x-wav" && c.ContentType() != "audio/wave" {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "request content type must be wave"})
-		return
-	}
-	voiceWaveform, err := io.ReadAll(c.Request.Body)
-	if err != nil || len(voiceWaveform) < 100 {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "failed to read request body"})
+	voiceWaveform, wavInfo, ok := svc.readWaveBody(c)
+	if !ok {
 		return
 	}
 	// Save the voice message to disk.
@@ -199,20 +159,7 @@ func (svc *HttpService) handlePostVoiceMessageAsync(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
-	// Transcribe the message in real time.
-	transcriptionResponse, err := svc.OpenAIClient.CreateTranscription(c.Request.Context(), openai.AudioRequest{
-		Model: "whisper-1",
-		// The file path is part of the form submission, the extension name must accurately indicate the audio format.
-		FilePath: "input.wav",
-		Reader:   bytes.NewReader(voiceWaveform),
-		Format:   openai.AudioResponseFormatJSON,
-	})
-	if err != nil {
-		log.Printf("failed to invoke whisper: %v", err)
-		c.JSON(http.StatusInternalServerError, err.Error())
-		return
-	}
-	// Create the user voice prompt in database.
+	// Create the user voice prompt in database and transcribe the message in real time.
 	prompt, err := svc.Database.CreateUserPrompt(c.Request.Context(), dbgen.CreateUserPromptParams{
 		AiPersonID: int64(aiPersonID),
 		Timestamp:  time.Now(),
@@ -221,14 +168,9 @@ func (svc *HttpService) handlePostVoiceMessageAsync(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
-	voicePrompt, err := svc.Database.CreateUserVoicePrompt(c.Request.Context(), dbgen.CreateUserVoicePromptParams{
-		UserPromptID:  prompt.ID,
-		Status:        "ready",
-		FileName:      sampleFileName,
-		Transcription: sql.NullString{String: transcriptionResponse.Text, Valid: true},
-	})
+	voicePrompt, err := svc.transcribeVoicePrompt(c.Request.Context(), prompt.ID, sampleFileName, voiceWaveform, wavInfo)
 	if err != nil {
-		log.Printf("create user voice prompt error: %v", err)
+		log.Printf("transcribe voice prompt error: %v", err)
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -241,66 +183,73 @@ func (svc *HttpService) handlePostVoiceMessageAsync(c *gin.Context) {
 		return
 	}
 	log.Printf("ai person and model: %+v", aiPersonAndModel)
-	// Generate the chat completion request, given the recent history.
-	completionRequest, err := svc.chatCompletionRequest(c.Request.Context(), aiPersonID, aiPersonAndModel.AiContextPrompt, transcriptionResponse.Text)
+	aiReply, err := svc.generateReply(c.Request.Context(), aiPersonID, prompt.ID, aiPersonAndModel.AiContextPrompt, aiPersonAndModel.AiExpressiveCues, voicePrompt.Transcription.String)
 	if err != nil {
-		log.Printf("chat completion request construction error: %+v", err)
+		log.Printf("generate reply error: %v", err)
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
-	log.Printf("Chat completion request for AI person %d is: %+v", aiPersonID, completionRequest)
-	resp, err := svc.OpenAIClient.CreateChatCompletion(c.Request.Context(), completionRequest)
-	if err != nil {
-		log.Printf("create chat completion error: %v", err)
+	// Create the AI reply record and its GPU task in database, the GPU worker fills in the file name.
+	if err := svc.createWithGPUTask(c, shared.GPUTaskConvertReplyToSpeech, func(tx *dbgen.Queries) (payload, response any, err error) {
+		aiReplyVoice, err := tx.CreateAIPersonReplyVoice(c.Request.Context(), dbgen.CreateAIPersonReplyVoiceParams{
+			AiPersonReplyID: aiReply.ID,
+			Status:          "processing",
+		})
+		return shared.ConvertReplyToSpeechPayload{AIPersonID: int64(aiPersonID), AIReplyVoiceID: aiReplyVoice.ID}, aiReplyVoice, err
+	}); err != nil {
+		log.Printf("create ai person reply voice error: %v", err)
 		c.JSON(http.StatusInternalServerError, err.Error())
-		return
 	}
-	var llmReply string
-	for _, choice := range resp.Choices {
-		llmReply += choice.Message.Content + " "
-	}
-	// Create the AI person reply in database.
-	aiReply, err := svc.Database.CreateAIPersonReply(c.Request.Context(), dbgen.CreateAIPersonReplyParams{
-		UserPromptID: prompt.ID,
-		Status:       "ready",
-		Message:      llmReply,
-		Timestamp:    timestamp,
-	})
+}
+
+// handleCancelVoiceModel is a gin handler that cancels the creation of a voice model by the GPU worker.
+// The GPU worker skips the task if it has not started, or aborts the task in flight.
+func (svc *HttpService) handleCancelVoiceModel(c *gin.Context) {
+	voiceModelID, _ := strconv.Atoi(c.Params.ByName("voice_model_id"))
+	cancelled, err := svc.Database.CancelVoiceModelByID(c.Request.Context(), int64(voiceModelID))
 	if err != nil {
-		log.Printf("create ai person reply error: %v", err)
+		log.Printf("cancel voice model error: %v", err)
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
-	log.Printf("ai reply: %+v", aiReply)
-	// Create the AI reply record in database.
-	aiReplyVoice, err := svc.Database.CreateAIPersonReplyVoice(c.Request.Context(), dbgen.CreateAIPersonReplyVoiceParams{
-		AiPersonReplyID: aiReply.ID,
-		Status:          "processing",
-		FileName:        sql.NullString{String: "waiting to be processed by GPU worker", Valid: true},
-	})
-	if err != nil {
-		log.Printf("create ai person reply voice error: %v", err)
+	voiceModel, err := svc.Database.GetVoiceModelByID(c.Request.Context(), int64(voiceModelID))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		log.Printf("get voice model by id error: %v", err)
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
-	// Post to the GPU task queue.
-	taskBody, err := json.Marshal(shared.GPUTask{
-		AIReplyVoiceID:  int(aiReplyVoice.ID),
-		AIReplyPersonID: aiPersonID,
-	})
+	if cancelled == 0 {
+		c.JSON(http.StatusConflict, gin.H{"message": fmt.Sprintf("voice model is already %s", voiceModel.Status)})
+		return
+	}
+	c.JSON(http.StatusOK, voiceModel)
+}
+
+// handleCancelAIPersonReplyVoice is a gin handler that cancels the conversion of an AI person's reply into speech by the GPU worker.
+// The GPU worker skips the task if it has not started, or aborts the task in flight.
+func (svc *HttpService) handleCancelAIPersonReplyVoice(c *gin.Context) {
+	replyVoiceID, _ := strconv.Atoi(c.Params.ByName("reply_voice_id"))
+	cancelled, err := svc.Database.CancelAIPersonReplyVoiceByID(c.Request.Context(), int64(replyVoiceID))
 	if err != nil {
-		log.Printf("marshal gputask error: %v", err)
+		log.Printf("cancel ai person reply voice error: %v", err)
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
-	err = svc.ServiceBusSender.SendMessage(c.Request.Context(), &azservicebus.Message{
-		Body:        taskBody,
-		ContentType: applicationJSON,
-	}, nil)
-	if err != nil {
-		log.Printf("service bus send message error: %v", err)
+	replyVoice, err := svc.Database.GetAIPersonReplyVoiceByID(c.Request.Context(), int64(replyVoiceID))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		log.Printf("get ai person reply voice by id error: %v", err)
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
-	c.JSON(http.StatusOK, aiReplyVoice)
+	if cancelled == 0 {
+		c.JSON(http.StatusConflict, gin.H{"message": fmt.Sprintf("reply voice is already %s", replyVoice.Status)})
+		return
+	}
+	c.JSON(http.StatusOK, replyVoice)
 }
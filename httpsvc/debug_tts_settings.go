@@ -0,0 +1,183 @@
+package httpsvc
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/db/dbgen"
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+	"github.com/gin-gonic/gin"
+)
+
+// TTSSettingsRequest sets the TTS settings of an AI person or voice model. The settings start from the named preset,
+// which defaults to shared.TTSPresetDefault, and the parameters present in the request override the preset's.
+type TTSSettingsRequest struct {
+	Preset       string   `json:"preset"`
+	TopK         *float64 `json:"topK"`
+	TopP         *float64 `json:"topP"`
+	MineosP      *float64 `json:"mineosP"`
+	SemanticTemp *float64 `json:"semanticTemp"`
+	WaveformTemp *float64 `json:"waveformTemp"`
+	FineTemp     *float64 `json:"fineTemp"`
+}
+
+// settings returns the preset name and the TTS settings of the request, or an error if the preset is unknown or a
+// parameter is out of its range.
+func (req TTSSettingsRequest) settings() (string, shared.TTSSettings, error) {
+	if req.Preset == "" {
+		req.Preset = shared.TTSPresetDefault
+	}
+	settings, exists := shared.TTSPresets[req.Preset]
+	if !exists {
+		return "", settings, fmt.Errorf("unknown preset %q, the presets are %v", req.Preset, shared.TTSPresetNames())
+	}
+	for _, param := range []struct {
+		value *float64
+		dest  *float64
+	}{
+		{req.TopK, &settings.TopK},
+		{req.TopP, &settings.TopP},
+		{req.MineosP, &settings.MineosP},
+		{req.SemanticTemp, &settings.SemanticTemp},
+		{req.WaveformTemp, &settings.WaveformTemp},
+		{req.FineTemp, &settings.FineTemp},
+	} {
+		if param.value != nil {
+			*param.dest = *param.value
+		}
+	}
+	return req.Preset, settings, settings.Validate()
+}
+
+// voiceModelAIPersonID returns the ID of the AI person the voice model belongs to.
+func (svc *HttpService) voiceModelAIPersonID(ctx context.Context, voiceModelID int64) (int64, error) {
+	voiceModel, err := svc.Database.GetVoiceModelByID(ctx, voiceModelID)
+	if err != nil {
+		return 0, err
+	}
+	voiceSample, err := svc.Database.GetVoiceSampleByID(ctx, voiceModel.VoiceSampleID)
+	return voiceSample.AiPersonID, err
+}
+
+// handleListTTSPresets is a gin handler that lists the named TTS presets.
+func (svc *HttpService) handleListTTSPresets(c *gin.Context) {
+	c.JSON(http.StatusOK, shared.TTSPresets)
+}
+
+// handleGetAIPersonTTSSettings is a gin handler that returns the TTS settings of an AI person, the voice models of the
+// AI person speak with them unless they have their own.
+func (svc *HttpService) handleGetAIPersonTTSSettings(c *gin.Context) {
+	aiPersonID, _ := strconv.Atoi(c.Params.ByName("ai_person_id"))
+	settings, err := shared.ResolveTTSSettings(c.Request.Context(), svc.Database, int64(aiPersonID), 0)
+	if err != nil {
+		log.Printf("resolve tts settings error: %v", err)
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// handleSetAIPersonTTSSettings is a gin handler that sets the TTS settings of an AI person.
+func (svc *HttpService) handleSetAIPersonTTSSettings(c *gin.Context) {
+	aiPersonID, _ := strconv.Atoi(c.Params.ByName("ai_person_id"))
+	var req TTSSettingsRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	preset, settings, err := req.settings()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if err := svc.Database.SetAIPersonTTSSettings(c.Request.Context(), dbgen.SetAIPersonTTSSettingsParams{
+		AiPersonID:   int64(aiPersonID),
+		Preset:       preset,
+		TopK:         settings.TopK,
+		TopP:         settings.TopP,
+		MineosP:      settings.MineosP,
+		SemanticTemp: settings.SemanticTemp,
+		WaveformTemp: settings.WaveformTemp,
+		FineTemp:     settings.FineTemp,
+	}); err != nil {
+		log.Printf("set ai person tts settings error: %v", err)
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, shared.ResolvedTTSSettings{TTSSettings: settings, Preset: preset, Source: shared.TTSSourceAIPerson})
+}
+
+// handleGetVoiceModelTTSSettings is a gin handler that returns the TTS settings a voice model speaks with, which are
+// its own if it has any, otherwise its AI person's.
+func (svc *HttpService) handleGetVoiceModelTTSSettings(c *gin.Context) {
+	voiceModelID, _ := strconv.Atoi(c.Params.ByName("voice_model_id"))
+	aiPersonID, err := svc.voiceModelAIPersonID(c.Request.Context(), int64(voiceModelID))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		log.Printf("get voice model ai person error: %v", err)
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	settings, err := shared.ResolveTTSSettings(c.Request.Context(), svc.Database, aiPersonID, int64(voiceModelID))
+	if err != nil {
+		log.Printf("resolve tts settings error: %v", err)
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// handleSetVoiceModelTTSSettings is a gin handler that sets the TTS settings of a voice model, overriding the settings
+// of its AI person.
+func (svc *HttpService) handleSetVoiceModelTTSSettings(c *gin.Context) {
+	voiceModelID, _ := strconv.Atoi(c.Params.ByName("voice_model_id"))
+	var req TTSSettingsRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	preset, settings, err := req.settings()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if err := svc.Database.SetVoiceModelTTSSettings(c.Request.Context(), dbgen.SetVoiceModelTTSSettingsParams{
+		VoiceModelID: int64(voiceModelID),
+		Preset:       preset,
+		TopK:         settings.TopK,
+		TopP:         settings.TopP,
+		MineosP:      settings.MineosP,
+		SemanticTemp: settings.SemanticTemp,
+		WaveformTemp: settings.WaveformTemp,
+		FineTemp:     settings.FineTemp,
+	}); err != nil {
+		log.Printf("set voice model tts settings error: %v", err)
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, shared.ResolvedTTSSettings{TTSSettings: settings, Preset: preset, Source: shared.TTSSourceVoiceModel})
+}
+
+// handleDeleteVoiceModelTTSSettings is a gin handler that deletes the TTS settings of a voice model, after which the
+// voice model speaks with the settings of its AI person.
+func (svc *HttpService) handleDeleteVoiceModelTTSSettings(c *gin.Context) {
+	voiceModelID, _ := strconv.Atoi(c.Params.ByName("voice_model_id"))
+	deleted, err := svc.Database.DeleteVoiceModelTTSSettings(c.Request.Context(), int64(voiceModelID))
+	if err != nil {
+		log.Printf("delete voice model tts settings error: %v", err)
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if deleted == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "voice model does not have tts settings of its own"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
@@ -2,9 +2,7 @@ package httpsvc
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
@@ -13,23 +11,17 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/HouzuoGuo/reconn-voice-clone/db/dbgen"
-	"github.com/HouzuoGuo/reconn-voice-clone/shared"
 )
 
 // handleCreateAIPerson a gin handler that creates a voice sample record from waveforms of the request.
 func (svc *HttpService) handleCreateVoiceSample(c *gin.Context) {
-	if c.ContentType() != "audio/wav" && c.ContentType() != "audio/x-wav" && c.ContentType() != "audio/wave" {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "request content type must be wave"})
-		return
-	}
 	aiPersonID, err := strconv.Atoi(c.Params.ByName("ai_person_id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"message": "request path must contain ai person id"})
 		return
 	}
-	wavContent, err := io.ReadAll(c.Request.Body)
-	if err != nil || len(wavContent) < 100 {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "failed to read request body"})
+	wavContent, wavInfo, ok := svc.readWaveBody(c)
+	if !ok {
 		return
 	}
 	// Name the voice sample after the time of day.
@@ -43,9 +35,14 @@ func (svc *HttpService) handleCreateVoiceSample(c *gin.Context) {
 	}
 	// Save to file on disk and then write to database.
 	voiceSample, err := svc.Database.CreateVoiceSample(c.Request.Context(), dbgen.CreateVoiceSampleParams{
-		AiPersonID: int64(aiPersonID),
-		FileName:   sql.NullString{String: sampleFileName, Valid: true},
-		Timestamp:  timestamp,
+		AiPersonID:      int64(aiPersonID),
+		FileName:        sql.NullString{String: sampleFileName, Valid: true},
+		Timestamp:       timestamp,
+		AudioFormat:     wavInfo.Format,
+		SampleRate:      int32(wavInfo.SampleRate),
+		Channels:        int32(wavInfo.Channels),
+		BitsPerSample:   int32(wavInfo.BitsPerSample),
+		DurationSeconds: wavInfo.Duration.Seconds(),
 	})
 	if err != nil {
 		log.Printf("create voice sample error: %+v", err)
@@ -79,6 +76,30 @@ func (svc *HttpService) handleGetLatestVoiceModel(c *gin.Context) {
 	c.JSON(http.StatusOK, latestModel)
 }
 
+// handleListVoiceModels is a gin handler that lists all voice models of an AI person, including the failed ones and their errors.
+func (svc *HttpService) handleListVoiceModels(c *gin.Context) {
+	aiPersonID, _ := strconv.Atoi(c.Params.ByName("ai_person_id"))
+	voiceModels, err := svc.Database.ListVoiceModels(c.Request.Context(), int64(aiPersonID))
+	if err != nil {
+		log.Printf("list voice models error: %+v", err)
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, voiceModels)
+}
+
+// handleGetVoiceModel is a gin handler that retrieves a voice model by ID, e.g. to check the progress of an asynchronous model creation.
+func (svc *HttpService) handleGetVoiceModel(c *gin.Context) {
+	voiceModelID, _ := strconv.Atoi(c.Params.ByName("voice_model_id"))
+	voiceModel, err := svc.Database.GetVoiceModelByID(c.Request.Context(), int64(voiceModelID))
+	if err != nil {
+		log.Printf("get voice model by id error: %+v", err)
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, voiceModel)
+}
+
 // Update voice model status by ID is not needed for debugging.
 
 // handleCreateVoiceModel is a gin handler that creates a new voice model by relaying a clone request to voice service in real time.
@@ -106,26 +127,12 @@ func (svc *HttpService) handleCreateVoiceModel(c *gin.Context) {
 		return
 	}
 	// Relay the clone request to voice service.
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/clone-rt/%d", svc.Config.VoiceServiceAddr, voiceSampleID), voiceSampleFile)
-	req.Header.Set("content-type", "audio/wav")
-	if err != nil {
-		log.Printf("failed to construct clone-rt request: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to construct voice service request"})
-		return
-	}
-	resp, err := svc.VoiceClient.Do(req)
+	cloneResp, err := svc.VoiceClient.Clone(c.Request.Context(), strconv.Itoa(voiceSampleID), voiceSampleFile)
 	if err != nil {
 		log.Printf("failed to make clone-rt request: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to make voice service request"})
 		return
 	}
-	log.Printf("clone-rt responded with status %d and content length %d", resp.StatusCode, resp.ContentLength)
-	var cloneResp shared.CloneRealTimeResponse
-	if err := json.NewDecoder(resp.Body).Decode(&cloneResp); err != nil {
-		log.Printf("failed to deserialise clone-rt response: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to make voice service request"})
-		return
-	}
 	// Back to this handler, create the cloned voice model record in database.
 	voiceModel, err := svc.Database.CreateVoiceModel(c.Request.Context(), dbgen.CreateVoiceModelParams{
 		VoiceSampleID: int64(voiceSampleID),
@@ -0,0 +1,13 @@
+package httpsvc
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleListVoiceServices is a gin handler that lists the voice service instances behind the synchronous endpoints,
+// along with their health, requests in flight, and failures.
+func (svc *HttpService) handleListVoiceServices(c *gin.Context) {
+	c.JSON(http.StatusOK, svc.VoiceClient.Stats())
+}
@@ -0,0 +1,142 @@
+package httpsvc
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/db/dbgen"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// IdempotencyKeyHeader is the optional request header that identifies a request across the client's retries.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotencyKeyTTL is the duration a successful response is replayed for the same idempotency key.
+	IdempotencyKeyTTL = 24 * time.Hour
+	// IdempotencyKeyLease is the duration after which a request still in progress, e.g. one that was interrupted by a
+	// crash, no longer holds its idempotency key. It is longer than the write timeout of the http server.
+	IdempotencyKeyLease = 10 * time.Minute
+	// idempotencyKeyContextKey is the gin context key of the idempotency key held by the request.
+	idempotencyKeyContextKey = "idempotency_key"
+	// idempotentResponseSavedContextKey is the gin context key of the flag that the response has been saved.
+	idempotentResponseSavedContextKey = "idempotent_response_saved"
+)
+
+// responseRecorder is a gin response writer that keeps a copy of the response body.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body *bytes.Buffer
+}
+
+func (rec *responseRecorder) Write(data []byte) (int, error) {
+	rec.body.Write(data)
+	return rec.ResponseWriter.Write(data)
+}
+
+func (rec *responseRecorder) WriteString(str string) (int, error) {
+	rec.body.WriteString(str)
+	return rec.ResponseWriter.WriteString(str)
+}
+
+// idempotent is a gin middleware that processes a request carrying an idempotency key only once.
+// The successful response is stored and replayed to the retries of the request with the same key, whereas a failed
+// request releases the key so that it may be retried. The handlers save their response in the same database
+// transaction as their records using saveIdempotentResponse. Requests without the key are processed as usual.
+func (svc *HttpService) idempotent(c *gin.Context) {
+	key := c.GetHeader(IdempotencyKeyHeader)
+	if key == "" {
+		c.Next()
+		return
+	}
+	path := c.Request.URL.Path
+	created, err := svc.Database.CreateIdempotencyKey(c.Request.Context(), dbgen.CreateIdempotencyKeyParams{
+		RequestPath:    path,
+		IdempotencyKey: key,
+		TtlSeconds:     IdempotencyKeyTTL.Seconds(),
+		LeaseSeconds:   IdempotencyKeyLease.Seconds(),
+	})
+	if err != nil {
+		log.Printf("failed to create idempotency key %q of %q: %v", key, path, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if created == 0 {
+		// The same request has been made before.
+		prev, err := svc.Database.GetIdempotencyKey(c.Request.Context(), dbgen.GetIdempotencyKeyParams{RequestPath: path, IdempotencyKey: key})
+		if err != nil {
+			log.Printf("failed to get idempotency key %q of %q: %v", key, path, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !prev.ResponseStatus.Valid {
+			c.AbortWithStatusJSON(http.StatusConflict, "request with the same idempotency key is in progress")
+			return
+		}
+		log.Printf("replaying the response of idempotency key %q of %q", key, path)
+		c.Data(int(prev.ResponseStatus.Int32), gin.MIMEJSON, prev.ResponseBody)
+		c.Abort()
+		return
+	}
+	c.Set(idempotencyKeyContextKey, key)
+	rec := &responseRecorder{ResponseWriter: c.Writer, body: new(bytes.Buffer)}
+	c.Writer = rec
+	// The request context may be gone after the handler has responded.
+	ctx := context.WithoutCancel(c.Request.Context())
+	finished := false
+	defer func() {
+		if !finished {
+			// Release the key of the handler that panicked.
+			if err := svc.Database.DeleteIdempotencyKey(ctx, dbgen.DeleteIdempotencyKeyParams{RequestPath: path, IdempotencyKey: key}); err != nil {
+				log.Printf("failed to delete idempotency key %q of %q: %v", key, path, err)
+			}
+		}
+	}()
+	c.Next()
+	finished = true
+	switch status := rec.Status(); {
+	case status >= 200 && status < 300 && c.GetBool(idempotentResponseSavedContextKey):
+		// The handler saved the response along with its records.
+	case status >= 200 && status < 300:
+		err = svc.Database.SaveIdempotencyKeyResponse(ctx, dbgen.SaveIdempotencyKeyResponseParams{
+			RequestPath:    path,
+			IdempotencyKey: key,
+			ResponseStatus: sql.NullInt32{Int32: int32(status), Valid: true},
+			ResponseBody:   rec.body.Bytes(),
+		})
+	default:
+		err = svc.Database.DeleteIdempotencyKey(ctx, dbgen.DeleteIdempotencyKeyParams{RequestPath: path, IdempotencyKey: key})
+	}
+	if err != nil {
+		log.Printf("failed to save the response of idempotency key %q of %q: %v", key, path, err)
+	}
+}
+
+// saveIdempotentResponse saves the response to the request holding an idempotency key using the queries, which are
+// usually of the database transaction that creates the records of the request. It does nothing for the requests
+// without the key.
+func (svc *HttpService) saveIdempotentResponse(c *gin.Context, queries *dbgen.Queries, status int, response any) error {
+	key := c.GetString(idempotencyKeyContextKey)
+	if key == "" {
+		return nil
+	}
+	body, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	if err := queries.SaveIdempotencyKeyResponse(c.Request.Context(), dbgen.SaveIdempotencyKeyResponseParams{
+		RequestPath:    c.Request.URL.Path,
+		IdempotencyKey: key,
+		ResponseStatus: sql.NullInt32{Int32: int32(status), Valid: true},
+		ResponseBody:   body,
+	}); err != nil {
+		return fmt.Errorf("save the response of idempotency key %q error: %w", key, err)
+	}
+	c.Set(idempotentResponseSavedContextKey, true)
+	return nil
+}
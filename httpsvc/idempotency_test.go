@@ -0,0 +1,99 @@
+package httpsvc
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/db/dbgen"
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+	"github.com/HouzuoGuo/reconn-voice-clone/voiceclient/voicetest"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveWithIdempotencyKey makes the POST request carrying the idempotency key to the router.
+func serveWithIdempotencyKey(router *gin.Engine, path, key string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", path, nil)
+	req.Header.Set(IdempotencyKeyHeader, key)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotentCreateVoiceModelAsync(t *testing.T) {
+	svc, router, _ := setupConversation(t)
+	ctx := context.Background()
+	aiPerson, _ := createAIPerson(t, svc, router)
+	var voiceSample dbgen.VoiceSample
+	serveJSON(t, router, "POST", fmt.Sprintf("/api/debug/ai_person/%d/voice_sample", aiPerson.ID), "audio/wav", voicetest.Synthesize([]byte("grandma"), "another voice sample of grandma"), &voiceSample)
+	path := fmt.Sprintf("/api/debug/voice_sample/%d/create_model_async", voiceSample.ID)
+	countModels := func() int {
+		models, err := svc.Database.ListVoiceModels(ctx, aiPerson.ID)
+		require.NoError(t, err)
+		return len(models)
+	}
+	modelsBefore := countModels()
+	// backdate moves the creation of the idempotency key into the past.
+	backdate := func(key string, age time.Duration) {
+		_, err := svc.LowLevelDB.ExecContext(ctx, `update idempotency_keys set created_at = now() - make_interval(secs => $3) where request_path = $1 and idempotency_key = $2`, path, key, age.Seconds())
+		require.NoError(t, err)
+	}
+
+	// The retry of a request gets the original response replayed instead of creating another model.
+	key := shared.NewID()
+	w := serveWithIdempotencyKey(router, path, key)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var voiceModel dbgen.VoiceModel
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &voiceModel))
+	assert.Equal(t, "processing", voiceModel.Status)
+	replay := serveWithIdempotencyKey(router, path, key)
+	require.Equal(t, http.StatusOK, replay.Code)
+	assert.JSONEq(t, w.Body.String(), replay.Body.String())
+	assert.Equal(t, modelsBefore+1, countModels())
+
+	// A key expires after its TTL, and the request is made again.
+	backdate(key, IdempotencyKeyTTL+time.Minute)
+	w = serveWithIdempotencyKey(router, path, key)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var newVoiceModel dbgen.VoiceModel
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &newVoiceModel))
+	assert.NotEqual(t, voiceModel.ID, newVoiceModel.ID)
+	assert.Equal(t, modelsBefore+2, countModels())
+
+	// A key held by a request in progress conflicts, until a retry takes it over after the lease.
+	inProgressKey := shared.NewID()
+	created, err := svc.Database.CreateIdempotencyKey(ctx, dbgen.CreateIdempotencyKeyParams{
+		RequestPath:    path,
+		IdempotencyKey: inProgressKey,
+		TtlSeconds:     IdempotencyKeyTTL.Seconds(),
+		LeaseSeconds:   IdempotencyKeyLease.Seconds(),
+	})
+	require.NoError(t, err)
+	require.EqualValues(t, 1, created)
+	w = serveWithIdempotencyKey(router, path, inProgressKey)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, modelsBefore+2, countModels())
+	backdate(inProgressKey, IdempotencyKeyLease+time.Minute)
+	w = serveWithIdempotencyKey(router, path, inProgressKey)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, modelsBefore+3, countModels())
+	replay = serveWithIdempotencyKey(router, path, inProgressKey)
+	require.Equal(t, http.StatusOK, replay.Code)
+	assert.JSONEq(t, w.Body.String(), replay.Body.String())
+	assert.Equal(t, modelsBefore+3, countModels())
+
+	// A failed request releases its key for the retry.
+	failingPath := "/api/debug/voice_sample/0/create_model_async"
+	failingKey := shared.NewID()
+	w = serveWithIdempotencyKey(router, failingPath, failingKey)
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	_, err = svc.Database.GetIdempotencyKey(ctx, dbgen.GetIdempotencyKeyParams{RequestPath: failingPath, IdempotencyKey: failingKey})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
@@ -3,16 +3,20 @@ package httpsvc
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"expvar"
 	"fmt"
 	"log"
 	"net/http"
 	"time"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
-	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	"github.com/gin-gonic/gin"
+	"github.com/HouzuoGuo/reconn-voice-clone/audio"
 	"github.com/HouzuoGuo/reconn-voice-clone/db"
 	"github.com/HouzuoGuo/reconn-voice-clone/db/dbgen"
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+	"github.com/HouzuoGuo/reconn-voice-clone/textnorm"
+	"github.com/HouzuoGuo/reconn-voice-clone/voiceclient"
 	openai "github.com/sashabaranov/go-openai"
 )
 
@@ -26,6 +30,11 @@ type Config struct {
 	BasicAuthPassword string
 	// VoiceServiceAddr is the address ("host:port") of the voice service (reconn/voicesvc).
 	VoiceServiceAddr string
+	// VoiceServiceAddrs are the voice service instances the synchronous endpoints balance their requests across, they
+	// default to VoiceServiceAddr.
+	VoiceServiceAddrs []string
+	// VoiceServiceBalancer has the health check and circuit breaker settings of the voice service instances.
+	VoiceServiceBalancer voiceclient.BalancerConfig
 	// OpenAIKey is the API key of OpenAI / ChatGPT.
 	OpenAIKey string
 
@@ -36,6 +45,10 @@ type Config struct {
 	VoiceSampleDir string
 	// VoiceModelDir is path to the directory of constructed user voice models.
 	VoiceModelDir string
+	// VoiceModelDirShared declares that each voice service reads and writes the voice models in VoiceModelDir too, e.g. a
+	// network file system mounted by all of them. It is required by more than one voice service, because the clone and
+	// TTS requests name the model files in VoiceModelDir without transferring them.
+	VoiceModelDirShared bool
 	// VoiceTempModelDir is the path to the directory of temporary user voice models used during TTS.
 	VoiceTempModelDir string
 	// VoiceOutputDir is the path to the directory of TTS output files.
@@ -47,22 +60,28 @@ type Config struct {
 	VoiceModelContainer string
 	// VoiceSampleContainer is the blob container name of the voice output files.
 	VoiceOutputContainer string
+	// TTSCache has the settings of the cache of synthesized speech.
+	TTSCache shared.TTSCacheConfig
+	// AudioLimits are the bounds of the uploaded voice samples and voice messages, the zero bounds default to
+	// audio.DefaultLimits.
+	AudioLimits audio.Limits
+	// TextNormLocale is the locale of the words the reply text is spoken in, it defaults to textnorm.DefaultLocale.
+	TextNormLocale string
 
-	// BlobConnectionString is the azure sas connection string of blob storage.
-	BlobConnectionString string
-	// ServiceBusConnectionString  the azure sas connection string of service bus.
-	ServiceBusConnection string
-
-	// ServiceBusQueue is the name of azure service bus queue.
-	ServiceBusQueue string
+	// BlobStore is the blob storage backend configuration.
+	BlobStore shared.BlobStoreConfig
+	// TaskQueue is the GPU task queue backend configuration.
+	TaskQueue shared.TaskQueueConfig
+	// OutboxPollInterval is the interval between the outbox relay's checks for unsent GPU tasks.
+	OutboxPollInterval time.Duration
 }
 
 // HttpService implements HTTP handlers for serving static content, relaying to voice service, and more.
 type HttpService struct {
 	// Config has the configuration of the web server and its external dependencies.
 	Config *Config
-	// VoiceClient is an HTTP client for the voice service (reconn/voicesvc).
-	VoiceClient *http.Client
+	// VoiceClient balances the requests across the voice service (reconn/voicesvc) instances.
+	VoiceClient *voiceclient.Balancer
 	// OpenAIClient is a ChatGPT client.
 	OpenAIClient *openai.Client
 
@@ -70,56 +89,67 @@ type HttpService struct {
 	LowLevelDB *sql.DB
 	// Database is the high level & strongly typed reconn DB client.
 	Database *dbgen.Queries
-	// BlobClient is the azure blob storage client.
-	BlobClient *azblob.Client
-	// ServiceBusClient is the azure service bus client.
-	ServiceBusClient *azservicebus.Client
-	// ServiceBusClient is the azure service bus sender client.
-	ServiceBusSender *azservicebus.Sender
+	// BlobStore is the blob storage client of voice samples, models, and output files.
+	BlobStore shared.BlobStore
+	// TaskQueue is the queue of tasks for the GPU workers.
+	TaskQueue shared.TaskQueue
+	// TTSCache caches the synthesized speech.
+	TTSCache *shared.TTSCache
+	// TextNormalizer turns the reply text into the text spoken by the voice service.
+	TextNormalizer *textnorm.Normalizer
+	// TaskOutbox holds the GPU tasks created along with their records until they are sent to the task queue.
+	TaskOutbox *shared.TaskOutbox
 }
 
 // New returns an initialised HTTP service.
 func New(conf *Config) (*HttpService, error) {
+	if len(conf.VoiceServiceAddrs) == 0 {
+		conf.VoiceServiceAddrs = []string{conf.VoiceServiceAddr}
+	}
+	if len(conf.VoiceServiceAddrs) > 1 && !conf.VoiceModelDirShared {
+		return nil, fmt.Errorf("the %d voice services %q require the voice model directory %q to be shared with each of them", len(conf.VoiceServiceAddrs), conf.VoiceServiceAddrs, conf.VoiceModelDir)
+	}
+	conf.AudioLimits = conf.AudioLimits.WithDefaults()
 	svc := &HttpService{
 		Config:       conf,
 		OpenAIClient: openai.NewClient(conf.OpenAIKey),
 		// The real-time voice service endpoint relays (mainly for development & testing) require a generous amount of timeout.
-		VoiceClient: &http.Client{Timeout: 5 * time.Minute},
+		VoiceClient: voiceclient.NewBalancer(conf.VoiceServiceAddrs, &http.Client{Timeout: 5 * time.Minute}, conf.VoiceServiceBalancer),
 	}
-	// Connect to DB.
-	var err error
-	svc.LowLevelDB, svc.Database, err = db.Connect(conf.Database)
-	if err != nil {
-		log.Fatalf("failed to connect to database: %v", err)
-		return nil, err
+	if conf.TextNormLocale == "" {
+		conf.TextNormLocale = textnorm.DefaultLocale
 	}
-	log.Printf("successfully connected to database %v:%v, stats: %+v", conf.Database.Host, conf.Database.Port, svc.LowLevelDB.Stats())
-	// Connect to Azure blob storage.
-	svc.BlobClient, err = azblob.NewClientFromConnectionString(conf.BlobConnectionString, nil)
-	if err != nil {
-		log.Fatalf("failed to connect to azure blob storage: %v", err)
+	var err error
+	if svc.TextNormalizer, err = textnorm.New(conf.TextNormLocale); err != nil {
 		return nil, err
 	}
-	blobProps, err := svc.BlobClient.ServiceClient().GetProperties(context.Background(), nil)
+	// Connect to DB.
+	svc.LowLevelDB, svc.Database, err = db.Connect(conf.Database)
 	if err != nil {
-		log.Fatalf("failed to connect to azure blob storage: %v", err)
-		return nil, err
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
-	log.Printf("successfully connected to azure storage (err? %v), %+#v", err, blobProps)
-	// Connect to azure service bus.
-	svc.ServiceBusClient, err = azservicebus.NewClientFromConnectionString(conf.ServiceBusConnection, nil)
+	log.Printf("successfully connected to database %v:%v, stats: %+v", conf.Database.Host, conf.Database.Port, svc.LowLevelDB.Stats())
+	// Connect to blob storage.
+	svc.BlobStore, err = shared.NewBlobStore(context.Background(), conf.BlobStore)
 	if err != nil {
-		log.Fatalf("failed to connect to azure service bus: %v", err)
-		return nil, err
+		return nil, fmt.Errorf("failed to connect to blob storage: %w", err)
 	}
-	svc.ServiceBusSender, err = svc.ServiceBusClient.NewSender(conf.ServiceBusQueue, nil)
+	log.Printf("successfully connected to %q blob storage", conf.BlobStore.Backend)
+	svc.TTSCache = shared.NewTTSCache(svc.Database, svc.BlobStore, conf.TTSCache)
+	// Connect to the GPU task queue.
+	svc.TaskQueue, err = shared.NewTaskQueue(conf.TaskQueue, svc.LowLevelDB)
 	if err != nil {
-		log.Fatalf("failed to connect to azure service bus: %v", err)
-		return nil, err
+		return nil, fmt.Errorf("failed to connect to %q task queue: %w", conf.TaskQueue.Backend, err)
 	}
+	svc.TaskOutbox = shared.NewTaskOutbox(svc.LowLevelDB, svc.TaskQueue, conf.OutboxPollInterval)
 	return svc, nil
 }
 
+// Close releases the task queue client and database connections.
+func (svc *HttpService) Close(ctx context.Context) error {
+	return errors.Join(svc.TaskQueue.Close(ctx), svc.LowLevelDB.Close())
+}
+
 func (svc *HttpService) SetupRouter() *gin.Engine {
 	if svc.Config.DebugMode {
 		gin.SetMode(gin.DebugMode)
@@ -156,6 +186,7 @@ func (svc *HttpService) SetupRouter() *gin.Engine {
 		router.POST("/api/debug/clone-rt/:user_id", svc.handleRelayCloneRealTime)
 		router.POST("/api/debug/tts-rt/:user_id", svc.handleRelayTextToSpeechRealTime)
 		router.GET("/api/debug/voice-model", svc.handleListVoiceModel)
+		router.GET("/api/debug/vars", gin.WrapH(expvar.Handler()))
 		router.POST("/api/debug/converse-single-prompt", svc.handleConverseSinglePrompt)
 		router.POST("/api/debug/transcribe-rt", svc.handleTranscribeRealTime)
 		// Debug user endpoints.
@@ -165,10 +196,13 @@ func (svc *HttpService) SetupRouter() *gin.Engine {
 		router.POST("/api/debug/ai_person", svc.handleCreateAIPerson)
 		router.GET("/api/debug/user/:user_id/ai_person", svc.handleListAIPersons)
 		router.PUT("/api/debug/ai_person/:ai_person_id", svc.handleUpdateAIPerson)
+		router.PUT("/api/debug/ai_person/:ai_person_id/expressive_cues", svc.handleUpdateAIPersonExpressiveCues)
 		// Debug voice sample and model endpoints.
 		router.POST("/api/debug/ai_person/:ai_person_id/voice_sample", svc.handleCreateVoiceSample)
 		router.GET("/api/debug/ai_person/:ai_person_id/voice_sample", svc.handleListVoiceSamples)
 		router.GET("/api/debug/ai_person/:ai_person_id/latest_model", svc.handleGetLatestVoiceModel)
+		router.GET("/api/debug/ai_person/:ai_person_id/voice_model", svc.handleListVoiceModels)
+		router.GET("/api/debug/voice_model/:voice_model_id", svc.handleGetVoiceModel)
 		router.POST("/api/debug/voice_sample/:voice_sample_id/create_model", svc.handleCreateVoiceModel)
 		// Debug conversations.
 		router.POST("/api/debug/ai_person/:ai_person_id/post_text_message", svc.handlePostTextMessage)
@@ -176,9 +210,22 @@ func (svc *HttpService) SetupRouter() *gin.Engine {
 		router.GET("/api/debug/ai_person/:ai_person_id/conversation", svc.handleGetAIPersonConversation)
 		router.GET("/api/debug/voice_output_file/:file_name", svc.handleGetVoiceOutputFile)
 		// Use GPU-enabled workers for asynchronous processing.
-		router.POST("/api/debug/voice_sample/:voice_sample_id/create_model_async", svc.handleCreateVoiceModelAsync)
-		router.POST("/api/debug/ai_person/:ai_person_id/post_text_message_async", svc.handlePostTextMessageAsync)
-		router.POST("/api/debug/ai_person/:ai_person_id/post_voice_message_async", svc.handlePostVoiceMessageAsync)
+		router.POST("/api/debug/voice_sample/:voice_sample_id/create_model_async", svc.idempotent, svc.handleCreateVoiceModelAsync)
+		router.POST("/api/debug/ai_person/:ai_person_id/post_text_message_async", svc.idempotent, svc.handlePostTextMessageAsync)
+		router.POST("/api/debug/ai_person/:ai_person_id/post_voice_message_async", svc.idempotent, svc.handlePostVoiceMessageAsync)
+		router.POST("/api/debug/voice_model/:voice_model_id/cancel", svc.handleCancelVoiceModel)
+		router.POST("/api/debug/reply_voice/:reply_voice_id/cancel", svc.handleCancelAIPersonReplyVoice)
+		// Debug GPU worker registry.
+		router.GET("/api/debug/gpu_worker", svc.handleListGPUWorkers)
+		// Debug voice service instances.
+		router.GET("/api/debug/voice_service", svc.handleListVoiceServices)
+		// Debug TTS presets and settings.
+		router.GET("/api/debug/tts_preset", svc.handleListTTSPresets)
+		router.GET("/api/debug/ai_person/:ai_person_id/tts_settings", svc.handleGetAIPersonTTSSettings)
+		router.PUT("/api/debug/ai_person/:ai_person_id/tts_settings", svc.handleSetAIPersonTTSSettings)
+		router.GET("/api/debug/voice_model/:voice_model_id/tts_settings", svc.handleGetVoiceModelTTSSettings)
+		router.PUT("/api/debug/voice_model/:voice_model_id/tts_settings", svc.handleSetVoiceModelTTSSettings)
+		router.DELETE("/api/debug/voice_model/:voice_model_id/tts_settings", svc.handleDeleteVoiceModelTTSSettings)
 	}
 	return router
 }
@@ -1,35 +1,61 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/HouzuoGuo/reconn-voice-clone/audio"
 	"github.com/HouzuoGuo/reconn-voice-clone/db"
 	"github.com/HouzuoGuo/reconn-voice-clone/httpsvc"
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+	"github.com/HouzuoGuo/reconn-voice-clone/textnorm"
+	"github.com/HouzuoGuo/reconn-voice-clone/voiceclient"
 	"github.com/HouzuoGuo/reconn-voice-clone/workersvc"
 )
 
 func main() {
-	var httpDebugMode, gpuWorkerMode bool
+	var httpDebugMode, gpuWorkerMode, withGPUWorker bool
 
 	var port int
 	var addr string
 	var tlsCert, tlsKey string
 
 	var basicAuthUser, basicAuthPassword string
-	var voiceServiceAddr, openaiKey string
+	var voiceServiceAddr, voiceServiceAddrs, voiceServiceSlots, openaiKey string
+	var voiceServiceBalancerConf voiceclient.BalancerConfig
+	var prefetch int
+	var priorityWeights string
+	var warmUpActiveAIPersons int
+	var lockRenewInterval, shutdownGracePeriod, outboxPollInterval, cancelCheckInterval, heartbeatInterval, modelAffinityTimeout, warmUpActivityWindow time.Duration
 	var dbConf db.Config
 	var voiceSampleDir, voiceModelDir, voiceTempModelDir, voiceOutputDir string
+	var voiceModelDirShared bool
 
-	var azBlobConnString, azVoiceSampleContainer, azVoiceModelContainer, azVoiceOutputContainer string
-	var azServiceBusConnString, azServiceBusQueue string
+	var blobStoreConf shared.BlobStoreConfig
+	var azVoiceSampleContainer, azVoiceModelContainer, azVoiceOutputContainer string
+	var ttsCacheConf shared.TTSCacheConfig
+	var textNormLocale string
+	var audioLimits audio.Limits
+	var taskQueueConf shared.TaskQueueConfig
+	var maxAttempts int
+	var retryBaseDelay, retryMaxDelay, stuckTaskDeadline, reaperInterval time.Duration
+	var deadLetterCommand, deadLetterID string
 
 	flag.BoolVar(&httpDebugMode, "debug", false, "start http server in debug mode")
 	flag.BoolVar(&gpuWorkerMode, "gpuworker", false, "start as GPU worker instead of an http server")
+	flag.BoolVar(&withGPUWorker, "withgpuworker", false, "start a GPU worker in the same process as the http server, e.g. for the in-memory task queue")
 
 	flag.IntVar(&port, "port", 8080, "web server listener port")
 	flag.StringVar(&addr, "addr", "0.0.0.0", "http server listener address")
@@ -40,6 +66,13 @@ func main() {
 	flag.StringVar(&tlsKey, "tlskey", "", "tls certificate key path")
 
 	flag.StringVar(&voiceServiceAddr, "voicesvcaddr", "localhost:8081", "voice service address (host:port)")
+	flag.StringVar(&voiceServiceAddrs, "voicesvcaddrs", "", "comma separated voice services (host:port) the http server balances its requests across, defaults to -voicesvcaddr")
+	flag.DurationVar(&voiceServiceBalancerConf.HealthCheckInterval, "voicesvchealthcheck", voiceclient.DefaultHealthCheckInterval, "interval between the http server's health checks of each voice service")
+	flag.IntVar(&voiceServiceBalancerConf.FailureThreshold, "voicesvcfailures", voiceclient.DefaultFailureThreshold, "number of consecutive failures after which the http server ejects a voice service")
+	flag.DurationVar(&voiceServiceBalancerConf.EjectionPeriod, "voicesvcejection", voiceclient.DefaultEjectionPeriod, "minimum duration an ejected voice service stays ejected before a successful health check readmits it")
+	flag.StringVar(&voiceServiceSlots, "voicesvcslots", "", "comma separated voice services and the number of GPU tasks each processes concurrently (host:port=N), defaults to -voicesvcaddr with 1 slot")
+	flag.IntVar(&prefetch, "prefetch", 1, "number of GPU tasks the GPU worker receives in advance of a free slot")
+	flag.StringVar(&priorityWeights, "priorityweights", "", "comma separated relative shares of the GPU worker's slots of the priority lanes (lane=N), defaults to interactive=4,bulk=1")
 	flag.StringVar(&openaiKey, "openaikey", "", "openai API secret key")
 
 	flag.StringVar(&dbConf.Host, "dbhost", "", "postgresql database host name")
@@ -47,48 +80,136 @@ func main() {
 	flag.StringVar(&dbConf.User, "dbuser", "", "postgresql database user name")
 	flag.StringVar(&dbConf.Password, "dbpassword", "", "postgresql database password")
 	flag.StringVar(&dbConf.Database, "dbname", "", "postgresql database password")
+	flag.StringVar(&dbConf.SSLMode, "dbsslmode", "verify-full", "postgresql database sslmode, e.g. disable for a local database")
 
 	flag.StringVar(&voiceSampleDir, "voicesampledir", "/tmp/voice_sample_dir", "path to the directory of incoming user voice samples")
 	flag.StringVar(&voiceModelDir, "voicemodeldir", "/tmp/voice_model_dir", "path to the directory of constructed user voice models")
+	flag.BoolVar(&voiceModelDirShared, "voicemodeldirshared", false, "the voice model directory is shared with each voice service, required by more than one voice service")
 	flag.StringVar(&voiceTempModelDir, "voicetempmodeldir", "/tmp/voice_temp_model_dir", "path to the directory of temporary user voice models used during TTS")
 	flag.StringVar(&voiceOutputDir, "voiceoutputdir", "/tmp/voice_output_dir", "path to the directory of TTS output files")
 
-	flag.StringVar(&azBlobConnString, "azblobconnstr", ``, "azure storage connections tring")
-	flag.StringVar(&azVoiceSampleContainer, "azvoicecontainer", "voice-sample", "azure storage voice sample container name")
-	flag.StringVar(&azVoiceModelContainer, "azmodelcontainer", "voice-model", "azure storage voice model container name")
-	flag.StringVar(&azVoiceOutputContainer, "azvoiceoutcontainer", "voice-output", "azure storage voice output container name")
+	flag.StringVar(&blobStoreConf.Backend, "blobstore", shared.BlobStoreAzure, "blob storage backend: azure, local, or s3")
+	flag.StringVar(&blobStoreConf.AzureConnectionString, "azblobconnstr", ``, "azure storage connections tring")
+	flag.StringVar(&blobStoreConf.LocalDir, "localblobdir", "/tmp/reconn_blob_dir", "path to the root directory of the local blob storage")
+	flag.StringVar(&blobStoreConf.S3Endpoint, "s3endpoint", "", "S3-compatible object storage address (host:port)")
+	flag.StringVar(&blobStoreConf.S3Region, "s3region", "", "S3-compatible object storage region name")
+	flag.StringVar(&blobStoreConf.S3AccessKey, "s3accesskey", "", "S3-compatible object storage access key ID")
+	flag.StringVar(&blobStoreConf.S3SecretKey, "s3secretkey", "", "S3-compatible object storage secret access key")
+	flag.BoolVar(&blobStoreConf.S3UseTLS, "s3tls", true, "connect to the S3-compatible object storage via HTTPS")
+	flag.StringVar(&azVoiceSampleContainer, "azvoicecontainer", "voice-sample", "blob storage voice sample container (or bucket) name")
+	flag.StringVar(&azVoiceModelContainer, "azmodelcontainer", "voice-model", "blob storage voice model container (or bucket) name")
+	flag.StringVar(&azVoiceOutputContainer, "azvoiceoutcontainer", "voice-output", "blob storage voice output container (or bucket) name")
 
-	flag.StringVar(&azServiceBusConnString, "azsvcbusconnstr", ``, "azure service bus connection string")
-	flag.StringVar(&azServiceBusQueue, "azsvcbusqueue", ``, "azure service bus queue name")
+	flag.StringVar(&ttsCacheConf.Container, "ttscachecontainer", shared.DefaultTTSCacheContainer, "blob storage container (or bucket) name of the cached synthesized speech")
+	flag.StringVar(&ttsCacheConf.VoiceServiceVersion, "ttscacheversion", shared.DefaultTTSCacheVoiceServiceVersion, "version of the voice service and its models, change it to stop serving the speech cached by the previous version")
+	flag.Int64Var(&ttsCacheConf.MaxBytes, "ttscachemaxbytes", shared.DefaultTTSCacheMaxBytes, "total size of the cached synthesized speech beyond which the least recently used is evicted, negative to disable the cache")
+	flag.DurationVar(&ttsCacheConf.MaxAge, "ttscachemaxage", shared.DefaultTTSCacheMaxAge, "how long unused synthesized speech stays in the cache")
+	flag.DurationVar(&ttsCacheConf.EvictInterval, "ttscacheevictinterval", shared.DefaultTTSCacheEvictInterval, "interval between the http server's evictions of the cached synthesized speech")
+	flag.IntVar(&audioLimits.MinSampleRate, "audiominsamplerate", audio.DefaultMinSampleRate, "lowest sample rate (Hz) of the uploaded voice samples and voice messages, negative for no limit")
+	flag.IntVar(&audioLimits.MaxSampleRate, "audiomaxsamplerate", audio.DefaultMaxSampleRate, "highest sample rate (Hz) of the uploaded voice samples and voice messages, negative for no limit")
+	flag.IntVar(&audioLimits.MaxChannels, "audiomaxchannels", audio.DefaultMaxChannels, "most channels of the uploaded voice samples and voice messages, negative for no limit")
+	flag.DurationVar(&audioLimits.MinDuration, "audiomindur", audio.DefaultMinDuration, "shortest duration of the uploaded voice samples and voice messages, negative for no limit")
+	flag.DurationVar(&audioLimits.MaxDuration, "audiomaxdur", audio.DefaultMaxDuration, "longest duration of the uploaded voice samples and voice messages, negative for no limit")
+	flag.StringVar(&textNormLocale, "textnormlocale", textnorm.DefaultLocale, fmt.Sprintf("locale of the words the numbers, dates, times, abbreviations, and symbols of the replies are spoken in: %v", textnorm.LocaleNames()))
+
+	flag.StringVar(&taskQueueConf.Backend, "taskqueue", shared.TaskQueueServiceBus, "GPU task queue backend: servicebus, memory, or postgres")
+	flag.StringVar(&taskQueueConf.ServiceBusConnection, "azsvcbusconnstr", ``, "azure service bus connection string")
+	flag.StringVar(&taskQueueConf.Name, "azsvcbusqueue", "gpu-tasks", "GPU task queue name (azure service bus queue name for servicebus)")
+	flag.DurationVar(&taskQueueConf.LockDuration, "taskqueuelock", shared.DefaultTaskLockDuration, "how long a received task stays locked for its GPU worker (postgres)")
+	flag.DurationVar(&lockRenewInterval, "lockrenewinterval", workersvc.DefaultLockRenewInterval, "interval between renewals of a GPU task's lock while the GPU worker processes it, well within the lock duration")
+	flag.DurationVar(&cancelCheckInterval, "cancelcheckinterval", workersvc.DefaultCancelCheckInterval, "interval between the GPU worker's checks of whether the user has cancelled a GPU task in flight")
+	flag.DurationVar(&heartbeatInterval, "heartbeatinterval", workersvc.DefaultHeartbeatInterval, "interval between the GPU worker's heartbeats in the worker registry")
+	flag.DurationVar(&modelAffinityTimeout, "modelaffinitytimeout", workersvc.DefaultModelAffinityTimeout, "how long a TTS task waits for a GPU worker that has its voice model cached before any GPU worker takes it, negative to disable")
+	flag.IntVar(&warmUpActiveAIPersons, "warmupaipersons", workersvc.DefaultWarmUpActiveAIPersons, "number of the most active AI persons whose voice models the GPU worker warms up on startup, negative to disable")
+	flag.DurationVar(&warmUpActivityWindow, "warmupwindow", workersvc.DefaultWarmUpActivityWindow, "period of recent prompts that determines the most active AI persons")
+	flag.DurationVar(&taskQueueConf.PollInterval, "taskqueuepoll", shared.DefaultTaskPollInterval, "interval between attempts to receive GPU tasks (postgres)")
+	flag.DurationVar(&outboxPollInterval, "outboxpoll", shared.DefaultOutboxPollInterval, "interval between the http server's and the GPU worker's checks for GPU tasks in the outbox that are yet to be sent to the task queue")
+
+	flag.IntVar(&maxAttempts, "maxattempts", workersvc.DefaultMaxAttempts, "maximum number of attempts of a GPU task before it is dead-lettered")
+	flag.DurationVar(&retryBaseDelay, "retrybasedelay", workersvc.DefaultRetryBaseDelay, "delay before retrying a failed GPU task, doubling with each attempt")
+	flag.DurationVar(&retryMaxDelay, "retrymaxdelay", workersvc.DefaultRetryMaxDelay, "upper limit of the delay before retrying a failed GPU task")
+	flag.DurationVar(&stuckTaskDeadline, "stucktaskdeadline", workersvc.DefaultStuckTaskDeadline, "how long a voice model or reply voice may stay in processing before the GPU worker re-enqueues its task or marks it failed")
+	flag.DurationVar(&reaperInterval, "reaperinterval", workersvc.DefaultReaperInterval, "interval between the GPU worker's checks for records stuck in processing, negative to disable")
+
+	flag.DurationVar(&shutdownGracePeriod, "shutdowngraceperiod", workersvc.DefaultShutdownGracePeriod, "how long the in-flight http requests and GPU tasks may carry on after SIGINT or SIGTERM")
+
+	flag.StringVar(&deadLetterCommand, "deadletter", "", "run a dead letter queue command and exit: list, inspect, or requeue")
+	flag.StringVar(&deadLetterID, "deadletterid", "", "ID of the dead-lettered GPU task to inspect or requeue")
 
 	flag.Parse()
 
-	if gpuWorkerMode {
-		log.Printf("about to start GPU worker for service bus queue %q", azServiceBusQueue)
-		workerConf := &workersvc.Config{
-			VoiceServiceAddr: voiceServiceAddr,
+	if deadLetterCommand != "" {
+		if err := runDeadLetterCommand(deadLetterCommand, deadLetterID, taskQueueConf, dbConf); err != nil {
+			log.Fatalf("dead letter command %q failed: %v", deadLetterCommand, err)
+		}
+		return
+	}
 
-			Database: dbConf,
+	voiceServices, err := workersvc.ParseVoiceServiceSlots(voiceServiceSlots)
+	if err != nil {
+		log.Fatalf("failed to parse -voicesvcslots: %v", err)
+	}
+	laneWeights, err := workersvc.ParsePriorityWeights(priorityWeights)
+	if err != nil {
+		log.Fatalf("failed to parse -priorityweights: %v", err)
+	}
+	workerConf := &workersvc.Config{
+		VoiceServiceAddr: voiceServiceAddr,
+		VoiceServices:    voiceServices,
+		Prefetch:         prefetch,
+		PriorityWeights:  laneWeights,
 
-			BlobConnectionString: azBlobConnString,
-			ServiceBusQueue:      azServiceBusQueue,
-			ServiceBusConnection: azServiceBusConnString,
+		LockRenewInterval:     lockRenewInterval,
+		ShutdownGracePeriod:   shutdownGracePeriod,
+		CancelCheckInterval:   cancelCheckInterval,
+		HeartbeatInterval:     heartbeatInterval,
+		ModelAffinityTimeout:  modelAffinityTimeout,
+		WarmUpActiveAIPersons: warmUpActiveAIPersons,
+		WarmUpActivityWindow:  warmUpActivityWindow,
 
-			VoiceSampleDir:       voiceSampleDir,
-			VoiceModelDir:        voiceModelDir,
-			VoiceTempModelDir:    voiceTempModelDir,
-			VoiceOutputDir:       voiceOutputDir,
-			VoiceSampleContainer: azVoiceSampleContainer,
-			VoiceModelContainer:  azVoiceModelContainer,
-			VoiceOutputContainer: azVoiceOutputContainer,
+		Database: dbConf,
+
+		BlobStore: blobStoreConf,
+		TaskQueue: taskQueueConf,
+
+		VoiceSampleDir:       voiceSampleDir,
+		VoiceModelDir:        voiceModelDir,
+		VoiceModelDirShared:  voiceModelDirShared,
+		VoiceTempModelDir:    voiceTempModelDir,
+		VoiceOutputDir:       voiceOutputDir,
+		VoiceSampleContainer: azVoiceSampleContainer,
+		VoiceModelContainer:  azVoiceModelContainer,
+		VoiceOutputContainer: azVoiceOutputContainer,
+		TTSCache:             ttsCacheConf,
+		TextNormLocale:       textNormLocale,
+
+		MaxAttempts:    maxAttempts,
+		RetryBaseDelay: retryBaseDelay,
+		RetryMaxDelay:  retryMaxDelay,
+
+		StuckTaskDeadline: stuckTaskDeadline,
+		ReaperInterval:    reaperInterval,
+
+		OutboxPollInterval: outboxPollInterval,
+	}
+	// Stop receiving requests and tasks on SIGINT or SIGTERM, e.g. during a deployment, and let the work in flight finish.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		// A second signal terminates the process straight away.
+		<-ctx.Done()
+		stop()
+	}()
+	if gpuWorkerMode {
+		log.Printf("about to start GPU worker for %q task queue %q", taskQueueConf.Backend, taskQueueConf.Name)
+		if err := startGPUWorker(ctx, workerConf); err != nil {
+			log.Fatalf("GPU worker exited: %v", err)
 		}
-		startGPUWorker(workerConf)
 	} else {
-		log.Printf("about to start web service on port %d, connect to backend voice service at %q, debug mode? %v, using http basic auth? %v", port, voiceServiceAddr, httpDebugMode, basicAuthUser != "")
 		httpConf := &httpsvc.Config{
-			DebugMode:        httpDebugMode,
-			VoiceServiceAddr: voiceServiceAddr,
-			OpenAIKey:        openaiKey,
+			DebugMode:            httpDebugMode,
+			VoiceServiceAddr:     voiceServiceAddr,
+			VoiceServiceBalancer: voiceServiceBalancerConf,
+			OpenAIKey:            openaiKey,
 
 			BasicAuthUser:     basicAuthUser,
 			BasicAuthPassword: basicAuthPassword,
@@ -97,25 +218,72 @@ func main() {
 
 			VoiceSampleDir:       voiceSampleDir,
 			VoiceModelDir:        voiceModelDir,
+			VoiceModelDirShared:  voiceModelDirShared,
 			VoiceTempModelDir:    voiceTempModelDir,
 			VoiceOutputDir:       voiceOutputDir,
 			VoiceSampleContainer: azVoiceSampleContainer,
 			VoiceModelContainer:  azVoiceModelContainer,
 			VoiceOutputContainer: azVoiceOutputContainer,
+			TTSCache:             ttsCacheConf,
+			TextNormLocale:       textNormLocale,
+			AudioLimits:          audioLimits,
 
-			BlobConnectionString: azBlobConnString,
-			ServiceBusQueue:      azServiceBusQueue,
-			ServiceBusConnection: azServiceBusConnString,
+			BlobStore:          blobStoreConf,
+			TaskQueue:          taskQueueConf,
+			OutboxPollInterval: outboxPollInterval,
+		}
+		httpConf.VoiceServiceAddrs = []string{voiceServiceAddr}
+		if voiceServiceAddrs != "" {
+			httpConf.VoiceServiceAddrs = nil
+			for _, addr := range strings.Split(voiceServiceAddrs, ",") {
+				httpConf.VoiceServiceAddrs = append(httpConf.VoiceServiceAddrs, strings.TrimSpace(addr))
+			}
+		}
+		log.Printf("about to start web service on port %d, connect to backend voice services at %q, debug mode? %v, using http basic auth? %v", port, httpConf.VoiceServiceAddrs, httpDebugMode, basicAuthUser != "")
+		workerDone := make(chan struct{})
+		if withGPUWorker {
+			log.Printf("about to start GPU worker in the same process for %q task queue %q", taskQueueConf.Backend, taskQueueConf.Name)
+			go func() {
+				defer close(workerDone)
+				if err := startGPUWorker(ctx, workerConf); err != nil {
+					log.Fatalf("GPU worker exited: %v", err)
+				}
+			}()
+		} else {
+			close(workerDone)
+		}
+		if err := startHTTPServer(ctx, httpConf, addr, port, tlsCert, tlsKey, shutdownGracePeriod); err != nil {
+			log.Fatalf("http server exited: %v", err)
 		}
-		startHTTPServer(httpConf, addr, port, tlsCert, tlsKey)
+		<-workerDone
 	}
 }
 
-func startHTTPServer(conf *httpsvc.Config, addr string, port int, tlsCert, tlsKey string) {
+// startHTTPServer serves http requests until the context is cancelled, and then gives the requests in flight the grace
+// period to finish.
+func startHTTPServer(ctx context.Context, conf *httpsvc.Config, addr string, port int, tlsCert, tlsKey string, gracePeriod time.Duration) error {
 	httpService, err := httpsvc.New(conf)
 	if err != nil {
-		log.Fatalf("failed to initialise http service: %v", err)
+		return fmt.Errorf("failed to initialise http service: %w", err)
 	}
+	defer func() {
+		if err := httpService.Close(context.Background()); err != nil {
+			log.Printf("failed to close http service: %v", err)
+		}
+	}()
+	// The outbox relay, the voice service health checks, and the TTS cache eviction carry on until the requests in flight have finished.
+	relayCtx, stopRelay := context.WithCancel(context.WithoutCancel(ctx))
+	relayDone := make(chan struct{})
+	go func() {
+		defer close(relayDone)
+		httpService.TaskOutbox.Run(relayCtx)
+	}()
+	go httpService.VoiceClient.Run(relayCtx)
+	go httpService.TTSCache.Run(relayCtx)
+	defer func() {
+		stopRelay()
+		<-relayDone
+	}()
 	server := &http.Server{
 		// The real-time voice service endpoint relays (mainly for development & testing) require a generous amount of timeout.
 		ReadTimeout:       5 * time.Minute,
@@ -125,17 +293,104 @@ func startHTTPServer(conf *httpsvc.Config, addr string, port int, tlsCert, tlsKe
 		Handler:           httpService.SetupRouter(),
 		Addr:              net.JoinHostPort(addr, strconv.Itoa(port)),
 	}
-	if tlsCert == "" {
-		log.Fatal(server.ListenAndServe())
-	} else {
-		log.Fatal(server.ListenAndServeTLS(tlsCert, tlsKey))
+	serverDone := make(chan error, 1)
+	go func() {
+		if tlsCert == "" {
+			serverDone <- server.ListenAndServe()
+		} else {
+			serverDone <- server.ListenAndServeTLS(tlsCert, tlsKey)
+		}
+	}()
+	select {
+	case err := <-serverDone:
+		return err
+	case <-ctx.Done():
 	}
+	log.Printf("shutting down http server, waiting up to %v for the requests in flight", gracePeriod)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("closing the remaining http connections: %v", err)
+		return server.Close()
+	}
+	return nil
 }
 
-func startGPUWorker(conf *workersvc.Config) {
+// startGPUWorker processes GPU tasks until the context is cancelled, and then gives the tasks in flight the grace
+// period to finish.
+func startGPUWorker(ctx context.Context, conf *workersvc.Config) error {
 	worker, err := workersvc.New(conf)
 	if err != nil {
-		log.Fatalf("failed to initialise GPU worker service: %v", err)
+		return fmt.Errorf("failed to initialise GPU worker service: %w", err)
+	}
+	defer func() {
+		if err := worker.Close(context.Background()); err != nil {
+			log.Printf("failed to close GPU worker service: %v", err)
+		}
+	}()
+	return worker.Run(ctx)
+}
+
+// runDeadLetterCommand lists, inspects, or requeues the tasks in the dead letter queue of the GPU task queue.
+func runDeadLetterCommand(command, id string, conf shared.TaskQueueConfig, dbConf db.Config) error {
+	ctx := context.Background()
+	var lowLevelDB *sql.DB
+	if conf.Backend == shared.TaskQueuePostgres {
+		var err error
+		if lowLevelDB, _, err = db.Connect(dbConf); err != nil {
+			return err
+		}
+		defer lowLevelDB.Close()
+	}
+	queue, err := shared.NewTaskQueue(conf, lowLevelDB)
+	if err != nil {
+		return err
+	}
+	defer queue.Close(ctx)
+	switch command {
+	case "list":
+		deadLetters, err := queue.ListDeadLetters(ctx, 100)
+		if err != nil {
+			return err
+		}
+		for _, deadLetter := range deadLetters {
+			summary := "malformed task"
+			if task, err := shared.ParseGPUTask(deadLetter.Body); err == nil {
+				summary = task.String()
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\n", deadLetter.ID, deadLetter.DeadLetteredAt.Format(time.RFC3339), deadLetter.Reason, summary)
+		}
+		return nil
+	case "inspect":
+		deadLetters, err := queue.ListDeadLetters(ctx, 1000)
+		if err != nil {
+			return err
+		}
+		for _, deadLetter := range deadLetters {
+			if deadLetter.ID == id {
+				out, err := json.MarshalIndent(map[string]any{
+					"id":             deadLetter.ID,
+					"deadLetteredAt": deadLetter.DeadLetteredAt,
+					"deliveryCount":  deadLetter.DeliveryCount,
+					"reason":         deadLetter.Reason,
+					"description":    deadLetter.Description,
+					"body":           string(deadLetter.Body),
+				}, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(out))
+				return nil
+			}
+		}
+		return shared.ErrDeadLetterNotFound
+	case "requeue":
+		if err := queue.RequeueDeadLetter(ctx, id); err != nil {
+			return err
+		}
+		fmt.Printf("requeued dead-lettered task %s\n", id)
+		return nil
+	default:
+		return fmt.Errorf("unknown dead letter command, use list, inspect, or requeue")
 	}
-	log.Fatalf("GPU worker exited: %v", worker.Run())
 }
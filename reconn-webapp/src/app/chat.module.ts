@@ -46,6 +46,16 @@ export interface SqlNullString {
   Valid?: boolean;
 }
 
+export interface SqlNullInt32 {
+  Int32?: number;
+  Valid?: boolean;
+}
+
+export interface SqlNullTime {
+  Time?: string;
+  Valid?: boolean;
+}
+
 export interface User {
   ID?: number;
   Name?: string;
@@ -79,6 +89,10 @@ export interface VoiceModel {
   Status?: string;
   FileName?: SqlNullString;
   Timestamp?: string;
+  ErrorMessage?: SqlNullString;
+  Attempts?: number;
+  StartedAt?: SqlNullTime;
+  FinishedAt?: SqlNullTime;
 }
 
 export interface UserPrompt {
@@ -99,6 +113,10 @@ export interface UserVoicePrompt {
   Status?: string;
   FileName?: string;
   Transcription?: SqlNullString;
+  ErrorMessage?: SqlNullString;
+  Attempts?: number;
+  StartedAt?: SqlNullTime;
+  FinishedAt?: SqlNullTime;
 }
 
 export interface AiPersonReply {
@@ -107,6 +125,10 @@ export interface AiPersonReply {
   Status?: string;
   Message?: string;
   Timestamp?: string;
+  ErrorMessage?: SqlNullString;
+  Attempts?: number;
+  StartedAt?: SqlNullTime;
+  FinishedAt?: SqlNullTime;
 }
 
 export interface AiPersonReplyVoice {
@@ -114,6 +136,10 @@ export interface AiPersonReplyVoice {
   AiPersonReplyID?: number;
   Status?: string;
   FileName?: SqlNullString;
+  ErrorMessage?: SqlNullString;
+  Attempts?: number;
+  StartedAt?: SqlNullTime;
+  FinishedAt?: SqlNullTime;
 }
 
 export interface ListConversationsRow {
@@ -129,6 +155,10 @@ export interface ListConversationsRow {
   ReplyTimestamp?: string;
   ReplyVoiceStatus?: SqlNullString;
   ReplyVoiceFilename?: SqlNullString;
+  VoiceErrorMessage?: SqlNullString;
+  ReplyErrorMessage?: SqlNullString;
+  ReplyVoiceErrorMessage?: SqlNullString;
+  ReplyVoiceAttempts?: SqlNullInt32;
 }
 
 export interface GetLatestVoiceModelRow {
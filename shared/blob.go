@@ -0,0 +1,194 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// BlobStoreAzure is the blob store backend name of azure blob storage.
+	BlobStoreAzure = "azure"
+	// BlobStoreLocal is the blob store backend name of a directory on the local file system.
+	BlobStoreLocal = "local"
+	// BlobStoreS3 is the blob store backend name of an S3-compatible object storage such as AWS S3 or MinIO.
+	BlobStoreS3 = "s3"
+)
+
+// BlobStore stores named blobs grouped by container (or bucket).
+type BlobStore interface {
+	// Download writes the content of the blob to the writer.
+	Download(ctx context.Context, container, name string, w io.Writer) error
+	// Upload reads the blob content from the reader and stores it under the name, replacing the existing blob if any.
+	// The size is the length of the content, or -1 if it is unknown.
+	Upload(ctx context.Context, container, name string, r io.Reader, size int64) error
+	// Delete removes the blob, it is not an error if the blob does not exist.
+	Delete(ctx context.Context, container, name string) error
+	// Ping verifies that the storage backend is reachable.
+	Ping(ctx context.Context) error
+}
+
+// BlobStoreConfig describes the blob storage backend and its connection parameters.
+type BlobStoreConfig struct {
+	// Backend is the name of the blob storage backend, see BlobStoreAzure, BlobStoreLocal, and BlobStoreS3.
+	Backend string
+
+	// AzureConnectionString is the azure sas connection string of blob storage.
+	AzureConnectionString string
+
+	// LocalDir is the path to the root directory of the local file system blob storage.
+	LocalDir string
+
+	// S3Endpoint is the address ("host:port") of the S3-compatible object storage.
+	S3Endpoint string
+	// S3Region is the optional region name of the S3 buckets.
+	S3Region string
+	// S3AccessKey is the access key ID of the S3-compatible object storage.
+	S3AccessKey string
+	// S3SecretKey is the secret access key of the S3-compatible object storage.
+	S3SecretKey string
+	// S3UseTLS flag indicates that the S3 endpoint shall be connected to via HTTPS.
+	S3UseTLS bool
+}
+
+// NewBlobStore returns an initialised blob store of the configured backend, after verifying that it is reachable.
+func NewBlobStore(ctx context.Context, conf BlobStoreConfig) (BlobStore, error) {
+	var store BlobStore
+	var err error
+	switch conf.Backend {
+	case BlobStoreAzure, "":
+		store, err = NewAzureBlobStore(conf.AzureConnectionString)
+	case BlobStoreLocal:
+		store, err = NewLocalBlobStore(conf.LocalDir)
+	case BlobStoreS3:
+		store, err = NewS3BlobStore(conf.S3Endpoint, conf.S3Region, conf.S3AccessKey, conf.S3SecretKey, conf.S3UseTLS)
+	default:
+		return nil, fmt.Errorf("unknown blob store backend %q", conf.Backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Ping(ctx); err != nil {
+		return nil, fmt.Errorf("failed to reach %q blob store: %w", conf.Backend, err)
+	}
+	return store, nil
+}
+
+// download is a download of a blob into a local file in progress, its error is set before done is closed.
+type download struct {
+	done chan struct{}
+	err  error
+}
+
+var (
+	// downloadsMutex protects downloads.
+	downloadsMutex sync.Mutex
+	// downloads are the downloads in progress by their local file path, the concurrent downloads of the same file wait for
+	// the first one instead of downloading it again.
+	downloads = map[string]*download{}
+)
+
+// DownloadBlobToLocalFileIfNotExist downloads the blob into the local directory, unless the file has already been downloaded.
+// The file appears in the directory only once completely downloaded, hence the callers never read a partial file.
+func DownloadBlobToLocalFileIfNotExist(ctx context.Context, store BlobStore, blobContainerName, fileName, localDir string) (string, error) {
+	localFilePath := path.Join(localDir, fileName)
+	if localDirStat, err := os.Stat(localDir); err != nil || !localDirStat.IsDir() {
+		err = fmt.Errorf("cannot access local fs directory %q: %w", localDir, err)
+		return "", err
+	}
+	if stat, err := os.Stat(localFilePath); err == nil && stat.Size() > 0 {
+		// Already downloaded to disk.
+		return localFilePath, nil
+	}
+	downloadsMutex.Lock()
+	inProgress, exists := downloads[localFilePath]
+	if !exists {
+		// The file may have been downloaded since the check above.
+		if stat, err := os.Stat(localFilePath); err == nil && stat.Size() > 0 {
+			downloadsMutex.Unlock()
+			return localFilePath, nil
+		}
+		inProgress = &download{done: make(chan struct{})}
+		downloads[localFilePath] = inProgress
+		downloadsMutex.Unlock()
+		inProgress.err = downloadToLocalFile(ctx, store, blobContainerName, fileName, localFilePath)
+		downloadsMutex.Lock()
+		delete(downloads, localFilePath)
+		downloadsMutex.Unlock()
+		close(inProgress.done)
+	} else {
+		downloadsMutex.Unlock()
+	}
+	select {
+	case <-inProgress.done:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	if inProgress.err != nil {
+		return "", inProgress.err
+	}
+	return localFilePath, nil
+}
+
+// downloadToLocalFile downloads the blob into a temporary file next to the local file, and then renames it to the local file.
+func downloadToLocalFile(ctx context.Context, store BlobStore, blobContainerName, fileName, localFilePath string) error {
+	tempFile, err := os.CreateTemp(filepath.Dir(localFilePath), "."+filepath.Base(localFilePath)+".download-*")
+	if err != nil {
+		return err
+	}
+	err = store.Download(ctx, blobContainerName, fileName, tempFile)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// The voice service reads the file too, like the files saved by UploadAndSave.
+		err = os.Chmod(tempFile.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tempFile.Name(), localFilePath)
+	}
+	if err != nil {
+		// Do not leave a partially downloaded file behind.
+		_ = os.Remove(tempFile.Name())
+	}
+	return err
+}
+
+// UploadFromLocalFile uploads the file from the local directory to the blob container.
+func UploadFromLocalFile(ctx context.Context, store BlobStore, blobContainerName, fileName, localDir string) error {
+	if localDirStat, err := os.Stat(localDir); err != nil || !localDirStat.IsDir() {
+		err = fmt.Errorf("cannot access local fs directory %q: %w", localDir, err)
+		return err
+	}
+	localFile, err := os.Open(path.Join(localDir, fileName))
+	if err != nil {
+		return err
+	}
+	defer localFile.Close()
+	stat, err := localFile.Stat()
+	if err != nil {
+		return err
+	}
+	return store.Upload(ctx, blobContainerName, fileName, localFile, stat.Size())
+}
+
+// UploadAndSave saves the data to a file in the local directory and uploads it to the blob container.
+func UploadAndSave(ctx context.Context, store BlobStore, blobContainerName, fileName, localDir string, data []byte) (string, error) {
+	if localDirStat, err := os.Stat(localDir); err != nil || !localDirStat.IsDir() {
+		err = fmt.Errorf("cannot access local fs directory %q: %w", localDir, err)
+		return "", err
+	}
+	localFilePath := path.Join(localDir, fileName)
+	if err := os.WriteFile(localFilePath, data, 0644); err != nil {
+		return "", err
+	}
+	if err := store.Upload(ctx, blobContainerName, fileName, bytes.NewReader(data), int64(len(data))); err != nil {
+		return "", err
+	}
+	return localFilePath, nil
+}
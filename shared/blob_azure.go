@@ -0,0 +1,52 @@
+package shared
+
+import (
+	"context"
+	"io"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+)
+
+// AzureBlobStore is a blob store backed by azure blob storage.
+type AzureBlobStore struct {
+	// Client is the azure blob storage client.
+	Client *azblob.Client
+}
+
+// NewAzureBlobStore returns an azure blob store client for the sas connection string.
+func NewAzureBlobStore(connString string) (*AzureBlobStore, error) {
+	client, err := azblob.NewClientFromConnectionString(connString, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &AzureBlobStore{Client: client}, nil
+}
+
+func (store *AzureBlobStore) Download(ctx context.Context, container, name string, w io.Writer) error {
+	resp, err := store.Client.DownloadStream(ctx, container, name, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func (store *AzureBlobStore) Upload(ctx context.Context, container, name string, r io.Reader, size int64) error {
+	_, err := store.Client.UploadStream(ctx, container, name, r, nil)
+	return err
+}
+
+func (store *AzureBlobStore) Delete(ctx context.Context, container, name string) error {
+	_, err := store.Client.DeleteBlob(ctx, container, name, nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (store *AzureBlobStore) Ping(ctx context.Context) error {
+	_, err := store.Client.ServiceClient().GetProperties(ctx, nil)
+	return err
+}
@@ -0,0 +1,98 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalBlobStore is a blob store backed by a directory on the local file system.
+// Each container is a sub-directory of the root directory.
+type LocalBlobStore struct {
+	// RootDir is the path to the root directory of all containers.
+	RootDir string
+}
+
+// NewLocalBlobStore returns a local file system blob store rooted at the directory, creating the directory if necessary.
+func NewLocalBlobStore(rootDir string) (*LocalBlobStore, error) {
+	if rootDir == "" {
+		return nil, fmt.Errorf("local blob store directory must not be empty")
+	}
+	if err := os.MkdirAll(rootDir, 0755); err != nil {
+		return nil, err
+	}
+	return &LocalBlobStore{RootDir: rootDir}, nil
+}
+
+// blobPath returns the path to the file of the blob, and rejects names that would escape the container directory.
+func (store *LocalBlobStore) blobPath(container, name string) (string, error) {
+	for _, elem := range []string{container, name} {
+		if elem == "" || elem == "." || elem == ".." || strings.ContainsAny(elem, `/\`) {
+			return "", fmt.Errorf("invalid blob container or name %q", elem)
+		}
+	}
+	return filepath.Join(store.RootDir, container, name), nil
+}
+
+func (store *LocalBlobStore) Download(ctx context.Context, container, name string, w io.Writer) error {
+	blobPath, err := store.blobPath(container, name)
+	if err != nil {
+		return err
+	}
+	blobFile, err := os.Open(blobPath)
+	if err != nil {
+		return err
+	}
+	defer blobFile.Close()
+	_, err = io.Copy(w, blobFile)
+	return err
+}
+
+func (store *LocalBlobStore) Upload(ctx context.Context, container, name string, r io.Reader, size int64) error {
+	blobPath, err := store.blobPath(container, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(blobPath), 0755); err != nil {
+		return err
+	}
+	// Write to a temporary file first so that readers never observe a partially written blob.
+	tmpFile, err := os.CreateTemp(filepath.Dir(blobPath), "."+name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := io.Copy(tmpFile, r); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), blobPath)
+}
+
+func (store *LocalBlobStore) Delete(ctx context.Context, container, name string) error {
+	blobPath, err := store.blobPath(container, name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(blobPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (store *LocalBlobStore) Ping(ctx context.Context) error {
+	stat, err := os.Stat(store.RootDir)
+	if err != nil {
+		return err
+	}
+	if !stat.IsDir() {
+		return fmt.Errorf("%q is not a directory", store.RootDir)
+	}
+	return nil
+}
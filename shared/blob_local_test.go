@@ -0,0 +1,97 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalBlobStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalBlobStore(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, store.Ping(ctx))
+
+	require.NoError(t, store.Upload(ctx, "voice-model", "1.npz", bytes.NewReader([]byte("model")), 5))
+	var buf bytes.Buffer
+	require.NoError(t, store.Download(ctx, "voice-model", "1.npz", &buf))
+	assert.Equal(t, "model", buf.String())
+
+	// Names must not escape the container directory.
+	assert.Error(t, store.Upload(ctx, "voice-model", "../1.npz", bytes.NewReader(nil), 0))
+	assert.Error(t, store.Download(ctx, "..", "1.npz", &buf))
+
+	// Download to a local directory, and a missing blob must not leave an empty file behind.
+	localDir := t.TempDir()
+	localPath, err := DownloadBlobToLocalFileIfNotExist(ctx, store, "voice-model", "1.npz", localDir)
+	require.NoError(t, err)
+	content, err := os.ReadFile(localPath)
+	require.NoError(t, err)
+	assert.Equal(t, "model", string(content))
+	_, err = DownloadBlobToLocalFileIfNotExist(ctx, store, "voice-model", "2.npz", localDir)
+	assert.Error(t, err)
+	assert.NoFileExists(t, filepath.Join(localDir, "2.npz"))
+
+	// Deleting a blob twice is fine.
+	require.NoError(t, store.Delete(ctx, "voice-model", "1.npz"))
+	require.NoError(t, store.Delete(ctx, "voice-model", "1.npz"))
+	assert.Error(t, store.Download(ctx, "voice-model", "1.npz", &buf))
+}
+
+// blockingBlobStore counts the downloads, and holds each one until released.
+type blockingBlobStore struct {
+	BlobStore
+	downloads atomic.Int32
+	release   chan struct{}
+}
+
+func (store *blockingBlobStore) Download(ctx context.Context, container, name string, w io.Writer) error {
+	store.downloads.Add(1)
+	<-store.release
+	return store.BlobStore.Download(ctx, container, name, w)
+}
+
+func TestDownloadBlobToLocalFileConcurrently(t *testing.T) {
+	ctx := context.Background()
+	localStore, err := NewLocalBlobStore(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, localStore.Upload(ctx, "voice-model", "1.npz", bytes.NewReader([]byte("model")), 5))
+	store := &blockingBlobStore{BlobStore: localStore, release: make(chan struct{})}
+	localDir := t.TempDir()
+
+	// The concurrent downloads of the same file download it once, and the file only appears once complete.
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			localPath, err := DownloadBlobToLocalFileIfNotExist(ctx, store, "voice-model", "1.npz", localDir)
+			assert.NoError(t, err)
+			content, err := os.ReadFile(localPath)
+			assert.NoError(t, err)
+			assert.Equal(t, "model", string(content))
+		}()
+	}
+	require.Eventually(t, func() bool { return store.downloads.Load() == 1 }, time.Second, time.Millisecond)
+	assert.NoFileExists(t, filepath.Join(localDir, "1.npz"))
+	close(store.release)
+	wg.Wait()
+	assert.EqualValues(t, 1, store.downloads.Load())
+
+	// A failed download leaves neither the file nor its temporary file behind.
+	_, err = DownloadBlobToLocalFileIfNotExist(ctx, store, "voice-model", "2.npz", localDir)
+	assert.Error(t, err)
+	entries, err := os.ReadDir(localDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "1.npz", entries[0].Name())
+}
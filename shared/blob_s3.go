@@ -0,0 +1,54 @@
+package shared
+
+import (
+	"context"
+	"io"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3BlobStore is a blob store backed by an S3-compatible object storage such as AWS S3 or MinIO.
+// Each container is a bucket.
+type S3BlobStore struct {
+	// Client is the S3 object storage client.
+	Client *minio.Client
+}
+
+// NewS3BlobStore returns an S3 blob store client for the endpoint ("host:port").
+func NewS3BlobStore(endpoint, region, accessKey, secretKey string, useTLS bool) (*S3BlobStore, error) {
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: useTLS,
+		Region: region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &S3BlobStore{Client: client}, nil
+}
+
+func (store *S3BlobStore) Download(ctx context.Context, container, name string, w io.Writer) error {
+	obj, err := store.Client.GetObject(ctx, container, name, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	_, err = io.Copy(w, obj)
+	return err
+}
+
+func (store *S3BlobStore) Upload(ctx context.Context, container, name string, r io.Reader, size int64) error {
+	_, err := store.Client.PutObject(ctx, container, name, r, size, minio.PutObjectOptions{})
+	return err
+}
+
+func (store *S3BlobStore) Delete(ctx context.Context, container, name string) error {
+	// Removing an object that does not exist succeeds.
+	return store.Client.RemoveObject(ctx, container, name, minio.RemoveObjectOptions{})
+}
+
+func (store *S3BlobStore) Ping(ctx context.Context) error {
+	_, err := store.Client.ListBuckets(ctx)
+	return err
+}
@@ -0,0 +1,152 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/db/dbgen"
+)
+
+const (
+	// DefaultOutboxPollInterval is the default interval between the outbox relay's checks for unsent tasks.
+	DefaultOutboxPollInterval = 1 * time.Second
+	// DefaultOutboxBatchSize is the default maximum number of tasks the outbox relay sends in a transaction.
+	DefaultOutboxBatchSize = 100
+	// DefaultOutboxRetention is the default duration the sent tasks stay in the outbox table.
+	DefaultOutboxRetention = 24 * time.Hour
+)
+
+// TaskOutbox is a transactional outbox of GPU tasks backed by the task_outbox table in the postgresql database.
+// A task is written to the outbox in the same transaction as the records it works on, and the relay sends it to the
+// task queue after the transaction commits, hence a committed record always has its task enqueued and a rolled back
+// record never does. The relay may send a task more than once, e.g. if it crashes before marking the task sent, which
+// the GPU worker tolerates by claiming the task's record.
+type TaskOutbox struct {
+	// LowLevelDB is an initialised low-level sql.DB database client.
+	LowLevelDB *sql.DB
+	// Database is the high level & strongly typed reconn DB client.
+	Database *dbgen.Queries
+	// TaskQueue is the queue the relay sends the tasks to.
+	TaskQueue TaskQueue
+	// PollInterval is the interval between the relay's checks for unsent tasks in the absence of notifications.
+	PollInterval time.Duration
+	// BatchSize is the maximum number of tasks the relay sends in a transaction.
+	BatchSize int
+	// Retention is how long the sent tasks stay in the outbox table before the relay deletes them.
+	Retention time.Duration
+
+	// notify wakes up the relay to send the newly committed tasks.
+	notify chan struct{}
+}
+
+// NewTaskOutbox returns an outbox relaying the tasks to the task queue.
+func NewTaskOutbox(lowLevelDB *sql.DB, queue TaskQueue, pollInterval time.Duration) *TaskOutbox {
+	if pollInterval <= 0 {
+		pollInterval = DefaultOutboxPollInterval
+	}
+	return &TaskOutbox{
+		LowLevelDB:   lowLevelDB,
+		Database:     dbgen.New(lowLevelDB),
+		TaskQueue:    queue,
+		PollInterval: pollInterval,
+		BatchSize:    DefaultOutboxBatchSize,
+		Retention:    DefaultOutboxRetention,
+		notify:       make(chan struct{}, 1),
+	}
+}
+
+// Add writes the task into the outbox using the queries of the caller's transaction.
+// The caller should call Notify after the transaction commits.
+func (outbox *TaskOutbox) Add(ctx context.Context, tx *dbgen.Queries, task GPUTask) error {
+	body, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.CreateTaskOutboxEntry(ctx, body); err != nil {
+		return fmt.Errorf("failed to add %v to outbox: %w", task, err)
+	}
+	return nil
+}
+
+// Notify wakes up the relay to send the newly committed tasks without waiting for the poll interval.
+func (outbox *TaskOutbox) Notify() {
+	select {
+	case outbox.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Run relays the unsent tasks to the task queue until the context is cancelled.
+func (outbox *TaskOutbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(outbox.PollInterval)
+	defer ticker.Stop()
+	lastCleanup := time.Time{}
+	for {
+		for {
+			sent, err := outbox.Relay(ctx)
+			if err != nil {
+				log.Printf("outbox relay failed: %v", err)
+			}
+			// Keep going while there may be more tasks than a batch.
+			if err != nil || sent < outbox.BatchSize {
+				break
+			}
+		}
+		if time.Since(lastCleanup) > outbox.Retention/24 {
+			if deleted, err := outbox.Database.DeleteSentTaskOutboxEntries(ctx, outbox.Retention.Seconds()); err != nil {
+				log.Printf("failed to delete sent outbox entries: %v", err)
+			} else if deleted > 0 {
+				log.Printf("deleted %d sent outbox entries", deleted)
+			}
+			lastCleanup = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-outbox.notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Relay sends a batch of unsent tasks to the task queue and marks them sent, and returns the number of tasks sent.
+// The batch stays locked until it is marked, hence concurrent relays never send the same task.
+func (outbox *TaskOutbox) Relay(ctx context.Context) (sent int, err error) {
+	tx, err := outbox.LowLevelDB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, tx.Rollback())
+		}
+	}()
+	txQueries := outbox.Database.WithTx(tx)
+	entries, err := txQueries.ListUnsentTaskOutboxEntries(ctx, int32(outbox.BatchSize))
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		if err := outbox.TaskQueue.Send(ctx, entry.Body, nil); err != nil {
+			// Keep the tasks sent so far from being sent again.
+			log.Printf("failed to send outbox entry %d: %v", entry.ID, err)
+			break
+		}
+		if err := txQueries.MarkTaskOutboxEntrySent(ctx, entry.ID); err != nil {
+			return 0, err
+		}
+		sent++
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if sent > 0 {
+		log.Printf("relayed %d tasks from outbox", sent)
+	}
+	return sent, nil
+}
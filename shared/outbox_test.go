@@ -0,0 +1,97 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/db/dbtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingTaskQueue records the IDs of the GPU tasks sent to it, and fails to send the task of failID.
+type recordingTaskQueue struct {
+	TaskQueue
+	failID string
+	sent   []string
+}
+
+func (queue *recordingTaskQueue) Send(ctx context.Context, body []byte, opts *SendOptions) error {
+	task, err := ParseGPUTask(body)
+	if err != nil {
+		return err
+	}
+	if task.ID == queue.failID {
+		return errors.New("injected failure")
+	}
+	queue.sent = append(queue.sent, task.ID)
+	return nil
+}
+
+// sentOf returns the IDs of the sent tasks among the IDs, the outbox may hold the tasks of the other tests too.
+func (queue *recordingTaskQueue) sentOf(ids ...string) (ret []string) {
+	for _, sent := range queue.sent {
+		for _, id := range ids {
+			if sent == id {
+				ret = append(ret, sent)
+			}
+		}
+	}
+	return
+}
+
+// relayAll relays the outbox until there is nothing left to send.
+func relayAll(t *testing.T, outbox *TaskOutbox) {
+	t.Helper()
+	for {
+		sent, err := outbox.Relay(context.Background())
+		require.NoError(t, err)
+		if sent == 0 {
+			return
+		}
+	}
+}
+
+func TestTaskOutboxRelay(t *testing.T) {
+	lowLevelDB, _ := dbtest.Connect(t)
+	ctx := context.Background()
+	queue := &recordingTaskQueue{TaskQueue: NewMemoryTaskQueue(t.Name())}
+	outbox := NewTaskOutbox(lowLevelDB, queue, 0)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		task, err := NewGPUTask(GPUTaskCreateVoiceModel, t.Name(), CreateVoiceModelPayload{VoiceModelID: int64(i)})
+		require.NoError(t, err)
+		require.NoError(t, outbox.Add(ctx, outbox.Database, task))
+		ids = append(ids, task.ID)
+	}
+
+	// A failed send stops the batch, and the tasks sent before it are not sent again.
+	queue.failID = ids[1]
+	relayAll(t, outbox)
+	assert.Equal(t, ids[:1], queue.sentOf(ids...))
+	relayAll(t, outbox)
+	assert.Equal(t, ids[:1], queue.sentOf(ids...))
+
+	// The rest of the tasks are sent in order once the queue recovers.
+	queue.failID = ""
+	relayAll(t, outbox)
+	assert.Equal(t, ids, queue.sentOf(ids...))
+}
+
+func TestTaskOutboxRollback(t *testing.T) {
+	lowLevelDB, database := dbtest.Connect(t)
+	ctx := context.Background()
+	queue := &recordingTaskQueue{TaskQueue: NewMemoryTaskQueue(t.Name())}
+	outbox := NewTaskOutbox(lowLevelDB, queue, 0)
+
+	// The task of a rolled back transaction is never sent.
+	tx, err := lowLevelDB.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	task, err := NewGPUTask(GPUTaskCreateVoiceModel, t.Name(), CreateVoiceModelPayload{VoiceModelID: 1})
+	require.NoError(t, err)
+	require.NoError(t, outbox.Add(ctx, database.WithTx(tx), task))
+	require.NoError(t, tx.Rollback())
+	relayAll(t, outbox)
+	assert.Empty(t, queue.sentOf(task.ID))
+}
@@ -0,0 +1,116 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// TaskQueueServiceBus is the task queue backend name of azure service bus.
+	TaskQueueServiceBus = "servicebus"
+	// TaskQueueMemory is the task queue backend name of an in-process queue, shared by the http server and GPU worker running in the same process.
+	TaskQueueMemory = "memory"
+	// TaskQueuePostgres is the task queue backend name of a job table in the postgresql database.
+	TaskQueuePostgres = "postgres"
+)
+
+// TaskQueue is a queue of GPU tasks with at-least-once delivery.
+// A received task is locked for its receiver until it is completed, abandoned, or dead-lettered.
+type TaskQueue interface {
+	// Send enqueues a task. The options may be nil.
+	Send(ctx context.Context, body []byte, opts *SendOptions) error
+	// Receive blocks until at least one task is available, and returns up to maxTasks of them.
+	Receive(ctx context.Context, maxTasks int) ([]*ReceivedTask, error)
+	// Complete removes a received task from the queue.
+	Complete(ctx context.Context, task *ReceivedTask) error
+	// Abandon releases the lock of a received task, making it available for redelivery.
+	Abandon(ctx context.Context, task *ReceivedTask) error
+	// RenewLock extends the lock of a received task that is still being processed.
+	// It returns ErrTaskLockLost if the lock has already expired and the task may be received again.
+	RenewLock(ctx context.Context, task *ReceivedTask) error
+	// DeadLetter moves a received task into the dead letter queue, where it is no longer delivered to receivers.
+	DeadLetter(ctx context.Context, task *ReceivedTask, reason, description string) error
+	// ListDeadLetters returns up to maxTasks tasks from the dead letter queue without removing them.
+	ListDeadLetters(ctx context.Context, maxTasks int) ([]*DeadLetter, error)
+	// RequeueDeadLetter moves the task of the ID from the dead letter queue back to the queue.
+	RequeueDeadLetter(ctx context.Context, id string) error
+	// Close releases the resources held by the queue client.
+	Close(ctx context.Context) error
+}
+
+// ReceivedTask is a task received from the queue and locked by the receiver.
+type ReceivedTask struct {
+	// Body is the serialised task.
+	Body []byte
+	// DeliveryCount is the number of times the task has been received, including this time.
+	DeliveryCount int
+	// handle is the backend's own representation of the received task.
+	handle any
+}
+
+// SendOptions are the optional parameters of sending a task.
+type SendOptions struct {
+	// Delay postpones the delivery of the task.
+	Delay time.Duration
+}
+
+// delay returns the delivery delay of the options, which may be nil.
+func (opts *SendOptions) delay() time.Duration {
+	if opts == nil || opts.Delay < 0 {
+		return 0
+	}
+	return opts.Delay
+}
+
+// DeadLetter is a task in the dead letter queue.
+type DeadLetter struct {
+	// ID identifies the task in the dead letter queue.
+	ID string
+	// Body is the serialised task.
+	Body []byte
+	// DeliveryCount is the number of times the task had been received before it was dead-lettered.
+	DeliveryCount int
+	// Reason is a short, machine readable reason of dead-lettering.
+	Reason string
+	// Description describes the reason in detail, e.g. the error message.
+	Description string
+	// DeadLetteredAt is the time the task entered the dead letter queue.
+	DeadLetteredAt time.Time
+}
+
+// ErrDeadLetterNotFound is returned when requeuing a task that is not in the dead letter queue.
+var ErrDeadLetterNotFound = errors.New("the task is not in the dead letter queue")
+
+// TaskQueueConfig describes the task queue backend and its connection parameters.
+type TaskQueueConfig struct {
+	// Backend is the name of the task queue backend, see TaskQueueServiceBus, TaskQueueMemory, and TaskQueuePostgres.
+	Backend string
+	// Name is the name of the queue.
+	Name string
+
+	// ServiceBusConnection is the azure sas connection string of service bus.
+	ServiceBusConnection string
+
+	// LockDuration is how long a received task stays locked for its receiver in the postgresql queue.
+	LockDuration time.Duration
+	// PollInterval is the interval between the postgresql queue's attempts to receive tasks.
+	PollInterval time.Duration
+}
+
+// NewTaskQueue returns an initialised task queue of the configured backend.
+// The postgresql backend uses the database connection for its job table.
+func NewTaskQueue(conf TaskQueueConfig, lowLevelDB *sql.DB) (TaskQueue, error) {
+	switch conf.Backend {
+	case TaskQueueServiceBus, "":
+		return NewServiceBusTaskQueue(conf.ServiceBusConnection, conf.Name)
+	case TaskQueueMemory:
+		return NewMemoryTaskQueue(conf.Name), nil
+	case TaskQueuePostgres:
+		return NewPostgresTaskQueue(lowLevelDB, conf.Name, conf.LockDuration, conf.PollInterval), nil
+	default:
+		return nil, fmt.Errorf("unknown task queue backend %q", conf.Backend)
+	}
+}
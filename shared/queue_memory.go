@@ -0,0 +1,189 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	memoryTaskQueues      = map[string]*MemoryTaskQueue{}
+	memoryTaskQueuesMutex = new(sync.Mutex)
+)
+
+// memoryTask is a task sitting in the in-process queue.
+type memoryTask struct {
+	body          []byte
+	deliveryCount int
+}
+
+// MemoryTaskQueue is an in-process task queue for running the http server and GPU worker in a single binary.
+// The tasks do not survive a restart of the process.
+type MemoryTaskQueue struct {
+	mutex *sync.Mutex
+	// pending tasks are waiting to be received in FIFO order.
+	pending []*memoryTask
+	// locked tasks have been received and are yet to be completed or abandoned.
+	locked map[*memoryTask]struct{}
+	// deadLetters are the dead-lettered tasks in the order of dead-lettering.
+	deadLetters []*DeadLetter
+	// lastDeadLetterID is the ID of the latest dead-lettered task.
+	lastDeadLetterID int
+	// notify has a buffered item when there may be pending tasks to receive.
+	notify chan struct{}
+}
+
+// NewMemoryTaskQueue returns the in-process task queue of the name.
+// All callers in the same process asking for the same name share the same queue.
+func NewMemoryTaskQueue(name string) *MemoryTaskQueue {
+	memoryTaskQueuesMutex.Lock()
+	defer memoryTaskQueuesMutex.Unlock()
+	if queue, exists := memoryTaskQueues[name]; exists {
+		return queue
+	}
+	queue := &MemoryTaskQueue{
+		mutex:  new(sync.Mutex),
+		locked: map[*memoryTask]struct{}{},
+		notify: make(chan struct{}, 1),
+	}
+	memoryTaskQueues[name] = queue
+	return queue
+}
+
+// push appends the task to the pending tasks and wakes up a receiver.
+func (queue *MemoryTaskQueue) push(task *memoryTask) {
+	queue.mutex.Lock()
+	queue.pending = append(queue.pending, task)
+	queue.mutex.Unlock()
+	select {
+	case queue.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (queue *MemoryTaskQueue) Send(ctx context.Context, body []byte, opts *SendOptions) error {
+	if delay := opts.delay(); delay > 0 {
+		time.AfterFunc(delay, func() { queue.push(&memoryTask{body: body}) })
+		return nil
+	}
+	queue.push(&memoryTask{body: body})
+	return nil
+}
+
+func (queue *MemoryTaskQueue) Receive(ctx context.Context, maxTasks int) ([]*ReceivedTask, error) {
+	for {
+		queue.mutex.Lock()
+		if len(queue.pending) > 0 {
+			count := min(maxTasks, len(queue.pending))
+			ret := make([]*ReceivedTask, 0, count)
+			for _, task := range queue.pending[:count] {
+				task.deliveryCount++
+				queue.locked[task] = struct{}{}
+				ret = append(ret, &ReceivedTask{Body: task.body, DeliveryCount: task.deliveryCount, handle: task})
+			}
+			queue.pending = queue.pending[count:]
+			remaining := len(queue.pending)
+			queue.mutex.Unlock()
+			if remaining > 0 {
+				// Let the other receivers have a go at the remaining tasks.
+				select {
+				case queue.notify <- struct{}{}:
+				default:
+				}
+			}
+			return ret, nil
+		}
+		queue.mutex.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-queue.notify:
+		}
+	}
+}
+
+// unlock removes the task from the locked tasks, and returns an error if the task was not locked.
+func (queue *MemoryTaskQueue) unlock(task *ReceivedTask) (*memoryTask, error) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	memTask := task.handle.(*memoryTask)
+	if _, exists := queue.locked[memTask]; !exists {
+		return nil, errors.New("the task is not locked by this receiver")
+	}
+	delete(queue.locked, memTask)
+	return memTask, nil
+}
+
+func (queue *MemoryTaskQueue) Complete(ctx context.Context, task *ReceivedTask) error {
+	_, err := queue.unlock(task)
+	return err
+}
+
+func (queue *MemoryTaskQueue) Abandon(ctx context.Context, task *ReceivedTask) error {
+	memTask, err := queue.unlock(task)
+	if err != nil {
+		return err
+	}
+	queue.push(memTask)
+	return nil
+}
+
+func (queue *MemoryTaskQueue) RenewLock(ctx context.Context, task *ReceivedTask) error {
+	// The in-process locks do not expire.
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	if _, exists := queue.locked[task.handle.(*memoryTask)]; !exists {
+		return ErrTaskLockLost
+	}
+	return nil
+}
+
+func (queue *MemoryTaskQueue) DeadLetter(ctx context.Context, task *ReceivedTask, reason, description string) error {
+	memTask, err := queue.unlock(task)
+	if err != nil {
+		return err
+	}
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	queue.lastDeadLetterID++
+	queue.deadLetters = append(queue.deadLetters, &DeadLetter{
+		ID:             strconv.Itoa(queue.lastDeadLetterID),
+		Body:           memTask.body,
+		DeliveryCount:  memTask.deliveryCount,
+		Reason:         reason,
+		Description:    description,
+		DeadLetteredAt: time.Now(),
+	})
+	return nil
+}
+
+func (queue *MemoryTaskQueue) ListDeadLetters(ctx context.Context, maxTasks int) ([]*DeadLetter, error) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	ret := make([]*DeadLetter, 0, min(maxTasks, len(queue.deadLetters)))
+	for _, deadLetter := range queue.deadLetters[:min(maxTasks, len(queue.deadLetters))] {
+		copied := *deadLetter
+		ret = append(ret, &copied)
+	}
+	return ret, nil
+}
+
+func (queue *MemoryTaskQueue) RequeueDeadLetter(ctx context.Context, id string) error {
+	queue.mutex.Lock()
+	for i, deadLetter := range queue.deadLetters {
+		if deadLetter.ID == id {
+			queue.deadLetters = append(queue.deadLetters[:i], queue.deadLetters[i+1:]...)
+			queue.mutex.Unlock()
+			queue.push(&memoryTask{body: RequeuedBody(deadLetter.Body)})
+			return nil
+		}
+	}
+	queue.mutex.Unlock()
+	return ErrDeadLetterNotFound
+}
+
+func (queue *MemoryTaskQueue) Close(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,104 @@
+package shared
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryTaskQueue(t *testing.T) {
+	ctx := context.Background()
+	queue := NewMemoryTaskQueue(t.Name())
+	// The queue is shared by name within the process.
+	assert.Same(t, queue, NewMemoryTaskQueue(t.Name()))
+
+	require.NoError(t, queue.Send(ctx, []byte("1"), nil))
+	require.NoError(t, queue.Send(ctx, []byte("2"), nil))
+	tasks, err := queue.Receive(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, tasks, 2)
+	assert.Equal(t, "1", string(tasks[0].Body))
+	assert.Equal(t, 1, tasks[0].DeliveryCount)
+
+	// An abandoned task is delivered again, a completed task is gone.
+	require.NoError(t, queue.RenewLock(ctx, tasks[0]))
+	require.NoError(t, queue.Complete(ctx, tasks[0]))
+	assert.ErrorIs(t, queue.RenewLock(ctx, tasks[0]), ErrTaskLockLost)
+	require.NoError(t, queue.Abandon(ctx, tasks[1]))
+	assert.Error(t, queue.Complete(ctx, tasks[0]))
+	tasks, err = queue.Receive(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	assert.Equal(t, "2", string(tasks[0].Body))
+	assert.Equal(t, 2, tasks[0].DeliveryCount)
+	require.NoError(t, queue.Complete(ctx, tasks[0]))
+
+	// Receive blocks until a task arrives or the context is done.
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = queue.Receive(timeoutCtx, 1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = queue.Send(ctx, []byte("3"), nil)
+	}()
+	tasks, err = queue.Receive(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "3", string(tasks[0].Body))
+	require.NoError(t, queue.Complete(ctx, tasks[0]))
+
+	// A delayed task is not delivered until the delay elapses.
+	require.NoError(t, queue.Send(ctx, []byte("4"), &SendOptions{Delay: 30 * time.Millisecond}))
+	start := time.Now()
+	tasks, err = queue.Receive(ctx, 1)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond)
+
+	// A dead-lettered task is no longer delivered until it is requeued.
+	require.NoError(t, queue.DeadLetter(ctx, tasks[0], "reason", "description"))
+	deadLetters, err := queue.ListDeadLetters(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, deadLetters, 1)
+	assert.Equal(t, "4", string(deadLetters[0].Body))
+	assert.Equal(t, "reason", deadLetters[0].Reason)
+	assert.Equal(t, "description", deadLetters[0].Description)
+	assert.ErrorIs(t, queue.RequeueDeadLetter(ctx, "does-not-exist"), ErrDeadLetterNotFound)
+	require.NoError(t, queue.RequeueDeadLetter(ctx, deadLetters[0].ID))
+	deadLetters, err = queue.ListDeadLetters(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, deadLetters)
+	tasks, err = queue.Receive(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "4", string(tasks[0].Body))
+}
+
+func TestMemoryTaskQueueRequeueStartsAttemptsOver(t *testing.T) {
+	ctx := context.Background()
+	queue := NewMemoryTaskQueue(t.Name())
+	task, err := NewGPUTask(GPUTaskCreateVoiceModel, "", CreateVoiceModelPayload{VoiceModelID: 1})
+	require.NoError(t, err)
+	task.Attempt = 4
+	body, err := json.Marshal(task)
+	require.NoError(t, err)
+	require.NoError(t, queue.Send(ctx, body, nil))
+	tasks, err := queue.Receive(ctx, 1)
+	require.NoError(t, err)
+	require.NoError(t, queue.DeadLetter(ctx, tasks[0], "max-attempts-exceeded", "voice service is unavailable"))
+	deadLetters, err := queue.ListDeadLetters(ctx, 10)
+	require.NoError(t, err)
+	require.NoError(t, queue.RequeueDeadLetter(ctx, deadLetters[0].ID))
+
+	tasks, err = queue.Receive(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, tasks[0].DeliveryCount)
+	requeued, err := ParseGPUTask(tasks[0].Body)
+	require.NoError(t, err)
+	assert.Zero(t, requeued.Attempt)
+	assert.True(t, requeued.Requeued)
+	assert.Equal(t, task.ID, requeued.ID)
+	assert.Equal(t, task.Payload, requeued.Payload)
+}
@@ -0,0 +1,186 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/db/dbgen"
+)
+
+const (
+	// DefaultTaskLockDuration is the default duration of a received task's lock in the postgresql queue.
+	DefaultTaskLockDuration = 5 * time.Minute
+	// DefaultTaskPollInterval is the default interval between the postgresql queue's attempts to receive tasks.
+	DefaultTaskPollInterval = 1 * time.Second
+)
+
+// ErrTaskLockLost is returned when a received task's lock has expired and the task may have been received by another receiver.
+var ErrTaskLockLost = errors.New("the task lock has expired or the task has been received again")
+
+// PostgresTaskQueue is a task queue backed by the task_queue_jobs table in the postgresql database.
+// Receivers lock jobs using "select ... for update skip locked", hence concurrent receivers never receive the same job.
+type PostgresTaskQueue struct {
+	// Database is the high level & strongly typed reconn DB client.
+	Database *dbgen.Queries
+	// Name is the name of the queue.
+	Name string
+	// LockDuration is how long a received task stays locked before it becomes available to other receivers.
+	LockDuration time.Duration
+	// PollInterval is the interval between attempts to receive tasks when the queue is empty.
+	PollInterval time.Duration
+}
+
+// NewPostgresTaskQueue returns a task queue client of the postgresql job table.
+func NewPostgresTaskQueue(lowLevelDB *sql.DB, name string, lockDuration, pollInterval time.Duration) *PostgresTaskQueue {
+	if lockDuration <= 0 {
+		lockDuration = DefaultTaskLockDuration
+	}
+	if pollInterval <= 0 {
+		pollInterval = DefaultTaskPollInterval
+	}
+	return &PostgresTaskQueue{
+		Database:     dbgen.New(lowLevelDB),
+		Name:         name,
+		LockDuration: lockDuration,
+		PollInterval: pollInterval,
+	}
+}
+
+func (queue *PostgresTaskQueue) Send(ctx context.Context, body []byte, opts *SendOptions) error {
+	_, err := queue.Database.CreateTaskQueueJob(ctx, dbgen.CreateTaskQueueJobParams{
+		Queue:        queue.Name,
+		Body:         body,
+		DelaySeconds: opts.delay().Seconds(),
+	})
+	return err
+}
+
+func (queue *PostgresTaskQueue) Receive(ctx context.Context, maxTasks int) ([]*ReceivedTask, error) {
+	ticker := time.NewTicker(queue.PollInterval)
+	defer ticker.Stop()
+	for {
+		jobs, err := queue.Database.ReceiveTaskQueueJobs(ctx, dbgen.ReceiveTaskQueueJobsParams{
+			LockSeconds: queue.LockDuration.Seconds(),
+			Queue:       queue.Name,
+			MaxJobs:     int32(maxTasks),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(jobs) > 0 {
+			ret := make([]*ReceivedTask, 0, len(jobs))
+			for _, job := range jobs {
+				job := job
+				ret = append(ret, &ReceivedTask{Body: job.Body, DeliveryCount: int(job.DeliveryCount), handle: &job})
+			}
+			return ret, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (queue *PostgresTaskQueue) Complete(ctx context.Context, task *ReceivedTask) error {
+	job := task.handle.(*dbgen.TaskQueueJob)
+	rows, err := queue.Database.DeleteTaskQueueJob(ctx, dbgen.DeleteTaskQueueJobParams{
+		ID:            job.ID,
+		DeliveryCount: job.DeliveryCount,
+	})
+	if err == nil && rows == 0 {
+		err = ErrTaskLockLost
+	}
+	return err
+}
+
+func (queue *PostgresTaskQueue) Abandon(ctx context.Context, task *ReceivedTask) error {
+	job := task.handle.(*dbgen.TaskQueueJob)
+	rows, err := queue.Database.UnlockTaskQueueJob(ctx, dbgen.UnlockTaskQueueJobParams{
+		ID:            job.ID,
+		DeliveryCount: job.DeliveryCount,
+	})
+	if err == nil && rows == 0 {
+		err = ErrTaskLockLost
+	}
+	return err
+}
+
+func (queue *PostgresTaskQueue) RenewLock(ctx context.Context, task *ReceivedTask) error {
+	job := task.handle.(*dbgen.TaskQueueJob)
+	rows, err := queue.Database.RenewTaskQueueJobLock(ctx, dbgen.RenewTaskQueueJobLockParams{
+		LockSeconds:   queue.LockDuration.Seconds(),
+		ID:            job.ID,
+		DeliveryCount: job.DeliveryCount,
+	})
+	if err == nil && rows == 0 {
+		err = ErrTaskLockLost
+	}
+	return err
+}
+
+func (queue *PostgresTaskQueue) DeadLetter(ctx context.Context, task *ReceivedTask, reason, description string) error {
+	job := task.handle.(*dbgen.TaskQueueJob)
+	rows, err := queue.Database.DeadLetterTaskQueueJob(ctx, dbgen.DeadLetterTaskQueueJobParams{
+		ID:                    job.ID,
+		DeliveryCount:         job.DeliveryCount,
+		DeadLetterReason:      sql.NullString{String: reason, Valid: true},
+		DeadLetterDescription: sql.NullString{String: description, Valid: true},
+	})
+	if err == nil && rows == 0 {
+		err = ErrTaskLockLost
+	}
+	return err
+}
+
+func (queue *PostgresTaskQueue) ListDeadLetters(ctx context.Context, maxTasks int) ([]*DeadLetter, error) {
+	jobs, err := queue.Database.ListDeadLetterTaskQueueJobs(ctx, dbgen.ListDeadLetterTaskQueueJobsParams{
+		Queue: queue.Name,
+		Limit: int32(maxTasks),
+	})
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*DeadLetter, 0, len(jobs))
+	for _, job := range jobs {
+		ret = append(ret, &DeadLetter{
+			ID:             strconv.FormatInt(job.ID, 10),
+			Body:           job.Body,
+			DeliveryCount:  int(job.DeliveryCount),
+			Reason:         job.DeadLetterReason.String,
+			Description:    job.DeadLetterDescription.String,
+			DeadLetteredAt: job.DeadLetteredAt.Time,
+		})
+	}
+	return ret, nil
+}
+
+func (queue *PostgresTaskQueue) RequeueDeadLetter(ctx context.Context, id string) error {
+	jobID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return ErrDeadLetterNotFound
+	}
+	job, err := queue.Database.GetDeadLetterTaskQueueJob(ctx, dbgen.GetDeadLetterTaskQueueJobParams{ID: jobID, Queue: queue.Name})
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrDeadLetterNotFound
+	} else if err != nil {
+		return err
+	}
+	rows, err := queue.Database.RequeueDeadLetterTaskQueueJob(ctx, dbgen.RequeueDeadLetterTaskQueueJobParams{
+		ID:    jobID,
+		Queue: queue.Name,
+		Body:  RequeuedBody(job.Body),
+	})
+	if err == nil && rows == 0 {
+		err = ErrDeadLetterNotFound
+	}
+	return err
+}
+
+func (queue *PostgresTaskQueue) Close(ctx context.Context) error {
+	return nil
+}
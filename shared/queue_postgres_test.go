@@ -0,0 +1,80 @@
+package shared
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/db/dbtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresTaskQueueSkipsLockedJobs(t *testing.T) {
+	lowLevelDB, _ := dbtest.Connect(t)
+	ctx := context.Background()
+	queue := NewPostgresTaskQueue(lowLevelDB, t.Name()+NewID(), time.Minute, 10*time.Millisecond)
+	require.NoError(t, queue.Send(ctx, []byte("first"), nil))
+	require.NoError(t, queue.Send(ctx, []byte("second"), nil))
+
+	// A receiver skips the job locked by another receiver's transaction instead of waiting for it.
+	tx, err := lowLevelDB.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `select id from task_queue_jobs where queue = $1 order by id limit 1 for update`, queue.Name)
+	require.NoError(t, err)
+	tasks, err := queue.Receive(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	assert.Equal(t, "second", string(tasks[0].Body))
+	require.NoError(t, tx.Rollback())
+
+	// The concurrent receivers never receive the same job.
+	require.NoError(t, queue.Send(ctx, []byte("third"), nil))
+	received := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			tasks, err := queue.Receive(ctx, 1)
+			if assert.NoError(t, err) && assert.Len(t, tasks, 1) {
+				received <- string(tasks[0].Body)
+			}
+		}()
+	}
+	assert.ElementsMatch(t, []string{"first", "third"}, []string{<-received, <-received})
+}
+
+func TestPostgresTaskQueueLease(t *testing.T) {
+	lowLevelDB, _ := dbtest.Connect(t)
+	ctx := context.Background()
+	queue := NewPostgresTaskQueue(lowLevelDB, t.Name()+NewID(), time.Second, 10*time.Millisecond)
+	require.NoError(t, queue.Send(ctx, []byte("task"), nil))
+	tasks, err := queue.Receive(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	first := tasks[0]
+	assert.Equal(t, 1, first.DeliveryCount)
+
+	// The received job stays invisible while its lock lasts.
+	shortCtx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancel()
+	tasks, err = queue.Receive(shortCtx, 1)
+	assert.Error(t, err)
+	assert.Empty(t, tasks)
+
+	// The job is delivered again once its lock expires, and the earlier delivery no longer holds it.
+	tasks, err = queue.Receive(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	second := tasks[0]
+	assert.Equal(t, "task", string(second.Body))
+	assert.Equal(t, 2, second.DeliveryCount)
+	assert.ErrorIs(t, queue.RenewLock(ctx, first), ErrTaskLockLost)
+	assert.ErrorIs(t, queue.Complete(ctx, first), ErrTaskLockLost)
+	assert.ErrorIs(t, queue.Abandon(ctx, first), ErrTaskLockLost)
+	assert.ErrorIs(t, queue.DeadLetter(ctx, first, "reason", "description"), ErrTaskLockLost)
+
+	// The latest delivery renews and completes the job.
+	require.NoError(t, queue.RenewLock(ctx, second))
+	require.NoError(t, queue.Complete(ctx, second))
+	assert.ErrorIs(t, queue.Complete(ctx, second), ErrTaskLockLost)
+}
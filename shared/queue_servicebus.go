@@ -0,0 +1,168 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+var applicationJSON *string = &([]string{"application/json"})[0]
+
+// ServiceBusTaskQueue is a task queue backed by an azure service bus queue.
+type ServiceBusTaskQueue struct {
+	// Client is the azure service bus client.
+	Client *azservicebus.Client
+	// Sender is the azure service bus sender client.
+	Sender *azservicebus.Sender
+	// Receiver is the azure service bus receiver client.
+	Receiver *azservicebus.Receiver
+	// DeadLetterReceiver is the azure service bus receiver client of the dead letter sub-queue.
+	DeadLetterReceiver *azservicebus.Receiver
+}
+
+// NewServiceBusTaskQueue returns a task queue client of the azure service bus queue.
+func NewServiceBusTaskQueue(connString, queueName string) (*ServiceBusTaskQueue, error) {
+	client, err := azservicebus.NewClientFromConnectionString(connString, nil)
+	if err != nil {
+		return nil, err
+	}
+	// The sender and receiver do not establish their links until they are used.
+	sender, err := client.NewSender(queueName, nil)
+	if err != nil {
+		return nil, err
+	}
+	receiver, err := client.NewReceiverForQueue(queueName, nil)
+	if err != nil {
+		return nil, err
+	}
+	deadLetterReceiver, err := client.NewReceiverForQueue(queueName, &azservicebus.ReceiverOptions{SubQueue: azservicebus.SubQueueDeadLetter})
+	if err != nil {
+		return nil, err
+	}
+	return &ServiceBusTaskQueue{Client: client, Sender: sender, Receiver: receiver, DeadLetterReceiver: deadLetterReceiver}, nil
+}
+
+func (queue *ServiceBusTaskQueue) Send(ctx context.Context, body []byte, opts *SendOptions) error {
+	msg := &azservicebus.Message{
+		Body:        body,
+		ContentType: applicationJSON,
+	}
+	if delay := opts.delay(); delay > 0 {
+		enqueueTime := time.Now().Add(delay)
+		msg.ScheduledEnqueueTime = &enqueueTime
+	}
+	return queue.Sender.SendMessage(ctx, msg, nil)
+}
+
+func (queue *ServiceBusTaskQueue) Receive(ctx context.Context, maxTasks int) ([]*ReceivedTask, error) {
+	messages, err := queue.Receiver.ReceiveMessages(ctx, maxTasks, nil)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*ReceivedTask, 0, len(messages))
+	for _, msg := range messages {
+		ret = append(ret, &ReceivedTask{Body: msg.Body, DeliveryCount: int(msg.DeliveryCount), handle: msg})
+	}
+	return ret, nil
+}
+
+func (queue *ServiceBusTaskQueue) Complete(ctx context.Context, task *ReceivedTask) error {
+	return queue.Receiver.CompleteMessage(ctx, task.handle.(*azservicebus.ReceivedMessage), nil)
+}
+
+func (queue *ServiceBusTaskQueue) Abandon(ctx context.Context, task *ReceivedTask) error {
+	return queue.Receiver.AbandonMessage(ctx, task.handle.(*azservicebus.ReceivedMessage), nil)
+}
+
+func (queue *ServiceBusTaskQueue) RenewLock(ctx context.Context, task *ReceivedTask) error {
+	err := queue.Receiver.RenewMessageLock(ctx, task.handle.(*azservicebus.ReceivedMessage), nil)
+	var sbErr *azservicebus.Error
+	if errors.As(err, &sbErr) && sbErr.Code == azservicebus.CodeLockLost {
+		return ErrTaskLockLost
+	}
+	return err
+}
+
+func (queue *ServiceBusTaskQueue) DeadLetter(ctx context.Context, task *ReceivedTask, reason, description string) error {
+	return queue.Receiver.DeadLetterMessage(ctx, task.handle.(*azservicebus.ReceivedMessage), &azservicebus.DeadLetterOptions{
+		Reason:           &reason,
+		ErrorDescription: &description,
+	})
+}
+
+func (queue *ServiceBusTaskQueue) ListDeadLetters(ctx context.Context, maxTasks int) ([]*DeadLetter, error) {
+	messages, err := queue.DeadLetterReceiver.PeekMessages(ctx, maxTasks, nil)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*DeadLetter, 0, len(messages))
+	for _, msg := range messages {
+		deadLetter := &DeadLetter{
+			Body:          msg.Body,
+			DeliveryCount: int(msg.DeliveryCount),
+		}
+		if msg.SequenceNumber != nil {
+			deadLetter.ID = strconv.FormatInt(*msg.SequenceNumber, 10)
+		}
+		if msg.DeadLetterReason != nil {
+			deadLetter.Reason = *msg.DeadLetterReason
+		}
+		if msg.DeadLetterErrorDescription != nil {
+			deadLetter.Description = *msg.DeadLetterErrorDescription
+		}
+		if msg.EnqueuedTime != nil {
+			deadLetter.DeadLetteredAt = *msg.EnqueuedTime
+		}
+		ret = append(ret, deadLetter)
+	}
+	return ret, nil
+}
+
+// deadLetterRequeueTimeout is the maximum duration of searching the dead letter queue for the task to requeue.
+const deadLetterRequeueTimeout = 30 * time.Second
+
+func (queue *ServiceBusTaskQueue) RequeueDeadLetter(ctx context.Context, id string) error {
+	sequenceNumber, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return ErrDeadLetterNotFound
+	}
+	// Service bus can only settle a dead-lettered message after receiving it, hence search for the message.
+	searchCtx, cancel := context.WithTimeout(ctx, deadLetterRequeueTimeout)
+	defer cancel()
+	var unrelated []*azservicebus.ReceivedMessage
+	defer func() {
+		for _, msg := range unrelated {
+			_ = queue.DeadLetterReceiver.AbandonMessage(ctx, msg, nil)
+		}
+	}()
+	for {
+		messages, err := queue.DeadLetterReceiver.ReceiveMessages(searchCtx, 10, nil)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return ErrDeadLetterNotFound
+		} else if err != nil {
+			return err
+		}
+		var found *azservicebus.ReceivedMessage
+		for _, msg := range messages {
+			if found == nil && msg.SequenceNumber != nil && *msg.SequenceNumber == sequenceNumber {
+				found = msg
+			} else {
+				unrelated = append(unrelated, msg)
+			}
+		}
+		if found != nil {
+			if err := queue.Send(ctx, RequeuedBody(found.Body), nil); err != nil {
+				unrelated = append(unrelated, found)
+				return err
+			}
+			return queue.DeadLetterReceiver.CompleteMessage(ctx, found, nil)
+		}
+	}
+}
+
+func (queue *ServiceBusTaskQueue) Close(ctx context.Context) error {
+	return errors.Join(queue.Sender.Close(ctx), queue.Receiver.Close(ctx), queue.DeadLetterReceiver.Close(ctx), queue.Client.Close(ctx))
+}
@@ -0,0 +1,64 @@
+package shared
+
+import (
+	"strings"
+	"unicode"
+)
+
+// sentenceAbbreviations are the abbreviations whose full stop does not end a sentence, in lower case and without the
+// trailing full stop.
+var sentenceAbbreviations = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true, "st": true, "jr": true, "sr": true,
+	"vs": true, "etc": true, "e.g": true, "i.e": true, "no": true, "approx": true,
+}
+
+// SplitSentences splits the text into sentences, each with its punctuation and without the surrounding white spaces.
+// A sentence ends with a full stop, question mark, exclamation mark, or ellipsis followed by a white space, unless the
+// full stop belongs to a common abbreviation or an initial, or the next word starts in lower case.
+func SplitSentences(text string) []string {
+	var sentences []string
+	runes := []rune(text)
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		if !strings.ContainsRune(".!?…", runes[i]) {
+			continue
+		}
+		// The sentence carries on to the consecutive punctuation marks and closing quotes.
+		end := i + 1
+		for end < len(runes) && strings.ContainsRune(".!?…\"'”’)]", runes[end]) {
+			end++
+		}
+		if end < len(runes) && !unicode.IsSpace(runes[end]) {
+			i = end - 1
+			continue
+		}
+		next := end
+		for next < len(runes) && unicode.IsSpace(runes[next]) {
+			next++
+		}
+		if next < len(runes) && (unicode.IsLower(runes[next]) || (runes[i] == '.' && end == i+1 && isAbbreviation(runes[start:i]))) {
+			i = end - 1
+			continue
+		}
+		if sentence := strings.TrimSpace(string(runes[start:end])); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+		start = end
+		i = end - 1
+	}
+	if sentence := strings.TrimSpace(string(runes[start:])); sentence != "" {
+		sentences = append(sentences, sentence)
+	}
+	return sentences
+}
+
+// isAbbreviation returns true if the last word of the text, which is followed by a full stop, is an abbreviation or an
+// initial.
+func isAbbreviation(text []rune) bool {
+	fields := strings.Fields(string(text))
+	if len(fields) == 0 {
+		return false
+	}
+	word := strings.TrimLeft(fields[len(fields)-1], "\"'“‘([")
+	return len([]rune(word)) == 1 && unicode.IsUpper([]rune(word)[0]) || sentenceAbbreviations[strings.ToLower(word)]
+}
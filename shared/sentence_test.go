@@ -0,0 +1,23 @@
+package shared
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitSentences(t *testing.T) {
+	for text, sentences := range map[string][]string{
+		"":               nil,
+		"  ":             nil,
+		"Hello":          {"Hello"},
+		"Hello there.  ": {"Hello there."},
+		"Nice to hear from you! How are you? I am well.": {"Nice to hear from you!", "How are you?", "I am well."},
+		"Wait... what?! \"Really.\" Yes.":                {"Wait... what?!", "\"Really.\"", "Yes."},
+		"Mr. Smith met Dr. J. Watson at 3 p.m. today.":   {"Mr. Smith met Dr. J. Watson at 3 p.m. today."},
+		"It costs 3.50 dollars, e.g. a coffee. Cheap.":   {"It costs 3.50 dollars, e.g. a coffee.", "Cheap."},
+		"First line.\nSecond line…\tThird":               {"First line.", "Second line…", "Third"},
+	} {
+		assert.Equal(t, sentences, SplitSentences(text), text)
+	}
+}
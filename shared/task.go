@@ -0,0 +1,179 @@
+package shared
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// GPUTaskVersion is the current version of the GPU task envelope.
+const GPUTaskVersion = 1
+
+// GPUTaskType discriminates the kinds of GPU tasks and their payloads.
+type GPUTaskType string
+
+const (
+	// GPUTaskCreateVoiceModel asks the GPU worker to clone a voice model from a voice sample. The payload is CreateVoiceModelPayload.
+	GPUTaskCreateVoiceModel GPUTaskType = "create-voice-model"
+	// GPUTaskConvertReplyToSpeech asks the GPU worker to convert an AI person's reply into speech. The payload is ConvertReplyToSpeechPayload.
+	GPUTaskConvertReplyToSpeech GPUTaskType = "convert-reply-to-speech"
+	// GPUTaskWarmUpVoiceModel asks a GPU worker to load a voice model ahead of its first TTS. The payload is WarmUpVoiceModelPayload.
+	GPUTaskWarmUpVoiceModel GPUTaskType = "warm-up-voice-model"
+)
+
+// GPUTaskPriority is the lane a GPU task waits in for a free slot of the GPU worker.
+type GPUTaskPriority string
+
+const (
+	// GPUTaskPriorityInteractive is the lane of the tasks a user is waiting for, e.g. a reply in a conversation.
+	GPUTaskPriorityInteractive GPUTaskPriority = "interactive"
+	// GPUTaskPriorityBulk is the lane of the long-running tasks nobody is waiting for in real time, e.g. voice cloning.
+	GPUTaskPriorityBulk GPUTaskPriority = "bulk"
+)
+
+// DefaultPriority returns the priority of the type of task, which NewGPUTask assigns to new tasks.
+func (taskType GPUTaskType) DefaultPriority() GPUTaskPriority {
+	if taskType == GPUTaskConvertReplyToSpeech {
+		return GPUTaskPriorityInteractive
+	}
+	return GPUTaskPriorityBulk
+}
+
+// GPUTask is the versioned envelope of a task intended for the GPU-enabled workers.
+type GPUTask struct {
+	// Version is the version of the envelope structure, see GPUTaskVersion.
+	Version int `json:"version"`
+	// Type is the kind of task, it determines the structure of the payload.
+	Type GPUTaskType `json:"type"`
+	// ID uniquely identifies the task.
+	ID string `json:"id"`
+	// CreatedAt is the time the task was first created.
+	CreatedAt time.Time `json:"createdAt"`
+	// Attempt is the number of times the task has been attempted before.
+	Attempt int `json:"attempt"`
+	// TraceID correlates the task with the request that caused it.
+	TraceID string `json:"traceId"`
+	// Requeued is true for a task moved back from the dead letters, its record may be processed again after having failed.
+	Requeued bool `json:"requeued,omitempty"`
+	// Priority is the lane of the task in the GPU worker, the default priority of the type applies if empty.
+	Priority GPUTaskPriority `json:"priority,omitempty"`
+	// Payload is the task parameters specific to the type of task.
+	Payload json.RawMessage `json:"payload"`
+}
+
+// CreateVoiceModelPayload is the payload of a GPUTaskCreateVoiceModel task.
+type CreateVoiceModelPayload struct {
+	// VoiceModelID is the voice model ID record in database for the GPU worker to create a voice model.
+	VoiceModelID int64 `json:"voiceModelId"`
+}
+
+// ConvertReplyToSpeechPayload is the payload of a GPUTaskConvertReplyToSpeech task.
+type ConvertReplyToSpeechPayload struct {
+	// AIPersonID is the AI person ID in database for the GPU worker to perform TTS.
+	AIPersonID int64 `json:"aiPersonId"`
+	// AIReplyVoiceID is the AI person reply voice ID in database for the GPU worker to perform TTS.
+	AIReplyVoiceID int64 `json:"aiReplyVoiceId"`
+}
+
+// WarmUpVoiceModelPayload is the payload of a GPUTaskWarmUpVoiceModel task.
+type WarmUpVoiceModelPayload struct {
+	// AIPersonID is the AI person ID in database the voice model belongs to.
+	AIPersonID int64 `json:"aiPersonId"`
+	// ModelFileName is the file name of the voice model in blob storage.
+	ModelFileName string `json:"modelFileName"`
+	// WorkerID is the GPU worker that shall warm up the voice model.
+	WorkerID string `json:"workerId"`
+}
+
+// NewID returns a random, unique identifier for tasks and traces.
+func NewID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		panic(fmt.Errorf("failed to read random bytes: %w", err))
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// NewGPUTask returns a new GPU task of the type carrying the payload.
+// A new trace ID is generated if the trace ID is empty.
+func NewGPUTask(taskType GPUTaskType, traceID string, payload any) (GPUTask, error) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return GPUTask{}, err
+	}
+	if traceID == "" {
+		traceID = NewID()
+	}
+	return GPUTask{
+		Version:   GPUTaskVersion,
+		Type:      taskType,
+		ID:        NewID(),
+		CreatedAt: time.Now(),
+		TraceID:   traceID,
+		Priority:  taskType.DefaultPriority(),
+		Payload:   payloadJSON,
+	}, nil
+}
+
+// ParseGPUTask deserialises and validates the envelope of a GPU task.
+func ParseGPUTask(body []byte) (GPUTask, error) {
+	var task GPUTask
+	if err := json.Unmarshal(body, &task); err != nil {
+		return task, fmt.Errorf("failed to unmarshal gpu task: %w", err)
+	}
+	if task.Version != GPUTaskVersion {
+		return task, fmt.Errorf("unsupported gpu task version %d", task.Version)
+	}
+	if task.Type == "" || task.ID == "" {
+		return task, errors.New("gpu task must have a type and an ID")
+	}
+	if len(task.Payload) == 0 {
+		return task, errors.New("gpu task must have a payload")
+	}
+	return task, nil
+}
+
+// Lane returns the priority of the task, or the default priority of its type if the task does not have one,
+// e.g. a task enqueued before the priorities were introduced.
+func (task GPUTask) Lane() GPUTaskPriority {
+	if task.Priority == "" {
+		return task.Type.DefaultPriority()
+	}
+	return task.Priority
+}
+
+// DecodePayload deserialises the task payload into the structure corresponding to its type.
+func (task GPUTask) DecodePayload(payload any) error {
+	decoder := json.NewDecoder(bytes.NewReader(task.Payload))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(payload); err != nil {
+		return fmt.Errorf("failed to decode %q task payload: %w", task.Type, err)
+	}
+	return nil
+}
+
+// RequeuedBody returns the body of a dead-lettered task moved back to the queue, with its attempts starting over so that
+// it may be retried again, and the requeued marker allowing it to claim its failed record. A body that is not a GPU task
+// is returned as is.
+func RequeuedBody(body []byte) []byte {
+	task, err := ParseGPUTask(body)
+	if err != nil {
+		return body
+	}
+	task.Attempt = 0
+	task.Requeued = true
+	requeued, err := json.Marshal(task)
+	if err != nil {
+		return body
+	}
+	return requeued
+}
+
+// String returns a short description of the task for logging.
+func (task GPUTask) String() string {
+	return fmt.Sprintf("%s task %s (trace %s, attempt %d)", task.Type, task.ID, task.TraceID, task.Attempt)
+}
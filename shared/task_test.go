@@ -0,0 +1,46 @@
+package shared
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGPUTaskEnvelope(t *testing.T) {
+	task, err := NewGPUTask(GPUTaskCreateVoiceModel, "", CreateVoiceModelPayload{VoiceModelID: 12})
+	require.NoError(t, err)
+	assert.NotEmpty(t, task.ID)
+	assert.NotEmpty(t, task.TraceID)
+	body, err := json.Marshal(task)
+	require.NoError(t, err)
+
+	parsed, err := ParseGPUTask(body)
+	require.NoError(t, err)
+	assert.Equal(t, GPUTaskCreateVoiceModel, parsed.Type)
+	assert.Equal(t, task.ID, parsed.ID)
+	assert.Equal(t, GPUTaskPriorityBulk, parsed.Lane())
+	var payload CreateVoiceModelPayload
+	require.NoError(t, parsed.DecodePayload(&payload))
+	assert.Equal(t, int64(12), payload.VoiceModelID)
+	// The payload of a different task type does not decode.
+	assert.Error(t, parsed.DecodePayload(&ConvertReplyToSpeechPayload{}))
+
+	// A task without a priority takes the default priority of its type.
+	parsed, err = ParseGPUTask([]byte(`{"version": 1, "type": "convert-reply-to-speech", "id": "1", "payload": {}}`))
+	require.NoError(t, err)
+	assert.Equal(t, GPUTaskPriorityInteractive, parsed.Lane())
+
+	for _, malformed := range []string{
+		``,
+		`{}`,
+		`{"VoiceModelID": 12}`,
+		`{"version": 2, "type": "create-voice-model", "id": "1", "payload": {}}`,
+		`{"version": 1, "type": "create-voice-model", "payload": {}}`,
+		`{"version": 1, "type": "create-voice-model", "id": "1"}`,
+	} {
+		_, err := ParseGPUTask([]byte(malformed))
+		assert.Error(t, err, malformed)
+	}
+}
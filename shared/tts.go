@@ -0,0 +1,121 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/db/dbgen"
+	"github.com/HouzuoGuo/reconn-voice-clone/expressive"
+)
+
+const (
+	// TTSPresetDefault is the preset of the AI persons and voice models without TTS settings of their own.
+	TTSPresetDefault = "default"
+	// TTSPresetStable makes the speech steadier and more predictable, at the expense of intonation.
+	TTSPresetStable = "stable"
+	// TTSPresetExpressive makes the speech livelier, at the expense of occasional artifacts.
+	TTSPresetExpressive = "expressive"
+)
+
+const (
+	// TTSSourceDefault means the TTS settings come from the default preset.
+	TTSSourceDefault = "default"
+	// TTSSourceAIPerson means the TTS settings are the AI person's.
+	TTSSourceAIPerson = "ai-person"
+	// TTSSourceVoiceModel means the TTS settings are the voice model's, overriding the AI person's.
+	TTSSourceVoiceModel = "voice-model"
+)
+
+// TTSSettings are the sampling parameters of Bark text-to-speech.
+type TTSSettings struct {
+	TopK         float64 `json:"topK"`
+	TopP         float64 `json:"topP"`
+	MineosP      float64 `json:"mineosP"`
+	SemanticTemp float64 `json:"semanticTemp"`
+	WaveformTemp float64 `json:"waveformTemp"`
+	FineTemp     float64 `json:"fineTemp"`
+}
+
+// TTSPresets are the named TTS settings.
+var TTSPresets = map[string]TTSSettings{
+	TTSPresetDefault:    {TopK: 99, TopP: 0.8, MineosP: 0.01, SemanticTemp: 0.8, WaveformTemp: 0.6, FineTemp: 0.5},
+	TTSPresetStable:     {TopK: 50, TopP: 0.7, MineosP: 0.05, SemanticTemp: 0.6, WaveformTemp: 0.5, FineTemp: 0.4},
+	TTSPresetExpressive: {TopK: 150, TopP: 0.95, MineosP: 0.01, SemanticTemp: 1.0, WaveformTemp: 0.8, FineTemp: 0.6},
+}
+
+// TTSPresetNames returns the names of the TTS presets in alphabetical order.
+func TTSPresetNames() []string {
+	ret := make([]string, 0, len(TTSPresets))
+	for name := range TTSPresets {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Validate returns an error if any of the parameters is out of its range.
+func (settings TTSSettings) Validate() error {
+	if settings.TopK < 1 || settings.TopK > 1000 || settings.TopK != math.Trunc(settings.TopK) {
+		return fmt.Errorf("topK must be a whole number between 1 and 1000, got %v", settings.TopK)
+	}
+	if settings.TopP <= 0 || settings.TopP > 1 {
+		return fmt.Errorf("topP must be greater than 0 and at most 1, got %v", settings.TopP)
+	}
+	if settings.MineosP < 0 || settings.MineosP > 1 {
+		return fmt.Errorf("mineosP must be between 0 and 1, got %v", settings.MineosP)
+	}
+	for _, temp := range []struct {
+		name  string
+		value float64
+	}{{"semanticTemp", settings.SemanticTemp}, {"waveformTemp", settings.WaveformTemp}, {"fineTemp", settings.FineTemp}} {
+		if temp.value <= 0 || temp.value > 2 {
+			return fmt.Errorf("%s must be greater than 0 and at most 2, got %v", temp.name, temp.value)
+		}
+	}
+	return nil
+}
+
+// ResolvedTTSSettings are the TTS settings of a voice model, along with where they come from.
+type ResolvedTTSSettings struct {
+	TTSSettings
+	// Preset is the named preset the settings started from.
+	Preset string `json:"preset"`
+	// Source is TTSSourceVoiceModel, TTSSourceAIPerson, or TTSSourceDefault.
+	Source string `json:"source"`
+}
+
+// ResolveTTSSettings returns the TTS settings of the AI person's voice model: the voice model's settings if it has any,
+// otherwise the AI person's, otherwise the default preset.
+func ResolveTTSSettings(ctx context.Context, database *dbgen.Queries, aiPersonID, voiceModelID int64) (ResolvedTTSSettings, error) {
+	row, err := database.GetTTSSettings(ctx, dbgen.GetTTSSettingsParams{AiPersonID: aiPersonID, VoiceModelID: voiceModelID})
+	if errors.Is(err, sql.ErrNoRows) {
+		return ResolvedTTSSettings{TTSSettings: TTSPresets[TTSPresetDefault], Preset: TTSPresetDefault, Source: TTSSourceDefault}, nil
+	} else if err != nil {
+		return ResolvedTTSSettings{}, fmt.Errorf("get tts settings error: %w", err)
+	}
+	return ResolvedTTSSettings{
+		TTSSettings: TTSSettings{
+			TopK:         row.TopK,
+			TopP:         row.TopP,
+			MineosP:      row.MineosP,
+			SemanticTemp: row.SemanticTemp,
+			WaveformTemp: row.WaveformTemp,
+			FineTemp:     row.FineTemp,
+		},
+		Preset: row.Preset,
+		Source: row.Source,
+	}, nil
+}
+
+// ReplySpeech returns the text of the AI person's reply to be spoken, with the expressive cues of the reply, if any,
+// translated into Bark's tokens.
+func ReplySpeech(reply dbgen.AiPersonReply) string {
+	if reply.SpeechMarkup.Valid {
+		return expressive.ToBark(reply.SpeechMarkup.String)
+	}
+	return reply.Message
+}
@@ -0,0 +1,234 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/db/dbgen"
+)
+
+const (
+	// DefaultTTSCacheContainer is the default blob container name of the cached speech.
+	DefaultTTSCacheContainer = "tts-cache"
+	// DefaultTTSCacheVoiceServiceVersion is the default version of the voice service that synthesized the cached speech.
+	DefaultTTSCacheVoiceServiceVersion = "1"
+	// DefaultTTSCacheMaxBytes is the default total size of the cached speech beyond which the least recently used
+	// entries are evicted.
+	DefaultTTSCacheMaxBytes = 10 << 30
+	// DefaultTTSCacheMaxAge is the default duration an unused entry stays in the cache.
+	DefaultTTSCacheMaxAge = 30 * 24 * time.Hour
+	// DefaultTTSCacheEvictInterval is the default interval between the evictions of the cache entries.
+	DefaultTTSCacheEvictInterval = 10 * time.Minute
+)
+
+// ttsCacheStats counts the cache "hits", "misses", "stores", "evictions", and "errors".
+// The counters are published along with the other expvar variables.
+var ttsCacheStats = expvar.NewMap("tts_cache")
+
+// TTSCacheConfig has the settings of the TTS cache.
+type TTSCacheConfig struct {
+	// Container is the blob container name of the cached speech.
+	Container string
+	// VoiceServiceVersion is part of the cache key, changing it after upgrading the voice service or its models stops
+	// the speech synthesized by the previous version from being served.
+	VoiceServiceVersion string
+	// MaxBytes is the total size of the cached speech beyond which the least recently used entries are evicted,
+	// a negative size disables the cache.
+	MaxBytes int64
+	// MaxAge is how long an unused entry stays in the cache.
+	MaxAge time.Duration
+	// EvictInterval is the interval between the evictions of the cache entries.
+	EvictInterval time.Duration
+}
+
+// TTSCache is a content-addressed cache of the speech synthesized by the voice service, stored in blob storage and
+// looked up in the tts_cache_entries table. The same text spoken by the same voice model with the same TTS settings
+// is synthesized only once.
+type TTSCache struct {
+	Config TTSCacheConfig
+	// Database is the high level & strongly typed reconn DB client.
+	Database *dbgen.Queries
+	// BlobStore stores the cached speech.
+	BlobStore BlobStore
+}
+
+// NewTTSCache returns a TTS cache stored in the database and blob storage.
+func NewTTSCache(database *dbgen.Queries, store BlobStore, conf TTSCacheConfig) *TTSCache {
+	if conf.Container == "" {
+		conf.Container = DefaultTTSCacheContainer
+	}
+	if conf.VoiceServiceVersion == "" {
+		conf.VoiceServiceVersion = DefaultTTSCacheVoiceServiceVersion
+	}
+	if conf.MaxBytes == 0 {
+		conf.MaxBytes = DefaultTTSCacheMaxBytes
+	}
+	if conf.MaxAge <= 0 {
+		conf.MaxAge = DefaultTTSCacheMaxAge
+	}
+	if conf.EvictInterval <= 0 {
+		conf.EvictInterval = DefaultTTSCacheEvictInterval
+	}
+	return &TTSCache{Config: conf, Database: database, BlobStore: store}
+}
+
+// NormalizeTTSCacheText returns the text with its leading, trailing, and repeated white spaces removed, which do not
+// change the speech.
+func NormalizeTTSCacheText(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// Key returns the cache key of the speech of the TTS request spoken by the voice model of the content.
+// The key depends on the content of the voice model rather than its file name, as a voice model file may be replaced
+// by cloning its voice sample again.
+func (cache *TTSCache) Key(model []byte, ttsRequest TextToSpeechRealTimeRequest) string {
+	modelSum := sha256.Sum256(model)
+	keyContent, _ := json.Marshal(struct {
+		Model    string      `json:"model"`
+		Text     string      `json:"text"`
+		Settings TTSSettings `json:"settings"`
+		Version  string      `json:"version"`
+	}{hex.EncodeToString(modelSum[:]), NormalizeTTSCacheText(ttsRequest.Text), ttsRequest.TTSSettings, cache.Config.VoiceServiceVersion})
+	sum := sha256.Sum256(keyContent)
+	return hex.EncodeToString(sum[:])
+}
+
+// Get returns the cached speech of the key. A broken entry is removed and reported as a miss.
+func (cache *TTSCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	if _, err := cache.Database.TouchTTSCacheEntry(ctx, key); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			ttsCacheStats.Add("errors", 1)
+			log.Printf("failed to look up tts cache entry %s: %v", key, err)
+		}
+		return nil, false
+	}
+	var buf bytes.Buffer
+	if err := cache.BlobStore.Download(ctx, cache.Config.Container, key+".wav", &buf); err != nil || buf.Len() == 0 {
+		ttsCacheStats.Add("errors", 1)
+		log.Printf("failed to download tts cache entry %s: %v", key, err)
+		if err := cache.Database.DeleteTTSCacheEntry(ctx, key); err != nil {
+			log.Printf("failed to delete broken tts cache entry %s: %v", key, err)
+		}
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// Put stores the speech synthesized using the voice model under the key.
+func (cache *TTSCache) Put(ctx context.Context, key, modelFileName string, wavContent []byte) error {
+	// Upload the blob first so that an entry is never without its blob, save for a concurrent eviction.
+	if err := cache.BlobStore.Upload(ctx, cache.Config.Container, key+".wav", bytes.NewReader(wavContent), int64(len(wavContent))); err != nil {
+		return fmt.Errorf("upload tts cache blob error: %w", err)
+	}
+	if err := cache.Database.CreateTTSCacheEntry(ctx, dbgen.CreateTTSCacheEntryParams{
+		CacheKey:      key,
+		ModelFileName: modelFileName,
+		SizeBytes:     int64(len(wavContent)),
+	}); err != nil {
+		return fmt.Errorf("create tts cache entry error: %w", err)
+	}
+	return nil
+}
+
+// Lookup returns the cache key of the TTS request spoken by the voice model in the local file, along with the cached
+// speech if there is any. The key is empty if the cache is disabled.
+func (cache *TTSCache) Lookup(ctx context.Context, modelPath string, ttsRequest TextToSpeechRealTimeRequest) (key string, wavContent []byte, hit bool, err error) {
+	if cache == nil || cache.Config.MaxBytes < 0 {
+		return "", nil, false, nil
+	}
+	model, err := os.ReadFile(modelPath)
+	if err != nil {
+		return "", nil, false, fmt.Errorf("read voice model error: %w", err)
+	}
+	key = cache.Key(model, ttsRequest)
+	if wavContent, hit = cache.Get(ctx, key); hit {
+		ttsCacheStats.Add("hits", 1)
+		log.Printf("tts cache hit of model %q and key %s", filepath.Base(modelPath), key)
+	} else {
+		ttsCacheStats.Add("misses", 1)
+	}
+	return key, wavContent, hit, nil
+}
+
+// Store caches the speech of the key returned by Lookup, a failure is logged rather than failing the caller.
+func (cache *TTSCache) Store(ctx context.Context, key, modelPath string, wavContent []byte) {
+	if key == "" {
+		return
+	}
+	if err := cache.Put(ctx, key, filepath.Base(modelPath), wavContent); err != nil {
+		ttsCacheStats.Add("errors", 1)
+		log.Printf("failed to cache the speech of model %q: %v", filepath.Base(modelPath), err)
+		return
+	}
+	ttsCacheStats.Add("stores", 1)
+}
+
+// Synthesize returns the cached speech of the TTS request spoken by the voice model in the local file, or calls the
+// synthesize function to convert the text into speech and caches the speech.
+// A failure of the cache itself does not fail the synthesis.
+func (cache *TTSCache) Synthesize(ctx context.Context, modelPath string, ttsRequest TextToSpeechRealTimeRequest, synthesize func() ([]byte, error)) ([]byte, error) {
+	key, wavContent, hit, err := cache.Lookup(ctx, modelPath, ttsRequest)
+	if err != nil || hit {
+		return wavContent, err
+	}
+	wavContent, err = synthesize()
+	if err != nil {
+		return nil, err
+	}
+	cache.Store(ctx, key, modelPath, wavContent)
+	return wavContent, nil
+}
+
+// Evict removes the least recently used entries beyond the size budget and the entries unused for longer than the
+// max age, and returns the number of entries removed.
+func (cache *TTSCache) Evict(ctx context.Context) (int, error) {
+	keys, err := cache.Database.EvictTTSCacheEntries(ctx, dbgen.EvictTTSCacheEntriesParams{
+		MaxBytes:      cache.Config.MaxBytes,
+		MaxAgeSeconds: cache.Config.MaxAge.Seconds(),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("evict tts cache entries error: %w", err)
+	}
+	// The entries are gone, a blob left behind by a failed deletion is overwritten if its key is cached again.
+	for _, key := range keys {
+		if err := cache.BlobStore.Delete(ctx, cache.Config.Container, key+".wav"); err != nil {
+			ttsCacheStats.Add("errors", 1)
+			log.Printf("failed to delete tts cache blob %s: %v", key, err)
+		}
+	}
+	ttsCacheStats.Add("evictions", int64(len(keys)))
+	return len(keys), nil
+}
+
+// Run evicts the cache entries periodically until the context is cancelled.
+func (cache *TTSCache) Run(ctx context.Context) {
+	if cache.Config.MaxBytes < 0 {
+		return
+	}
+	ticker := time.NewTicker(cache.Config.EvictInterval)
+	defer ticker.Stop()
+	for {
+		if evicted, err := cache.Evict(ctx); err != nil {
+			log.Printf("tts cache eviction failed: %v", err)
+		} else if evicted > 0 {
+			log.Printf("evicted %d tts cache entries", evicted)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
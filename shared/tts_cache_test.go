@@ -0,0 +1,78 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/db/dbtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTTSCacheKey(t *testing.T) {
+	cache := NewTTSCache(nil, nil, TTSCacheConfig{})
+	ttsRequest := TextToSpeechRealTimeRequest{Text: "Nice to hear from you.", TTSSettings: TTSPresets[TTSPresetDefault]}
+	key := cache.Key([]byte("model"), ttsRequest)
+	assert.Len(t, key, 64)
+	// The white spaces around and between the words do not change the speech.
+	assert.Equal(t, key, cache.Key([]byte("model"), TextToSpeechRealTimeRequest{Text: " Nice  to hear\nfrom you. ", TTSSettings: ttsRequest.TTSSettings}))
+
+	// Everything else does.
+	assert.NotEqual(t, key, cache.Key([]byte("another model"), ttsRequest))
+	assert.NotEqual(t, key, cache.Key([]byte("model"), TextToSpeechRealTimeRequest{Text: "Nice to hear from you!", TTSSettings: ttsRequest.TTSSettings}))
+	assert.NotEqual(t, key, cache.Key([]byte("model"), TextToSpeechRealTimeRequest{Text: ttsRequest.Text, TTSSettings: TTSPresets[TTSPresetStable]}))
+	upgraded := NewTTSCache(nil, nil, TTSCacheConfig{VoiceServiceVersion: "2"})
+	assert.NotEqual(t, key, upgraded.Key([]byte("model"), ttsRequest))
+}
+
+func TestTTSCache(t *testing.T) {
+	_, database := dbtest.Connect(t)
+	ctx := context.Background()
+	store, err := NewLocalBlobStore(t.TempDir())
+	require.NoError(t, err)
+	// A version of its own keeps the test apart from the entries of the other tests.
+	cache := NewTTSCache(database, store, TTSCacheConfig{VoiceServiceVersion: t.Name() + NewID()})
+	modelPath := filepath.Join(t.TempDir(), "1.npz")
+	require.NoError(t, os.WriteFile(modelPath, []byte("model"), 0644))
+
+	synthesized := 0
+	synthesize := func() ([]byte, error) {
+		synthesized++
+		return []byte("RIFF speech"), nil
+	}
+	ttsRequest := TextToSpeechRealTimeRequest{Text: "Nice to hear from you.", TTSSettings: TTSPresets[TTSPresetDefault]}
+	// The speech is synthesized once and then served from the cache.
+	for i := 0; i < 2; i++ {
+		speech, err := cache.Synthesize(ctx, modelPath, ttsRequest, synthesize)
+		require.NoError(t, err)
+		assert.Equal(t, "RIFF speech", string(speech))
+		assert.Equal(t, 1, synthesized)
+	}
+	// A failed synthesis is not cached.
+	_, err = cache.Synthesize(ctx, modelPath, TextToSpeechRealTimeRequest{Text: "Are you there?", TTSSettings: ttsRequest.TTSSettings}, func() ([]byte, error) {
+		return nil, errors.New("voice service is down")
+	})
+	assert.Error(t, err)
+	_, hit := cache.Get(ctx, cache.Key([]byte("model"), TextToSpeechRealTimeRequest{Text: "Are you there?", TTSSettings: ttsRequest.TTSSettings}))
+	assert.False(t, hit)
+
+	// An entry without its blob is a miss, and the speech is synthesized again.
+	key := cache.Key([]byte("model"), ttsRequest)
+	require.NoError(t, store.Delete(ctx, cache.Config.Container, key+".wav"))
+	_, err = cache.Synthesize(ctx, modelPath, ttsRequest, synthesize)
+	require.NoError(t, err)
+	assert.Equal(t, 2, synthesized)
+
+	// The entries beyond the size budget are evicted along with their blobs. The entries of the other tests go too,
+	// which costs them no more than a miss.
+	cache.Config.MaxBytes = 1
+	evicted, err := cache.Evict(ctx)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, evicted, 1)
+	_, hit = cache.Get(ctx, key)
+	assert.False(t, hit)
+	assert.NoFileExists(t, filepath.Join(store.RootDir, cache.Config.Container, key+".wav"))
+}
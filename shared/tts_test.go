@@ -0,0 +1,37 @@
+package shared
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTTSSettings(t *testing.T) {
+	for _, name := range TTSPresetNames() {
+		assert.NoError(t, TTSPresets[name].Validate(), name)
+	}
+	assert.Equal(t, []string{TTSPresetDefault, TTSPresetExpressive, TTSPresetStable}, TTSPresetNames())
+
+	for _, invalid := range []func(*TTSSettings){
+		func(s *TTSSettings) { s.TopK = 0 },
+		func(s *TTSSettings) { s.TopK = 1.5 },
+		func(s *TTSSettings) { s.TopK = 1001 },
+		func(s *TTSSettings) { s.TopP = 0 },
+		func(s *TTSSettings) { s.TopP = 1.1 },
+		func(s *TTSSettings) { s.MineosP = -0.1 },
+		func(s *TTSSettings) { s.SemanticTemp = 0 },
+		func(s *TTSSettings) { s.WaveformTemp = 2.5 },
+		func(s *TTSSettings) { s.FineTemp = -1 },
+	} {
+		settings := TTSPresets[TTSPresetDefault]
+		invalid(&settings)
+		assert.Error(t, settings.Validate(), "%+v", settings)
+	}
+
+	// The voice service reads the settings alongside the text.
+	body, err := json.Marshal(TextToSpeechRealTimeRequest{Text: "hi", TTSSettings: TTSPresets[TTSPresetStable]})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"text":"hi","topK":50,"topP":0.7,"mineosP":0.05,"semanticTemp":0.6,"waveformTemp":0.5,"fineTemp":0.4}`, string(body))
+}
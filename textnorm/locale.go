@@ -0,0 +1,171 @@
+package textnorm
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Currency is how a currency's amounts are spoken.
+type Currency struct {
+	// Major and MajorPlural are the names of the main unit, e.g. "dollar" and "dollars".
+	Major, MajorPlural string
+	// Minor and MinorPlural are the names of the hundredth of the main unit, e.g. "cent" and "cents".
+	Minor, MinorPlural string
+}
+
+// Unit is how a unit of measurement following a number is spoken.
+type Unit struct {
+	Singular, Plural string
+}
+
+// Locale has the words and conventions of a language and region.
+type Locale struct {
+	// Name identifies the locale, e.g. "en-US".
+	Name string
+
+	// Ones are the words of the numbers below twenty, and Tens the words of the multiples of ten below a hundred.
+	Ones [20]string
+	Tens [10]string
+	// Hundred is the word of a hundred, and Scales the words of a thousand, million, billion, and trillion.
+	Hundred string
+	Scales  [4]string
+	// And is the word some locales put before the last two digits of a number, e.g. "one hundred and five", empty
+	// if the locale does not.
+	And string
+	// Point is the word of the decimal point, and Minus the word of a negative sign.
+	Point, Minus string
+	// To is the word between the two numbers of a range, e.g. "ten to twenty".
+	To string
+	// Number is the word of the number sign before a number, e.g. "number five".
+	Number string
+	// MoneyAnd joins the amounts of the main unit and the hundredths of money, e.g. "five dollars and fifty cents".
+	MoneyAnd string
+	// Oh is the word of a zero before a single digit in years and times, e.g. "nineteen oh five".
+	Oh string
+	// OClock follows the hour of a time on the hour.
+	OClock string
+	// AM and PM are the spoken forms of the time of day.
+	AM, PM string
+
+	// Months are the names of the months from January.
+	Months [12]string
+	// DayFirst flag indicates the dates written as day/month/year, otherwise month/day/year.
+	DayFirst bool
+
+	// Currencies are the currencies by their symbols.
+	Currencies map[rune]Currency
+	// Units are the units of measurement by their abbreviations, they are spoken only after a number.
+	Units map[string]Unit
+	// Percent is the word of the percent sign.
+	Percent string
+	// Abbreviations are the abbreviations with their trailing full stop, and their spoken forms.
+	Abbreviations map[string]string
+	// Symbols are the symbols spoken as words.
+	Symbols map[rune]string
+	// Dot and At are the spoken forms of the dots and at sign in web addresses and email addresses.
+	Dot, At string
+}
+
+// englishUS is the American English locale.
+var englishUS = Locale{
+	Name: "en-US",
+	Ones: [20]string{"zero", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine",
+		"ten", "eleven", "twelve", "thirteen", "fourteen", "fifteen", "sixteen", "seventeen", "eighteen", "nineteen"},
+	Tens:     [10]string{"", "", "twenty", "thirty", "forty", "fifty", "sixty", "seventy", "eighty", "ninety"},
+	Hundred:  "hundred",
+	Scales:   [4]string{"thousand", "million", "billion", "trillion"},
+	Point:    "point",
+	Minus:    "minus",
+	To:       "to",
+	Number:   "number",
+	MoneyAnd: "and",
+	Oh:       "oh",
+	OClock:   "o'clock",
+	AM:       "a m",
+	PM:       "p m",
+	Months: [12]string{"January", "February", "March", "April", "May", "June",
+		"July", "August", "September", "October", "November", "December"},
+	Currencies: map[rune]Currency{
+		'$': {"dollar", "dollars", "cent", "cents"},
+		'€': {"euro", "euros", "cent", "cents"},
+		'£': {"pound", "pounds", "penny", "pence"},
+		'¥': {"yen", "yen", "sen", "sen"},
+	},
+	Units: map[string]Unit{
+		"km":   {"kilometer", "kilometers"},
+		"cm":   {"centimeter", "centimeters"},
+		"mm":   {"millimeter", "millimeters"},
+		"kg":   {"kilogram", "kilograms"},
+		"lb":   {"pound", "pounds"},
+		"lbs":  {"pound", "pounds"},
+		"ft":   {"foot", "feet"},
+		"mph":  {"mile per hour", "miles per hour"},
+		"km/h": {"kilometer per hour", "kilometers per hour"},
+		"°C":   {"degree Celsius", "degrees Celsius"},
+		"°F":   {"degree Fahrenheit", "degrees Fahrenheit"},
+	},
+	Percent: "percent",
+	Abbreviations: map[string]string{
+		"Mr.": "Mister", "Mrs.": "Missus", "Ms.": "Miz", "Dr.": "Doctor", "Prof.": "Professor",
+		"Jr.": "Junior", "Sr.": "Senior", "vs.": "versus", "etc.": "et cetera", "e.g.": "for example",
+		"i.e.": "that is", "approx.": "approximately",
+	},
+	Symbols: map[rune]string{
+		'&': "and", '@': "at", '+': "plus", '=': "equals", '°': "degrees", '~': "about",
+	},
+	Dot: "dot",
+	At:  "at",
+}
+
+// englishGB is the British English locale.
+var englishGB = func() Locale {
+	locale := englishUS
+	locale.Name = "en-GB"
+	locale.And = "and"
+	locale.DayFirst = true
+	locale.Units = map[string]Unit{}
+	for abbreviation, unit := range englishUS.Units {
+		locale.Units[abbreviation] = unit
+	}
+	locale.Units["km"] = Unit{"kilometre", "kilometres"}
+	locale.Units["cm"] = Unit{"centimetre", "centimetres"}
+	locale.Units["mm"] = Unit{"millimetre", "millimetres"}
+	locale.Units["km/h"] = Unit{"kilometre per hour", "kilometres per hour"}
+	locale.Abbreviations = map[string]string{}
+	for abbreviation, spoken := range englishUS.Abbreviations {
+		locale.Abbreviations[abbreviation] = spoken
+	}
+	// The British titles go without a full stop.
+	for _, title := range []string{"Mr", "Mrs", "Ms", "Dr", "Prof"} {
+		locale.Abbreviations[title] = englishUS.Abbreviations[title+"."]
+	}
+	return locale
+}()
+
+// Locales are the supported locales by name.
+var Locales = map[string]*Locale{
+	englishUS.Name: &englishUS,
+	englishGB.Name: &englishGB,
+}
+
+// DefaultLocale is the name of the default locale.
+const DefaultLocale = "en-US"
+
+// LocaleNames returns the names of the supported locales in alphabetical order.
+func LocaleNames() []string {
+	ret := make([]string, 0, len(Locales))
+	for name := range Locales {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// LookupLocale returns the locale of the name, or an error if the locale is not supported.
+func LookupLocale(name string) (*Locale, error) {
+	locale, exists := Locales[name]
+	if !exists {
+		return nil, fmt.Errorf("unsupported text normalisation locale %q, the supported locales are %v", name, LocaleNames())
+	}
+	return locale, nil
+}
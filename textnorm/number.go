@@ -0,0 +1,111 @@
+package textnorm
+
+import (
+	"strings"
+)
+
+// Cardinal returns the words of the whole number, e.g. "one hundred twenty-three".
+func (locale *Locale) Cardinal(n int64) string {
+	if n < 0 {
+		return locale.Minus + " " + locale.Cardinal(-n)
+	}
+	if n == 0 {
+		return locale.Ones[0]
+	}
+	// Speak each group of three digits followed by its scale, from the largest group.
+	var groups []int64
+	for rest := n; rest > 0; rest /= 1000 {
+		groups = append(groups, rest%1000)
+	}
+	var words []string
+	for i := len(groups) - 1; i >= 0; i-- {
+		if groups[i] == 0 {
+			continue
+		}
+		// The locales with "and" put it before the last group if it is below a hundred, e.g. "two thousand and five".
+		if i == 0 && len(words) > 0 && groups[0] < 100 && locale.And != "" {
+			words = append(words, locale.And)
+		}
+		words = append(words, locale.belowThousand(groups[i]))
+		if i > 0 {
+			words = append(words, locale.Scales[min(i, len(locale.Scales))-1])
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// belowThousand returns the words of the number between 1 and 999.
+func (locale *Locale) belowThousand(n int64) string {
+	var words []string
+	if n >= 100 {
+		words = append(words, locale.Ones[n/100], locale.Hundred)
+		n %= 100
+		if n > 0 && locale.And != "" {
+			words = append(words, locale.And)
+		}
+	}
+	if n > 0 {
+		words = append(words, locale.belowHundred(n))
+	}
+	return strings.Join(words, " ")
+}
+
+// belowHundred returns the words of the number between 0 and 99.
+func (locale *Locale) belowHundred(n int64) string {
+	if n < 20 {
+		return locale.Ones[n]
+	}
+	if n%10 == 0 {
+		return locale.Tens[n/10]
+	}
+	return locale.Tens[n/10] + "-" + locale.Ones[n%10]
+}
+
+// ordinalSuffixes are the irregular ordinals of the last word of a cardinal number.
+var ordinalSuffixes = map[string]string{
+	"one": "first", "two": "second", "three": "third", "five": "fifth", "eight": "eighth", "nine": "ninth", "twelve": "twelfth",
+}
+
+// Ordinal returns the words of the ordinal number, e.g. "twenty-first".
+func (locale *Locale) Ordinal(n int64) string {
+	cardinal := locale.Cardinal(n)
+	// Only the last word of the number changes, e.g. "twenty-one" becomes "twenty-first".
+	cut := strings.LastIndexAny(cardinal, " -") + 1
+	last := cardinal[cut:]
+	switch {
+	case ordinalSuffixes[last] != "":
+		last = ordinalSuffixes[last]
+	case strings.HasSuffix(last, "y"):
+		last = strings.TrimSuffix(last, "y") + "ieth"
+	default:
+		last += "th"
+	}
+	return cardinal[:cut] + last
+}
+
+// Year returns the words of the year as it is usually spoken, e.g. "nineteen oh five" or "twenty twenty-four".
+func (locale *Locale) Year(year int64) string {
+	if year < 1000 || year > 9999 || (year >= 2000 && year < 2010) {
+		return locale.Cardinal(year)
+	}
+	century, rest := year/100, year%100
+	switch {
+	case rest == 0:
+		return locale.belowHundred(century) + " " + locale.Hundred
+	case rest < 10:
+		return locale.belowHundred(century) + " " + locale.Oh + " " + locale.Ones[rest]
+	default:
+		return locale.belowHundred(century) + " " + locale.belowHundred(rest)
+	}
+}
+
+// Digits returns the words of each digit of the string, e.g. "four one five".
+func (locale *Locale) Digits(digits string) string {
+	words := make([]string, 0, len(digits))
+	for _, digit := range digits {
+		if digit >= '0' && digit <= '9' {
+			words = append(words, locale.Ones[digit-'0'])
+		}
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,404 @@
+// Package textnorm turns the text written by the LLM into the text spoken by the voice service, by stripping the
+// markup and emoji, and spelling out the numbers, dates, times, abbreviations, and symbols in words of a locale.
+package textnorm
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	// Markdown and HTML.
+	codeFencePattern      = regexp.MustCompile("(?m)^\\s*```.*$")
+	imagePattern          = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	linkPattern           = regexp.MustCompile(`\[([^\]]+)\]\([^)]*\)`)
+	htmlTagPattern        = regexp.MustCompile(`</?[a-zA-Z][^<>]*>`)
+	headingPattern        = regexp.MustCompile(`(?m)^[ \t]*#{1,6}[ \t]+`)
+	blockquotePattern     = regexp.MustCompile(`(?m)^[ \t]*>+[ \t]?`)
+	horizontalRulePattern = regexp.MustCompile(`(?m)^[ \t]*([-*_][ \t]*){3,}$`)
+	listItemPattern       = regexp.MustCompile(`(?m)^[ \t]*([-*+]|\d+[.)])[ \t]+`)
+	emphasisPattern       = regexp.MustCompile("\\*+|~~|`+|_+")
+
+	// Web and email addresses.
+	emailPattern  = regexp.MustCompile(`\b[\w.+-]+@[\w-]+(\.[\w-]+)+\b`)
+	urlPattern    = regexp.MustCompile(`\b(https?://|www\.)[^\s<>()\[\]]+`)
+	domainPattern = regexp.MustCompile(`\b[a-z0-9-]+(\.[a-z0-9-]+)*\.(com|org|net|edu|gov|io|ai|dev|app|co|uk|us)\b`)
+
+	// Dates and times.
+	time12Pattern      = regexp.MustCompile(`(?i)\b(\d{1,2})(?::(\d{2}))?\s?([ap])\.?m\b\.?`)
+	time24Pattern      = regexp.MustCompile(`\b(\d{1,2}):(\d{2})(?::\d{2})?\b`)
+	timeOfDayDotFollow = regexp.MustCompile(`\b(a m|p m)\.(\s+[a-z])`)
+	isoDatePattern     = regexp.MustCompile(`\b(\d{4})-(\d{2})-(\d{2})\b`)
+	slashDatePattern   = regexp.MustCompile(`\b(\d{1,2})/(\d{1,2})/(\d{4}|\d{2})\b`)
+
+	// Numbers.
+	phonePattern      = regexp.MustCompile(`\b\d{3}[-.]\d{3}[-.]\d{4}\b|\b\d{3}-\d{4}\b`)
+	rangePattern      = regexp.MustCompile(`\b(\d+)-(\d+)\b`)
+	ordinalPattern    = regexp.MustCompile(`(?i)\b(\d+)(st|nd|rd|th)\b`)
+	percentPattern    = regexp.MustCompile(numberExpr + `\s?%`)
+	numberSignPattern = regexp.MustCompile(`(?:#|\bNo\.)\s?(\d)`)
+	minusPattern      = regexp.MustCompile(`(^|\s)-(\d)`)
+	numberPattern     = regexp.MustCompile(numberExpr + `\b`)
+
+	// Symbols and spacing.
+	wordSeparatorPattern    = regexp.MustCompile(`(\w)/(\w)`)
+	unspokenSymbolPattern   = regexp.MustCompile(`[|\\^{}<>#]`)
+	whitespacePattern       = regexp.MustCompile(`\s+`)
+	spaceBeforePunctPattern = regexp.MustCompile(`\s+([,.!?;:])`)
+	repeatedCommaPattern    = regexp.MustCompile(`,(\s*,)+`)
+)
+
+// numberExpr matches a number of the whole digits, optionally grouped by commas, and the optional fraction digits.
+const numberExpr = `\b(\d{1,3}(?:,\d{3})+|\d+)(?:\.(\d+))?`
+
+// digitsSpokenOneByOne is the length of the ungrouped numbers spoken digit by digit, such as phone numbers and IDs.
+const digitsSpokenOneByOne = 10
+
+// Normalizer turns written text into speakable text in the words of a locale.
+type Normalizer struct {
+	Locale *Locale
+
+	currencyPattern     *regexp.Regexp
+	unitPattern         *regexp.Regexp
+	abbreviationPattern *regexp.Regexp
+	monthDayPattern     *regexp.Regexp
+	dayMonthPattern     *regexp.Regexp
+	months              map[string]int64
+}
+
+// New returns a normalizer of the locale, e.g. "en-US".
+func New(localeName string) (*Normalizer, error) {
+	locale, err := LookupLocale(localeName)
+	if err != nil {
+		return nil, err
+	}
+	norm := &Normalizer{Locale: locale, months: make(map[string]int64)}
+	var currencySymbols string
+	for symbol := range locale.Currencies {
+		currencySymbols += regexp.QuoteMeta(string(symbol))
+	}
+	norm.currencyPattern = regexp.MustCompile(`([` + currencySymbols + `])\s?(\d{1,3}(?:,\d{3})+|\d+)(?:\.(\d{1,2}))?\b(?:\s(` + strings.Join(locale.Scales[:], "|") + `)\b)?`)
+	units := make([]string, 0, len(locale.Units))
+	for abbreviation := range locale.Units {
+		units = append(units, abbreviation)
+	}
+	norm.unitPattern = regexp.MustCompile(numberExpr + `\s?(` + alternation(units) + `)\b`)
+	abbreviations := make([]string, 0, len(locale.Abbreviations))
+	for abbreviation := range locale.Abbreviations {
+		abbreviations = append(abbreviations, abbreviation)
+	}
+	norm.abbreviationPattern = regexp.MustCompile(`(^|[^\w.])(` + alternation(abbreviations) + `)(\s|$|[,;:!?)])`)
+	for i, month := range locale.Months {
+		norm.months[month] = int64(i) + 1
+	}
+	months := alternation(locale.Months[:])
+	norm.monthDayPattern = regexp.MustCompile(`\b(` + months + `)\s(\d{1,2})(?:st|nd|rd|th)?\b(?:,?\s(\d{4})\b)?`)
+	norm.dayMonthPattern = regexp.MustCompile(`\b(\d{1,2})(?:st|nd|rd|th)?\s(?:of\s)?(` + months + `)\b(?:,?\s(\d{4})\b)?`)
+	return norm, nil
+}
+
+// alternation returns the regular expression matching any of the literal words, the longest words are tried first.
+func alternation(words []string) string {
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = regexp.QuoteMeta(word)
+	}
+	sort.Slice(quoted, func(a, b int) bool { return len(quoted[a]) > len(quoted[b]) })
+	return strings.Join(quoted, "|")
+}
+
+// Normalize returns the text to be spoken in place of the written text.
+func (norm *Normalizer) Normalize(text string) string {
+	text = stripMarkup(text)
+	text = norm.speakAddresses(text)
+	text = norm.speakAbbreviations(text)
+	text = norm.speakDatesAndTimes(text)
+	text = norm.speakNumbers(text)
+	text = norm.speakSymbols(text)
+	text = stripEmoji(text)
+	text = whitespacePattern.ReplaceAllString(text, " ")
+	text = spaceBeforePunctPattern.ReplaceAllString(text, "$1")
+	text = repeatedCommaPattern.ReplaceAllString(text, ",")
+	return strings.TrimSpace(text)
+}
+
+// stripMarkup removes the markdown and HTML from the text, and keeps the text of the links and images.
+func stripMarkup(text string) string {
+	text = codeFencePattern.ReplaceAllString(text, "")
+	text = imagePattern.ReplaceAllString(text, "$1")
+	text = linkPattern.ReplaceAllString(text, "$1")
+	text = htmlTagPattern.ReplaceAllString(text, " ")
+	text = headingPattern.ReplaceAllString(text, "")
+	text = blockquotePattern.ReplaceAllString(text, "")
+	text = horizontalRulePattern.ReplaceAllString(text, "")
+	text = listItemPattern.ReplaceAllString(text, "")
+	return emphasisPattern.ReplaceAllStringFunc(text, func(emphasis string) string {
+		// The underscores join words in names such as "snake_case".
+		if emphasis[0] == '_' {
+			return " "
+		}
+		return ""
+	})
+}
+
+// speakAddresses replaces the email addresses, web addresses, and domain names with their spoken forms, a web
+// address is spoken by its domain name alone.
+func (norm *Normalizer) speakAddresses(text string) string {
+	text = emailPattern.ReplaceAllStringFunc(text, func(email string) string {
+		user, domain, _ := strings.Cut(email, "@")
+		return norm.speakDots(user) + " " + norm.Locale.At + " " + norm.speakDots(domain)
+	})
+	text = urlPattern.ReplaceAllStringFunc(text, func(url string) string {
+		// The punctuation following the address belongs to the sentence.
+		trimmed := strings.TrimRight(url, ".,;:!?'\"")
+		host := strings.TrimPrefix(strings.TrimPrefix(trimmed, "http://"), "https://")
+		host = strings.TrimPrefix(host, "www.")
+		host, _, _ = strings.Cut(host, "/")
+		host, _, _ = strings.Cut(host, "?")
+		host, _, _ = strings.Cut(host, ":")
+		return norm.speakDots(host) + url[len(trimmed):]
+	})
+	return domainPattern.ReplaceAllStringFunc(text, norm.speakDots)
+}
+
+// speakDots replaces the dots of the address with the word of a dot.
+func (norm *Normalizer) speakDots(address string) string {
+	return strings.Join(strings.Split(address, "."), " "+norm.Locale.Dot+" ")
+}
+
+// speakAbbreviations replaces the abbreviations with their spoken forms.
+func (norm *Normalizer) speakAbbreviations(text string) string {
+	return norm.abbreviationPattern.ReplaceAllStringFunc(text, func(match string) string {
+		groups := norm.abbreviationPattern.FindStringSubmatch(match)
+		before, abbreviation, after := groups[1], groups[2], groups[3]
+		spoken := norm.Locale.Abbreviations[abbreviation]
+		// The full stop of an abbreviation at the end of the text also ends the sentence.
+		if after == "" && strings.HasSuffix(abbreviation, ".") {
+			spoken += "."
+		}
+		return before + spoken + after
+	})
+}
+
+// speakDatesAndTimes replaces the dates and times with their spoken forms.
+func (norm *Normalizer) speakDatesAndTimes(text string) string {
+	locale := norm.Locale
+	text = isoDatePattern.ReplaceAllStringFunc(text, func(match string) string {
+		groups := isoDatePattern.FindStringSubmatch(match)
+		return norm.date(match, atoi(groups[1]), atoi(groups[2]), atoi(groups[3]))
+	})
+	text = slashDatePattern.ReplaceAllStringFunc(text, func(match string) string {
+		groups := slashDatePattern.FindStringSubmatch(match)
+		month, day, year := atoi(groups[1]), atoi(groups[2]), atoi(groups[3])
+		if locale.DayFirst {
+			month, day = day, month
+		}
+		if len(groups[3]) == 2 {
+			year += 2000
+		}
+		return norm.date(match, year, month, day)
+	})
+	text = norm.monthDayPattern.ReplaceAllStringFunc(text, func(match string) string {
+		groups := norm.monthDayPattern.FindStringSubmatch(match)
+		return norm.date(match, atoi(groups[3]), norm.months[groups[1]], atoi(groups[2]))
+	})
+	text = norm.dayMonthPattern.ReplaceAllStringFunc(text, func(match string) string {
+		groups := norm.dayMonthPattern.FindStringSubmatch(match)
+		return norm.date(match, atoi(groups[3]), norm.months[groups[2]], atoi(groups[1]))
+	})
+	text = time12Pattern.ReplaceAllStringFunc(text, func(match string) string {
+		groups := time12Pattern.FindStringSubmatch(match)
+		hour, minute := atoi(groups[1]), atoi(groups[2])
+		if hour < 1 || hour > 12 || minute > 59 {
+			return match
+		}
+		timeOfDay := locale.AM
+		if strings.EqualFold(groups[3], "p") {
+			timeOfDay = locale.PM
+		}
+		spoken := locale.Cardinal(hour)
+		if minute > 0 {
+			spoken += " " + norm.minutes(minute)
+		}
+		spoken += " " + timeOfDay
+		// Keep the full stop of "p.m." in case it also ends the sentence, it is removed again if the sentence goes on.
+		if strings.HasSuffix(match, "m.") || strings.HasSuffix(match, "M.") {
+			spoken += "."
+		}
+		return spoken
+	})
+	text = timeOfDayDotFollow.ReplaceAllString(text, "$1$2")
+	return time24Pattern.ReplaceAllStringFunc(text, func(match string) string {
+		groups := time24Pattern.FindStringSubmatch(match)
+		hour, minute := atoi(groups[1]), atoi(groups[2])
+		if hour > 23 || minute > 59 {
+			return match
+		}
+		if minute == 0 {
+			return locale.Cardinal(hour) + " " + locale.OClock
+		}
+		return locale.Cardinal(hour) + " " + norm.minutes(minute)
+	})
+}
+
+// minutes returns the words of the minutes of a time, e.g. "oh five" or "forty-five".
+func (norm *Normalizer) minutes(minute int64) string {
+	if minute < 10 {
+		return norm.Locale.Oh + " " + norm.Locale.Ones[minute]
+	}
+	return norm.Locale.Cardinal(minute)
+}
+
+// date returns the words of the date in the order of the locale, the year is left out if it is 0. The written date
+// is returned as is if it is not a valid date.
+func (norm *Normalizer) date(written string, year, month, day int64) string {
+	if month < 1 || month > 12 || day < 1 || day > 31 {
+		return written
+	}
+	locale := norm.Locale
+	var spoken string
+	if locale.DayFirst {
+		spoken = "the " + locale.Ordinal(day) + " of " + locale.Months[month-1]
+		if year > 0 {
+			spoken += " " + locale.Year(year)
+		}
+	} else {
+		spoken = locale.Months[month-1] + " " + locale.Ordinal(day)
+		if year > 0 {
+			spoken += ", " + locale.Year(year)
+		}
+	}
+	return spoken
+}
+
+// speakNumbers replaces the amounts of money, measurements, percentages, and the other numbers with their words.
+func (norm *Normalizer) speakNumbers(text string) string {
+	locale := norm.Locale
+	text = norm.currencyPattern.ReplaceAllStringFunc(text, func(match string) string {
+		groups := norm.currencyPattern.FindStringSubmatch(match)
+		symbol, _ := utf8.DecodeRuneInString(groups[1])
+		currency := locale.Currencies[symbol]
+		whole, cents, scale := atoi(strings.ReplaceAll(groups[2], ",", "")), groups[3], groups[4]
+		if scale != "" {
+			// "$1.5 million" is spoken as "one point five million dollars".
+			return norm.number(groups[2], cents) + " " + scale + " " + currency.MajorPlural
+		}
+		if len(cents) == 1 {
+			cents += "0"
+		}
+		var words []string
+		if whole > 0 || atoi(cents) == 0 {
+			words = append(words, locale.Cardinal(whole), plural(whole, currency.Major, currency.MajorPlural))
+		}
+		if minor := atoi(cents); minor > 0 {
+			if len(words) > 0 {
+				words = append(words, locale.MoneyAnd)
+			}
+			words = append(words, locale.Cardinal(minor), plural(minor, currency.Minor, currency.MinorPlural))
+		}
+		return strings.Join(words, " ")
+	})
+	text = norm.unitPattern.ReplaceAllStringFunc(text, func(match string) string {
+		groups := norm.unitPattern.FindStringSubmatch(match)
+		unit := locale.Units[groups[3]]
+		name := unit.Plural
+		if groups[1] == "1" && groups[2] == "" {
+			name = unit.Singular
+		}
+		return norm.number(groups[1], groups[2]) + " " + name
+	})
+	text = percentPattern.ReplaceAllStringFunc(text, func(match string) string {
+		groups := percentPattern.FindStringSubmatch(match)
+		return norm.number(groups[1], groups[2]) + " " + locale.Percent
+	})
+	text = ordinalPattern.ReplaceAllStringFunc(text, func(match string) string {
+		digits := ordinalPattern.FindStringSubmatch(match)[1]
+		if len(digits) >= digitsSpokenOneByOne {
+			return match
+		}
+		return locale.Ordinal(atoi(digits))
+	})
+	text = phonePattern.ReplaceAllStringFunc(text, func(phone string) string {
+		groups := strings.FieldsFunc(phone, func(r rune) bool { return r == '-' || r == '.' })
+		for i, group := range groups {
+			groups[i] = locale.Digits(group)
+		}
+		return strings.Join(groups, ", ")
+	})
+	text = rangePattern.ReplaceAllString(text, "$1 "+locale.To+" $2")
+	text = numberSignPattern.ReplaceAllString(text, locale.Number+" $1")
+	text = minusPattern.ReplaceAllString(text, "${1}"+locale.Minus+" $2")
+	return numberPattern.ReplaceAllStringFunc(text, func(match string) string {
+		groups := numberPattern.FindStringSubmatch(match)
+		return norm.number(groups[1], groups[2])
+	})
+}
+
+// number returns the words of the number made of the whole digits, optionally grouped by commas, and the fraction
+// digits. The long numbers without grouping, such as phone numbers and IDs, and the numbers with leading zeros are
+// spoken digit by digit.
+func (norm *Normalizer) number(whole, fraction string) string {
+	locale := norm.Locale
+	var spoken string
+	if !strings.Contains(whole, ",") && (len(whole) >= digitsSpokenOneByOne || (len(whole) > 1 && whole[0] == '0')) {
+		spoken = locale.Digits(whole)
+	} else if n, err := strconv.ParseInt(strings.ReplaceAll(whole, ",", ""), 10, 64); err == nil {
+		spoken = locale.Cardinal(n)
+	} else {
+		spoken = locale.Digits(whole)
+	}
+	if fraction != "" {
+		spoken += " " + locale.Point + " " + locale.Digits(fraction)
+	}
+	return spoken
+}
+
+// speakSymbols replaces the symbols with their words, and removes the symbols that are not spoken.
+func (norm *Normalizer) speakSymbols(text string) string {
+	text = wordSeparatorPattern.ReplaceAllString(text, "$1 $2")
+	text = unspokenSymbolPattern.ReplaceAllString(text, " ")
+	var ret strings.Builder
+	for _, r := range text {
+		if word, exists := norm.Locale.Symbols[r]; exists {
+			ret.WriteString(" " + word + " ")
+		} else {
+			ret.WriteRune(r)
+		}
+	}
+	return ret.String()
+}
+
+// stripEmoji removes the emoji and the other pictographic symbols from the text.
+func stripEmoji(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '♪':
+			// Bark sings the words between music notes.
+			return r
+		case unicode.Is(unicode.So, r), unicode.Is(unicode.Sk, r) && r > unicode.MaxLatin1, unicode.Is(unicode.Me, r):
+			return -1
+		case r == '\u200d' || r == '\ufe0e' || r == '\ufe0f':
+			// The zero width joiner and variation selectors of the emoji sequences.
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// plural returns the singular form if the amount is one, otherwise the plural form.
+func plural(amount int64, singular, plural string) string {
+	if amount == 1 {
+		return singular
+	}
+	return plural
+}
+
+// atoi returns the number of the decimal digits, or 0 if there is none.
+func atoi(digits string) int64 {
+	n, _ := strconv.ParseInt(digits, 10, 64)
+	return n
+}
@@ -0,0 +1,78 @@
+package textnorm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNumbers(t *testing.T) {
+	us, gb := Locales["en-US"], Locales["en-GB"]
+	assert.Equal(t, "zero", us.Cardinal(0))
+	assert.Equal(t, "one hundred twenty-three", us.Cardinal(123))
+	assert.Equal(t, "one hundred and twenty-three", gb.Cardinal(123))
+	assert.Equal(t, "two thousand five", us.Cardinal(2005))
+	assert.Equal(t, "two thousand and five", gb.Cardinal(2005))
+	assert.Equal(t, "one million two hundred thousand", us.Cardinal(1200000))
+	assert.Equal(t, "minus forty", us.Cardinal(-40))
+	assert.Equal(t, "first", us.Ordinal(1))
+	assert.Equal(t, "twelfth", us.Ordinal(12))
+	assert.Equal(t, "twentieth", us.Ordinal(20))
+	assert.Equal(t, "twenty-third", us.Ordinal(23))
+	assert.Equal(t, "one hundred first", us.Ordinal(101))
+	assert.Equal(t, "nineteen oh five", us.Year(1905))
+	assert.Equal(t, "nineteen hundred", us.Year(1900))
+	assert.Equal(t, "two thousand eight", us.Year(2008))
+	assert.Equal(t, "twenty twenty-four", us.Year(2024))
+	assert.Equal(t, "four one five", us.Digits("415"))
+}
+
+func TestNormalize(t *testing.T) {
+	_, err := New("xx-XX")
+	require.Error(t, err)
+	us, err := New("en-US")
+	require.NoError(t, err)
+	gb, err := New("en-GB")
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		norm      *Normalizer
+		text, out string
+	}{
+		// Markup and emoji.
+		{us, "**Hello** there, _my_ friend! 😊👍🏽", "Hello there, my friend!"},
+		{us, "# Tips\n- Drink `water`\n- Rest\n\n> Be well.", "Tips Drink water Rest Be well."},
+		{us, "See [the guide](https://example.com/guide) <b>now</b>.", "See the guide now."},
+		{us, "I love you ❤️ [laughs] ... truly… ♪ la la ♪", "I love you [laughs]... truly… ♪ la la ♪"},
+		// Addresses.
+		{us, "Visit https://www.example.com/a?b=1.", "Visit example dot com."},
+		{us, "Email grandma@family.org or go to example.co.uk", "Email grandma at family dot org or go to example dot co dot uk"},
+		// Abbreviations.
+		{us, "Dr. Smith and Mrs. Jones, e.g. my neighbours, etc.", "Doctor Smith and Missus Jones, for example my neighbours, et cetera."},
+		{us, "No. I live at No. 5.", "No. I live at number five."},
+		{gb, "Dr Smith is here.", "Doctor Smith is here."},
+		// Times.
+		{us, "Meet at 3:45pm or 10 a.m. tomorrow.", "Meet at three forty-five p m or ten a m tomorrow."},
+		{us, "Lunch is at 12:05 PM.", "Lunch is at twelve oh five p m."},
+		{us, "The train leaves at 15:30, not 9:00.", "The train leaves at fifteen thirty, not nine o'clock."},
+		// Dates.
+		{us, "Born on 1905-03-02.", "Born on March second, nineteen oh five."},
+		{gb, "Born on 1905-03-02.", "Born on the second of March nineteen oh five."},
+		{us, "Due 4/7/2024 and 7 May.", "Due April seventh, twenty twenty-four and May seventh."},
+		{gb, "Due 4/7/2024 and May 7th.", "Due the fourth of July twenty twenty-four and the seventh of May."},
+		{us, "You may 2 times.", "You may two times."},
+		// Money, measurements, and percentages.
+		{us, "It costs $12.50, or £1 and €0.99.", "It costs twelve dollars and fifty cents, or one pound and ninety-nine cents."},
+		{us, "They raised $1.5 million for $3.", "They raised one point five million dollars for three dollars."},
+		{us, "Walk 5km at 1 mph, it is 20°C and 50% sunny.", "Walk five kilometers at one mile per hour, it is twenty degrees Celsius and fifty percent sunny."},
+		{gb, "Walk 1,500 km.", "Walk one thousand five hundred kilometres."},
+		// Numbers and symbols.
+		{us, "The 21st guest of 1,234,567 paid 3.14 & got -5 points.", "The twenty-first guest of one million two hundred thirty-four thousand five hundred sixty-seven paid three point one four and got minus five points."},
+		{us, "Call 555-123-4567 with order 12345678901.", "Call five five five, one two three, four five six seven with order one two three four five six seven eight nine zero one."},
+		{us, "Pick 10-20 cards from #3, code 007.", "Pick ten to twenty cards from number three, code zero zero seven."},
+		{gb, "I have 105 cards and/or COVID-19.", "I have one hundred and five cards and or COVID-nineteen."},
+	} {
+		assert.Equal(t, tc.out, tc.norm.Normalize(tc.text), tc.text)
+	}
+}
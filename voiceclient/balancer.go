@@ -0,0 +1,253 @@
+package voiceclient
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+)
+
+const (
+	// DefaultHealthCheckInterval is the default interval between the health checks of each voice service.
+	DefaultHealthCheckInterval = 10 * time.Second
+	// DefaultFailureThreshold is the default number of consecutive failures after which a voice service is ejected.
+	DefaultFailureThreshold = 3
+	// DefaultEjectionPeriod is the default minimum duration a voice service stays ejected before a successful health
+	// check readmits it.
+	DefaultEjectionPeriod = 30 * time.Second
+)
+
+// BalancerConfig has the health check and circuit breaker settings of a Balancer.
+type BalancerConfig struct {
+	// HealthCheckInterval is the interval between the health checks of each voice service.
+	HealthCheckInterval time.Duration
+	// FailureThreshold is the number of consecutive failed requests or health checks after which a voice service is ejected.
+	FailureThreshold int
+	// EjectionPeriod is the minimum duration a voice service stays ejected before a successful health check readmits it.
+	EjectionPeriod time.Duration
+}
+
+// BackendStats are the statistics of a voice service behind the balancer.
+type BackendStats struct {
+	Addr string `json:"addr"`
+	// Healthy is false while the voice service is ejected.
+	Healthy bool `json:"healthy"`
+	// Outstanding is the number of requests in flight.
+	Outstanding int `json:"outstanding"`
+	// Requests and Failures count the requests made to the voice service and the failed ones.
+	Requests int64 `json:"requests"`
+	Failures int64 `json:"failures"`
+	// ConsecutiveFailures counts the failed requests and health checks since the latest successful request or health
+	// check.
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+	// Ejections counts the times the voice service has been ejected.
+	Ejections    int64     `json:"ejections"`
+	EjectedUntil time.Time `json:"ejectedUntil"`
+	LastError    string    `json:"lastError"`
+	LastErrorAt  time.Time `json:"lastErrorAt"`
+	// LastHealthCheckAt is the time of the latest health check, regardless of its outcome.
+	LastHealthCheckAt time.Time `json:"lastHealthCheckAt"`
+}
+
+// backend is a voice service behind the balancer.
+type backend struct {
+	client *Client
+	stats  BackendStats
+}
+
+// Balancer spreads the requests across several voice services, picking the one with the least requests in flight.
+// A voice service that fails consecutive requests or health checks is ejected, and readmitted once it passes a health
+// check after the ejection period.
+type Balancer struct {
+	Config BalancerConfig
+
+	mutex    sync.Mutex
+	backends []*backend
+	// next is where the search for the least loaded backend starts, it rotates so that the ties take turns.
+	next int
+}
+
+// NewBalancer returns a balancer of the voice services at the addresses, of which there must be at least one.
+// The health checks start with Run.
+func NewBalancer(addrs []string, httpClient *http.Client, conf BalancerConfig) *Balancer {
+	if conf.HealthCheckInterval <= 0 {
+		conf.HealthCheckInterval = DefaultHealthCheckInterval
+	}
+	if conf.FailureThreshold <= 0 {
+		conf.FailureThreshold = DefaultFailureThreshold
+	}
+	if conf.EjectionPeriod <= 0 {
+		conf.EjectionPeriod = DefaultEjectionPeriod
+	}
+	balancer := &Balancer{Config: conf}
+	for _, addr := range addrs {
+		balancer.backends = append(balancer.backends, &backend{
+			client: New(addr, httpClient),
+			stats:  BackendStats{Addr: addr, Healthy: true},
+		})
+	}
+	return balancer
+}
+
+// pick returns the healthy backend with the least requests in flight, and counts the request as outstanding.
+// If all backends have been ejected, the least loaded of them all is picked rather than failing the request outright.
+func (balancer *Balancer) pick() *backend {
+	balancer.mutex.Lock()
+	defer balancer.mutex.Unlock()
+	var picked *backend
+	for _, healthyOnly := range []bool{true, false} {
+		for i := range balancer.backends {
+			candidate := balancer.backends[(balancer.next+i)%len(balancer.backends)]
+			if healthyOnly && !candidate.stats.Healthy {
+				continue
+			}
+			if picked == nil || candidate.stats.Outstanding < picked.stats.Outstanding {
+				picked = candidate
+			}
+		}
+		if picked != nil {
+			break
+		}
+	}
+	balancer.next = (balancer.next + 1) % len(balancer.backends)
+	picked.stats.Outstanding++
+	picked.stats.Requests++
+	return picked
+}
+
+// recordFailure counts a failed request or health check, and ejects the backend after too many consecutive failures.
+// The caller holds the mutex.
+func (balancer *Balancer) recordFailure(b *backend, err error) {
+	b.stats.ConsecutiveFailures++
+	b.stats.LastError = err.Error()
+	b.stats.LastErrorAt = time.Now()
+	if b.stats.Healthy && b.stats.ConsecutiveFailures >= balancer.Config.FailureThreshold {
+		b.stats.Healthy = false
+		b.stats.EjectedUntil = time.Now().Add(balancer.Config.EjectionPeriod)
+		b.stats.Ejections++
+		log.Printf("ejected voice service %s after %d consecutive failures, the latest: %v", b.stats.Addr, b.stats.ConsecutiveFailures, err)
+	}
+}
+
+// release records the outcome of the request made to the backend.
+func (balancer *Balancer) release(ctx context.Context, b *backend, err error) {
+	balancer.mutex.Lock()
+	defer balancer.mutex.Unlock()
+	b.stats.Outstanding--
+	switch {
+	case err == nil:
+		b.stats.ConsecutiveFailures = 0
+	case ctx.Err() != nil || !IsRetryable(err):
+		// The caller gave up or the request itself was rejected, neither says the voice service is unwell.
+	default:
+		b.stats.Failures++
+		balancer.recordFailure(b, err)
+	}
+}
+
+// do makes the request to the picked voice service.
+func (balancer *Balancer) do(ctx context.Context, request func(client *Client) error) error {
+	b := balancer.pick()
+	err := request(b.client)
+	balancer.release(ctx, b, err)
+	return err
+}
+
+// Clone clones the voice in the wave sample into a new voice model identified by the ID, see Client.Clone.
+func (balancer *Balancer) Clone(ctx context.Context, id string, wav io.Reader) (cloneResp shared.CloneRealTimeResponse, err error) {
+	err = balancer.do(ctx, func(client *Client) error {
+		cloneResp, err = client.Clone(ctx, id, wav)
+		return err
+	})
+	return
+}
+
+// Synthesize converts the text into speech using the voice model, see Client.Synthesize.
+func (balancer *Balancer) Synthesize(ctx context.Context, model string, ttsRequest shared.TextToSpeechRealTimeRequest) (wavContent []byte, err error) {
+	err = balancer.do(ctx, func(client *Client) error {
+		wavContent, err = client.Synthesize(ctx, model, ttsRequest)
+		return err
+	})
+	return
+}
+
+// SynthesizeSegments converts the texts of the TTS requests into speech one after another, and calls the function with
+// the speech of each segment as soon as it is ready. The segments are synthesized by the same voice service, which
+// keeps the voice of the continuation's later segments consistent with its first. The function's error stops the
+// synthesis without counting against the voice service.
+func (balancer *Balancer) SynthesizeSegments(ctx context.Context, model string, ttsRequests []shared.TextToSpeechRealTimeRequest, onSegment func(index int, wavContent []byte) error) error {
+	var segmentErr error
+	err := balancer.do(ctx, func(client *Client) error {
+		for i, ttsRequest := range ttsRequests {
+			wavContent, err := client.Synthesize(ctx, model, ttsRequest)
+			if err != nil {
+				return err
+			}
+			if segmentErr = onSegment(i, wavContent); segmentErr != nil {
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return segmentErr
+}
+
+// CheckHealth checks the health of each voice service, and readmits the ejected ones that are healthy again after
+// the ejection period.
+func (balancer *Balancer) CheckHealth(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, balancer.Config.HealthCheckInterval)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, b := range balancer.backends {
+		wg.Add(1)
+		go func(b *backend) {
+			defer wg.Done()
+			err := b.client.CheckHealth(ctx)
+			balancer.mutex.Lock()
+			defer balancer.mutex.Unlock()
+			b.stats.LastHealthCheckAt = time.Now()
+			if err != nil {
+				balancer.recordFailure(b, err)
+				return
+			}
+			b.stats.ConsecutiveFailures = 0
+			if !b.stats.Healthy && time.Now().After(b.stats.EjectedUntil) {
+				b.stats.Healthy = true
+				log.Printf("readmitted voice service %s after it passed a health check", b.stats.Addr)
+			}
+		}(b)
+	}
+	wg.Wait()
+}
+
+// Run checks the health of the voice services periodically until the context is cancelled.
+func (balancer *Balancer) Run(ctx context.Context) {
+	ticker := time.NewTicker(balancer.Config.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		balancer.CheckHealth(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stats returns the statistics of each voice service behind the balancer.
+func (balancer *Balancer) Stats() []BackendStats {
+	balancer.mutex.Lock()
+	defer balancer.mutex.Unlock()
+	ret := make([]BackendStats, 0, len(balancer.backends))
+	for _, b := range balancer.backends {
+		ret = append(ret, b.stats)
+	}
+	return ret
+}
@@ -0,0 +1,121 @@
+package voiceclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestVoiceService returns the address of a voice service that synthesizes speech unless it is told to fail.
+func newTestVoiceService(t *testing.T, failing *atomic.Bool) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path == "/health" {
+			return
+		}
+		w.Header().Set("content-type", "audio/wav")
+		_, _ = w.Write([]byte("RIFF"))
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestBalancerPicksLeastOutstanding(t *testing.T) {
+	balancer := NewBalancer([]string{"a:8081", "b:8081", "c:8081"}, http.DefaultClient, BalancerConfig{})
+	first := balancer.pick()
+	second := balancer.pick()
+	third := balancer.pick()
+	assert.ElementsMatch(t, []string{"a:8081", "b:8081", "c:8081"}, []string{first.stats.Addr, second.stats.Addr, third.stats.Addr})
+	// The backend whose request finished first is the least loaded.
+	balancer.release(context.Background(), second, nil)
+	assert.Same(t, second, balancer.pick())
+	// An ejected backend is skipped.
+	balancer.release(context.Background(), first, nil)
+	first.stats.Healthy = false
+	assert.NotSame(t, first, balancer.pick())
+}
+
+func TestBalancerEjectsAndReadmits(t *testing.T) {
+	var failing atomic.Bool
+	addr := newTestVoiceService(t, &failing)
+	balancer := NewBalancer([]string{addr}, http.DefaultClient, BalancerConfig{FailureThreshold: 2, EjectionPeriod: time.Millisecond})
+	ctx := context.Background()
+	ttsRequest := shared.TextToSpeechRealTimeRequest{Text: "hello"}
+
+	failing.Store(true)
+	for i := 0; i < 2; i++ {
+		_, err := balancer.Synthesize(ctx, "model", ttsRequest)
+		require.Error(t, err)
+	}
+	stats := balancer.Stats()[0]
+	assert.False(t, stats.Healthy)
+	assert.EqualValues(t, 1, stats.Ejections)
+	assert.EqualValues(t, 2, stats.Failures)
+	// A failing health check does not readmit the backend.
+	time.Sleep(2 * time.Millisecond)
+	balancer.CheckHealth(ctx)
+	assert.False(t, balancer.Stats()[0].Healthy)
+
+	failing.Store(false)
+	balancer.CheckHealth(ctx)
+	stats = balancer.Stats()[0]
+	assert.True(t, stats.Healthy)
+	assert.Zero(t, stats.ConsecutiveFailures)
+	wav, err := balancer.Synthesize(ctx, "model", ttsRequest)
+	require.NoError(t, err)
+	assert.Equal(t, "RIFF", string(wav))
+	assert.Zero(t, balancer.Stats()[0].Outstanding)
+
+	// A passing health check resets the failures of a healthy backend too.
+	failing.Store(true)
+	_, err = balancer.Synthesize(ctx, "model", ttsRequest)
+	require.Error(t, err)
+	failing.Store(false)
+	balancer.CheckHealth(ctx)
+	failing.Store(true)
+	_, err = balancer.Synthesize(ctx, "model", ttsRequest)
+	require.Error(t, err)
+	stats = balancer.Stats()[0]
+	assert.True(t, stats.Healthy)
+	assert.Equal(t, 1, stats.ConsecutiveFailures)
+}
+
+func TestBalancerSynthesizeSegments(t *testing.T) {
+	var failing atomic.Bool
+	first, second := newTestVoiceService(t, &failing), newTestVoiceService(t, &failing)
+	balancer := NewBalancer([]string{first, second}, http.DefaultClient, BalancerConfig{FailureThreshold: 1})
+	ctx := context.Background()
+	ttsRequests := []shared.TextToSpeechRealTimeRequest{{Text: "Hello."}, {Text: "How are you?"}, {Text: "Bye."}}
+
+	// The segments of a continuation are synthesized by the same voice service, as a single request.
+	var segments []int
+	require.NoError(t, balancer.SynthesizeSegments(ctx, "model", ttsRequests, func(index int, wavContent []byte) error {
+		assert.Equal(t, "RIFF", string(wavContent))
+		segments = append(segments, index)
+		return nil
+	}))
+	assert.Equal(t, []int{0, 1, 2}, segments)
+	stats := balancer.Stats()
+	assert.Equal(t, int64(1), stats[0].Requests+stats[1].Requests)
+
+	// The caller stopping the synthesis does not count against the voice service.
+	errStop := errors.New("client went away")
+	err := balancer.SynthesizeSegments(ctx, "model", ttsRequests, func(index int, wavContent []byte) error { return errStop })
+	assert.ErrorIs(t, err, errStop)
+	for _, backend := range balancer.Stats() {
+		assert.True(t, backend.Healthy)
+		assert.Zero(t, backend.Failures)
+	}
+}
@@ -0,0 +1,160 @@
+// Package voiceclient is the client of the voice service (reconn/voicesvc), which clones voices and converts text to speech.
+package voiceclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+)
+
+// maxErrorBodyLength is the maximum length of an unsuccessful response's body kept in the StatusError.
+const maxErrorBodyLength = 1024
+
+// ErrMalformedResponse is returned when a successful response of the voice service cannot be understood.
+var ErrMalformedResponse = errors.New("malformed voice service response")
+
+// StatusError is returned when the voice service responds with an unsuccessful status code.
+type StatusError struct {
+	// Endpoint is the voice service endpoint, e.g. "clone-rt".
+	Endpoint string
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// Body is the beginning of the response body, which usually explains the error.
+	Body string
+}
+
+func (err *StatusError) Error() string {
+	if err.Body == "" {
+		return fmt.Sprintf("voice service %s responded with status %d", err.Endpoint, err.StatusCode)
+	}
+	return fmt.Sprintf("voice service %s responded with status %d: %s", err.Endpoint, err.StatusCode, err.Body)
+}
+
+// Retryable returns true if the request may succeed on retry, e.g. the voice service is overloaded or failed internally.
+// Otherwise the voice service rejected the request itself.
+func (err *StatusError) Retryable() bool {
+	return err.StatusCode >= 500 || err.StatusCode == http.StatusRequestTimeout || err.StatusCode == http.StatusTooManyRequests
+}
+
+// IsRetryable returns true unless the error is a StatusError of a request the voice service rejected.
+func IsRetryable(err error) bool {
+	var statusErr *StatusError
+	return !errors.As(err, &statusErr) || statusErr.Retryable()
+}
+
+// Client makes requests to a voice service instance.
+type Client struct {
+	// Addr is the address ("host:port") of the voice service.
+	Addr string
+	// HTTPClient makes the HTTP requests, voice cloning and TTS require a generous amount of timeout.
+	HTTPClient *http.Client
+}
+
+// New returns a client of the voice service at the address.
+func New(addr string, httpClient *http.Client) *Client {
+	return &Client{Addr: addr, HTTPClient: httpClient}
+}
+
+// modelName returns the name the voice service knows the voice model by, which is its file name without the extension.
+func modelName(modelFileName string) string {
+	return strings.TrimSuffix(modelFileName, ".npz")
+}
+
+// do makes a request to the endpoint, and returns the response if it is successful, or a StatusError otherwise.
+// The caller closes the body of the successful response.
+func (client *Client) do(ctx context.Context, method, endpoint, id, contentType string, body io.Reader) (*http.Response, error) {
+	url := fmt.Sprintf("http://%s/%s", client.Addr, endpoint)
+	if id != "" {
+		url += "/" + id
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct %s request: %w", endpoint, err)
+	}
+	if contentType != "" {
+		req.Header.Set("content-type", contentType)
+	}
+	resp, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make %s request: %w", endpoint, err)
+	}
+	if method == http.MethodPost {
+		log.Printf("%s responded with status %d and content length %d", endpoint, resp.StatusCode, resp.ContentLength)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		errBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyLength))
+		return nil, &StatusError{Endpoint: endpoint, StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(errBody))}
+	}
+	return resp, nil
+}
+
+// Clone clones the voice in the wave sample into a new voice model identified by the ID.
+// The voice service saves the model in its voice model directory, and responds with the model's file name.
+func (client *Client) Clone(ctx context.Context, id string, wav io.Reader) (shared.CloneRealTimeResponse, error) {
+	var cloneResp shared.CloneRealTimeResponse
+	resp, err := client.do(ctx, http.MethodPost, "clone-rt", id, "audio/wav", wav)
+	if err != nil {
+		return cloneResp, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&cloneResp); err != nil {
+		return cloneResp, fmt.Errorf("%w: failed to deserialise clone-rt response: %v", ErrMalformedResponse, err)
+	}
+	if cloneResp.ModelDestinationFile == "" {
+		return cloneResp, fmt.Errorf("%w: clone-rt response does not have the model file", ErrMalformedResponse)
+	}
+	return cloneResp, nil
+}
+
+// Synthesize converts the text into speech using the voice model, and returns the wave content.
+// The model is the file name of the voice model, which must be present in the voice model directory.
+func (client *Client) Synthesize(ctx context.Context, model string, ttsRequest shared.TextToSpeechRealTimeRequest) ([]byte, error) {
+	reqBody, err := json.Marshal(ttsRequest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct tts-rt request: %w", err)
+	}
+	resp, err := client.do(ctx, http.MethodPost, "tts-rt", modelName(model), "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("content-type"); !strings.HasPrefix(contentType, "audio/") {
+		return nil, fmt.Errorf("%w: tts-rt responded with content type %q", ErrMalformedResponse, contentType)
+	}
+	wavContent, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tts-rt response body: %w", err)
+	} else if len(wavContent) == 0 {
+		return nil, fmt.Errorf("%w: tts-rt responded with an empty body", ErrMalformedResponse)
+	}
+	return wavContent, nil
+}
+
+// WarmUp asks the voice service to read the voice model from disk ahead of its first TTS, so that the TTS finds the file
+// in the page cache. The voice service does not keep the model loaded.
+// The model is the file name of the voice model, which must be present in the voice model directory.
+func (client *Client) WarmUp(ctx context.Context, model string) error {
+	resp, err := client.do(ctx, http.MethodPost, "warm-up", modelName(model), "", nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// CheckHealth returns an error if the voice service is not up.
+func (client *Client) CheckHealth(ctx context.Context) error {
+	resp, err := client.do(ctx, http.MethodGet, "health", "", "", nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,79 @@
+package voiceclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return New(strings.TrimPrefix(server.URL, "http://"), server.Client())
+}
+
+func TestClone(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/clone-rt/123", r.URL.Path)
+		assert.Equal(t, "audio/wav", r.Header.Get("content-type"))
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "wave", string(body))
+		w.Header().Set("content-type", "application/json")
+		_, _ = w.Write([]byte(`{"model": "123.npz"}`))
+	})
+	resp, err := client.Clone(context.Background(), "123", strings.NewReader("wave"))
+	require.NoError(t, err)
+	assert.Equal(t, "123.npz", resp.ModelDestinationFile)
+}
+
+func TestSynthesize(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/tts-rt/123", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("content-type"))
+		w.Header().Set("content-type", "audio/wav")
+		_, _ = w.Write([]byte("RIFF"))
+	})
+	wav, err := client.Synthesize(context.Background(), "123.npz", shared.TextToSpeechRealTimeRequest{Text: "hello"})
+	require.NoError(t, err)
+	assert.Equal(t, "RIFF", string(wav))
+}
+
+func TestSynthesizeError(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of memory", http.StatusInternalServerError)
+	})
+	_, err := client.Synthesize(context.Background(), "123", shared.TextToSpeechRealTimeRequest{Text: "hello"})
+	var statusErr *StatusError
+	require.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusInternalServerError, statusErr.StatusCode)
+	assert.Equal(t, "out of memory", statusErr.Body)
+	assert.True(t, IsRetryable(err))
+
+	// An error page is not mistaken for speech.
+	client = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "text/html")
+		_, _ = w.Write([]byte("<html></html>"))
+	})
+	_, err = client.Synthesize(context.Background(), "123", shared.TextToSpeechRealTimeRequest{Text: "hello"})
+	assert.ErrorIs(t, err, ErrMalformedResponse)
+}
+
+func TestWarmUpNotFound(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/warm-up/123", r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	})
+	err := client.WarmUp(context.Background(), "123.npz")
+	var statusErr *StatusError
+	require.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusNotFound, statusErr.StatusCode)
+	assert.False(t, IsRetryable(err))
+}
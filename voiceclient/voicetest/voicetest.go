@@ -0,0 +1,216 @@
+// Package voicetest provides a fake voice service (reconn/voicesvc) for tests, which speaks the same protocol as the
+// real one without a GPU: it clones a voice into a dummy model, and synthesizes a deterministic tone with noise whose
+// duration depends on the text.
+package voicetest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+)
+
+const (
+	// SampleRate is the sample rate of the synthesized speech, the same as Bark's.
+	SampleRate = 24000
+	// durationPerChar is roughly how long it takes to speak a character.
+	durationPerChar = 60 * time.Millisecond
+	// minDuration is the duration of the speech of the shortest text.
+	minDuration = 250 * time.Millisecond
+)
+
+// Server is a fake voice service listening on a local address.
+type Server struct {
+	// Addr is the address ("host:port") of the voice service.
+	Addr string
+	// ModelDir is the voice model directory shared with the code under test, as with the real voice service.
+	ModelDir string
+
+	server   *httptest.Server
+	mutex    sync.Mutex
+	latency  time.Duration
+	failures []int
+	requests map[string]int
+}
+
+// NewServer starts a fake voice service which saves and reads the voice models in the directory.
+// The caller should close the server when it is done.
+func NewServer(modelDir string) *Server {
+	fake := &Server{ModelDir: modelDir, requests: map[string]int{}}
+	fake.server = httptest.NewServer(http.HandlerFunc(fake.serveHTTP))
+	fake.Addr = strings.TrimPrefix(fake.server.URL, "http://")
+	return fake
+}
+
+// Close shuts down the fake voice service.
+func (fake *Server) Close() {
+	fake.server.Close()
+}
+
+// SetLatency delays each response by the duration, e.g. to keep the requests in flight.
+func (fake *Server) SetLatency(latency time.Duration) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	fake.latency = latency
+}
+
+// FailNext fails the next n requests with the HTTP status code, the health checks included.
+func (fake *Server) FailNext(n int, statusCode int) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	for i := 0; i < n; i++ {
+		fake.failures = append(fake.failures, statusCode)
+	}
+}
+
+// Requests returns the number of requests received by the endpoint, e.g. "tts-rt", including the failed ones.
+func (fake *Server) Requests(endpoint string) int {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	return fake.requests[endpoint]
+}
+
+// SpeechDuration returns the duration of the speech synthesized from the text.
+func SpeechDuration(text string) time.Duration {
+	return max(minDuration, time.Duration(len([]rune(text)))*durationPerChar)
+}
+
+// nextRequest counts the request, and returns its latency and the status code of its injected failure if any.
+func (fake *Server) nextRequest(endpoint string) (latency time.Duration, failure int) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	fake.requests[endpoint]++
+	if len(fake.failures) > 0 {
+		failure = fake.failures[0]
+		fake.failures = fake.failures[1:]
+	}
+	return fake.latency, failure
+}
+
+func (fake *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	endpoint, id, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	latency, failure := fake.nextRequest(endpoint)
+	select {
+	case <-time.After(latency):
+	case <-r.Context().Done():
+		return
+	}
+	if failure != 0 {
+		http.Error(w, "injected failure", failure)
+		return
+	}
+	switch {
+	case endpoint == "health" && r.Method == http.MethodGet:
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	case endpoint == "clone-rt" && r.Method == http.MethodPost && id != "":
+		fake.clone(w, r, id)
+	case endpoint == "tts-rt" && r.Method == http.MethodPost && id != "":
+		fake.tts(w, r, id)
+	case endpoint == "warm-up" && r.Method == http.MethodPost && id != "":
+		if _, err := os.Stat(fake.modelPath(id)); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// modelPath returns the path to the voice model of the user.
+func (fake *Server) modelPath(userID string) string {
+	return filepath.Join(fake.ModelDir, filepath.Base(userID)+".npz")
+}
+
+// clone saves a dummy voice model derived from the wave sample.
+func (fake *Server) clone(w http.ResponseWriter, r *http.Request, userID string) {
+	switch r.Header.Get("content-type") {
+	case "audio/x-wav", "audio/wav", "audio/wave":
+	default:
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+	sample, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(sample) < 44 || string(sample[0:4]) != "RIFF" || string(sample[8:12]) != "WAVE" {
+		http.Error(w, "the voice sample is not a wave file", http.StatusInternalServerError)
+		return
+	}
+	hash := fnv.New64a()
+	_, _ = hash.Write(sample)
+	model := fmt.Sprintf("fake voice model of sample %x", hash.Sum64())
+	if err := os.WriteFile(fake.modelPath(userID), []byte(model), 0644); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(shared.CloneRealTimeResponse{ModelDestinationFile: filepath.Base(userID) + ".npz"})
+}
+
+// tts synthesizes the speech of the text using the voice model.
+func (fake *Server) tts(w http.ResponseWriter, r *http.Request, userID string) {
+	if r.Header.Get("content-type") != "application/json" {
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+	var ttsRequest shared.TextToSpeechRealTimeRequest
+	if err := json.NewDecoder(r.Body).Decode(&ttsRequest); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	model, err := os.ReadFile(fake.modelPath(userID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "audio/wav")
+	_, _ = w.Write(Synthesize(model, ttsRequest.Text))
+}
+
+// Synthesize returns the 16-bit mono wave content of the speech of the text. The pitch of the tone depends on the
+// voice model, and the noise on the text, hence the same model and text always produce the same speech.
+func Synthesize(model []byte, text string) []byte {
+	modelHash, textHash := fnv.New64a(), fnv.New64a()
+	_, _ = modelHash.Write(model)
+	_, _ = textHash.Write([]byte(text))
+	frequency := 100 + float64(modelHash.Sum64()%300)
+	noise := rand.New(rand.NewSource(int64(textHash.Sum64())))
+	samples := make([]int16, int(SpeechDuration(text).Seconds()*SampleRate))
+	for i := range samples {
+		tone := math.Sin(2 * math.Pi * frequency * float64(i) / SampleRate)
+		samples[i] = int16((0.5*tone + 0.1*(noise.Float64()*2-1)) * math.MaxInt16)
+	}
+	var buf bytes.Buffer
+	dataSize := uint32(len(samples) * 2)
+	buf.WriteString("RIFF")
+	_ = binary.Write(&buf, binary.LittleEndian, 36+dataSize)
+	buf.WriteString("WAVEfmt ")
+	for _, field := range []any{
+		uint32(16),             // fmt chunk size
+		uint16(1),              // PCM
+		uint16(1),              // channels
+		uint32(SampleRate),     // sample rate
+		uint32(SampleRate * 2), // byte rate
+		uint16(2),              // block align
+		uint16(16),             // bits per sample
+	} {
+		_ = binary.Write(&buf, binary.LittleEndian, field)
+	}
+	buf.WriteString("data")
+	_ = binary.Write(&buf, binary.LittleEndian, dataSize)
+	_ = binary.Write(&buf, binary.LittleEndian, samples)
+	return buf.Bytes()
+}
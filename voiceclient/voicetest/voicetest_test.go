@@ -0,0 +1,67 @@
+package voicetest
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+	"github.com/HouzuoGuo/reconn-voice-clone/voiceclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloneAndSynthesize(t *testing.T) {
+	fake := NewServer(t.TempDir())
+	defer fake.Close()
+	client := voiceclient.New(fake.Addr, http.DefaultClient)
+	ctx := context.Background()
+
+	sample := Synthesize([]byte("speaker"), "the voice sample")
+	cloneResp, err := client.Clone(ctx, "123", bytes.NewReader(sample))
+	require.NoError(t, err)
+	assert.Equal(t, "123.npz", cloneResp.ModelDestinationFile)
+	assert.FileExists(t, filepath.Join(fake.ModelDir, "123.npz"))
+
+	short, err := client.Synthesize(ctx, "123.npz", shared.TextToSpeechRealTimeRequest{Text: "Hi there, how are you doing?"})
+	require.NoError(t, err)
+	long, err := client.Synthesize(ctx, "123.npz", shared.TextToSpeechRealTimeRequest{Text: "Hi there, how are you doing? It has been a while since we last spoke."})
+	require.NoError(t, err)
+	assert.Equal(t, "RIFF", string(short[:4]))
+	assert.Greater(t, len(long), len(short))
+	// The same model and text always produce the same speech.
+	again, err := client.Synthesize(ctx, "123", shared.TextToSpeechRealTimeRequest{Text: "Hi there, how are you doing?"})
+	require.NoError(t, err)
+	assert.Equal(t, short, again)
+	assert.Equal(t, 3, fake.Requests("tts-rt"))
+
+	require.NoError(t, client.WarmUp(ctx, "123.npz"))
+	assert.False(t, voiceclient.IsRetryable(client.WarmUp(ctx, "456.npz")))
+}
+
+func TestInjectedFailures(t *testing.T) {
+	fake := NewServer(t.TempDir())
+	defer fake.Close()
+	client := voiceclient.New(fake.Addr, http.DefaultClient)
+	require.NoError(t, os.WriteFile(filepath.Join(fake.ModelDir, "123.npz"), []byte("model"), 0644))
+	ctx := context.Background()
+	ttsRequest := shared.TextToSpeechRealTimeRequest{Text: "hello"}
+
+	fake.FailNext(1, http.StatusInternalServerError)
+	_, err := client.Synthesize(ctx, "123", ttsRequest)
+	var statusErr *voiceclient.StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusInternalServerError, statusErr.StatusCode)
+	_, err = client.Synthesize(ctx, "123", ttsRequest)
+	require.NoError(t, err)
+
+	fake.SetLatency(200 * time.Millisecond)
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = client.Synthesize(timeoutCtx, "123", ttsRequest)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
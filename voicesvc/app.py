@@ -26,7 +26,9 @@ def create_app(svc: VoiceSvc):
         return response
 
     basic.readback_handler(app)
+    basic.health_handler(app)
     clone.clone_rt_handler(app, svc)
     clone.tts_rt_handler(app, svc)
+    clone.warm_up_handler(app, svc)
 
     return app
@@ -12,3 +12,10 @@ def readback_handler(app: Flask):
                 "request-url": request.url,
             }
         )
+
+
+# Tell the clients that the voice service is up, they probe it to balance their requests across the voice services.
+def health_handler(app: Flask):
+    @app.route("/health", methods=["GET"])
+    def health_handler():
+        return jsonify({"status": "ok"})
@@ -32,9 +32,21 @@ def tts_rt_handler(app: Flask, svc: VoiceSvc):
         tts_output_wav = svc.tts(
             # Kudus to Yonatan for identifying this parameter set:
             # user_id, transaction_id, text, 99, 0.8, 0.01, 0.7, 0.6, 0.5
-            user_id, transaction_id, text, request.json["topK"], request.json["topP"], request.json["mineosP"], request.json["semanticTemp"], request.json["waveformTemp"], request.json["fineTemp"]
+            user_id, transaction_id, text, request.json["topK"], request.json["topP"], request.json["mineosP"], request.json["semanticTemp"], request.json["waveformTemp"], request.json["fineTemp"],
+            # The segments of a reply spoken one per request share the voice of the first segment.
+            request.json.get("continuationId", ""), request.json.get("lastSegment", False)
         )
         response = make_response()
         response.headers["content-type"] = "audio/wav"
         response.data = codecs.open(tts_output_wav, "rb").read()
         return response, 200
+
+
+# Read the user's voice model from disk into the page cache ahead of the first TTS.
+def warm_up_handler(app: Flask, svc: VoiceSvc):
+    @app.route("/warm-up/<user_id>", methods=["POST"])
+    def warm_up_handler(user_id: str):
+        app.logger.info(f"warm-up requested for user {user_id}")
+        if not svc.pre_read(user_id):
+            return "", 404
+        return "", 200
@@ -1,8 +1,10 @@
+import glob
 import huggingface_hub
 import logging
 import nltk
 import numpy
 import os
+import time
 import torch
 import torchaudio
 import urllib
@@ -21,6 +23,11 @@ from bark_voice_clone.hubert.pre_kmeans_hubert import CustomHubert
 from encodec.utils import convert_audio
 
 
+# The temporary model of a continuation is removed after its last segment, or after it has not been used for this
+# long, e.g. when the client went away in the middle of the continuation.
+CONTINUATION_MODEL_TTL_SECONDS = 60 * 60
+
+
 class VoiceSvc:
     def __init__(
         self,
@@ -159,6 +166,17 @@ class VoiceSvc:
         )
         return base_name
 
+    def pre_read(self, user_id) -> bool:
+        model = os.path.join(self.voice_model_dir, f"{user_id}.npz")
+        if not os.path.isfile(model):
+            return False
+        # Read the entire model once and discard it, so that the first TTS of the user finds the file in the page cache
+        # of the operating system rather than waiting for the disk. Bark loads the model again for each TTS.
+        with numpy.load(model) as npz:
+            for key in npz.files:
+                npz[key]
+        return True
+
     def tts(
         self,
         user_id: str,
@@ -170,18 +188,31 @@ class VoiceSvc:
         semantic_temp: float,
         waveform_temp: float,
         fine_temp: float,
+        continuation_id: str = "",
+        last_segment: bool = False,
     ) -> str:
         voice_segments = []
         # Voice model cloned from user's sample.
         original_model = os.path.join(self.voice_model_dir, f"{user_id}.npz")
-        # Temporary model created during this TTS invocation.
+        # Temporary model created during this TTS invocation, or during the first segment of the continuation.
         temp_model = os.path.join(
             self.voice_temp_model_dir, f"{user_id}-{transaction_id}.npz"
         )
+        if continuation_id:
+            temp_model = os.path.join(
+                self.voice_temp_model_dir,
+                f"{user_id}-continuation-{os.path.basename(continuation_id)}.npz",
+            )
         tts_output_wav = os.path.join(
             self.voice_output_dir, f"{user_id}-{transaction_id}.wav"
         )
+        self.remove_expired_continuation_models()
         active_model = original_model
+        if continuation_id and os.path.exists(temp_model):
+            # Speak the later segments of the continuation in the voice of its first segment.
+            active_model = temp_model
+            # Keep the model from expiring while the continuation is in use.
+            os.utime(temp_model)
         for index, sentence in enumerate(nltk.sent_tokenize(text_prompt)):
             logging.info(
                 f'converting sentence "{sentence}" for {user_id} transaction {transaction_id}'
@@ -206,7 +237,7 @@ class VoiceSvc:
             )
             voice_segments += [audio_array]
 
-            if index < 1:
+            if active_model == original_model:
                 # Save the model from the first sentence and use it in subsequent sentences.
                 numpy.savez_compressed(
                     temp_model,
@@ -215,7 +246,22 @@ class VoiceSvc:
                     fine_prompt=full_out["fine_prompt"],
                 )
                 active_model = temp_model
-        os.remove(temp_model)
+        # TODO: the temporary model of a TTS invocation that raised before this point is left behind, unlike the
+        # continuation models it never expires.
+        if (not continuation_id or last_segment) and os.path.exists(temp_model):
+            os.remove(temp_model)
         write_wav(tts_output_wav, SAMPLE_RATE, numpy.concatenate(voice_segments))
-        # TODO FIXME: periodically clean up left over temporary models
         return tts_output_wav
+
+    def remove_expired_continuation_models(self):
+        expiry = time.time() - CONTINUATION_MODEL_TTL_SECONDS
+        for model in glob.glob(
+            os.path.join(self.voice_temp_model_dir, "*-continuation-*.npz")
+        ):
+            try:
+                if os.path.getmtime(model) < expiry:
+                    logging.info(f"removing expired continuation model {model}")
+                    os.remove(model)
+            except FileNotFoundError:
+                # Removed by its last segment in the meantime.
+                pass
@@ -0,0 +1,100 @@
+package workersvc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/db/dbgen"
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+)
+
+const (
+	// DefaultModelAffinityTimeout is the default duration a TTS task waits for a worker that has its voice model
+	// cached, after which any worker processes it.
+	DefaultModelAffinityTimeout = 10 * time.Second
+	// modelAffinityDeferDelay is the delay before a deferred task is received again, hopefully by a worker caching its model.
+	modelAffinityDeferDelay = 1 * time.Second
+)
+
+// DeferredError is returned by a handler that leaves the task to another worker.
+// The task is sent back to the queue after the delay, which does not count as an attempt.
+type DeferredError struct {
+	Delay  time.Duration
+	Reason string
+}
+
+func (err *DeferredError) Error() string {
+	return fmt.Sprintf("deferred by %v: %s", err.Delay, err.Reason)
+}
+
+// Defer leaves the task to another worker after the delay.
+func Defer(delay time.Duration, reason string) error {
+	return &DeferredError{Delay: delay, Reason: reason}
+}
+
+// deadWorkerThreshold is how long after its last heartbeat a worker is considered dead.
+func (worker *GPUWorker) deadWorkerThreshold() time.Duration {
+	return 4 * worker.Config.HeartbeatInterval
+}
+
+// routeByModel defers the task if the voice model is not cached by this worker but by another live worker, until the
+// task has waited for the affinity timeout, after which this worker takes the task and downloads the model.
+func (worker *GPUWorker) routeByModel(ctx context.Context, task shared.GPUTask, modelFileName string) error {
+	if worker.Config.ModelAffinityTimeout < 0 || time.Since(task.CreatedAt) >= worker.Config.ModelAffinityTimeout {
+		return nil
+	}
+	workerIDs, err := worker.Database.ListLiveGPUWorkersCachingModel(ctx, dbgen.ListLiveGPUWorkersCachingModelParams{
+		ModelFileName: modelFileName,
+		DeadSeconds:   worker.deadWorkerThreshold().Seconds(),
+	})
+	if err != nil {
+		// Routing is an optimisation, the task carries on regardless.
+		log.Printf("failed to list the workers caching voice model %q: %v", modelFileName, err)
+		return nil
+	}
+	if len(workerIDs) == 0 || slices.Contains(workerIDs, worker.ID) {
+		return nil
+	}
+	return Defer(modelAffinityDeferDelay, fmt.Sprintf("voice model %q is cached by workers %v", modelFileName, workerIDs))
+}
+
+// recordModelCached records that the voice model is cached in the local disk of this worker.
+func (worker *GPUWorker) recordModelCached(ctx context.Context, modelFileName string) {
+	if err := worker.Database.RecordGPUWorkerModelCache(ctx, dbgen.RecordGPUWorkerModelCacheParams{
+		WorkerID:      worker.ID,
+		ModelFileName: modelFileName,
+	}); err != nil {
+		log.Printf("failed to record the cache of voice model %q: %v", modelFileName, err)
+	}
+}
+
+// sendDeferred sends the task back to the queue after the delay, and completes the current delivery.
+// The task keeps its attempt count, including the redeliveries so far.
+func (worker *GPUWorker) sendDeferred(ctx context.Context, task *shared.ReceivedTask, gpuTask shared.GPUTask, deferred *DeferredError) {
+	body, err := json.Marshal(gpuTask)
+	if err == nil {
+		err = worker.TaskQueue.Send(ctx, body, &shared.SendOptions{Delay: deferred.Delay})
+	}
+	if err != nil {
+		log.Printf("failed to defer %v, abandoning it instead: %v", gpuTask, err)
+		if err := worker.TaskQueue.Abandon(ctx, task); err != nil {
+			log.Printf("failed to abandon %v: %v", gpuTask, err)
+		}
+		return
+	}
+	if err := worker.TaskQueue.Complete(ctx, task); err != nil {
+		log.Printf("failed to complete the deferred %v: %v", gpuTask, err)
+	}
+}
+
+// asDeferred returns the DeferredError in the error chain, if any.
+func asDeferred(err error) (*DeferredError, bool) {
+	var deferred *DeferredError
+	ok := errors.As(err, &deferred)
+	return deferred, ok
+}
@@ -0,0 +1,48 @@
+package workersvc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeferredTask(t *testing.T) {
+	ctx := context.Background()
+	queue := shared.NewMemoryTaskQueue(t.Name())
+	worker := &GPUWorker{
+		Config:          &Config{MaxAttempts: 1, LockRenewInterval: time.Minute},
+		TaskQueue:       queue,
+		Handlers:        map[shared.GPUTaskType]TaskHandler{},
+		FailureHandlers: map[shared.GPUTaskType]TaskFailureHandler{},
+	}
+	deferrals := 0
+	worker.RegisterHandler(shared.GPUTaskConvertReplyToSpeech, func(ctx context.Context, task shared.GPUTask) error {
+		if deferrals < 2 {
+			deferrals++
+			return Defer(10*time.Millisecond, "voice model is cached elsewhere")
+		}
+		return nil
+	})
+	worker.RegisterFailureHandler(shared.GPUTaskConvertReplyToSpeech, func(ctx context.Context, task shared.GPUTask, taskErr error, final bool) error {
+		t.Fatal("a deferred task must not be recorded as a failure")
+		return nil
+	})
+	require.NoError(t, queue.Send(ctx, newTestTaskBody(t, shared.GPUTaskConvertReplyToSpeech, shared.ConvertReplyToSpeechPayload{AIReplyVoiceID: 1}), nil))
+	// The deferrals do not use up the only attempt of the task.
+	for i := 0; i < 3; i++ {
+		tasks, err := queue.Receive(ctx, 1)
+		require.NoError(t, err)
+		task, err := shared.ParseGPUTask(tasks[0].Body)
+		require.NoError(t, err)
+		assert.Equal(t, 0, task.Attempt)
+		worker.processReceivedTask(ctx, tasks[0])
+	}
+	assert.Equal(t, 2, deferrals)
+	deadLetters, err := queue.ListDeadLetters(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, deadLetters)
+}
@@ -0,0 +1,59 @@
+package workersvc
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+)
+
+// DefaultCancelCheckInterval is the default interval between checks of whether a task in flight has been cancelled.
+const DefaultCancelCheckInterval = 5 * time.Second
+
+// ErrTaskCancelled is the cause of cancelling a task's processing after the user cancelled the task's record.
+var ErrTaskCancelled = errors.New("the task has been cancelled")
+
+// TaskCancellationCheck returns true if the database record the GPU task works on has been cancelled.
+type TaskCancellationCheck func(ctx context.Context, task shared.GPUTask) (bool, error)
+
+// RegisterCancellationCheck registers the cancellation check of a type of GPU task, replacing the existing check of the same type.
+func (worker *GPUWorker) RegisterCancellationCheck(taskType shared.GPUTaskType, check TaskCancellationCheck) {
+	worker.CancellationChecks[taskType] = check
+}
+
+// watchCancellation periodically checks whether the task has been cancelled until the job context is done.
+// Once the task is cancelled, the job is cancelled with ErrTaskCancelled as the cause, which aborts the voice service
+// request in flight. The returned channel is closed after the checks stop.
+func (worker *GPUWorker) watchCancellation(jobCtx context.Context, cancelJob context.CancelCauseFunc, task shared.GPUTask) <-chan struct{} {
+	done := make(chan struct{})
+	check, exists := worker.CancellationChecks[task.Type]
+	if !exists {
+		close(done)
+		return done
+	}
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(worker.Config.CancelCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-jobCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			cancelled, err := check(jobCtx, task)
+			switch {
+			case jobCtx.Err() != nil:
+			case err != nil:
+				log.Printf("failed to check whether %v has been cancelled: %v", task, err)
+			case cancelled:
+				log.Printf("%v has been cancelled, stopping its processing", task)
+				cancelJob(ErrTaskCancelled)
+				return
+			}
+		}
+	}()
+	return done
+}
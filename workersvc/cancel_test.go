@@ -0,0 +1,54 @@
+package workersvc
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCancelledTaskIsDropped(t *testing.T) {
+	ctx := context.Background()
+	queue := shared.NewMemoryTaskQueue(t.Name())
+	worker := &GPUWorker{
+		Config:             &Config{MaxAttempts: 3, LockRenewInterval: time.Minute, CancelCheckInterval: time.Millisecond},
+		TaskQueue:          queue,
+		Handlers:           map[shared.GPUTaskType]TaskHandler{},
+		FailureHandlers:    map[shared.GPUTaskType]TaskFailureHandler{},
+		CancellationChecks: map[shared.GPUTaskType]TaskCancellationCheck{},
+	}
+	var cancelled atomic.Bool
+	var cause error
+	worker.RegisterHandler(shared.GPUTaskCreateVoiceModel, func(ctx context.Context, task shared.GPUTask) error {
+		// The user cancels the task while it is being processed.
+		cancelled.Store(true)
+		<-ctx.Done()
+		cause = context.Cause(ctx)
+		return ctx.Err()
+	})
+	worker.RegisterFailureHandler(shared.GPUTaskCreateVoiceModel, func(ctx context.Context, task shared.GPUTask, taskErr error, final bool) error {
+		t.Fatal("a cancelled task must not be recorded as a failure")
+		return nil
+	})
+	worker.RegisterCancellationCheck(shared.GPUTaskCreateVoiceModel, func(ctx context.Context, task shared.GPUTask) (bool, error) {
+		return cancelled.Load(), nil
+	})
+	require.NoError(t, queue.Send(ctx, newTestTaskBody(t, shared.GPUTaskCreateVoiceModel, shared.CreateVoiceModelPayload{VoiceModelID: 1}), nil))
+	tasks, err := queue.Receive(ctx, 1)
+	require.NoError(t, err)
+	worker.processReceivedTask(ctx, tasks[0])
+	assert.ErrorIs(t, cause, ErrTaskCancelled)
+
+	// The task is neither retried nor dead-lettered.
+	receiveCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err = queue.Receive(receiveCtx, 1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	deadLetters, err := queue.ListDeadLetters(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, deadLetters)
+}
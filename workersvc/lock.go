@@ -0,0 +1,45 @@
+package workersvc
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+)
+
+// DefaultLockRenewInterval is the default interval between renewals of a task's lock while it waits for a slot and is being processed.
+// It should be well within the lock duration, which is 1 minute by default for service bus and 5 minutes for postgresql.
+const DefaultLockRenewInterval = 20 * time.Second
+
+// renewLock keeps renewing the lock of the received task until the job context is done.
+// If the lock is lost, the task may be redelivered to another worker, hence the job is cancelled with ErrTaskLockLost as the cause.
+// The returned channel is closed after the renewal stops.
+func (worker *GPUWorker) renewLock(jobCtx context.Context, cancelJob context.CancelCauseFunc, task *shared.ReceivedTask) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(worker.Config.LockRenewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-jobCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			err := worker.TaskQueue.RenewLock(jobCtx, task)
+			switch {
+			case err == nil || jobCtx.Err() != nil:
+			case errors.Is(err, shared.ErrTaskLockLost):
+				log.Printf("lost the lock of message %q, cancelling its processing", string(task.Body))
+				cancelJob(err)
+				return
+			default:
+				// The lock may still be renewed in time on the next tick.
+				log.Printf("failed to renew the lock of message %q: %v", string(task.Body), err)
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,51 @@
+package workersvc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lockLosingTaskQueue is an in-process task queue that fails to renew any lock.
+type lockLosingTaskQueue struct {
+	*shared.MemoryTaskQueue
+}
+
+func (queue lockLosingTaskQueue) RenewLock(ctx context.Context, task *shared.ReceivedTask) error {
+	return shared.ErrTaskLockLost
+}
+
+func TestLockLostCancelsTask(t *testing.T) {
+	ctx := context.Background()
+	queue := lockLosingTaskQueue{shared.NewMemoryTaskQueue(t.Name())}
+	worker := &GPUWorker{
+		Config:          &Config{MaxAttempts: 1, LockRenewInterval: time.Millisecond},
+		TaskQueue:       queue,
+		Handlers:        map[shared.GPUTaskType]TaskHandler{},
+		FailureHandlers: map[shared.GPUTaskType]TaskFailureHandler{},
+	}
+	worker.RegisterHandler(shared.GPUTaskCreateVoiceModel, func(ctx context.Context, task shared.GPUTask) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	worker.RegisterFailureHandler(shared.GPUTaskCreateVoiceModel, func(ctx context.Context, task shared.GPUTask, taskErr error, final bool) error {
+		t.Fatal("a cancelled attempt must not be recorded as a failure")
+		return nil
+	})
+	require.NoError(t, queue.Send(ctx, newTestTaskBody(t, shared.GPUTaskCreateVoiceModel, shared.CreateVoiceModelPayload{VoiceModelID: 1}), nil))
+	tasks, err := queue.Receive(ctx, 1)
+	require.NoError(t, err)
+	worker.processReceivedTask(ctx, tasks[0])
+
+	// The task is abandoned rather than retried or dead-lettered.
+	tasks, err = queue.Receive(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, tasks[0].DeliveryCount)
+	deadLetters, err := queue.ListDeadLetters(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, deadLetters)
+}
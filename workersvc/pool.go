@@ -0,0 +1,369 @@
+package workersvc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+	"github.com/HouzuoGuo/reconn-voice-clone/voiceclient"
+)
+
+// VoiceServiceSlots is the number of GPU tasks a voice service (reconn/voicesvc) instance may process concurrently.
+type VoiceServiceSlots struct {
+	// Addr is the address ("host:port") of the voice service.
+	Addr string
+	// Concurrency is the number of tasks processed by the voice service at the same time.
+	Concurrency int
+}
+
+// ParseVoiceServiceSlots parses a comma separated list of "host:port=concurrency", the concurrency defaults to 1 if omitted.
+func ParseVoiceServiceSlots(str string) ([]VoiceServiceSlots, error) {
+	var ret []VoiceServiceSlots
+	for _, item := range strings.Split(str, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		addr, concurrencyStr, hasConcurrency := strings.Cut(item, "=")
+		slots := VoiceServiceSlots{Addr: addr, Concurrency: 1}
+		if hasConcurrency {
+			concurrency, err := strconv.Atoi(concurrencyStr)
+			if err != nil || concurrency < 1 {
+				return nil, fmt.Errorf("invalid concurrency of voice service %q: %q", addr, concurrencyStr)
+			}
+			slots.Concurrency = concurrency
+		}
+		if slots.Addr == "" {
+			return nil, fmt.Errorf("missing voice service address in %q", item)
+		}
+		ret = append(ret, slots)
+	}
+	return ret, nil
+}
+
+type voiceServiceAddrKey struct{}
+
+// withVoiceServiceAddr returns a context carrying the address of the voice service assigned to the task.
+func withVoiceServiceAddr(ctx context.Context, addr string) context.Context {
+	return context.WithValue(ctx, voiceServiceAddrKey{}, addr)
+}
+
+// voiceServiceAddr returns the address of the voice service assigned to the task being processed.
+func (worker *GPUWorker) voiceServiceAddr(ctx context.Context) string {
+	if addr, ok := ctx.Value(voiceServiceAddrKey{}).(string); ok {
+		return addr
+	}
+	return worker.Config.VoiceServiceAddr
+}
+
+// voiceClient returns the client of the voice service assigned to the task being processed.
+func (worker *GPUWorker) voiceClient(ctx context.Context) *voiceclient.Client {
+	return voiceclient.New(worker.voiceServiceAddr(ctx), worker.VoiceHTTPClient)
+}
+
+// voiceServices returns the voice services and their concurrency, which default to VoiceServiceAddr with a concurrency of 1.
+func (worker *GPUWorker) voiceServices() []VoiceServiceSlots {
+	if len(worker.Config.VoiceServices) == 0 {
+		return []VoiceServiceSlots{{Addr: worker.Config.VoiceServiceAddr, Concurrency: 1}}
+	}
+	return worker.Config.VoiceServices
+}
+
+// DefaultPriorityWeights is the default share of the free slots each priority lane receives when the lanes compete.
+// An interactive task goes ahead of up to 4 waiting bulk tasks, and then a bulk task gets its turn.
+var DefaultPriorityWeights = map[shared.GPUTaskPriority]int{
+	shared.GPUTaskPriorityInteractive: 4,
+	shared.GPUTaskPriorityBulk:        1,
+}
+
+// ParsePriorityWeights parses a comma separated list of "lane=weight", the weights default to DefaultPriorityWeights if omitted.
+func ParsePriorityWeights(str string) (map[shared.GPUTaskPriority]int, error) {
+	ret := map[shared.GPUTaskPriority]int{}
+	for lane, weight := range DefaultPriorityWeights {
+		ret[lane] = weight
+	}
+	for _, item := range strings.Split(str, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		lane, weightStr, _ := strings.Cut(item, "=")
+		weight, err := strconv.Atoi(weightStr)
+		if err != nil || weight < 1 {
+			return nil, fmt.Errorf("invalid weight of priority lane %q: %q", lane, weightStr)
+		}
+		if lane == "" {
+			return nil, fmt.Errorf("missing priority lane in %q", item)
+		}
+		ret[shared.GPUTaskPriority(lane)] = weight
+	}
+	return ret, nil
+}
+
+// fairScheduler holds the received tasks waiting for a free slot.
+// It hands out the tasks of each priority lane in FIFO order, and takes turns between the lanes in proportion to their
+// weights (smooth weighted round-robin), hence the interactive tasks go ahead of a burst of bulk tasks, while the bulk
+// tasks still get their share of the slots and are never starved.
+type fairScheduler struct {
+	mutex *sync.Mutex
+	cond  *sync.Cond
+	// weights are the relative shares of the lanes, a lane without a weight has the weight of 1.
+	weights map[shared.GPUTaskPriority]int
+	// queues have the waiting tasks by lane.
+	queues map[shared.GPUTaskPriority][]*shared.ReceivedTask
+	// lanes are the lanes that have waiting tasks in order of arrival.
+	lanes []shared.GPUTaskPriority
+	// credits are the accumulated turns of the lanes that have waiting tasks.
+	credits map[shared.GPUTaskPriority]int
+	closed  bool
+}
+
+func newFairScheduler(weights map[shared.GPUTaskPriority]int) *fairScheduler {
+	mutex := new(sync.Mutex)
+	return &fairScheduler{
+		mutex:   mutex,
+		cond:    sync.NewCond(mutex),
+		weights: weights,
+		queues:  map[shared.GPUTaskPriority][]*shared.ReceivedTask{},
+		credits: map[shared.GPUTaskPriority]int{},
+	}
+}
+
+// weight returns the weight of the lane.
+func (sched *fairScheduler) weight(lane shared.GPUTaskPriority) int {
+	if weight := sched.weights[lane]; weight > 0 {
+		return weight
+	}
+	return 1
+}
+
+// push adds a received task to the queue of its lane. A malformed task queues up under the empty lane.
+func (sched *fairScheduler) push(task *shared.ReceivedTask) {
+	var lane shared.GPUTaskPriority
+	if gpuTask, err := shared.ParseGPUTask(task.Body); err == nil {
+		lane = gpuTask.Lane()
+	}
+	sched.mutex.Lock()
+	defer sched.mutex.Unlock()
+	if len(sched.queues[lane]) == 0 {
+		sched.lanes = append(sched.lanes, lane)
+	}
+	sched.queues[lane] = append(sched.queues[lane], task)
+	sched.cond.Signal()
+}
+
+// next blocks until a task is available and returns it, or returns nil after the scheduler is closed.
+func (sched *fairScheduler) next() *shared.ReceivedTask {
+	sched.mutex.Lock()
+	defer sched.mutex.Unlock()
+	for len(sched.lanes) == 0 {
+		if sched.closed {
+			return nil
+		}
+		sched.cond.Wait()
+	}
+	// Every waiting lane earns its weight in credits, and the richest lane pays the total for its turn.
+	chosen, total := 0, 0
+	for i, lane := range sched.lanes {
+		sched.credits[lane] += sched.weight(lane)
+		total += sched.weight(lane)
+		if sched.credits[lane] > sched.credits[sched.lanes[chosen]] {
+			chosen = i
+		}
+	}
+	lane := sched.lanes[chosen]
+	sched.credits[lane] -= total
+	task := sched.queues[lane][0]
+	sched.queues[lane] = sched.queues[lane][1:]
+	if len(sched.queues[lane]) == 0 {
+		// The lane starts afresh when its tasks arrive again.
+		delete(sched.queues, lane)
+		delete(sched.credits, lane)
+		sched.lanes = append(sched.lanes[:chosen], sched.lanes[chosen+1:]...)
+	}
+	return task
+}
+
+// close removes and returns the waiting tasks, and wakes up all callers of next, which return nil from now on.
+func (sched *fairScheduler) close() (waiting []*shared.ReceivedTask) {
+	sched.mutex.Lock()
+	defer sched.mutex.Unlock()
+	for _, lane := range sched.lanes {
+		waiting = append(waiting, sched.queues[lane]...)
+	}
+	sched.queues = map[shared.GPUTaskPriority][]*shared.ReceivedTask{}
+	sched.credits = map[shared.GPUTaskPriority]int{}
+	sched.lanes = nil
+	sched.closed = true
+	sched.cond.Broadcast()
+	return
+}
+
+// runPool receives tasks and processes them concurrently in the voice service slots until the context is cancelled.
+// It then abandons the tasks waiting for a slot, and gives the tasks in flight the shutdown grace period to finish, after
+// which their processing is cancelled and they are abandoned too.
+func (worker *GPUWorker) runPool(ctx context.Context) error {
+	voiceServices := worker.voiceServices()
+	sched := newFairScheduler(worker.Config.PriorityWeights)
+	// capacity has a token for each task either in flight or prefetched and waiting for a slot.
+	totalSlots := 0
+	for _, svc := range voiceServices {
+		totalSlots += svc.Concurrency
+	}
+	capacity := make(chan struct{}, totalSlots+worker.Config.Prefetch)
+	// The tasks in flight carry on after the pool stops receiving, until the grace period is over.
+	jobsCtx, cancelJobs := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelJobs()
+	prefetched := &prefetchedLocks{renewals: map[*shared.ReceivedTask]prefetchedLock{}}
+	// Each slot processes one task at a time using its voice service.
+	slotsDone := new(sync.WaitGroup)
+	for _, svc := range voiceServices {
+		slotCtx := withVoiceServiceAddr(jobsCtx, svc.Addr)
+		for i := 0; i < svc.Concurrency; i++ {
+			slotsDone.Add(1)
+			go func() {
+				defer slotsDone.Done()
+				for task := sched.next(); task != nil; task = sched.next() {
+					if err := prefetched.release(task); err != nil {
+						// The task may have been redelivered to another worker already.
+						log.Printf("dropping message %q that lost its lock while waiting for a slot: %v", string(task.Body), err)
+					} else {
+						worker.processReceivedTask(slotCtx, task)
+					}
+					<-capacity
+				}
+			}()
+		}
+	}
+	log.Printf("processing GPU tasks in %d slots of %d voice services with %d prefetched", totalSlots, len(voiceServices), worker.Config.Prefetch)
+	err := worker.receiveIntoScheduler(ctx, sched, capacity, func(task *shared.ReceivedTask) {
+		prefetched.keep(jobsCtx, worker, task)
+	})
+	// Stop taking on tasks, and return the tasks that have not started to the queue for the other workers.
+	for _, task := range sched.close() {
+		if err := prefetched.release(task); err != nil {
+			log.Printf("message %q lost its lock while waiting for a slot: %v", string(task.Body), err)
+			continue
+		}
+		if err := worker.TaskQueue.Abandon(jobsCtx, task); err != nil {
+			log.Printf("failed to abandon message %q: %v", string(task.Body), err)
+		}
+	}
+	allDone := make(chan struct{})
+	go func() {
+		slotsDone.Wait()
+		close(allDone)
+	}()
+	select {
+	case <-allDone:
+	case <-time.After(worker.Config.ShutdownGracePeriod):
+		log.Printf("cancelling the GPU tasks still in flight after the grace period of %v", worker.Config.ShutdownGracePeriod)
+		cancelJobs()
+		<-allDone
+	}
+	return err
+}
+
+// Backoff of receiving tasks again after the task queue failed to deliver them.
+const (
+	receiveRetryBaseDelay = time.Second
+	receiveRetryMaxDelay  = time.Minute
+)
+
+// receiveIntoScheduler receives as many tasks as there is capacity for, and hands them to the scheduler after calling
+// received on each of them. A failure of the task queue is retried with backoff, and only the cancellation of the
+// context stops receiving.
+func (worker *GPUWorker) receiveIntoScheduler(ctx context.Context, sched *fairScheduler, capacity chan struct{}, received func(*shared.ReceivedTask)) error {
+	retryDelay := receiveRetryBaseDelay
+	for {
+		// Wait for at least one free slot, and then take up the remaining capacity without waiting.
+		select {
+		case capacity <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		maxTasks := 1
+	fill:
+		for maxTasks < cap(capacity) {
+			select {
+			case capacity <- struct{}{}:
+				maxTasks++
+			default:
+				break fill
+			}
+		}
+		log.Printf("waiting for up to %d messages", maxTasks)
+		tasks, err := worker.TaskQueue.Receive(ctx, maxTasks)
+		if err != nil {
+			for i := 0; i < maxTasks; i++ {
+				<-capacity
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("failed to receive messages, retrying in %v: %v", retryDelay, err)
+			select {
+			case <-time.After(retryDelay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			retryDelay = min(retryDelay*2, receiveRetryMaxDelay)
+			continue
+		}
+		retryDelay = receiveRetryBaseDelay
+		for i := len(tasks); i < maxTasks; i++ {
+			<-capacity
+		}
+		for _, task := range tasks {
+			received(task)
+			sched.push(task)
+		}
+	}
+}
+
+// prefetchedLocks keeps renewing the locks of the received tasks while they wait in the scheduler for a free slot, so
+// that the task queue does not redeliver them in the meantime.
+type prefetchedLocks struct {
+	mutex    sync.Mutex
+	renewals map[*shared.ReceivedTask]prefetchedLock
+}
+
+// prefetchedLock is the lock renewal of a task waiting for a free slot.
+type prefetchedLock struct {
+	ctx  context.Context
+	stop context.CancelCauseFunc
+	done <-chan struct{}
+}
+
+// keep starts renewing the lock of the received task until it is released.
+func (locks *prefetchedLocks) keep(ctx context.Context, worker *GPUWorker, task *shared.ReceivedTask) {
+	lockCtx, stop := context.WithCancelCause(ctx)
+	done := worker.renewLock(lockCtx, stop, task)
+	locks.mutex.Lock()
+	defer locks.mutex.Unlock()
+	locks.renewals[task] = prefetchedLock{ctx: lockCtx, stop: stop, done: done}
+}
+
+// release stops renewing the lock of the task, and returns ErrTaskLockLost if the lock was lost while the task was
+// waiting.
+func (locks *prefetchedLocks) release(task *shared.ReceivedTask) error {
+	locks.mutex.Lock()
+	lock, exists := locks.renewals[task]
+	delete(locks.renewals, task)
+	locks.mutex.Unlock()
+	if !exists {
+		return nil
+	}
+	lock.stop(nil)
+	<-lock.done
+	if cause := context.Cause(lock.ctx); errors.Is(cause, shared.ErrTaskLockLost) {
+		return cause
+	}
+	return nil
+}
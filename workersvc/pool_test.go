@@ -0,0 +1,280 @@
+package workersvc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVoiceServiceSlots(t *testing.T) {
+	slots, err := ParseVoiceServiceSlots("")
+	require.NoError(t, err)
+	assert.Empty(t, slots)
+	slots, err = ParseVoiceServiceSlots("gpu0:8081=2, gpu1:8081")
+	require.NoError(t, err)
+	assert.Equal(t, []VoiceServiceSlots{{Addr: "gpu0:8081", Concurrency: 2}, {Addr: "gpu1:8081", Concurrency: 1}}, slots)
+	_, err = ParseVoiceServiceSlots("gpu0:8081=0")
+	assert.Error(t, err)
+	_, err = ParseVoiceServiceSlots("=2")
+	assert.Error(t, err)
+}
+
+func newTestTaskBody(t *testing.T, taskType shared.GPUTaskType, payload any) []byte {
+	task, err := shared.NewGPUTask(taskType, "", payload)
+	require.NoError(t, err)
+	body, err := json.Marshal(task)
+	require.NoError(t, err)
+	return body
+}
+
+func TestParsePriorityWeights(t *testing.T) {
+	weights, err := ParsePriorityWeights("")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultPriorityWeights, weights)
+	weights, err = ParsePriorityWeights("bulk=2, urgent=10")
+	require.NoError(t, err)
+	assert.Equal(t, map[shared.GPUTaskPriority]int{shared.GPUTaskPriorityInteractive: 4, shared.GPUTaskPriorityBulk: 2, "urgent": 10}, weights)
+	_, err = ParsePriorityWeights("bulk=0")
+	assert.Error(t, err)
+	_, err = ParsePriorityWeights("bulk")
+	assert.Error(t, err)
+}
+
+func TestFairScheduler(t *testing.T) {
+	sched := newFairScheduler(map[shared.GPUTaskPriority]int{shared.GPUTaskPriorityInteractive: 2, shared.GPUTaskPriorityBulk: 1})
+	for i := 0; i < 3; i++ {
+		sched.push(&shared.ReceivedTask{Body: newTestTaskBody(t, shared.GPUTaskCreateVoiceModel, shared.CreateVoiceModelPayload{VoiceModelID: int64(i)})})
+	}
+	for i := 0; i < 3; i++ {
+		sched.push(&shared.ReceivedTask{Body: newTestTaskBody(t, shared.GPUTaskConvertReplyToSpeech, shared.ConvertReplyToSpeechPayload{AIReplyVoiceID: int64(i)})})
+	}
+	var order []shared.GPUTaskType
+	for i := 0; i < 6; i++ {
+		task, err := shared.ParseGPUTask(sched.next().Body)
+		require.NoError(t, err)
+		order = append(order, task.Type)
+	}
+	// The reply conversion goes ahead of the model creation received earlier, and takes two turns for each of the
+	// model creation's, which does not wait for all of the reply conversion to go first.
+	assert.Equal(t, []shared.GPUTaskType{
+		shared.GPUTaskConvertReplyToSpeech, shared.GPUTaskCreateVoiceModel, shared.GPUTaskConvertReplyToSpeech,
+		shared.GPUTaskConvertReplyToSpeech, shared.GPUTaskCreateVoiceModel, shared.GPUTaskCreateVoiceModel,
+	}, order)
+	sched.close()
+	assert.Nil(t, sched.next())
+}
+
+func TestRunPool(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	queue := shared.NewMemoryTaskQueue(t.Name())
+	worker := &GPUWorker{
+		Config: &Config{
+			VoiceServices:       []VoiceServiceSlots{{Addr: "gpu0", Concurrency: 2}, {Addr: "gpu1", Concurrency: 1}},
+			Prefetch:            1,
+			MaxAttempts:         1,
+			LockRenewInterval:   time.Minute,
+			ShutdownGracePeriod: time.Minute,
+		},
+		TaskQueue:       queue,
+		Handlers:        map[shared.GPUTaskType]TaskHandler{},
+		FailureHandlers: map[shared.GPUTaskType]TaskFailureHandler{},
+	}
+	// The model creation blocks until released, the reply conversion finishes straight away.
+	release := make(chan struct{})
+	started := make(chan string, 10)
+	converted := make(chan struct{}, 10)
+	worker.RegisterHandler(shared.GPUTaskCreateVoiceModel, func(ctx context.Context, task shared.GPUTask) error {
+		started <- worker.voiceServiceAddr(ctx)
+		<-release
+		return nil
+	})
+	worker.RegisterHandler(shared.GPUTaskConvertReplyToSpeech, func(ctx context.Context, task shared.GPUTask) error {
+		converted <- struct{}{}
+		return nil
+	})
+	poolDone := make(chan error)
+	go func() { poolDone <- worker.runPool(ctx) }()
+
+	// All three slots work on a long task at the same time.
+	for i := 0; i < 3; i++ {
+		require.NoError(t, queue.Send(ctx, newTestTaskBody(t, shared.GPUTaskCreateVoiceModel, shared.CreateVoiceModelPayload{VoiceModelID: int64(i)}), nil))
+	}
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		select {
+		case addr := <-started:
+			addrs = append(addrs, addr)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the slots did not process tasks concurrently")
+		}
+	}
+	sort.Strings(addrs)
+	assert.Equal(t, []string{"gpu0", "gpu0", "gpu1"}, addrs)
+
+	// The pool keeps receiving while the slots are busy, and processes the task as soon as a slot frees up.
+	require.NoError(t, queue.Send(ctx, newTestTaskBody(t, shared.GPUTaskConvertReplyToSpeech, shared.ConvertReplyToSpeechPayload{AIReplyVoiceID: 1}), nil))
+	select {
+	case <-converted:
+		t.Fatal("the task must wait for a free slot")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-converted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the prefetched task was not processed")
+	}
+
+	cancel()
+	select {
+	case err := <-poolDone:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pool did not stop")
+	}
+}
+
+func TestRunPoolShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	queue := shared.NewMemoryTaskQueue(t.Name())
+	worker := &GPUWorker{
+		Config: &Config{
+			VoiceServices:       []VoiceServiceSlots{{Addr: "gpu0", Concurrency: 1}},
+			Prefetch:            1,
+			MaxAttempts:         1,
+			LockRenewInterval:   time.Minute,
+			ShutdownGracePeriod: 200 * time.Millisecond,
+		},
+		TaskQueue:       queue,
+		Handlers:        map[shared.GPUTaskType]TaskHandler{},
+		FailureHandlers: map[shared.GPUTaskType]TaskFailureHandler{},
+	}
+	// The task in flight outlasts the grace period.
+	started := make(chan struct{}, 10)
+	var cancelledAt time.Time
+	worker.RegisterHandler(shared.GPUTaskCreateVoiceModel, func(ctx context.Context, task shared.GPUTask) error {
+		started <- struct{}{}
+		<-ctx.Done()
+		cancelledAt = time.Now()
+		return ctx.Err()
+	})
+	for i := 0; i < 2; i++ {
+		require.NoError(t, queue.Send(ctx, newTestTaskBody(t, shared.GPUTaskCreateVoiceModel, shared.CreateVoiceModelPayload{VoiceModelID: int64(i)}), nil))
+	}
+	poolDone := make(chan error)
+	go func() { poolDone <- worker.runPool(ctx) }()
+	<-started
+
+	stoppedAt := time.Now()
+	cancel()
+	select {
+	case <-poolDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pool did not stop")
+	}
+	assert.GreaterOrEqual(t, cancelledAt.Sub(stoppedAt), worker.Config.ShutdownGracePeriod)
+	assert.Len(t, started, 0)
+	// Both the task in flight and the prefetched task are back in the queue.
+	tasks, err := queue.Receive(context.Background(), 10)
+	require.NoError(t, err)
+	require.Len(t, tasks, 2)
+	for _, task := range tasks {
+		assert.Equal(t, 2, task.DeliveryCount)
+	}
+}
+
+// flakyTaskQueue is an in-process task queue that fails to receive a number of times, and counts the lock renewals of
+// each task.
+type flakyTaskQueue struct {
+	*shared.MemoryTaskQueue
+	mutex           sync.Mutex
+	receiveFailures int
+	renewals        map[string]int
+}
+
+func (queue *flakyTaskQueue) Receive(ctx context.Context, maxTasks int) ([]*shared.ReceivedTask, error) {
+	queue.mutex.Lock()
+	if queue.receiveFailures > 0 {
+		queue.receiveFailures--
+		queue.mutex.Unlock()
+		return nil, errors.New("connection reset by peer")
+	}
+	queue.mutex.Unlock()
+	return queue.MemoryTaskQueue.Receive(ctx, maxTasks)
+}
+
+func (queue *flakyTaskQueue) RenewLock(ctx context.Context, task *shared.ReceivedTask) error {
+	queue.mutex.Lock()
+	queue.renewals[string(task.Body)]++
+	queue.mutex.Unlock()
+	return queue.MemoryTaskQueue.RenewLock(ctx, task)
+}
+
+func (queue *flakyTaskQueue) renewalsOf(body []byte) int {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	return queue.renewals[string(body)]
+}
+
+func TestRunPoolPrefetchedLocksAndReceiveFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	queue := &flakyTaskQueue{MemoryTaskQueue: shared.NewMemoryTaskQueue(t.Name()), receiveFailures: 1, renewals: map[string]int{}}
+	worker := &GPUWorker{
+		Config: &Config{
+			VoiceServices:       []VoiceServiceSlots{{Addr: "gpu0", Concurrency: 1}},
+			Prefetch:            1,
+			MaxAttempts:         1,
+			LockRenewInterval:   10 * time.Millisecond,
+			ShutdownGracePeriod: time.Minute,
+		},
+		TaskQueue:       queue,
+		Handlers:        map[shared.GPUTaskType]TaskHandler{},
+		FailureHandlers: map[shared.GPUTaskType]TaskFailureHandler{},
+	}
+	release := make(chan struct{})
+	processed := make(chan int64, 10)
+	worker.RegisterHandler(shared.GPUTaskCreateVoiceModel, func(ctx context.Context, task shared.GPUTask) error {
+		var payload shared.CreateVoiceModelPayload
+		require.NoError(t, task.DecodePayload(&payload))
+		<-release
+		processed <- payload.VoiceModelID
+		return nil
+	})
+	poolDone := make(chan error)
+	go func() { poolDone <- worker.runPool(ctx) }()
+
+	// The pool carries on receiving after the queue failed.
+	inFlight := newTestTaskBody(t, shared.GPUTaskCreateVoiceModel, shared.CreateVoiceModelPayload{VoiceModelID: 1})
+	waiting := newTestTaskBody(t, shared.GPUTaskCreateVoiceModel, shared.CreateVoiceModelPayload{VoiceModelID: 2})
+	require.NoError(t, queue.Send(ctx, inFlight, nil))
+	require.NoError(t, queue.Send(ctx, waiting, nil))
+	// The lock of the task waiting for the busy slot is renewed too.
+	require.Eventually(t, func() bool {
+		return queue.renewalsOf(inFlight) > 0 && queue.renewalsOf(waiting) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	close(release)
+	for _, voiceModelID := range []int64{1, 2} {
+		select {
+		case id := <-processed:
+			assert.Equal(t, voiceModelID, id)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the tasks were not processed")
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-poolDone:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pool did not stop")
+	}
+}
@@ -0,0 +1,145 @@
+package workersvc
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/db/dbgen"
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+)
+
+const (
+	// DefaultStuckTaskDeadline is the default duration a record may stay in processing before the reaper acts on it.
+	// It should comfortably exceed the task lock duration plus the maximum retry delay.
+	DefaultStuckTaskDeadline = 30 * time.Minute
+	// DefaultReaperInterval is the default interval between the reaper's passes over the stuck records.
+	DefaultReaperInterval = 1 * time.Minute
+)
+
+// reaperActions counts the reaper's actions by "table.action", e.g. "voice_models.requeued".
+// The counters are published along with the other expvar variables.
+var reaperActions = expvar.NewMap("gpu_worker_reaper_actions")
+
+// RunReaper periodically looks for the records stuck in processing, e.g. after a worker crashed mid-task, until the context is cancelled.
+func (worker *GPUWorker) RunReaper(ctx context.Context) {
+	ticker := time.NewTicker(worker.Config.ReaperInterval)
+	defer ticker.Stop()
+	for {
+		if err := worker.Reap(ctx); err != nil {
+			log.Printf("reaper failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reap makes a single pass over the records stuck in processing for longer than the deadline.
+// A stuck record's task is re-enqueued if it has attempts to spare, otherwise the record is marked failed.
+// Concurrent reapers claim each record before acting on it, hence only one of them acts on a record.
+func (worker *GPUWorker) Reap(ctx context.Context) error {
+	stuckSeconds := worker.Config.StuckTaskDeadline.Seconds()
+	models, err := worker.Database.ListStuckVoiceModels(ctx, stuckSeconds)
+	if err != nil {
+		return fmt.Errorf("list stuck voice models error: %w", err)
+	}
+	for _, model := range models {
+		err := worker.reapTask(ctx, "voice_models", model.ID, int(model.Attempts), shared.GPUTaskCreateVoiceModel,
+			shared.CreateVoiceModelPayload{VoiceModelID: model.ID},
+			func(tx *dbgen.Queries) (int64, error) {
+				return tx.ClaimStuckVoiceModelByID(ctx, dbgen.ClaimStuckVoiceModelByIDParams{
+					ID:           model.ID,
+					StuckSeconds: stuckSeconds,
+				})
+			},
+			func(tx *dbgen.Queries, errMessage sql.NullString) error {
+				return tx.FinishVoiceModelByID(ctx, dbgen.FinishVoiceModelByIDParams{ID: model.ID, Status: "failed", ErrorMessage: errMessage})
+			})
+		if err != nil {
+			return err
+		}
+	}
+	replyVoices, err := worker.Database.ListStuckAIPersonReplyVoices(ctx, stuckSeconds)
+	if err != nil {
+		return fmt.Errorf("list stuck ai person reply voices error: %w", err)
+	}
+	for _, replyVoice := range replyVoices {
+		err := worker.reapTask(ctx, "ai_person_reply_voices", replyVoice.ID, int(replyVoice.Attempts), shared.GPUTaskConvertReplyToSpeech,
+			shared.ConvertReplyToSpeechPayload{AIPersonID: replyVoice.AiPersonID, AIReplyVoiceID: replyVoice.ID},
+			func(tx *dbgen.Queries) (int64, error) {
+				return tx.ClaimStuckAIPersonReplyVoiceByID(ctx, dbgen.ClaimStuckAIPersonReplyVoiceByIDParams{
+					ID:           replyVoice.ID,
+					StuckSeconds: stuckSeconds,
+				})
+			},
+			func(tx *dbgen.Queries, errMessage sql.NullString) error {
+				return tx.FinishAIPersonReplyVoiceByID(ctx, dbgen.FinishAIPersonReplyVoiceByIDParams{ID: replyVoice.ID, Status: "failed", ErrorMessage: errMessage})
+			})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reapTask either marks a stuck record failed using the failure callback if it has run out of attempts, or re-enqueues
+// its task through the outbox. The claim callback claims the record for the reaper and releases the claim of the stuck
+// task, the record is left alone if another reaper or a worker has claimed it in the meantime. The claim and its outcome
+// are written in the same transaction, hence each claim re-enqueues exactly one task and a rolled back claim none.
+func (worker *GPUWorker) reapTask(ctx context.Context, table string, id int64, attempts int, taskType shared.GPUTaskType, payload any,
+	claim func(tx *dbgen.Queries) (int64, error), markFailed func(tx *dbgen.Queries, errMessage sql.NullString) error) error {
+	tx, err := worker.LowLevelDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	txQueries := worker.Database.WithTx(tx)
+	claimed, err := claim(txQueries)
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed to claim stuck %s record %d: %w", table, id, err), tx.Rollback())
+	} else if claimed == 0 {
+		log.Printf("stuck %s record %d has been claimed in the meantime by a worker or another reaper", table, id)
+		return tx.Rollback()
+	}
+	action := "requeued"
+	if attempts >= worker.Config.MaxAttempts {
+		// The reaper's claim carries no task ID, hence the record is not marked failed if a worker has claimed it since.
+		errMessage := fmt.Sprintf("stuck in processing for longer than %v after %d attempts", worker.Config.StuckTaskDeadline, attempts)
+		if err := markFailed(txQueries, sql.NullString{String: errMessage, Valid: true}); err != nil {
+			return errors.Join(fmt.Errorf("failed to mark stuck %s record %d failed: %w", table, id, err), tx.Rollback())
+		}
+		action = "failed"
+	} else {
+		task, err := shared.NewGPUTask(taskType, fmt.Sprintf("reaper/%s/%d", table, id), payload)
+		if err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
+		task.Attempt = attempts
+		// Whichever task claims the record first processes it, and the others skip it, including this task if the
+		// record has been handled in the meantime.
+		if err := worker.TaskOutbox.Add(ctx, txQueries, task); err != nil {
+			return errors.Join(fmt.Errorf("failed to re-enqueue %v of stuck %s record %d: %w", task, table, id, err), tx.Rollback())
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if action == "requeued" {
+		worker.TaskOutbox.Notify()
+	}
+	worker.countReaperAction(table, action, id)
+	return nil
+}
+
+// countReaperAction increments the metric of the reaper's action and logs it.
+func (worker *GPUWorker) countReaperAction(table, action string, id int64) {
+	metric := table + "." + action
+	reaperActions.Add(metric, 1)
+	log.Printf("reaper %s stuck %s record %d, metric gpu_worker_reaper_actions[%q]=%s", action, table, id, metric, reaperActions.Get(metric))
+}
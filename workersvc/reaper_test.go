@@ -0,0 +1,87 @@
+package workersvc
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/db/dbgen"
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReaper(t *testing.T) {
+	worker, _ := setupWorker(t)
+	ctx := context.Background()
+	user, err := worker.Database.CreateUser(ctx, dbgen.CreateUserParams{Name: "test-" + shared.NewID(), Status: "normal"})
+	require.NoError(t, err)
+	aiPerson, err := worker.Database.CreateAIPerson(ctx, dbgen.CreateAIPersonParams{UserID: user.ID, Name: "grandma", ContextPrompt: "You are a grandma."})
+	require.NoError(t, err)
+	voiceSample, err := worker.Database.CreateVoiceSample(ctx, dbgen.CreateVoiceSampleParams{AiPersonID: aiPerson.ID, Timestamp: time.Now()})
+	require.NoError(t, err)
+	// createStuckModel creates a voice model in processing since before the stuck task deadline.
+	createStuckModel := func(attempts int) dbgen.VoiceModel {
+		voiceModel, err := worker.Database.CreateVoiceModel(ctx, dbgen.CreateVoiceModelParams{
+			VoiceSampleID: voiceSample.ID,
+			Status:        "processing",
+			Timestamp:     time.Now().Add(-2 * worker.Config.StuckTaskDeadline),
+		})
+		require.NoError(t, err)
+		_, err = worker.LowLevelDB.ExecContext(ctx, `update voice_models set attempts = $2 where id = $1`, voiceModel.ID, attempts)
+		require.NoError(t, err)
+		return voiceModel
+	}
+	// countRequeued returns the number of tasks the reaper has re-enqueued for the voice model.
+	countRequeued := func(voiceModel dbgen.VoiceModel) (count int) {
+		traceID := fmt.Sprintf(`"traceId":"reaper/voice_models/%d"`, voiceModel.ID)
+		err := worker.LowLevelDB.QueryRowContext(ctx, `select count(*) from task_outbox where position($1 in convert_from(body, 'UTF8')) > 0`, traceID).Scan(&count)
+		require.NoError(t, err)
+		return
+	}
+
+	// The concurrent reapers re-enqueue the stuck model's task once, and release the claim of the stuck task.
+	stuck := createStuckModel(1)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, worker.Reap(ctx))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, countRequeued(stuck))
+	stuck, err = worker.Database.GetVoiceModelByID(ctx, stuck.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "processing", stuck.Status)
+	assert.False(t, stuck.TaskID.Valid)
+	// The claimed model is no longer stuck until the deadline passes again.
+	require.NoError(t, worker.Reap(ctx))
+	assert.Equal(t, 1, countRequeued(stuck))
+
+	// The stuck model that has used up its attempts is marked failed instead.
+	exhausted := createStuckModel(worker.Config.MaxAttempts)
+	require.NoError(t, worker.Reap(ctx))
+	assert.Equal(t, 0, countRequeued(exhausted))
+	exhausted, err = worker.Database.GetVoiceModelByID(ctx, exhausted.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "failed", exhausted.Status)
+	assert.Contains(t, exhausted.ErrorMessage.String, "stuck in processing")
+
+	// The model claimed by a worker since is no longer stuck, and the reaper leaves it alone.
+	claimed := createStuckModel(worker.Config.MaxAttempts)
+	_, err = worker.Database.ClaimVoiceModelByID(ctx, dbgen.ClaimVoiceModelByIDParams{
+		TaskID:       sql.NullString{String: shared.NewID(), Valid: true},
+		ID:           claimed.ID,
+		LeaseSeconds: worker.Config.StuckTaskDeadline.Seconds(),
+	})
+	require.NoError(t, err)
+	require.NoError(t, worker.Reap(ctx))
+	claimed, err = worker.Database.GetVoiceModelByID(ctx, claimed.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "processing", claimed.Status)
+}
@@ -0,0 +1,142 @@
+package workersvc
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/db/dbgen"
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+)
+
+const (
+	// DefaultHeartbeatInterval is the default interval between the worker's heartbeats in the worker registry.
+	DefaultHeartbeatInterval = 15 * time.Second
+	// DeadWorkerRetention is how long the registry remembers a worker after its last heartbeat.
+	DeadWorkerRetention = 7 * 24 * time.Hour
+)
+
+// workerStatus is what the worker reports in its heartbeats: the tasks in flight and the latest task failure.
+type workerStatus struct {
+	mutex       sync.Mutex
+	inFlight    map[string]shared.GPUTask
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// taskStarted adds the task to the tasks in flight.
+func (status *workerStatus) taskStarted(task shared.GPUTask) {
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	if status.inFlight == nil {
+		status.inFlight = map[string]shared.GPUTask{}
+	}
+	status.inFlight[task.ID] = task
+}
+
+// taskFinished removes the task from the tasks in flight, and remembers the task's error if it failed.
+func (status *workerStatus) taskFinished(task shared.GPUTask, err error) {
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	delete(status.inFlight, task.ID)
+	if err != nil {
+		status.lastError = fmt.Sprintf("%v: %v", task, err)
+		status.lastErrorAt = time.Now()
+	}
+}
+
+// heartbeat returns the heartbeat parameters of the worker's current status.
+func (status *workerStatus) heartbeat(workerID string) dbgen.HeartbeatGPUWorkerParams {
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	ret := dbgen.HeartbeatGPUWorkerParams{ID: workerID, CurrentTasks: []string{}}
+	for _, task := range status.inFlight {
+		ret.CurrentTasks = append(ret.CurrentTasks, task.String())
+	}
+	sort.Strings(ret.CurrentTasks)
+	if status.lastError != "" {
+		ret.LastError = sql.NullString{String: status.lastError, Valid: true}
+		ret.LastErrorAt = sql.NullTime{Time: status.lastErrorAt, Valid: true}
+	}
+	return ret
+}
+
+// buildVersion returns the version control revision the worker was built from, or "unknown".
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	revision, modified := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision == "" {
+		return info.Main.Version
+	} else if modified {
+		return revision + "-dirty"
+	}
+	return revision
+}
+
+// register adds the worker to the worker registry along with its capabilities.
+func (worker *GPUWorker) register(ctx context.Context) error {
+	hostName, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	params := dbgen.RegisterGPUWorkerParams{
+		ID:       worker.ID,
+		HostName: hostName,
+		Version:  buildVersion(),
+	}
+	for _, svc := range worker.voiceServices() {
+		params.VoiceServiceAddrs = append(params.VoiceServiceAddrs, svc.Addr)
+		params.Concurrency += int32(svc.Concurrency)
+	}
+	for taskType := range worker.Handlers {
+		params.TaskTypes = append(params.TaskTypes, string(taskType))
+	}
+	sort.Strings(params.TaskTypes)
+	if err := worker.Database.RegisterGPUWorker(ctx, params); err != nil {
+		return err
+	}
+	log.Printf("registered GPU worker %s on %s, version %s, task types %v, voice services %v", worker.ID, hostName, params.Version, params.TaskTypes, params.VoiceServiceAddrs)
+	return nil
+}
+
+// RunHeartbeat heartbeats periodically in the worker registry until the context is cancelled, after which the worker
+// is marked stopped. The worker should have registered itself beforehand.
+func (worker *GPUWorker) RunHeartbeat(ctx context.Context) {
+	if deleted, err := worker.Database.DeleteDeadGPUWorkers(ctx, DeadWorkerRetention.Seconds()); err != nil {
+		log.Printf("failed to delete dead GPU workers: %v", err)
+	} else if deleted > 0 {
+		log.Printf("deleted %d GPU workers that have been dead for longer than %v", deleted, DeadWorkerRetention)
+	}
+	ticker := time.NewTicker(worker.Config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := worker.Database.StopGPUWorker(context.WithoutCancel(ctx), worker.ID); err != nil {
+				log.Printf("failed to mark GPU worker %s stopped: %v", worker.ID, err)
+			}
+			return
+		case <-ticker.C:
+		}
+		if err := worker.Database.HeartbeatGPUWorker(ctx, worker.status.heartbeat(worker.ID)); err != nil {
+			log.Printf("GPU worker %s failed to heartbeat: %v", worker.ID, err)
+		}
+	}
+}
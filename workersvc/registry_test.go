@@ -0,0 +1,37 @@
+package workersvc
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkerStatus(t *testing.T) {
+	var status workerStatus
+	heartbeat := status.heartbeat("worker")
+	assert.Equal(t, "worker", heartbeat.ID)
+	assert.Empty(t, heartbeat.CurrentTasks)
+	assert.False(t, heartbeat.LastError.Valid)
+
+	task1, err := shared.NewGPUTask(shared.GPUTaskCreateVoiceModel, "", shared.CreateVoiceModelPayload{VoiceModelID: 1})
+	require.NoError(t, err)
+	task2, err := shared.NewGPUTask(shared.GPUTaskConvertReplyToSpeech, "", shared.ConvertReplyToSpeechPayload{AIReplyVoiceID: 2})
+	require.NoError(t, err)
+	status.taskStarted(task1)
+	status.taskStarted(task2)
+	assert.ElementsMatch(t, []string{task1.String(), task2.String()}, status.heartbeat("worker").CurrentTasks)
+
+	// The latest failure stays after the failed task has finished.
+	status.taskFinished(task1, errors.New("voice service is down"))
+	heartbeat = status.heartbeat("worker")
+	assert.Equal(t, []string{task2.String()}, heartbeat.CurrentTasks)
+	assert.Contains(t, heartbeat.LastError.String, "voice service is down")
+	assert.True(t, heartbeat.LastErrorAt.Valid)
+	status.taskFinished(task2, nil)
+	heartbeat = status.heartbeat("worker")
+	assert.Empty(t, heartbeat.CurrentTasks)
+	assert.Contains(t, heartbeat.LastError.String, task1.ID)
+}
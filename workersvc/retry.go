@@ -0,0 +1,180 @@
+package workersvc
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+	"github.com/HouzuoGuo/reconn-voice-clone/voiceclient"
+)
+
+const (
+	// DefaultMaxAttempts is the default maximum number of attempts of a GPU task before it is dead-lettered.
+	DefaultMaxAttempts = 5
+	// DefaultRetryBaseDelay is the default delay before retrying a GPU task after its first failed attempt.
+	DefaultRetryBaseDelay = 10 * time.Second
+	// DefaultRetryMaxDelay is the default upper limit of the exponentially growing retry delay.
+	DefaultRetryMaxDelay = 10 * time.Minute
+)
+
+const (
+	// DeadLetterMalformed is the dead letter reason of a task that cannot be parsed.
+	DeadLetterMalformed = "malformed-task"
+	// DeadLetterPermanentFailure is the dead letter reason of a task that failed and will not succeed on retry.
+	DeadLetterPermanentFailure = "permanent-failure"
+	// DeadLetterMaxAttemptsExceeded is the dead letter reason of a task that failed too many times.
+	DeadLetterMaxAttemptsExceeded = "max-attempts-exceeded"
+)
+
+// PermanentError is a task failure that will not succeed on retry, e.g. a malformed payload or a missing database record.
+type PermanentError struct {
+	Err error
+}
+
+func (err *PermanentError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *PermanentError) Unwrap() error {
+	return err.Err
+}
+
+// Permanent marks the task failure as permanent, the task will be dead-lettered instead of retried.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent returns true if the task failure will not succeed on retry, including a request rejected by the voice service.
+func IsPermanent(err error) bool {
+	var permanentErr *PermanentError
+	return errors.As(err, &permanentErr) || errors.Is(err, sql.ErrNoRows) || !voiceclient.IsRetryable(err)
+}
+
+// retryDelay returns the delay before the next attempt, doubling with each attempt up to the maximum delay.
+func (worker *GPUWorker) retryDelay(attempt int) time.Duration {
+	delay := worker.Config.RetryBaseDelay
+	for i := 0; i < attempt && delay < worker.Config.RetryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, worker.Config.RetryMaxDelay)
+}
+
+// processReceivedTask processes a task received from the queue, and then completes, retries, or dead-letters it.
+func (worker *GPUWorker) processReceivedTask(ctx context.Context, task *shared.ReceivedTask) {
+	log.Printf("received message: %v", string(task.Body))
+	gpuTask, err := shared.ParseGPUTask(task.Body)
+	if err != nil {
+		worker.deadLetter(ctx, task, DeadLetterMalformed, err)
+		return
+	}
+	// Redeliveries of the same message, e.g. after a worker crashed mid-task, count as attempts too.
+	gpuTask.Attempt += task.DeliveryCount - 1
+	// Keep the message locked for as long as the task is being processed, and stop if the user cancels the task.
+	jobCtx, cancelJob := context.WithCancelCause(ctx)
+	renewalDone := worker.renewLock(jobCtx, cancelJob, task)
+	watchDone := worker.watchCancellation(jobCtx, cancelJob, gpuTask)
+	worker.status.taskStarted(gpuTask)
+	err = worker.Process(jobCtx, gpuTask)
+	cancelled := context.Cause(jobCtx)
+	deferred, isDeferred := asDeferred(err)
+	if cancelled != nil || isDeferred {
+		worker.status.taskFinished(gpuTask, nil)
+	} else {
+		worker.status.taskFinished(gpuTask, err)
+	}
+	cancelJob(nil)
+	<-renewalDone
+	<-watchDone
+	if errors.Is(cancelled, ErrTaskCancelled) {
+		// The user no longer wants the outcome, hence the task is dropped rather than attempted again.
+		log.Printf("%v was cancelled by the user, dropping it", gpuTask)
+		if err := worker.TaskQueue.Complete(context.WithoutCancel(ctx), task); err != nil {
+			log.Printf("failed to complete %v: %v", gpuTask, err)
+		}
+		return
+	}
+	if cancelled != nil {
+		// The attempt did not run its course, so make the task available to the other workers straight away.
+		log.Printf("%v was cancelled, abandoning it: %v", gpuTask, cancelled)
+		if !errors.Is(cancelled, shared.ErrTaskLockLost) {
+			// Release the claim of the interrupted attempt so that the redelivery does not wait for its lease to expire.
+			// The claim of a lost lock stays, as the task may already be running elsewhere.
+			worker.recordFailure(context.WithoutCancel(ctx), gpuTask, fmt.Errorf("the attempt was interrupted: %w", cancelled), false)
+		}
+		if err := worker.TaskQueue.Abandon(context.WithoutCancel(ctx), task); err != nil {
+			log.Printf("failed to abandon %v: %v", gpuTask, err)
+		}
+		return
+	}
+	switch {
+	case isDeferred:
+		log.Printf("%v is %v", gpuTask, deferred)
+		worker.sendDeferred(ctx, task, gpuTask, deferred)
+	case err == nil:
+		log.Printf("successfully processed %v", gpuTask)
+		if err := worker.TaskQueue.Complete(ctx, task); err != nil {
+			log.Printf("failed to complete %v: %v", gpuTask, err)
+		}
+	case IsPermanent(err):
+		log.Printf("%v failed permanently: %v", gpuTask, err)
+		worker.recordFailure(ctx, gpuTask, err, true)
+		worker.deadLetter(ctx, task, DeadLetterPermanentFailure, err)
+	case gpuTask.Attempt+1 >= worker.Config.MaxAttempts:
+		log.Printf("%v failed and exhausted all %d attempts: %v", gpuTask, worker.Config.MaxAttempts, err)
+		worker.recordFailure(ctx, gpuTask, err, true)
+		worker.deadLetter(ctx, task, DeadLetterMaxAttemptsExceeded, err)
+	default:
+		log.Printf("%v failed and will be retried: %v", gpuTask, err)
+		worker.recordFailure(ctx, gpuTask, err, false)
+		worker.retry(ctx, task, gpuTask)
+	}
+}
+
+// recordFailure invokes the failure handler registered for the task's type, if there is one.
+func (worker *GPUWorker) recordFailure(ctx context.Context, gpuTask shared.GPUTask, taskErr error, final bool) {
+	handler, exists := worker.FailureHandlers[gpuTask.Type]
+	if !exists {
+		return
+	}
+	if err := handler(ctx, gpuTask, taskErr, final); err != nil {
+		log.Printf("failed to record the failure of %v: %v", gpuTask, err)
+	}
+}
+
+// retry enqueues the next attempt of the task with a backoff delay, and completes the current attempt.
+func (worker *GPUWorker) retry(ctx context.Context, task *shared.ReceivedTask, gpuTask shared.GPUTask) {
+	delay := worker.retryDelay(gpuTask.Attempt)
+	gpuTask.Attempt++
+	body, err := json.Marshal(gpuTask)
+	if err == nil {
+		err = worker.TaskQueue.Send(ctx, body, &shared.SendOptions{Delay: delay})
+	}
+	if err != nil {
+		// Fall back to an immediate redelivery, which still counts as an attempt.
+		log.Printf("failed to enqueue the next attempt of %v, abandoning it instead: %v", gpuTask, err)
+		if err := worker.TaskQueue.Abandon(ctx, task); err != nil {
+			log.Printf("failed to abandon %v: %v", gpuTask, err)
+		}
+		return
+	}
+	log.Printf("scheduled attempt %d of %v in %v", gpuTask.Attempt+1, gpuTask.ID, delay)
+	if err := worker.TaskQueue.Complete(ctx, task); err != nil {
+		log.Printf("failed to complete the previous attempt of %v: %v", gpuTask, err)
+	}
+}
+
+// deadLetter moves the task into the dead letter queue with the reason and error attached.
+func (worker *GPUWorker) deadLetter(ctx context.Context, task *shared.ReceivedTask, reason string, cause error) {
+	log.Printf("dead-lettering message %q due to %s: %v", string(task.Body), reason, cause)
+	if err := worker.TaskQueue.DeadLetter(ctx, task, reason, cause.Error()); err != nil {
+		log.Printf("failed to dead-letter message: %v", err)
+	}
+}
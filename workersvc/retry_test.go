@@ -0,0 +1,94 @@
+package workersvc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+	"github.com/HouzuoGuo/reconn-voice-clone/voiceclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryDelay(t *testing.T) {
+	worker := &GPUWorker{Config: &Config{RetryBaseDelay: time.Second, RetryMaxDelay: 5 * time.Second}}
+	assert.Equal(t, time.Second, worker.retryDelay(0))
+	assert.Equal(t, 2*time.Second, worker.retryDelay(1))
+	assert.Equal(t, 4*time.Second, worker.retryDelay(2))
+	assert.Equal(t, 5*time.Second, worker.retryDelay(3))
+	assert.Equal(t, 5*time.Second, worker.retryDelay(100))
+}
+
+func TestIsPermanent(t *testing.T) {
+	assert.True(t, IsPermanent(Permanent(errors.New("malformed payload"))))
+	assert.False(t, IsPermanent(errors.New("connection refused")))
+	// The voice service rejecting the request is permanent, whereas its internal failure may go away on retry.
+	assert.True(t, IsPermanent(fmt.Errorf("tts request error: %w", &voiceclient.StatusError{Endpoint: "tts-rt", StatusCode: http.StatusNotAcceptable})))
+	assert.False(t, IsPermanent(fmt.Errorf("tts request error: %w", &voiceclient.StatusError{Endpoint: "tts-rt", StatusCode: http.StatusInternalServerError})))
+}
+
+func TestProcessReceivedTask(t *testing.T) {
+	ctx := context.Background()
+	queue := shared.NewMemoryTaskQueue(t.Name())
+	worker := &GPUWorker{
+		Config:          &Config{MaxAttempts: 3, RetryBaseDelay: time.Millisecond, RetryMaxDelay: time.Millisecond, LockRenewInterval: time.Minute},
+		TaskQueue:       queue,
+		Handlers:        map[shared.GPUTaskType]TaskHandler{},
+		FailureHandlers: map[shared.GPUTaskType]TaskFailureHandler{},
+	}
+	var attempts []int
+	worker.RegisterHandler(shared.GPUTaskCreateVoiceModel, func(ctx context.Context, task shared.GPUTask) error {
+		attempts = append(attempts, task.Attempt)
+		return errors.New("voice service is unavailable")
+	})
+	var failures []bool
+	worker.RegisterFailureHandler(shared.GPUTaskCreateVoiceModel, func(ctx context.Context, task shared.GPUTask, taskErr error, final bool) error {
+		assert.EqualError(t, taskErr, "voice service is unavailable")
+		failures = append(failures, final)
+		return nil
+	})
+	receiveAndProcess := func() {
+		tasks, err := queue.Receive(ctx, 1)
+		require.NoError(t, err)
+		worker.processReceivedTask(ctx, tasks[0])
+	}
+
+	// A retryable failure is retried until the attempts are exhausted.
+	task, err := shared.NewGPUTask(shared.GPUTaskCreateVoiceModel, "", shared.CreateVoiceModelPayload{VoiceModelID: 1})
+	require.NoError(t, err)
+	body, err := json.Marshal(task)
+	require.NoError(t, err)
+	require.NoError(t, queue.Send(ctx, body, nil))
+	for i := 0; i < 3; i++ {
+		receiveAndProcess()
+	}
+	assert.Equal(t, []int{0, 1, 2}, attempts)
+	// Only the last failure is final.
+	assert.Equal(t, []bool{false, false, true}, failures)
+	deadLetters, err := queue.ListDeadLetters(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, deadLetters, 1)
+	assert.Equal(t, DeadLetterMaxAttemptsExceeded, deadLetters[0].Reason)
+	assert.Equal(t, "voice service is unavailable", deadLetters[0].Description)
+
+	// Malformed tasks and tasks without a handler are dead-lettered straight away.
+	require.NoError(t, queue.Send(ctx, []byte(`{"VoiceModelID": 1}`), nil))
+	receiveAndProcess()
+	task, err = shared.NewGPUTask("unknown-task-type", "", struct{}{})
+	require.NoError(t, err)
+	body, err = json.Marshal(task)
+	require.NoError(t, err)
+	require.NoError(t, queue.Send(ctx, body, nil))
+	receiveAndProcess()
+	deadLetters, err = queue.ListDeadLetters(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, deadLetters, 3)
+	assert.Equal(t, DeadLetterMalformed, deadLetters[1].Reason)
+	assert.Equal(t, DeadLetterPermanentFailure, deadLetters[2].Reason)
+	assert.Len(t, attempts, 3)
+}
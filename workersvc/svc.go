@@ -1,40 +1,43 @@
 package workersvc
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
-	"strings"
+	"strconv"
 	"time"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
-	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	"github.com/HouzuoGuo/reconn-voice-clone/db"
 	"github.com/HouzuoGuo/reconn-voice-clone/db/dbgen"
 	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+	"github.com/HouzuoGuo/reconn-voice-clone/textnorm"
 )
 
+// DefaultShutdownGracePeriod is the default duration the tasks in flight may carry on after the worker is told to stop.
+// It should leave enough time for the rest of the shutdown within the typical 30 seconds a container is given to stop.
+const DefaultShutdownGracePeriod = 20 * time.Second
+
 // Config has the configuration of the GPU worker service itself and its external dependencies.
 type Config struct {
 	// Database configuration.
 	Database db.Config
-	// ServiceBusConnectionString  the azure sas connection string of service bus.
-	ServiceBusConnection string
-	// ServiceBusQueue is the name of azure service bus queue.
-	ServiceBusQueue string
-	// BlobConnectionString is the azure sas connection string of blob storage.
-	BlobConnectionString string
+	// TaskQueue is the GPU task queue backend configuration.
+	TaskQueue shared.TaskQueueConfig
+	// BlobStore is the blob storage backend configuration.
+	BlobStore shared.BlobStoreConfig
 
 	// VoiceSampleDir is the path to the directory of incoming user voice samples.
 	VoiceSampleDir string
 	// VoiceModelDir is path to the directory of constructed user voice models.
 	VoiceModelDir string
+	// VoiceModelDirShared declares that each voice service reads and writes the voice models in VoiceModelDir too, e.g. a
+	// network file system mounted by all of them. It is required by more than one voice service, because the clone and
+	// TTS requests name the model files in VoiceModelDir without transferring them.
+	VoiceModelDirShared bool
 	// VoiceTempModelDir is the path to the directory of temporary user voice models used during TTS.
 	VoiceTempModelDir string
 	// VoiceOutputDir is the path to the directory of TTS output files.
@@ -46,231 +49,433 @@ type Config struct {
 	VoiceModelContainer string
 	// VoiceSampleContainer is the blob container name of the voice output files.
 	VoiceOutputContainer string
+	// TTSCache has the settings of the cache of synthesized speech.
+	TTSCache shared.TTSCacheConfig
+	// TextNormLocale is the locale of the words the reply text is spoken in, it defaults to textnorm.DefaultLocale.
+	TextNormLocale string
 
 	// VoiceServiceAddr is the address ("host:port") of the voice service (reconn/voicesvc).
 	VoiceServiceAddr string
+	// VoiceServices are the voice service instances and their concurrency, they default to VoiceServiceAddr with a concurrency of 1.
+	VoiceServices []VoiceServiceSlots
+	// Prefetch is the number of tasks received in advance of a slot becoming free.
+	// The prefetched tasks are where the priority lanes pick their tasks from, hence a larger prefetch lets an
+	// interactive task overtake more bulk tasks.
+	Prefetch int
+	// PriorityWeights are the relative shares of the slots of the priority lanes, they default to DefaultPriorityWeights.
+	PriorityWeights map[shared.GPUTaskPriority]int
+	// LockRenewInterval is the interval between renewals of a task's lock while it is being processed.
+	LockRenewInterval time.Duration
+	// ShutdownGracePeriod is how long the tasks in flight may carry on after the worker is told to stop.
+	ShutdownGracePeriod time.Duration
+	// ModelAffinityTimeout is how long a TTS task waits for a worker that has its voice model cached, after which any
+	// worker processes it. A negative timeout disables the routing by voice model.
+	ModelAffinityTimeout time.Duration
+	// WarmUpActiveAIPersons is the number of the most active AI persons whose voice models the worker warms up on
+	// startup, a negative number disables the warm-up.
+	WarmUpActiveAIPersons int
+	// WarmUpActivityWindow is the period of prompts which determines the most active AI persons.
+	WarmUpActivityWindow time.Duration
+	// HeartbeatInterval is the interval between the worker's heartbeats in the worker registry.
+	HeartbeatInterval time.Duration
+	// CancelCheckInterval is the interval between checks of whether a task in flight has been cancelled by the user.
+	CancelCheckInterval time.Duration
+
+	// MaxAttempts is the maximum number of attempts of a GPU task before it is dead-lettered.
+	MaxAttempts int
+	// RetryBaseDelay is the delay before retrying a GPU task after its first failed attempt, it doubles with each attempt.
+	RetryBaseDelay time.Duration
+	// RetryMaxDelay is the upper limit of the delay before retrying a GPU task.
+	RetryMaxDelay time.Duration
+
+	// StuckTaskDeadline is how long a record may stay in processing before the reaper re-enqueues its task or marks it failed.
+	StuckTaskDeadline time.Duration
+	// ReaperInterval is the interval between the reaper's passes over the stuck records, a negative interval disables the reaper.
+	ReaperInterval time.Duration
+	// OutboxPollInterval is the interval between the outbox relay's checks for the tasks re-enqueued by the reaper.
+	OutboxPollInterval time.Duration
 }
 
+// TaskHandler processes a GPU task of a specific type.
+type TaskHandler func(ctx context.Context, task shared.GPUTask) error
+
+// TaskFailureHandler records the failure of a GPU task in the database record the task works on.
+// The failure is final if the task has been dead-lettered and will not be attempted again.
+type TaskFailureHandler func(ctx context.Context, task shared.GPUTask, taskErr error, final bool) error
+
 type GPUWorker struct {
+	// ID identifies the worker in the worker registry, it is generated when the worker starts.
+	ID string
 	// Config has the GPU worker configuration and its external dependencies.
 	Config *Config
-	// VoiceClient is an HTTP client for the voice service (reconn/voicesvc).
-	VoiceClient *http.Client
+	// VoiceHTTPClient is the HTTP client shared by the clients of the voice services (reconn/voicesvc).
+	VoiceHTTPClient *http.Client
 	// LowLevelDB is an initialised low-level sql.DB database client.
 	LowLevelDB *sql.DB
 	// Database is the high level & strongly typed reconn DB client.
 	Database *dbgen.Queries
-	// BlobClient is the azure blob storage client.
-	BlobClient *azblob.Client
-	// ServiceBusClient is the azure service bus client.
-	ServiceBusClient *azservicebus.Client
-	// ServiceBusSender is the azure service bus receiver client.
-	ServiceBusReceiver *azservicebus.Receiver
+	// BlobStore is the blob storage client of voice samples, models, and output files.
+	BlobStore shared.BlobStore
+	// TaskQueue is the queue of tasks for the GPU workers.
+	TaskQueue shared.TaskQueue
+	// TTSCache caches the synthesized speech.
+	TTSCache *shared.TTSCache
+	// TextNormalizer turns the reply text into the text spoken by the voice service.
+	TextNormalizer *textnorm.Normalizer
+	// TaskOutbox holds the GPU tasks re-enqueued by the reaper until they are sent to the task queue.
+	TaskOutbox *shared.TaskOutbox
+	// Handlers process the received tasks according to their type.
+	Handlers map[shared.GPUTaskType]TaskHandler
+	// FailureHandlers record the failed attempts of the tasks according to their type.
+	FailureHandlers map[shared.GPUTaskType]TaskFailureHandler
+	// CancellationChecks tell whether the tasks in flight have been cancelled according to their type.
+	CancellationChecks map[shared.GPUTaskType]TaskCancellationCheck
+
+	// status is reported in the heartbeats.
+	status workerStatus
 }
 
 // New returns a newly initialised instance of the GPU worker service.
 func New(conf *Config) (*GPUWorker, error) {
+	if len(conf.VoiceServices) > 1 && !conf.VoiceModelDirShared {
+		return nil, fmt.Errorf("the %d voice services require the voice model directory %q to be shared with each of them", len(conf.VoiceServices), conf.VoiceModelDir)
+	}
+	if conf.MaxAttempts < 1 {
+		conf.MaxAttempts = DefaultMaxAttempts
+	}
+	if conf.RetryBaseDelay <= 0 {
+		conf.RetryBaseDelay = DefaultRetryBaseDelay
+	}
+	if conf.RetryMaxDelay < conf.RetryBaseDelay {
+		conf.RetryMaxDelay = max(DefaultRetryMaxDelay, conf.RetryBaseDelay)
+	}
+	if conf.ShutdownGracePeriod <= 0 {
+		conf.ShutdownGracePeriod = DefaultShutdownGracePeriod
+	}
+	if conf.LockRenewInterval <= 0 {
+		conf.LockRenewInterval = DefaultLockRenewInterval
+	}
+	if conf.ModelAffinityTimeout == 0 {
+		conf.ModelAffinityTimeout = DefaultModelAffinityTimeout
+	}
+	if conf.WarmUpActiveAIPersons == 0 {
+		conf.WarmUpActiveAIPersons = DefaultWarmUpActiveAIPersons
+	}
+	if conf.WarmUpActivityWindow <= 0 {
+		conf.WarmUpActivityWindow = DefaultWarmUpActivityWindow
+	}
+	if conf.HeartbeatInterval <= 0 {
+		conf.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if conf.CancelCheckInterval <= 0 {
+		conf.CancelCheckInterval = DefaultCancelCheckInterval
+	}
+	if conf.Prefetch < 0 {
+		conf.Prefetch = 0
+	}
+	if conf.PriorityWeights == nil {
+		conf.PriorityWeights = DefaultPriorityWeights
+	}
+	if conf.StuckTaskDeadline <= 0 {
+		conf.StuckTaskDeadline = DefaultStuckTaskDeadline
+	}
+	if conf.ReaperInterval == 0 {
+		conf.ReaperInterval = DefaultReaperInterval
+	}
+	if conf.TextNormLocale == "" {
+		conf.TextNormLocale = textnorm.DefaultLocale
+	}
+	textNormalizer, err := textnorm.New(conf.TextNormLocale)
+	if err != nil {
+		return nil, err
+	}
 	worker := &GPUWorker{
+		ID:     shared.NewID(),
 		Config: conf,
 		// The real-time voice service endpoint relays (mainly for development & testing) require a generous amount of timeout.
-		VoiceClient: &http.Client{Timeout: 5 * time.Minute},
+		VoiceHTTPClient:    &http.Client{Timeout: 5 * time.Minute},
+		TextNormalizer:     textNormalizer,
+		Handlers:           map[shared.GPUTaskType]TaskHandler{},
+		FailureHandlers:    map[shared.GPUTaskType]TaskFailureHandler{},
+		CancellationChecks: map[shared.GPUTaskType]TaskCancellationCheck{},
 	}
-	var err error
+	worker.RegisterHandler(shared.GPUTaskCreateVoiceModel, worker.createVoiceModel)
+	worker.RegisterFailureHandler(shared.GPUTaskCreateVoiceModel, worker.createVoiceModelFailed)
+	worker.RegisterCancellationCheck(shared.GPUTaskCreateVoiceModel, worker.createVoiceModelCancelled)
+	worker.RegisterHandler(shared.GPUTaskConvertReplyToSpeech, worker.convertReplyToSpeech)
+	worker.RegisterFailureHandler(shared.GPUTaskConvertReplyToSpeech, worker.convertReplyToSpeechFailed)
+	worker.RegisterCancellationCheck(shared.GPUTaskConvertReplyToSpeech, worker.convertReplyToSpeechCancelled)
+	worker.RegisterHandler(shared.GPUTaskWarmUpVoiceModel, worker.warmUpVoiceModel)
 	// Connect to DB.
 	worker.LowLevelDB, worker.Database, err = db.Connect(conf.Database)
 	if err != nil {
-		log.Fatalf("failed to connect to database: %v", err)
-		return nil, err
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 	log.Printf("successfully connected to database %v:%v, stats: %+v", conf.Database.Host, conf.Database.Port, worker.LowLevelDB.Stats())
-	// Connect to Azure blob storage.
-	worker.BlobClient, err = azblob.NewClientFromConnectionString(conf.BlobConnectionString, nil)
+	// Connect to blob storage.
+	worker.BlobStore, err = shared.NewBlobStore(context.Background(), conf.BlobStore)
 	if err != nil {
-		log.Fatalf("failed to connect to azure blob storage: %v", err)
-		return nil, err
-	}
-	blobProps, err := worker.BlobClient.ServiceClient().GetProperties(context.Background(), nil)
-	if err != nil {
-		log.Fatalf("failed to connect to azure blob storage: %v", err)
-		return nil, err
-	}
-	log.Printf("successfully connected to azure storage (err? %v), %+#v", err, blobProps)
-	// Connect to azure service bus.
-	worker.ServiceBusClient, err = azservicebus.NewClientFromConnectionString(conf.ServiceBusConnection, nil)
-	if err != nil {
-		log.Fatalf("failed to connect to azure service bus: %v", err)
-		return nil, err
+		return nil, fmt.Errorf("failed to connect to blob storage: %w", err)
 	}
-	// Connect to azure service bus.
-	worker.ServiceBusReceiver, err = worker.ServiceBusClient.NewReceiverForQueue(conf.ServiceBusQueue, nil)
+	log.Printf("successfully connected to %q blob storage", conf.BlobStore.Backend)
+	worker.TTSCache = shared.NewTTSCache(worker.Database, worker.BlobStore, conf.TTSCache)
+	// Connect to the GPU task queue.
+	worker.TaskQueue, err = shared.NewTaskQueue(conf.TaskQueue, worker.LowLevelDB)
 	if err != nil {
-		log.Fatalf("failed to connect to azure service bus: %v", err)
-		return nil, err
+		return nil, fmt.Errorf("failed to connect to %q task queue: %w", conf.TaskQueue.Backend, err)
 	}
+	worker.TaskOutbox = shared.NewTaskOutbox(worker.LowLevelDB, worker.TaskQueue, conf.OutboxPollInterval)
 	return worker, nil
 }
 
-func (worker *GPUWorker) Run() error {
-	for {
-		log.Printf("waiting for the next message")
-		messages, err := worker.ServiceBusReceiver.ReceiveMessages(context.Background(), 1, nil)
-		if err != nil {
-			log.Printf("failed to receive messages: %v", err)
-			return err
-		}
-		for _, msg := range messages {
-			log.Printf("received message: %v", string(msg.Body))
-			var gpuTask shared.GPUTask
-			if err := json.Unmarshal(msg.Body, &gpuTask); err != nil {
-				log.Printf("failed to unmarshal message body %q: %v", string(msg.Body), err)
-			}
-			worker.Process(gpuTask)
-			if err := worker.ServiceBusReceiver.CompleteMessage(context.Background(), msg, nil); err != nil {
-				log.Printf("failed to complete message: %v", err)
-			}
-		}
+// Run receives the GPU tasks and processes them concurrently in the voice service slots, until the task queue fails
+// or the context is cancelled. Once cancelled, it stops receiving tasks and lets the tasks in flight finish within the
+// shutdown grace period, it then abandons the unfinished tasks for redelivery and returns nil.
+func (worker *GPUWorker) Run(ctx context.Context) error {
+	if worker.Config.ReaperInterval > 0 {
+		go worker.TaskOutbox.Run(ctx)
+		go worker.RunReaper(ctx)
+	}
+	if err := worker.register(ctx); err != nil {
+		log.Printf("failed to register GPU worker %s: %v", worker.ID, err)
 	}
+	if worker.Config.WarmUpActiveAIPersons > 0 {
+		go worker.warmUpActiveModels(ctx)
+	}
+	// The worker stays registered as alive until the tasks in flight have finished.
+	heartbeatCtx, stopHeartbeat := context.WithCancel(context.WithoutCancel(ctx))
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		worker.RunHeartbeat(heartbeatCtx)
+	}()
+	err := worker.runPool(ctx)
+	stopHeartbeat()
+	<-heartbeatDone
+	if ctx.Err() != nil {
+		log.Printf("GPU worker stopped")
+		return nil
+	}
+	return err
 }
 
-func (worker *GPUWorker) Process(task shared.GPUTask) {
-	if task.VoiceModelID > 0 {
-		worker.createVoiceModel(context.Background(), task)
-	} else if task.AIReplyVoiceID > 0 {
-		worker.convertReplyToSpeech(context.Background(), task)
+// Close releases the task queue client and database connections.
+func (worker *GPUWorker) Close(ctx context.Context) error {
+	return errors.Join(worker.TaskQueue.Close(ctx), worker.LowLevelDB.Close())
+}
+
+// RegisterHandler registers the handler of a type of GPU task, replacing the existing handler of the same type.
+func (worker *GPUWorker) RegisterHandler(taskType shared.GPUTaskType, handler TaskHandler) {
+	worker.Handlers[taskType] = handler
+}
+
+// RegisterFailureHandler registers the failure handler of a type of GPU task, replacing the existing failure handler of the same type.
+func (worker *GPUWorker) RegisterFailureHandler(taskType shared.GPUTaskType, handler TaskFailureHandler) {
+	worker.FailureHandlers[taskType] = handler
+}
+
+// Process dispatches the task to the handler registered for its type.
+// The handler returns a PermanentError if the task shall not be retried.
+func (worker *GPUWorker) Process(ctx context.Context, task shared.GPUTask) error {
+	handler, exists := worker.Handlers[task.Type]
+	if !exists {
+		return Permanent(fmt.Errorf("no handler registered for %q tasks", task.Type))
 	}
+	return handler(ctx, task)
 }
 
-func (worker *GPUWorker) createVoiceModel(ctx context.Context, task shared.GPUTask) {
-	voiceModelID := task.VoiceModelID
-	wipModel, err := worker.Database.GetVoiceModelByID(ctx, int64(voiceModelID))
+func (worker *GPUWorker) createVoiceModel(ctx context.Context, task shared.GPUTask) error {
+	var payload shared.CreateVoiceModelPayload
+	if err := task.DecodePayload(&payload); err != nil {
+		return Permanent(err)
+	}
+	wipModel, err := worker.Database.GetVoiceModelByID(ctx, payload.VoiceModelID)
 	if err != nil {
-		log.Printf("failed to get voice model by id: %v", err)
-		return
+		return fmt.Errorf("failed to get voice model by id: %w", err)
+	}
+	// Duplicate deliveries of the task must not clone the voice again, though a task requeued from the dead letters clones the failed model again.
+	claimed, err := worker.Database.ClaimVoiceModelByID(ctx, dbgen.ClaimVoiceModelByIDParams{
+		TaskID:       sql.NullString{String: task.ID, Valid: true},
+		ID:           wipModel.ID,
+		LeaseSeconds: worker.Config.StuckTaskDeadline.Seconds(),
+		Requeued:     task.Requeued,
+	})
+	if err != nil {
+		return fmt.Errorf("claim voice model error: %w", err)
+	} else if claimed == 0 {
+		log.Printf("skipping %v because voice model %d is %s or claimed by task %s", task, wipModel.ID, wipModel.Status, wipModel.TaskID.String)
+		return nil
 	}
 	// Retrieve the sample record from database.
 	voiceSample, err := worker.Database.GetVoiceSampleByID(ctx, wipModel.VoiceSampleID)
 	if err != nil {
-		log.Printf("get voice sample by id error: %+v", err)
-		return
+		return fmt.Errorf("get voice sample by id error: %w", err)
 	}
 	// Retrieve the sample wave file from blob storage.
-	localFilePath, err := shared.DownloadBlobToLocalFileIfNotExist(ctx, worker.BlobClient, worker.Config.VoiceSampleContainer, voiceSample.FileName.String, worker.Config.VoiceSampleDir)
+	localFilePath, err := shared.DownloadBlobToLocalFileIfNotExist(ctx, worker.BlobStore, worker.Config.VoiceSampleContainer, voiceSample.FileName.String, worker.Config.VoiceSampleDir)
 	if err != nil {
-		log.Printf("blob download file error: %v", err)
-		return
+		return fmt.Errorf("blob download file error: %w", err)
 	}
 	// Open the wave file from local disk.
 	voiceSampleFile, err := os.Open(localFilePath)
-	defer voiceSampleFile.Close()
 	if err != nil {
-		return
+		return err
 	}
+	defer voiceSampleFile.Close()
 	// Relay the clone request to voice service.
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/clone-rt/%d", worker.Config.VoiceServiceAddr, voiceModelID), voiceSampleFile)
-	req.Header.Set("content-type", "audio/wav")
-	if err != nil {
-		log.Printf("failed to construct clone-rt request: %v", err)
-		return
-	}
-	resp, err := worker.VoiceClient.Do(req)
+	cloneResp, err := worker.voiceClient(ctx).Clone(ctx, strconv.FormatInt(payload.VoiceModelID, 10), voiceSampleFile)
 	if err != nil {
-		log.Printf("failed to make clone-rt request: %v", err)
-		return
-	}
-	log.Printf("clone-rt responded with status %d and content length %d", resp.StatusCode, resp.ContentLength)
-	var cloneResp shared.CloneRealTimeResponse
-	if err := json.NewDecoder(resp.Body).Decode(&cloneResp); err != nil {
-		log.Printf("failed to deserialise clone-rt response: %v", err)
-		return
+		return fmt.Errorf("clone request error: %w", err)
 	}
 	// Store the voice model in blob storage.
-	if err := shared.UploadFromLocalFile(ctx, worker.BlobClient, worker.Config.VoiceModelContainer, cloneResp.ModelDestinationFile, worker.Config.VoiceModelDir); err != nil {
-		log.Printf("upload from local file error: %+v", err)
-		return
+	if err := shared.UploadFromLocalFile(ctx, worker.BlobStore, worker.Config.VoiceModelContainer, cloneResp.ModelDestinationFile, worker.Config.VoiceModelDir); err != nil {
+		return fmt.Errorf("upload from local file error: %w", err)
 	}
+	// The TTS tasks of the new model prefer this worker, which has the model in its local disk.
+	worker.recordModelCached(ctx, cloneResp.ModelDestinationFile)
 	// Update the voice model record in database.
-	err = worker.Database.UpdateVoiceModelByID(ctx, dbgen.UpdateVoiceModelByIDParams{
-		ID:       int64(voiceModelID),
+	err = worker.Database.FinishVoiceModelByID(ctx, dbgen.FinishVoiceModelByIDParams{
+		ID:       payload.VoiceModelID,
 		Status:   "ready",
 		FileName: sql.NullString{String: cloneResp.ModelDestinationFile, Valid: true},
+		TaskID:   sql.NullString{String: task.ID, Valid: true},
 	})
 	if err != nil {
-		log.Printf("update voice model by id error: %+v", err)
-		return
+		return fmt.Errorf("finish voice model by id error: %w", err)
+	}
+	// Get the other workers ready for the TTS of the new model.
+	if err := worker.fanOutWarmUp(ctx, voiceSample.AiPersonID, cloneResp.ModelDestinationFile); err != nil {
+		log.Printf("failed to fan out the warm-up of voice model %q: %v", cloneResp.ModelDestinationFile, err)
 	}
+	return nil
 }
 
-func (worker *GPUWorker) convertReplyToSpeech(ctx context.Context, task shared.GPUTask) {
-	aiReplyVoiceID := task.AIReplyVoiceID
-	wipReplyVoice, err := worker.Database.GetAIPersonReplyVoiceByID(ctx, int64(aiReplyVoiceID))
-	if err != nil {
-		log.Printf("failed to get reply voice by id: %v", err)
-		return
+// createVoiceModelFailed records the error of a failed attempt to create a voice model, and marks the model failed if the failure is final.
+func (worker *GPUWorker) createVoiceModelFailed(ctx context.Context, task shared.GPUTask, taskErr error, final bool) error {
+	var payload shared.CreateVoiceModelPayload
+	if err := task.DecodePayload(&payload); err != nil {
+		return err
 	}
-	// Retrieve the reply content record from database.
-	aiReply, err := worker.Database.GetAIPersonReplyByID(ctx, wipReplyVoice.AiPersonReplyID)
+	errMessage := sql.NullString{String: taskErr.Error(), Valid: true}
+	taskID := sql.NullString{String: task.ID, Valid: true}
+	if !final {
+		return worker.Database.UpdateVoiceModelErrorByID(ctx, dbgen.UpdateVoiceModelErrorByIDParams{ID: payload.VoiceModelID, ErrorMessage: errMessage, TaskID: taskID})
+	}
+	return worker.Database.FinishVoiceModelByID(ctx, dbgen.FinishVoiceModelByIDParams{ID: payload.VoiceModelID, Status: "failed", ErrorMessage: errMessage, TaskID: taskID})
+}
+
+func (worker *GPUWorker) createVoiceModelCancelled(ctx context.Context, task shared.GPUTask) (bool, error) {
+	var payload shared.CreateVoiceModelPayload
+	if err := task.DecodePayload(&payload); err != nil {
+		return false, err
+	}
+	voiceModel, err := worker.Database.GetVoiceModelByID(ctx, payload.VoiceModelID)
+	return voiceModel.Status == "cancelled", err
+}
+
+func (worker *GPUWorker) convertReplyToSpeech(ctx context.Context, task shared.GPUTask) error {
+	var payload shared.ConvertReplyToSpeechPayload
+	if err := task.DecodePayload(&payload); err != nil {
+		return Permanent(err)
+	}
+	wipReplyVoice, err := worker.Database.GetAIPersonReplyVoiceByID(ctx, payload.AIReplyVoiceID)
 	if err != nil {
-		log.Printf("get voice sample by id error: %+v", err)
-		return
+		return fmt.Errorf("failed to get reply voice by id: %w", err)
 	}
 	// Read the voice model and context prompt from this AI person.
-	aiPersonAndModel, err := worker.Database.GetLatestVoiceModel(ctx, int64(task.AIReplyPersonID))
+	aiPersonAndModel, err := worker.Database.GetLatestVoiceModel(ctx, payload.AIPersonID)
 	if err != nil {
-		log.Printf("get latest voice model error: %+v", err)
-		return
+		return fmt.Errorf("get latest voice model error: %w", err)
 	}
 	log.Printf("ai person and model: %+v", aiPersonAndModel)
-	// Download the model file to local disk and then relay to python voice server.
-	if _, err := shared.DownloadBlobToLocalFileIfNotExist(ctx, worker.BlobClient, worker.Config.VoiceModelContainer, aiPersonAndModel.FileName.String, worker.Config.VoiceModelDir); err != nil {
-		log.Printf("download model error: %v", err)
-		return
-	}
-	// Convert the reply into voice.
-	ttsRequestBody, err := json.Marshal(shared.TextToSpeechRealTimeRequest{
-		Text:         aiReply.Message,
-		TopK:         99,
-		TopP:         0.8,
-		MineosP:      0.01,
-		SemanticTemp: 0.8,
-		WaveformTemp: 0.6,
-		FineTemp:     0.5,
+	// Leave the task to a worker that has the voice model cached if there is one.
+	if wipReplyVoice.Status == "processing" || task.Requeued {
+		if err := worker.routeByModel(ctx, task, aiPersonAndModel.FileName.String); err != nil {
+			return err
+		}
+	}
+	// Duplicate deliveries of the task must not generate and overwrite the speech again, though a task requeued from the dead letters retries the failed reply voice.
+	claimed, err := worker.Database.ClaimAIPersonReplyVoiceByID(ctx, dbgen.ClaimAIPersonReplyVoiceByIDParams{
+		TaskID:       sql.NullString{String: task.ID, Valid: true},
+		ID:           wipReplyVoice.ID,
+		LeaseSeconds: worker.Config.StuckTaskDeadline.Seconds(),
+		Requeued:     task.Requeued,
 	})
 	if err != nil {
-		log.Printf("tts request construction error: %v", err)
-		return
+		return fmt.Errorf("claim ai person reply voice error: %w", err)
+	} else if claimed == 0 {
+		log.Printf("skipping %v because reply voice %d is %s or claimed by task %s", task, wipReplyVoice.ID, wipReplyVoice.Status, wipReplyVoice.TaskID.String)
+		return nil
 	}
-	ttsRequest, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/tts-rt/%s", worker.Config.VoiceServiceAddr, strings.TrimSuffix(aiPersonAndModel.FileName.String, ".npz")), bytes.NewReader(ttsRequestBody))
-	ttsRequest.Header.Set("content-type", "application/json")
+	// Retrieve the reply content record from database.
+	aiReply, err := worker.Database.GetAIPersonReplyByID(ctx, wipReplyVoice.AiPersonReplyID)
 	if err != nil {
-		log.Printf("tts request construction error: %v", err)
-		return
+		return fmt.Errorf("get ai person reply by id error: %w", err)
 	}
-	ttsResponse, err := worker.VoiceClient.Do(ttsRequest)
+	// Download the model file to local disk and then relay to python voice server.
+	modelPath, err := shared.DownloadBlobToLocalFileIfNotExist(ctx, worker.BlobStore, worker.Config.VoiceModelContainer, aiPersonAndModel.FileName.String, worker.Config.VoiceModelDir)
 	if err != nil {
-		log.Printf("tts request error: %v", err)
-		return
+		return fmt.Errorf("download model error: %w", err)
 	}
-	log.Printf("tts-rt responded with status %d and content length %d", ttsResponse.StatusCode, ttsResponse.ContentLength)
-	ttsWaveContent, err := io.ReadAll(ttsResponse.Body)
+	worker.recordModelCached(ctx, aiPersonAndModel.FileName.String)
+	// Convert the reply into voice, unless the same speech has been synthesized before.
+	ttsSettings, err := shared.ResolveTTSSettings(ctx, worker.Database, payload.AIPersonID, aiPersonAndModel.ID)
 	if err != nil {
-		log.Printf("failed to read tts response body: %v", err)
-		return
+		return err
+	}
+	ttsRequest := shared.TextToSpeechRealTimeRequest{
+		// The reply record keeps the text as the LLM wrote it.
+		Text:        worker.TextNormalizer.Normalize(shared.ReplySpeech(aiReply)),
+		TTSSettings: ttsSettings.TTSSettings,
+	}
+	ttsWaveContent, err := worker.TTSCache.Synthesize(ctx, modelPath, ttsRequest, func() ([]byte, error) {
+		wavContent, err := worker.voiceClient(ctx).Synthesize(ctx, aiPersonAndModel.FileName.String, ttsRequest)
+		if err != nil {
+			return nil, fmt.Errorf("tts request error: %w", err)
+		}
+		return wavContent, nil
+	})
+	if err != nil {
+		return err
 	}
 	// Save the converted speech.
 	timestamp := time.Now()
-	fileName := fmt.Sprintf("%d-%s.wav", task.AIReplyPersonID, timestamp.Format(time.RFC3339))
-	if _, err := shared.UploadAndSave(ctx, worker.BlobClient, worker.Config.VoiceOutputContainer, fileName, worker.Config.VoiceOutputDir, ttsWaveContent); err != nil {
-		log.Printf("upload and save error: %v", err)
-		return
+	fileName := fmt.Sprintf("%d-%s.wav", payload.AIPersonID, timestamp.Format(time.RFC3339))
+	if _, err := shared.UploadAndSave(ctx, worker.BlobStore, worker.Config.VoiceOutputContainer, fileName, worker.Config.VoiceOutputDir, ttsWaveContent); err != nil {
+		return fmt.Errorf("upload and save error: %w", err)
 	}
 	// Update the AI reply voice record.
-	err = worker.Database.UpdateAIPersonReplyVoiceStatusByID(ctx, dbgen.UpdateAIPersonReplyVoiceStatusByIDParams{
-		ID:       int64(aiReplyVoiceID),
+	err = worker.Database.FinishAIPersonReplyVoiceByID(ctx, dbgen.FinishAIPersonReplyVoiceByIDParams{
+		ID:       payload.AIReplyVoiceID,
 		Status:   "ready",
 		FileName: sql.NullString{String: fileName, Valid: true},
+		TaskID:   sql.NullString{String: task.ID, Valid: true},
 	})
 	if err != nil {
-		log.Printf("update ai person reply voice status by ID error: %v", err)
-		return
+		return fmt.Errorf("finish ai person reply voice by id error: %w", err)
+	}
+	return nil
+}
+
+// convertReplyToSpeechFailed records the error of a failed attempt to convert a reply into speech, and marks the reply voice failed if the failure is final.
+func (worker *GPUWorker) convertReplyToSpeechFailed(ctx context.Context, task shared.GPUTask, taskErr error, final bool) error {
+	var payload shared.ConvertReplyToSpeechPayload
+	if err := task.DecodePayload(&payload); err != nil {
+		return err
+	}
+	errMessage := sql.NullString{String: taskErr.Error(), Valid: true}
+	taskID := sql.NullString{String: task.ID, Valid: true}
+	if !final {
+		return worker.Database.UpdateAIPersonReplyVoiceErrorByID(ctx, dbgen.UpdateAIPersonReplyVoiceErrorByIDParams{ID: payload.AIReplyVoiceID, ErrorMessage: errMessage, TaskID: taskID})
+	}
+	return worker.Database.FinishAIPersonReplyVoiceByID(ctx, dbgen.FinishAIPersonReplyVoiceByIDParams{ID: payload.AIReplyVoiceID, Status: "failed", ErrorMessage: errMessage, TaskID: taskID})
+}
+
+func (worker *GPUWorker) convertReplyToSpeechCancelled(ctx context.Context, task shared.GPUTask) (bool, error) {
+	var payload shared.ConvertReplyToSpeechPayload
+	if err := task.DecodePayload(&payload); err != nil {
+		return false, err
 	}
+	replyVoice, err := worker.Database.GetAIPersonReplyVoiceByID(ctx, payload.AIReplyVoiceID)
+	return replyVoice.Status == "cancelled", err
 }
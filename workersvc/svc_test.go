@@ -0,0 +1,164 @@
+package workersvc
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/db/dbgen"
+	"github.com/HouzuoGuo/reconn-voice-clone/db/dbtest"
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+	"github.com/HouzuoGuo/reconn-voice-clone/voiceclient/voicetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupWorker returns a GPU worker connected to the test database and a fake voice service.
+func setupWorker(t *testing.T) (*GPUWorker, *voicetest.Server) {
+	t.Helper()
+	dbtest.Connect(t)
+	voiceModelDir := t.TempDir()
+	voiceService := voicetest.NewServer(voiceModelDir)
+	t.Cleanup(voiceService.Close)
+	worker, err := New(&Config{
+		Database:             dbtest.Config(t),
+		TaskQueue:            shared.TaskQueueConfig{Backend: shared.TaskQueueMemory, Name: t.Name()},
+		BlobStore:            shared.BlobStoreConfig{Backend: shared.BlobStoreLocal, LocalDir: t.TempDir()},
+		VoiceSampleDir:       t.TempDir(),
+		VoiceModelDir:        voiceModelDir,
+		VoiceTempModelDir:    t.TempDir(),
+		VoiceOutputDir:       t.TempDir(),
+		VoiceSampleContainer: "voice-samples",
+		VoiceModelContainer:  "voice-models",
+		VoiceOutputContainer: "voice-outputs",
+		TTSCache:             shared.TTSCacheConfig{VoiceServiceVersion: t.Name() + shared.NewID()},
+		VoiceServiceAddr:     voiceService.Addr,
+		ModelAffinityTimeout: -1,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = worker.Close(context.Background()) })
+	return worker, voiceService
+}
+
+// processTask processes a new task of the type and payload.
+func processTask(t *testing.T, worker *GPUWorker, taskType shared.GPUTaskType, payload any) error {
+	t.Helper()
+	task, err := shared.NewGPUTask(taskType, "", payload)
+	require.NoError(t, err)
+	return worker.Process(context.Background(), task)
+}
+
+func TestAsyncConversationPipeline(t *testing.T) {
+	worker, voiceService := setupWorker(t)
+	ctx := context.Background()
+	user, err := worker.Database.CreateUser(ctx, dbgen.CreateUserParams{Name: "test-" + shared.NewID(), Status: "normal"})
+	require.NoError(t, err)
+	aiPerson, err := worker.Database.CreateAIPerson(ctx, dbgen.CreateAIPersonParams{UserID: user.ID, Name: "grandma", ContextPrompt: "You are a grandma."})
+	require.NoError(t, err)
+
+	// Clone the voice sample into a voice model.
+	sampleFileName := shared.NewID() + ".wav"
+	_, err = shared.UploadAndSave(ctx, worker.BlobStore, worker.Config.VoiceSampleContainer, sampleFileName, worker.Config.VoiceSampleDir, voicetest.Synthesize([]byte("grandma"), "the voice sample of grandma"))
+	require.NoError(t, err)
+	voiceSample, err := worker.Database.CreateVoiceSample(ctx, dbgen.CreateVoiceSampleParams{AiPersonID: aiPerson.ID, FileName: sql.NullString{String: sampleFileName, Valid: true}, Timestamp: time.Now()})
+	require.NoError(t, err)
+	voiceModel, err := worker.Database.CreateVoiceModel(ctx, dbgen.CreateVoiceModelParams{VoiceSampleID: voiceSample.ID, Status: "processing", Timestamp: time.Now()})
+	require.NoError(t, err)
+	require.NoError(t, processTask(t, worker, shared.GPUTaskCreateVoiceModel, shared.CreateVoiceModelPayload{VoiceModelID: voiceModel.ID}))
+	voiceModel, err = worker.Database.GetVoiceModelByID(ctx, voiceModel.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "ready", voiceModel.Status)
+	assert.Equal(t, 1, voiceService.Requests("clone-rt"))
+
+	// Convert a reply into speech.
+	convertReply := func(message string) (dbgen.AiPersonReplyVoice, error) {
+		prompt, err := worker.Database.CreateUserPrompt(ctx, dbgen.CreateUserPromptParams{AiPersonID: aiPerson.ID, Timestamp: time.Now()})
+		require.NoError(t, err)
+		reply, err := worker.Database.CreateAIPersonReply(ctx, dbgen.CreateAIPersonReplyParams{UserPromptID: prompt.ID, Status: "ready", Message: message, Timestamp: time.Now()})
+		require.NoError(t, err)
+		replyVoice, err := worker.Database.CreateAIPersonReplyVoice(ctx, dbgen.CreateAIPersonReplyVoiceParams{AiPersonReplyID: reply.ID, Status: "processing"})
+		require.NoError(t, err)
+		taskErr := processTask(t, worker, shared.GPUTaskConvertReplyToSpeech, shared.ConvertReplyToSpeechPayload{AIPersonID: aiPerson.ID, AIReplyVoiceID: replyVoice.ID})
+		replyVoice, err = worker.Database.GetAIPersonReplyVoiceByID(ctx, replyVoice.ID)
+		require.NoError(t, err)
+		return replyVoice, taskErr
+	}
+	// The voice service speaks the reply in words.
+	replyVoice, err := convertReply("Nice to hear from **you** at 3pm.")
+	require.NoError(t, err)
+	assert.Equal(t, "ready", replyVoice.Status)
+	model, err := os.ReadFile(filepath.Join(worker.Config.VoiceModelDir, voiceModel.FileName.String))
+	require.NoError(t, err)
+	speech, err := os.ReadFile(filepath.Join(worker.Config.VoiceOutputDir, replyVoice.FileName.String))
+	require.NoError(t, err)
+	assert.Equal(t, voicetest.Synthesize(model, "Nice to hear from you at three p m."), speech)
+
+	// An internal failure of the voice service is retried, whereas a rejected request is not.
+	voiceService.FailNext(1, http.StatusInternalServerError)
+	replyVoice, err = convertReply("Are you there?")
+	require.Error(t, err)
+	assert.False(t, IsPermanent(err))
+	assert.Equal(t, "processing", replyVoice.Status)
+	voiceService.FailNext(1, http.StatusNotAcceptable)
+	_, err = convertReply("Are you there?")
+	assert.True(t, IsPermanent(err))
+}
+
+func TestRequeuedTaskRetriesFailedVoiceModel(t *testing.T) {
+	worker, voiceService := setupWorker(t)
+	ctx := context.Background()
+	user, err := worker.Database.CreateUser(ctx, dbgen.CreateUserParams{Name: "test-" + shared.NewID(), Status: "normal"})
+	require.NoError(t, err)
+	aiPerson, err := worker.Database.CreateAIPerson(ctx, dbgen.CreateAIPersonParams{UserID: user.ID, Name: "grandpa", ContextPrompt: "You are a grandpa."})
+	require.NoError(t, err)
+	sampleFileName := shared.NewID() + ".wav"
+	_, err = shared.UploadAndSave(ctx, worker.BlobStore, worker.Config.VoiceSampleContainer, sampleFileName, worker.Config.VoiceSampleDir, voicetest.Synthesize([]byte("grandpa"), "the voice sample of grandpa"))
+	require.NoError(t, err)
+	voiceSample, err := worker.Database.CreateVoiceSample(ctx, dbgen.CreateVoiceSampleParams{AiPersonID: aiPerson.ID, FileName: sql.NullString{String: sampleFileName, Valid: true}, Timestamp: time.Now()})
+	require.NoError(t, err)
+	voiceModel, err := worker.Database.CreateVoiceModel(ctx, dbgen.CreateVoiceModelParams{VoiceSampleID: voiceSample.ID, Status: "processing", Timestamp: time.Now()})
+	require.NoError(t, err)
+
+	// The task fails for good and the model with it.
+	task, err := shared.NewGPUTask(shared.GPUTaskCreateVoiceModel, "", shared.CreateVoiceModelPayload{VoiceModelID: voiceModel.ID})
+	require.NoError(t, err)
+	voiceService.FailNext(1, http.StatusNotAcceptable)
+	taskErr := worker.Process(ctx, task)
+	require.True(t, IsPermanent(taskErr))
+	worker.recordFailure(ctx, task, taskErr, true)
+	voiceModel, err = worker.Database.GetVoiceModelByID(ctx, voiceModel.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "failed", voiceModel.Status)
+
+	// A redelivery of the task leaves the failed model alone.
+	require.NoError(t, worker.Process(ctx, task))
+	assert.Equal(t, 1, voiceService.Requests("clone-rt"))
+
+	// The task requeued from the dead letters clones the voice again.
+	requeued, err := shared.ParseGPUTask(shared.RequeuedBody(mustMarshal(t, task)))
+	require.NoError(t, err)
+	require.NoError(t, worker.Process(ctx, requeued))
+	voiceModel, err = worker.Database.GetVoiceModelByID(ctx, voiceModel.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "ready", voiceModel.Status)
+	assert.EqualValues(t, 1, voiceModel.Attempts)
+	assert.Equal(t, 2, voiceService.Requests("clone-rt"))
+}
+
+// mustMarshal returns the JSON encoding of the value.
+func mustMarshal(t *testing.T, v any) []byte {
+	t.Helper()
+	body, err := json.Marshal(v)
+	require.NoError(t, err)
+	return body
+}
+
+func TestNewRequiresSharedVoiceModelDir(t *testing.T) {
+	_, err := New(&Config{VoiceServices: []VoiceServiceSlots{{Addr: "gpu0:8081", Concurrency: 1}, {Addr: "gpu1:8081", Concurrency: 1}}})
+	assert.ErrorContains(t, err, "to be shared")
+}
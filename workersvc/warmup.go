@@ -0,0 +1,107 @@
+package workersvc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/db/dbgen"
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+	"github.com/HouzuoGuo/reconn-voice-clone/voiceclient"
+)
+
+const (
+	// DefaultWarmUpActiveAIPersons is the default number of the most active AI persons whose voice models a worker
+	// warms up on startup.
+	DefaultWarmUpActiveAIPersons = 10
+	// DefaultWarmUpActivityWindow is the default period of prompts which determines the most active AI persons.
+	DefaultWarmUpActivityWindow = 7 * 24 * time.Hour
+	// warmUpTimeout is how long a warm-up task waits for its worker to receive it, after which the task is dropped.
+	warmUpTimeout = 1 * time.Minute
+)
+
+// warmUpModel downloads the voice model into the local disk, and asks the voice services of the worker to pre-read it
+// from the disk.
+func (worker *GPUWorker) warmUpModel(ctx context.Context, modelFileName string) error {
+	if _, err := shared.DownloadBlobToLocalFileIfNotExist(ctx, worker.BlobStore, worker.Config.VoiceModelContainer, modelFileName, worker.Config.VoiceModelDir); err != nil {
+		return fmt.Errorf("download model error: %w", err)
+	}
+	worker.recordModelCached(ctx, modelFileName)
+	for _, svc := range worker.voiceServices() {
+		if err := voiceclient.New(svc.Addr, worker.VoiceHTTPClient).WarmUp(ctx, modelFileName); err != nil {
+			return fmt.Errorf("warm-up request error: %w", err)
+		}
+	}
+	return nil
+}
+
+// warmUpVoiceModel handles a warm-up task intended for a specific worker, the other workers leave the task to it.
+// The warm-up is an optimisation, hence the task is dropped if its worker does not receive it in time.
+func (worker *GPUWorker) warmUpVoiceModel(ctx context.Context, task shared.GPUTask) error {
+	var payload shared.WarmUpVoiceModelPayload
+	if err := task.DecodePayload(&payload); err != nil {
+		return Permanent(err)
+	}
+	if payload.WorkerID != worker.ID {
+		if time.Since(task.CreatedAt) < warmUpTimeout {
+			return Defer(modelAffinityDeferDelay, fmt.Sprintf("the warm-up is intended for worker %s", payload.WorkerID))
+		}
+		log.Printf("dropping %v because its worker %s did not receive it in time", task, payload.WorkerID)
+		return nil
+	}
+	return worker.warmUpModel(ctx, payload.ModelFileName)
+}
+
+// fanOutWarmUp sends a warm-up task of the new voice model to each of the other live workers that have served the
+// AI person before, as they are likely to serve the AI person again.
+func (worker *GPUWorker) fanOutWarmUp(ctx context.Context, aiPersonID int64, modelFileName string) error {
+	workerIDs, err := worker.Database.ListLiveGPUWorkersServingAIPerson(ctx, dbgen.ListLiveGPUWorkersServingAIPersonParams{
+		AiPersonID:  aiPersonID,
+		DeadSeconds: worker.deadWorkerThreshold().Seconds(),
+	})
+	if err != nil {
+		return err
+	}
+	for _, workerID := range workerIDs {
+		if workerID == worker.ID {
+			continue
+		}
+		task, err := shared.NewGPUTask(shared.GPUTaskWarmUpVoiceModel, "", shared.WarmUpVoiceModelPayload{
+			AIPersonID:    aiPersonID,
+			ModelFileName: modelFileName,
+			WorkerID:      workerID,
+		})
+		if err != nil {
+			return err
+		}
+		body, err := json.Marshal(task)
+		if err != nil {
+			return err
+		}
+		if err := worker.TaskQueue.Send(ctx, body, nil); err != nil {
+			return err
+		}
+		log.Printf("enqueued %v for worker %s", task, workerID)
+	}
+	return nil
+}
+
+// warmUpActiveModels warms up the latest voice models of the most active AI persons, e.g. when the worker starts.
+func (worker *GPUWorker) warmUpActiveModels(ctx context.Context) {
+	models, err := worker.Database.ListMostActiveVoiceModels(ctx, dbgen.ListMostActiveVoiceModelsParams{
+		SinceSeconds: worker.Config.WarmUpActivityWindow.Seconds(),
+		MaxAiPersons: int32(worker.Config.WarmUpActiveAIPersons),
+	})
+	if err != nil {
+		log.Printf("failed to list the voice models of the most active AI persons: %v", err)
+		return
+	}
+	for _, model := range models {
+		if err := worker.warmUpModel(ctx, model.FileName.String); err != nil {
+			log.Printf("failed to warm up voice model %q of AI person %d: %v", model.FileName.String, model.AiPersonID, err)
+		}
+	}
+	log.Printf("warmed up the voice models of %d most active AI persons", len(models))
+}
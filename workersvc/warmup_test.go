@@ -0,0 +1,24 @@
+package workersvc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/reconn-voice-clone/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarmUpForAnotherWorker(t *testing.T) {
+	worker := &GPUWorker{ID: "worker-a", Config: &Config{}}
+	task, err := shared.NewGPUTask(shared.GPUTaskWarmUpVoiceModel, "", shared.WarmUpVoiceModelPayload{AIPersonID: 1, ModelFileName: "model.npz", WorkerID: "worker-b"})
+	require.NoError(t, err)
+	// The task is left to its worker for a while.
+	err = worker.warmUpVoiceModel(context.Background(), task)
+	_, deferred := asDeferred(err)
+	assert.True(t, deferred)
+	// And then dropped.
+	task.CreatedAt = time.Now().Add(-warmUpTimeout)
+	assert.NoError(t, worker.warmUpVoiceModel(context.Background(), task))
+}